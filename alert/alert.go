@@ -0,0 +1,74 @@
+// Package alert notifies operators of problems that don't fail a run, such
+// as upstream data going stale.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Alert is a condition that has started or stopped.
+type Alert struct {
+	// Name identifies the kind of alert, e.g. "upstream_stale".
+	Name string `json:"name"`
+	// Firing is true when the condition starts, and false when it's
+	// resolved.
+	Firing  bool                   `json:"firing"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+// Notifier sends alerts somewhere operators will see them.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Log writes alerts to the log. It's used when nothing else is configured.
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, a Alert) error {
+	state := "RESOLVED"
+	if a.Firing {
+		state = "FIRING"
+	}
+	log.Printf("alert %v %v: %v", a.Name, state, a.Message)
+	return nil
+}
+
+// Webhook POSTs alerts as JSON to a URL, and logs them too.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (wh Webhook) Notify(ctx context.Context, a Alert) error {
+	Log{}.Notify(ctx, a)
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", wh.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("couldn't send alert: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("couldn't send alert: webhook returned %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Package bq streams licence change events into a BigQuery table, so the
+// change history can be queried as soon as a snapshot is processed.
+package bq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// maxRowsPerInsert is how many rows are sent per insertAll request. BigQuery
+// recommends at most 500.
+const maxRowsPerInsert = 500
+
+// Events is a BigQuery table of licence change events, one row per licence
+// added, removed or modified by a snapshot.
+type Events struct {
+	Project, Dataset, Table string
+	svc                     *bigquery.Service
+}
+
+// schema is the events table's schema. It's partitioned by snapshot.
+var schema = &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+	{Name: "snapshot", Type: "TIMESTAMP", Mode: "REQUIRED"},
+	{Name: "previous", Type: "TIMESTAMP", Mode: "REQUIRED"},
+	{Name: "licenceid", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "type", Type: "STRING", Mode: "REQUIRED", Description: "added, removed or modified"},
+	{Name: "fields", Type: "STRING", Mode: "REPEATED", Description: "columns that changed, if the licence has one row before and after"},
+	{Name: "inserted", Type: "TIMESTAMP", Mode: "REQUIRED"},
+}}
+
+// Open returns the events table named "project.dataset.table", creating it
+// if it doesn't exist.
+func Open(ctx context.Context, name string) (*Events, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("BigQuery table %q isn't of the form project.dataset.table", name)
+	}
+	svc, err := bigquery.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create BigQuery client: %v", err)
+	}
+	e := &Events{Project: parts[0], Dataset: parts[1], Table: parts[2], svc: svc}
+	if err := e.create(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// create creates the table, if it doesn't already exist.
+func (e *Events) create(ctx context.Context) error {
+	t := &bigquery.Table{
+		TableReference:   &bigquery.TableReference{ProjectId: e.Project, DatasetId: e.Dataset, TableId: e.Table},
+		Schema:           schema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: "MONTH", Field: "snapshot"},
+	}
+	_, err := e.svc.Tables.Insert(e.Project, e.Dataset, t).Context(ctx).Do()
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't create BigQuery table %v: %v", e, err)
+	}
+	log.Printf("created BigQuery table %v", e)
+	return nil
+}
+
+func (e *Events) String() string {
+	return e.Project + "." + e.Dataset + "." + e.Table
+}
+
+// Insert streams the events of snapshot, relative to previous. Each row's
+// insert ID is derived from the snapshot and licence, so retrying an insert
+// doesn't duplicate rows, within BigQuery's best-effort deduplication
+// window.
+func (e *Events) Insert(ctx context.Context, snapshot, previous string, events []convert.LicenceEvent) error {
+	inserted := time.Now().UTC().Format(time.RFC3339)
+	for start := 0; start < len(events); start += maxRowsPerInsert {
+		batch := events[start:min(start+maxRowsPerInsert, len(events))]
+		req := &bigquery.TableDataInsertAllRequest{}
+		for _, ev := range batch {
+			fields := ev.Fields
+			if fields == nil {
+				fields = []string{}
+			}
+			req.Rows = append(req.Rows, &bigquery.TableDataInsertAllRequestRows{
+				InsertId: snapshot + "/" + ev.LicenceID,
+				Json: map[string]bigquery.JsonValue{
+					"snapshot":  snapshot,
+					"previous":  previous,
+					"licenceid": ev.LicenceID,
+					"type":      ev.Type,
+					"fields":    fields,
+					"inserted":  inserted,
+				},
+			})
+		}
+		resp, err := e.svc.Tabledata.InsertAll(e.Project, e.Dataset, e.Table, req).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("couldn't insert into %v: %v", e, err)
+		}
+		if len(resp.InsertErrors) > 0 {
+			ie := resp.InsertErrors[0]
+			msg := "unknown error"
+			if len(ie.Errors) > 0 {
+				msg = ie.Errors[0].Message
+			}
+			return fmt.Errorf("couldn't insert %v of %v rows into %v: row %v: %v", len(resp.InsertErrors), len(batch), e, start+int(ie.Index), msg)
+		}
+	}
+	log.Printf("streamed %v licence events for %v into %v", len(events), snapshot, e)
+	return nil
+}
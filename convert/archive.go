@@ -0,0 +1,166 @@
+package convert
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// ArchiveFormat is how an export is packaged.
+type ArchiveFormat string
+
+const (
+	FormatZip   ArchiveFormat = "zip"
+	FormatTarGz ArchiveFormat = "tar.gz"
+	FormatTar   ArchiveFormat = "tar"
+	Format7z    ArchiveFormat = "7z"
+	// FormatMDB is a bare Access database, or one that was only gzipped.
+	FormatMDB   ArchiveFormat = "mdb"
+	FormatMDBGz ArchiveFormat = "mdb.gz"
+)
+
+// ArchiveFormats are all the formats DetectArchive recognises. 7z is only
+// recognised, to say so when it fails: prism.mdb can't be extracted from it.
+var ArchiveFormats = []ArchiveFormat{FormatZip, FormatTarGz, FormatTar, Format7z, FormatMDB, FormatMDBGz}
+
+var archiveContentTypes = map[ArchiveFormat]string{
+	FormatZip:   "application/zip",
+	FormatTarGz: "application/gzip",
+	FormatTar:   "application/x-tar",
+	Format7z:    "application/x-7z-compressed",
+	FormatMDB:   "application/x-msaccess",
+	FormatMDBGz: "application/gzip",
+}
+
+// ContentType is the MIME type of an export in the format.
+func (f ArchiveFormat) ContentType() string {
+	return archiveContentTypes[f]
+}
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	sevenZipMagic = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+)
+
+// detectLen is how much of the start of an export DetectArchive is given:
+// enough for a gzip stream to yield the header of what it compresses.
+const detectLen = 64 << 10
+
+// DetectArchive identifies how an export starting with b is packaged from its
+// magic bytes, rather than trusting the URL or Content-Type. Exports are
+// zips unless they're clearly something else, so one that's unrecognisable
+// is taken to be a damaged zip: the error wraps ErrCorruptArchive.
+func DetectArchive(b []byte) (ArchiveFormat, error) {
+	switch {
+	case bytes.HasPrefix(b, zipMagic):
+		return FormatZip, nil
+	case bytes.HasPrefix(b, sevenZipMagic):
+		return Format7z, nil
+	case isTar(b):
+		return FormatTar, nil
+	case isAccess(b):
+		return FormatMDB, nil
+	case bytes.HasPrefix(b, gzipMagic):
+		// Peek inside to tell a tarball from a gzipped database.
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return "", fmt.Errorf("couldn't read gzip: %v", err)
+		}
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(zr, head)
+		switch head = head[:n]; {
+		case isTar(head):
+			return FormatTarGz, nil
+		case isAccess(head):
+			return FormatMDBGz, nil
+		}
+		return "", errors.New("gzipped export is neither a tarball nor an Access database")
+	case bytes.Contains(b, zipMagic):
+		// A zip whose start is damaged, or has something before it. Its
+		// central directory may still be readable, and if not, it may be
+		// salvageable.
+		return FormatZip, nil
+	}
+	n := min(len(b), 8)
+	return "", fmt.Errorf("%w: unknown export format, starting % x", ErrCorruptArchive, b[:n])
+}
+
+// DetectExport reads enough of the start of archive for DetectArchive.
+func DetectExport(archive io.ReaderAt, size int64) (ArchiveFormat, error) {
+	head := make([]byte, min(size, detectLen))
+	if _, err := archive.ReadAt(head, 0); err != nil && err != io.EOF {
+		return "", fmt.Errorf("couldn't read export: %v", err)
+	}
+	return DetectArchive(head)
+}
+
+// isTar reports whether b starts with a POSIX or GNU tar header.
+func isTar(b []byte) bool {
+	return len(b) >= 262 && bytes.Equal(b[257:262], []byte("ustar"))
+}
+
+// isAccess reports whether b starts an Access database.
+func isAccess(b []byte) bool {
+	return len(b) >= 19 && (bytes.Equal(b[4:19], []byte("Standard Jet DB")) || bytes.Equal(b[4:19], []byte("Standard ACE DB")))
+}
+
+// openMDB returns a reader of prism.mdb from inside archive. The caller
+// must close it.
+func openMDB(archive *io.SectionReader, format ArchiveFormat) (io.ReadCloser, error) {
+	switch format {
+	case FormatZip:
+		zipR, err := zip.NewReader(archive, archive.Size())
+		if err != nil {
+			return nil, fmt.Errorf("%w: error opening zip: %v", ErrCorruptArchive, err)
+		}
+		log.Println("finding prism.mdb")
+		prismMDB, err := FindPrismMDB(zipR)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find prism.mdb: %v", err)
+		}
+		r, err := prismMDB.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptArchive, err)
+		}
+		return r, nil
+	case FormatTar, FormatTarGz:
+		var r io.Reader = archive
+		if format == FormatTarGz {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read gzip: %v", err)
+			}
+			r = zr
+		}
+		return findInTar(tar.NewReader(r))
+	case FormatMDB:
+		return io.NopCloser(archive), nil
+	case FormatMDBGz:
+		return gzip.NewReader(archive)
+	}
+	return nil, fmt.Errorf("can't open %v exports in-process", format)
+}
+
+// findInTar returns prism.mdb from a tarball, in whichever directory it is.
+func findInTar(tr *tar.Reader) (io.ReadCloser, error) {
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no prism.mdb found in tarball")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read tarball: %v", err)
+		}
+		if h.Typeflag == tar.TypeReg && strings.EqualFold(path.Base(h.Name), "prism.mdb") {
+			return io.NopCloser(tr), nil
+		}
+	}
+}
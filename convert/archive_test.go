@@ -0,0 +1,127 @@
+package convert
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// accessDB stands in for prism.mdb: just enough for isAccess.
+var accessDB = append([]byte("\x00\x01\x00\x00Standard Jet DB\x00"), bytes.Repeat([]byte{0}, 1000)...)
+
+func gzipOf(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, name string, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(b))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(b)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipFile(t *testing.T, name string, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectArchive(t *testing.T) {
+	z := zipFile(t, "prism.mdb", accessDB)
+	tests := []struct {
+		name    string
+		b       []byte
+		want    ArchiveFormat
+		corrupt bool
+		wantErr bool
+	}{
+		{name: "zip", b: z, want: FormatZip},
+		{name: "zip after junk", b: append([]byte("junk"), z...), want: FormatZip},
+		{name: "tar", b: tarOf(t, "export/prism.mdb", accessDB), want: FormatTar},
+		{name: "tar.gz", b: gzipOf(t, tarOf(t, "prism.mdb", accessDB)), want: FormatTarGz},
+		{name: "7z", b: []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0, 4}, want: Format7z},
+		{name: "mdb", b: accessDB, want: FormatMDB},
+		{name: "mdb.gz", b: gzipOf(t, accessDB), want: FormatMDBGz},
+		{name: "gzipped text", b: gzipOf(t, []byte("hello")), wantErr: true},
+		{name: "damaged zip", b: bytes.Repeat([]byte{0xff}, 100), wantErr: true, corrupt: true},
+		{name: "empty", b: nil, wantErr: true, corrupt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectArchive(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectArchive() = %v, %v, want error %v", got, err, tt.wantErr)
+			}
+			if errors.Is(err, ErrCorruptArchive) != tt.corrupt {
+				t.Errorf("DetectArchive() = %v, want ErrCorruptArchive %v", err, tt.corrupt)
+			}
+			if got != tt.want {
+				t.Errorf("DetectArchive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMDBFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		b       []byte
+		wantErr bool
+	}{
+		{name: "zip", b: zipFile(t, "prism.mdb", accessDB)},
+		{name: "tar", b: tarOf(t, "export/PRISM.MDB", accessDB)},
+		{name: "tar.gz", b: gzipOf(t, tarOf(t, "prism.mdb", accessDB))},
+		{name: "mdb", b: accessDB},
+		{name: "mdb.gz", b: gzipOf(t, accessDB)},
+		{name: "tar without prism.mdb", b: tarOf(t, "readme.txt", accessDB), wantErr: true},
+		{name: "7z", b: []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0, 4}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ExtractMDB(bytes.NewReader(tt.b), int64(len(tt.b)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExtractMDB() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			got, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<30))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, accessDB) {
+				t.Errorf("extracted %v bytes, want the %v of prism.mdb", len(got), len(accessDB))
+			}
+		})
+	}
+}
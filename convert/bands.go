@@ -0,0 +1,138 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+func init() {
+	Register(BandSummary{})
+}
+
+// Band is a range of frequencies used for fixed links, named the way
+// spectrum planners refer to it.
+type Band struct {
+	Name string
+	// LowMHz is inclusive, HighMHz exclusive.
+	LowMHz, HighMHz float64
+}
+
+// Bands are the fixed-service bands links are grouped into, in order of
+// frequency. Frequencies outside all of them are in the band "other".
+var Bands = []Band{
+	{"VHF", 30, 300},
+	{"UHF", 300, 1350},
+	{"1.4 GHz", 1350, 1700},
+	{"2 GHz", 1700, 2700},
+	{"4 GHz", 3600, 4200},
+	{"5 GHz", 4400, 5000},
+	{"L6 GHz", 5925, 6425},
+	{"U6 GHz", 6425, 7125},
+	{"7 GHz", 7125, 7900},
+	{"8 GHz", 7900, 8500},
+	{"10 GHz", 10000, 10700},
+	{"11 GHz", 10700, 11700},
+	{"13 GHz", 12750, 13250},
+	{"15 GHz", 14400, 15350},
+	{"18 GHz", 17700, 19700},
+	{"23 GHz", 21200, 23600},
+	{"26 GHz", 24250, 26500},
+	{"28 GHz", 27500, 29500},
+	{"32 GHz", 31800, 33400},
+	{"38 GHz", 37000, 39500},
+	{"42 GHz", 40500, 43500},
+	{"52 GHz", 51400, 52600},
+	{"70 GHz", 71000, 76000},
+	{"80 GHz", 81000, 86000},
+}
+
+// OtherBand is the band of frequencies outside all of Bands.
+const OtherBand = "other"
+
+// BandOf returns the name of the band containing freqMHz.
+func BandOf(freqMHz float64) string {
+	for _, b := range Bands {
+		if freqMHz >= b.LowMHz && freqMHz < b.HighMHz {
+			return b.Name
+		}
+	}
+	return OtherBand
+}
+
+// BandNames returns the names of all bands, including OtherBand, in order
+// of frequency.
+func BandNames() []string {
+	names := make([]string, 0, len(Bands)+1)
+	for _, b := range Bands {
+		names = append(names, b.Name)
+	}
+	return append(names, OtherBand)
+}
+
+// CountByBand returns how many links are in each band.
+func CountByBand(links []Link) map[string]int {
+	counts := make(map[string]int)
+	for _, l := range links {
+		counts[l.Band()]++
+	}
+	return counts
+}
+
+// BandSummary summarises the links in each frequency band, for people who
+// don't need per-link detail.
+type BandSummary struct{}
+
+func (BandSummary) Name() string        { return "bands.csv" }
+func (BandSummary) ContentType() string { return "text/csv" }
+
+type bandStats struct {
+	links                int
+	licensees            map[string]bool
+	minPathKm, maxPathKm float64
+}
+
+func (BandSummary) Convert(rows *Rows) (io.Reader, error) {
+	links, err := rows.Links()
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]*bandStats)
+	for _, l := range links {
+		s := stats[l.Band()]
+		if s == nil {
+			s = &bandStats{licensees: make(map[string]bool), minPathKm: math.Inf(1)}
+			stats[l.Band()] = s
+		}
+		s.links++
+		s.licensees[l.ClientName] = true
+		d := l.PathKm()
+		s.minPathKm = math.Min(s.minPathKm, d)
+		s.maxPathKm = math.Max(s.maxPathKm, d)
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"band", "links", "licensees", "min_path_km", "max_path_km"})
+	for _, band := range BandNames() {
+		s := stats[band]
+		if s == nil {
+			continue
+		}
+		w.Write([]string{
+			band,
+			strconv.Itoa(s.links),
+			strconv.Itoa(len(s.licensees)),
+			strconv.FormatFloat(s.minPathKm, 'f', 2, 64),
+			strconv.FormatFloat(s.maxPathKm, 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("couldn't write band summary: %v", err)
+	}
+	return &buf, nil
+}
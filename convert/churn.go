@@ -0,0 +1,99 @@
+package convert
+
+import (
+	"slices"
+	"strings"
+)
+
+// Churn counts how licences changed between two snapshots.
+type Churn struct {
+	Added int `json:"added"`
+	// Removed licences have been cancelled or have expired.
+	Removed  int `json:"removed"`
+	Modified int `json:"modified"`
+}
+
+// LicenceEvent is a change to one licence between two snapshots.
+type LicenceEvent struct {
+	LicenceID string `json:"licenceid"`
+	// Type is "added", "removed" or "modified".
+	Type string `json:"type"`
+	// Fields lists the columns that changed, if a modified licence has a
+	// single row before and after.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// CompareLicences counts the licences added, removed and modified between
+// prev and cur.
+func CompareLicences(prev, cur *Rows) Churn {
+	var c Churn
+	for _, e := range LicenceEvents(prev, cur) {
+		switch e.Type {
+		case "added":
+			c.Added++
+		case "removed":
+			c.Removed++
+		case "modified":
+			c.Modified++
+		}
+	}
+	return c
+}
+
+// LicenceEvents lists the licences added, removed and modified between prev
+// and cur, ordered by licence ID. Only columns in both snapshots are
+// compared, so a change to the query doesn't make every licence look
+// modified.
+func LicenceEvents(prev, cur *Rows) []LicenceEvent {
+	var cols []string
+	for _, h := range cur.Header {
+		if prev.Column(h) >= 0 {
+			cols = append(cols, h)
+		}
+	}
+	before, after := licenceRows(prev, cols), licenceRows(cur, cols)
+	var events []LicenceEvent
+	for id, rows := range after {
+		old, ok := before[id]
+		switch {
+		case !ok:
+			events = append(events, LicenceEvent{LicenceID: id, Type: "added"})
+		case !slices.Equal(old, rows):
+			e := LicenceEvent{LicenceID: id, Type: "modified"}
+			if len(old) == 1 && len(rows) == 1 {
+				from, to := strings.Split(old[0], "\x00"), strings.Split(rows[0], "\x00")
+				for i, col := range cols {
+					if from[i] != to[i] {
+						e.Fields = append(e.Fields, col)
+					}
+				}
+			}
+			events = append(events, e)
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			events = append(events, LicenceEvent{LicenceID: id, Type: "removed"})
+		}
+	}
+	slices.SortFunc(events, func(a, b LicenceEvent) int { return strings.Compare(a.LicenceID, b.LicenceID) })
+	return events
+}
+
+// licenceRows groups the rows of r by licence, each row reduced to the
+// given columns and the rows of each licence sorted.
+func licenceRows(r *Rows, cols []string) map[string][]string {
+	m := make(map[string][]string)
+	vals := make([]string, len(cols))
+	for _, rec := range r.Records {
+		for i, col := range cols {
+			vals[i] = r.Get(rec, col)
+		}
+		id := r.Get(rec, "licenceid")
+		m[id] = append(m[id], strings.Join(vals, "\x00"))
+	}
+	for _, rows := range m {
+		slices.Sort(rows)
+	}
+	return m
+}
@@ -0,0 +1,63 @@
+package convert
+
+import "testing"
+
+func TestCompareLicences(t *testing.T) {
+	header := []string{"licenceid", "clientname", "frequency"}
+	prev := &Rows{
+		Header: header,
+		Records: [][]string{
+			{"1", "Acme", "7000"},
+			{"2", "Acme", "7100"},
+			{"3", "Kiwi", "18000"},
+			{"3", "Kiwi", "18100"},
+			{"4", "Tui", "23000"},
+		},
+	}
+	tests := []struct {
+		name string
+		cur  *Rows
+		want Churn
+	}{
+		{name: "unchanged", cur: prev, want: Churn{}},
+		{
+			name: "reordered rows",
+			cur: &Rows{Header: header, Records: [][]string{
+				{"4", "Tui", "23000"},
+				{"3", "Kiwi", "18100"},
+				{"3", "Kiwi", "18000"},
+				{"2", "Acme", "7100"},
+				{"1", "Acme", "7000"},
+			}},
+			want: Churn{},
+		},
+		{
+			name: "added, removed and modified",
+			cur: &Rows{Header: header, Records: [][]string{
+				{"1", "Acme", "7000"},
+				{"2", "Acme Ltd", "7100"},
+				{"3", "Kiwi", "18000"},
+				{"5", "Weka", "38000"},
+				{"6", "Weka", "38100"},
+			}},
+			want: Churn{Added: 2, Removed: 1, Modified: 2},
+		},
+		{
+			name: "new column",
+			cur: &Rows{Header: append(header, "status"), Records: [][]string{
+				{"1", "Acme", "7000", "Current"},
+				{"2", "Acme", "7100", "Current"},
+				{"3", "Kiwi", "18000", "Current"},
+				{"3", "Kiwi", "18100", "Current"},
+				{"4", "Tui", "23000", "Current"},
+			}},
+			want: Churn{},
+		},
+		{name: "empty", cur: &Rows{Header: header}, want: Churn{Removed: 4}},
+	}
+	for _, tt := range tests {
+		if got := CompareLicences(prev, tt.cur); got != tt.want {
+			t.Errorf("%v: CompareLicences() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+func init() {
+	Register(Clusters{})
+}
+
+// Zoom levels to cluster at. Beyond the maximum, the frontend draws every
+// link.
+const (
+	clusterMinZoom = 4
+	clusterMaxZoom = 9
+	// clusterCellPx is the size of a grid cell in screen pixels, at 256px
+	// tiles.
+	clusterCellPx = 64
+)
+
+// Clusters groups links into grid cells at each of a range of zoom levels,
+// so the frontend can show counts at national scale instead of drawing tens
+// of thousands of lines. Each link is placed by its midpoint.
+type Clusters struct{}
+
+func (Clusters) Name() string        { return "clusters.json" }
+func (Clusters) ContentType() string { return "application/json" }
+
+type cluster struct {
+	Lat   float64 `json:"lat"`
+	Lng   float64 `json:"lng"`
+	Links int     `json:"links"`
+}
+
+type clusterOutput struct {
+	CellPx int `json:"cell_px"`
+	// Zooms maps each zoom level to its clusters, most links first.
+	Zooms map[string][]cluster `json:"zooms"`
+}
+
+// Convert outputs the clusters as JSON. Each cluster is at the mean position
+// of its links' midpoints.
+func (Clusters) Convert(rows *Rows) (io.Reader, error) {
+	links, err := rows.Links()
+	if err != nil {
+		return nil, err
+	}
+	mids := make([]geo.Point, len(links))
+	for i, l := range links {
+		mids[i] = geo.Intermediate(l.Tx, l.Rx, 0.5)
+	}
+	out := clusterOutput{CellPx: clusterCellPx, Zooms: make(map[string][]cluster)}
+	for z := clusterMinZoom; z <= clusterMaxZoom; z++ {
+		out.Zooms[strconv.Itoa(z)] = clusterAt(mids, z)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(out); err != nil {
+		return nil, fmt.Errorf("couldn't encode clusters: %v", err)
+	}
+	return &buf, nil
+}
+
+// clusterAt groups pts into grid cells at zoom level z.
+func clusterAt(pts []geo.Point, z int) []cluster {
+	type cell struct{ x, y int }
+	sums := make(map[cell]*cluster)
+	for _, p := range pts {
+		x, y := mercatorPx(p, z)
+		c := cell{int(x / clusterCellPx), int(y / clusterCellPx)}
+		s := sums[c]
+		if s == nil {
+			s = &cluster{}
+			sums[c] = s
+		}
+		s.Lat += p.Lat
+		s.Lng += p.Lng
+		s.Links++
+	}
+	out := make([]cluster, 0, len(sums))
+	for _, s := range sums {
+		n := float64(s.Links)
+		out = append(out, cluster{
+			Lat:   math.Round(s.Lat/n*1e5) / 1e5,
+			Lng:   math.Round(s.Lng/n*1e5) / 1e5,
+			Links: s.Links,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Links != out[j].Links {
+			return out[i].Links > out[j].Links
+		}
+		if out[i].Lat != out[j].Lat {
+			return out[i].Lat < out[j].Lat
+		}
+		return out[i].Lng < out[j].Lng
+	})
+	return out
+}
+
+// mercatorPx projects p to Web Mercator pixel coordinates at zoom level z,
+// with 256px tiles.
+func mercatorPx(p geo.Point, z int) (x, y float64) {
+	scale := 256 * math.Exp2(float64(z))
+	lat := p.Lat * math.Pi / 180
+	x = (p.Lng + 180) / 360 * scale
+	y = (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * scale
+	return x, y
+}
@@ -0,0 +1,161 @@
+// Package convert turns the PRISM export into the CSV and JSON we publish.
+//
+// PRISM is distributed as an Access database, prism.mdb, inside prism.zip.
+// It's converted to sqlite3 with mdb-sqlite, queried with the sqlite3 CLI,
+// and the resulting CSV is turned into JSON with a small Python script. The
+// native conversion, which reproduces that pipeline in Go, reads it with
+// package mdb and extracts the links with QueryLinks instead.
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// QueryFile is the default SQL run against the converted database to extract
+// the point-to-point links.
+const QueryFile = "select_point_to_point_links.sql"
+
+// QueryHash identifies the current version of the extraction SQL in
+// queryFile.
+func QueryHash(queryFile string) (string, error) {
+	b, err := os.ReadFile(queryFile)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read %v: %v", queryFile, err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ExtractMDB finds prism.mdb in the bytes of the export and saves it to a
+// temporary file. mdb-sqlite requires a file: it won't work with stdin. It's
+// the caller's responsibility to close and delete the file.
+//
+// The export is usually prism.zip, but its packaging is detected from its
+// contents: see DetectArchive. Zip64 archives are read like any other. If a
+// zip is damaged, the error wraps ErrCorruptArchive, and SalvageMDB may
+// still be able to recover prism.mdb.
+func ExtractMDB(archive io.ReaderAt, size int64) (*os.File, error) {
+	format, err := DetectExport(archive, size)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("opening %v export", format)
+	if format == Format7z {
+		return nil, errors.New("couldn't open prism.mdb: 7z exports aren't supported")
+	}
+
+	mdbTmp, err := TempFile("prism.mdb")
+	if err != nil {
+		return nil, err
+	}
+	log.Println("opening prism.mdb")
+	mdbR, err := openMDB(io.NewSectionReader(archive, 0, size), format)
+	if err != nil {
+		mdbTmp.Close()
+		os.Remove(mdbTmp.Name())
+		return nil, fmt.Errorf("couldn't open prism.mdb: %w", err)
+	}
+	defer mdbR.Close()
+
+	log.Println("saving prism.mdb to disk")
+	n, err := io.Copy(mdbTmp, mdbR)
+	log.Printf("read %v bytes from prism.mdb\n", n)
+	if err != nil {
+		mdbTmp.Close()
+		os.Remove(mdbTmp.Name())
+		if format == FormatZip {
+			// The central directory was fine, but the entry wasn't.
+			return nil, fmt.Errorf("%w: couldn't read prism.mdb from zip: %v", ErrCorruptArchive, err)
+		}
+		return nil, fmt.Errorf("couldn't read prism.mdb from %v export: %v", format, err)
+	}
+	return mdbTmp, nil
+}
+
+// FindPrismMDB returns prism.mdb from inside prism.zip, preferring one at
+// the top level to one in a directory.
+func FindPrismMDB(r *zip.Reader) (*zip.File, error) {
+	for _, f := range r.File {
+		if f.Name == "prism.mdb" {
+			return f, nil
+		}
+	}
+	for _, f := range r.File {
+		if strings.EqualFold(path.Base(f.Name), "prism.mdb") {
+			return f, nil
+		}
+	}
+	return nil, errors.New("no prism.mdb found in prism.zip")
+}
+
+// MDBToSqlite converts the Access database mdbTmp into the sqlite3 database
+// tmpSqlite.
+func MDBToSqlite(mdbTmp *os.File, tmpSqlite *os.File) error {
+	// Convert to sqlite3
+	cmd := exec.Command(JavaPath, "-jar", MDBSqliteJar, mdbTmp.Name(), tmpSqlite.Name())
+	log.Printf("Converting to sqlite3: running %v\n", cmd.String())
+	if javaOutput, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("couldn't read output from java: %v, output: %v", err, javaOutput)
+	}
+
+	// Compact and analyze output with sqlite3, so queries are planned the
+	// same way for every snapshot.
+	return OptimizeSqlite(tmpSqlite.Name())
+}
+
+// TempFile creates a temporary file. It's the caller's responsibility to close and delete the file.
+func TempFile(pattern string) (f *os.File, err error) {
+	if f, err = ioutil.TempFile(os.TempDir(), pattern); err != nil {
+		err = fmt.Errorf("couldn't create temp file: %v", err)
+	}
+	return
+}
+
+// QuerySqliteToCSV runs the SQL in queryFile against tmpSqlite, writing CSV
+// to tmpCsv.
+func QuerySqliteToCSV(tmpSqlite *os.File, queryFile string, tmpCsv io.Writer) error {
+	// Run SQL to ouput CSV
+	sqlF, err := os.Open(queryFile)
+	if err != nil {
+		return err
+	}
+
+	var selectErr bytes.Buffer
+	c := exec.Command(Sqlite3Path, tmpSqlite.Name())
+	c.Stdin = sqlF
+	c.Stdout = tmpCsv
+	c.Stderr = &selectErr
+
+	log.Printf("Extracting data from sqlite: running %v\n", c.String())
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("couldn't select: %v, stderr: %v", err, selectErr.String())
+	}
+	return nil
+}
+
+// CSVToJSON converts CSV into a JSON list of objects keyed by column name.
+// All output fields are strings.
+func CSVToJSON(tmpCsv io.Reader, tmpJSON io.Writer) error {
+	var jsonErr bytes.Buffer
+	c := exec.Command(Python3Path, CSV2JSONPy)
+	c.Stdout = tmpJSON
+	c.Stdin = tmpCsv
+	c.Stderr = &jsonErr
+	log.Printf("Converting to JSON: running %v\n", c.String())
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("couldn't convert to json: %v, stderr: %v", err, jsonErr.String())
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/internal/fixture"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func BenchmarkExtractMDB(b *testing.B) {
+	z := fixture.Zip(64 << 20)
+	b.SetBytes(int64(len(z)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := ExtractMDB(bytes.NewReader(z), int64(len(z)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func BenchmarkParseCSV(b *testing.B) {
+	c := fixture.CSV(fixture.Links)
+	b.SetBytes(int64(len(c)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseCSV(bytes.NewReader(c)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteJSON measures writing prism.json from rows, as the native
+// conversion does.
+func BenchmarkWriteJSON(b *testing.B) {
+	rows, err := ParseCSV(bytes.NewReader(fixture.CSV(fixture.Links)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := WriteJSON(rows, io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCSVToJSON measures converting prism.csv to prism.json with
+// csv2json2.py, as the legacy conversion does.
+func BenchmarkCSVToJSON(b *testing.B) {
+	if err := checkRunnable(context.Background(), Python3Path, "--version"); err != nil {
+		b.Skip(err)
+	}
+	// CSVToJSON runs csv2json2.py from the working directory, as the server
+	// does from the repository's root.
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	c := fixture.CSV(fixture.Links)
+	b.SetBytes(int64(len(c)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := CSVToJSON(bytes.NewReader(c), io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGeoJSON(b *testing.B) {
+	rows, err := ParseCSV(bytes.NewReader(fixture.CSV(fixture.Links)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := (GeoJSON{}).Convert(rows); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
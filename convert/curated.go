@@ -0,0 +1,138 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Column picks a column for CuratedCSV, and what to call it.
+type Column struct {
+	Name string
+	// As is the column's name in the output, or "" to keep Name.
+	As string
+}
+
+// ParseColumns parses a comma-separated list of columns, each optionally
+// renamed with "=", e.g. "licenceid,clientname=licensee".
+func ParseColumns(spec string) ([]Column, error) {
+	var cols []Column
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, as, _ := strings.Cut(item, "=")
+		name, as = strings.TrimSpace(name), strings.TrimSpace(as)
+		if name == "" {
+			return nil, fmt.Errorf("bad column %q: no name", item)
+		}
+		cols = append(cols, Column{Name: name, As: as})
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns in %q", spec)
+	}
+	return cols, nil
+}
+
+// Dialect describes how CuratedCSV writes CSV, for consumers like Excel
+// that are picky about it.
+type Dialect struct {
+	// Comma is the field delimiter: ',' if zero.
+	Comma rune
+	// QuoteAll quotes every field, rather than only those that need it.
+	QuoteAll bool
+	// CRLF ends lines with \r\n rather than \n.
+	CRLF bool
+	// BOM starts the output with a UTF-8 byte order mark, which Excel needs
+	// to recognise UTF-8.
+	BOM bool
+}
+
+// ParseDelimiter parses a delimiter flag: a single character, or "tab".
+func ParseDelimiter(s string) (rune, error) {
+	if s == "tab" || s == `\t` {
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+		return 0, fmt.Errorf("bad delimiter %q: want a single character other than a quote or newline", s)
+	}
+	return r[0], nil
+}
+
+// writeRecord writes one line of CSV in dialect d.
+func (d Dialect) writeRecord(w *bytes.Buffer, rec []string) {
+	comma := d.Comma
+	if comma == 0 {
+		comma = ','
+	}
+	for i, f := range rec {
+		if i > 0 {
+			w.WriteRune(comma)
+		}
+		if d.QuoteAll || d.needsQuotes(f, comma) {
+			w.WriteByte('"')
+			w.WriteString(strings.ReplaceAll(f, `"`, `""`))
+			w.WriteByte('"')
+		} else {
+			w.WriteString(f)
+		}
+	}
+	if d.CRLF {
+		w.WriteString("\r\n")
+	} else {
+		w.WriteByte('\n')
+	}
+}
+
+// needsQuotes reports whether f must be quoted, following encoding/csv.
+func (d Dialect) needsQuotes(f string, comma rune) bool {
+	if f == "" {
+		return false
+	}
+	return strings.ContainsRune(f, comma) || strings.ContainsAny(f, "\"\r\n") || f[0] == ' ' || f[0] == '\t'
+}
+
+// CuratedCSV publishes a CSV with a fixed choice and order of columns, so
+// spreadsheet users get the same layout even when the query changes.
+// Columns a snapshot doesn't have are left blank. If Columns is empty,
+// every column is published as is, which is useful to just change the
+// Dialect.
+type CuratedCSV struct {
+	Columns []Column
+	Dialect Dialect
+}
+
+func (CuratedCSV) Name() string        { return "curated.csv" }
+func (CuratedCSV) ContentType() string { return "text/csv; charset=utf-8" }
+
+func (c CuratedCSV) Convert(rows *Rows) (io.Reader, error) {
+	cols := c.Columns
+	if len(cols) == 0 {
+		for _, h := range rows.Header {
+			cols = append(cols, Column{Name: h})
+		}
+	}
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.Name
+		if col.As != "" {
+			header[i] = col.As
+		}
+	}
+	var buf bytes.Buffer
+	if c.Dialect.BOM {
+		buf.WriteString("\uFEFF")
+	}
+	c.Dialect.writeRecord(&buf, header)
+	out := make([]string, len(cols))
+	for _, rec := range rows.Records {
+		for i, col := range cols {
+			out[i] = rows.Get(rec, col.Name)
+		}
+		c.Dialect.writeRecord(&buf, out)
+	}
+	return &buf, nil
+}
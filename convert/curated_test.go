@@ -0,0 +1,162 @@
+package convert
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseColumns(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []Column
+		wantErr bool
+	}{
+		{spec: "licenceid", want: []Column{{Name: "licenceid"}}},
+		{spec: "licenceid,clientname=licensee", want: []Column{{Name: "licenceid"}, {Name: "clientname", As: "licensee"}}},
+		{spec: " licenceid , clientname = licensee ,", want: []Column{{Name: "licenceid"}, {Name: "clientname", As: "licensee"}}},
+		{spec: "frequency=", want: []Column{{Name: "frequency"}}},
+		{spec: "", wantErr: true},
+		{spec: " , ", wantErr: true},
+		{spec: "licenceid,=licensee", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseColumns(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseColumns(%q) = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseColumns(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCuratedCSVColumns(t *testing.T) {
+	rows := &Rows{
+		Header: []string{"licenceid", "clientname", "frequency"},
+		Records: [][]string{
+			{"1", "Acme, Ltd", "7000"},
+			{"2", "Kiwi Net", "18000"},
+		},
+	}
+	tests := []struct {
+		name string
+		cols []Column
+		want string
+	}{
+		{
+			name: "quoted",
+			cols: []Column{{Name: "clientname"}},
+			want: "clientname\n\"Acme, Ltd\"\nKiwi Net\n",
+		},
+		{
+			name: "reordered and renamed",
+			cols: []Column{{Name: "frequency", As: "MHz"}, {Name: "licenceid"}},
+			want: "MHz,licenceid\n7000,1\n18000,2\n",
+		},
+		{
+			name: "missing column",
+			cols: []Column{{Name: "licenceid"}, {Name: "status"}},
+			want: "licenceid,status\n1,\n2,\n",
+		},
+	}
+	for _, tt := range tests {
+		r, err := CuratedCSV{Columns: tt.cols}.Convert(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: Convert() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    rune
+		wantErr bool
+	}{
+		{s: ",", want: ','},
+		{s: ";", want: ';'},
+		{s: "|", want: '|'},
+		{s: "tab", want: '\t'},
+		{s: `\t`, want: '\t'},
+		{s: "\t", want: '\t'},
+		{s: "", wantErr: true},
+		{s: ",,", wantErr: true},
+		{s: `"`, wantErr: true},
+		{s: "\n", wantErr: true},
+		{s: "\r", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDelimiter(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDelimiter(%q) = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDelimiter(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCuratedCSVDialect(t *testing.T) {
+	rows := &Rows{
+		Header: []string{"licenceid", "clientname"},
+		Records: [][]string{
+			{"1", "Acme; \"The\" Best"},
+			{"2", " Kiwi"},
+			{"3", ""},
+		},
+	}
+	tests := []struct {
+		name    string
+		dialect Dialect
+		want    string
+	}{
+		{
+			name: "default",
+			want: "licenceid,clientname\n1,\"Acme; \"\"The\"\" Best\"\n2,\" Kiwi\"\n3,\n",
+		},
+		{
+			name:    "semicolon",
+			dialect: Dialect{Comma: ';'},
+			want:    "licenceid;clientname\n1;\"Acme; \"\"The\"\" Best\"\n2;\" Kiwi\"\n3;\n",
+		},
+		{
+			name:    "tab",
+			dialect: Dialect{Comma: '\t'},
+			want:    "licenceid\tclientname\n1\t\"Acme; \"\"The\"\" Best\"\n2\t\" Kiwi\"\n3\t\n",
+		},
+		{
+			name:    "quote all",
+			dialect: Dialect{QuoteAll: true},
+			want:    "\"licenceid\",\"clientname\"\n\"1\",\"Acme; \"\"The\"\" Best\"\n\"2\",\" Kiwi\"\n\"3\",\"\"\n",
+		},
+		{
+			name:    "Excel",
+			dialect: Dialect{Comma: ';', CRLF: true, BOM: true},
+			want:    "\uFEFFlicenceid;clientname\r\n1;\"Acme; \"\"The\"\" Best\"\r\n2;\" Kiwi\"\r\n3;\r\n",
+		},
+	}
+	for _, tt := range tests {
+		// No columns publishes them all, to just change the dialect.
+		r, err := CuratedCSV{Dialect: tt.dialect}.Convert(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: Convert() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
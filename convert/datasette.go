@@ -0,0 +1,113 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v16/arrow"
+)
+
+func init() {
+	Register(Datasette{})
+}
+
+// Datasette renders rows as a SQLite database for hosting in Datasette: a
+// typed links table with each link's band and path length, indexes on
+// licence, band and licensee, full-text search on licensee and site names,
+// and summary views.
+type Datasette struct{}
+
+func (Datasette) Name() string        { return "datasette.sqlite" }
+func (Datasette) ContentType() string { return "application/vnd.sqlite3" }
+func (Datasette) usesSqlite3()        {}
+
+// datasetteViews summarise the links table.
+const datasetteViews = `CREATE INDEX links_licenceid ON links (licenceid);
+CREATE INDEX links_band ON links (band);
+CREATE INDEX links_clientname ON links (clientname);
+CREATE VIRTUAL TABLE links_fts USING fts5 (clientname, tx_name, rx_name, content = 'links', content_rowid = 'rowid');
+INSERT INTO links_fts (rowid, clientname, tx_name, rx_name) SELECT rowid, clientname, tx_name, rx_name FROM links;
+CREATE VIEW licensees AS
+  SELECT clientname, count(*) AS links, count(DISTINCT licenceid) AS licences, round(sum(path_km), 2) AS total_path_km
+  FROM links GROUP BY clientname ORDER BY links DESC;
+CREATE VIEW bands AS
+  SELECT band, count(*) AS links, count(DISTINCT clientname) AS licensees, min(path_km) AS min_path_km, max(path_km) AS max_path_km
+  FROM links GROUP BY band ORDER BY min(frequency);
+`
+
+func (Datasette) Convert(rows *Rows) (io.Reader, error) {
+	f, err := TempFile("prism.datasette.sqlite")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var script bytes.Buffer
+	if err := writeDatasetteSQL(&script, rows); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	c := exec.Command(Sqlite3Path, f.Name())
+	c.Stdin = &script
+	c.Stderr = &stderr
+	log.Printf("Writing Datasette database: running %v\n", c.String())
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("couldn't write Datasette database: %v, stderr: %v", err, stderr.String())
+	}
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read Datasette database: %v", err)
+	}
+	return bytes.NewReader(out), nil
+}
+
+// writeDatasetteSQL writes a sqlite3 script that builds the database.
+func writeDatasetteSQL(w io.Writer, rows *Rows) error {
+	links, err := rows.Links()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	types := make([]arrow.DataType, len(rows.Header))
+	cols := make([]string, 0, len(rows.Header)+2)
+	for i, h := range rows.Header {
+		types[i] = columnType(rows, i)
+		sqlType := "TEXT"
+		switch types[i] {
+		case arrow.PrimitiveTypes.Int64:
+			sqlType = "INTEGER"
+		case arrow.PrimitiveTypes.Float64:
+			sqlType = "REAL"
+		}
+		cols = append(cols, sqlIdent(h)+" "+sqlType)
+	}
+	cols = append(cols, "band TEXT", "path_km REAL")
+	fmt.Fprintf(bw, "BEGIN;\nCREATE TABLE links (%v);\n", strings.Join(cols, ", "))
+	for i, rec := range rows.Records {
+		vals := make([]string, 0, len(rec)+2)
+		for j, v := range rec {
+			switch {
+			case v == "":
+				vals = append(vals, "NULL")
+			case types[j] == arrow.BinaryTypes.String:
+				vals = append(vals, sqlString(v))
+			default:
+				vals = append(vals, v)
+			}
+		}
+		vals = append(vals, sqlString(links[i].Band()), strconv.FormatFloat(links[i].PathKm(), 'f', 3, 64))
+		fmt.Fprintf(bw, "INSERT INTO links VALUES (%v);\n", strings.Join(vals, ", "))
+	}
+	bw.WriteString(datasetteViews)
+	bw.WriteString("COMMIT;\n")
+	bw.WriteString(optimizeSQL())
+	return bw.Flush()
+}
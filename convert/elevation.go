@@ -0,0 +1,73 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/mhansen/nzwirelessmap-fetch/dem"
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+// Ground elevation columns, added by AddElevations.
+const (
+	colTxGround = "tx_ground_m"
+	colRxGround = "rx_ground_m"
+)
+
+// AddElevations adds the ground elevation in metres of each link's
+// endpoints, looked up in src. Elevations src doesn't have are left blank.
+func AddElevations(ctx context.Context, src dem.Source, rows *Rows) error {
+	links, err := rows.Links()
+	if err != nil {
+		return err
+	}
+	// Many links share sites, so look each point up once.
+	idx := make(map[geo.Point]int)
+	var pts []geo.Point
+	for _, l := range links {
+		for _, p := range []geo.Point{l.Tx, l.Rx} {
+			if _, ok := idx[p]; !ok {
+				idx[p] = len(pts)
+				pts = append(pts, p)
+			}
+		}
+	}
+	elev, err := src.Elevations(ctx, pts)
+	if err != nil {
+		return fmt.Errorf("couldn't look up elevations: %v", err)
+	}
+	format := func(p geo.Point) string {
+		v := elev[idx[p]]
+		if math.IsNaN(v) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	tx := make([]string, len(links))
+	rx := make([]string, len(links))
+	for i, l := range links {
+		tx[i], rx[i] = format(l.Tx), format(l.Rx)
+	}
+	rows.SetColumn(colTxGround, tx)
+	rows.SetColumn(colRxGround, rx)
+	return nil
+}
+
+// SetColumn sets the named column to vals, one per record, adding the
+// column if it's not already there.
+func (r *Rows) SetColumn(name string, vals []string) {
+	i := r.Column(name)
+	if i < 0 {
+		i = len(r.Header)
+		r.Header = append(r.Header, name)
+	}
+	for j, rec := range r.Records {
+		for len(rec) <= i {
+			rec = append(rec, "")
+		}
+		rec[i] = vals[j]
+		r.Records[j] = rec
+	}
+}
@@ -0,0 +1,148 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+func init() {
+	Register(GeoJSON{})
+}
+
+// GeoJSON renders each link as a LineString feature from transmitter to
+// receiver. Columns other than the coordinates become string properties.
+// If MergeBidirectional is set, a path licensed both ways is one feature,
+// with the reverse link's licence and frequency in reverse_licenceid and
+// reverse_frequency. Each feature also has mid_lat, mid_lng and label_angle
+// properties for placing a label along it.
+type GeoJSON struct{}
+
+func (GeoJSON) Name() string        { return "geojson" }
+func (GeoJSON) ContentType() string { return "application/geo+json" }
+
+// ArcThresholdKm is the length beyond which links are drawn as great-circle
+// arcs rather than straight lines, which on a Mercator map are visibly off
+// the true path for long links. 0 disables arcs.
+var ArcThresholdKm = 50.0
+
+// arcStepKm is the spacing of points along an arc.
+const arcStepKm = 5.0
+
+// Coordinate columns, as named by the query.
+const (
+	colTxLat = "tx_lat"
+	colTxLng = "tx_lng"
+	colRxLat = "rx_lat"
+	colRxLng = "rx_lng"
+)
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Geometry   geometry          `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+type geometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+// labelAnchor returns where to put a link's label: the midpoint of its path,
+// and the rotation in degrees clockwise from horizontal that lines text up
+// with the path on a Web Mercator map, kept within ±90° so it's never
+// upside down.
+func labelAnchor(l Link) (mid geo.Point, angle float64) {
+	x1, y1 := mercatorPx(l.Tx, 0)
+	x2, y2 := mercatorPx(l.Rx, 0)
+	// Screen y increases downwards, so atan2 already gives a clockwise angle.
+	angle = math.Atan2(y2-y1, x2-x1) * 180 / math.Pi
+	if angle > 90 {
+		angle -= 180
+	} else if angle < -90 {
+		angle += 180
+	}
+	return geo.Intermediate(l.Tx, l.Rx, 0.5), angle
+}
+
+// linkCoordinates returns the GeoJSON coordinates of a link's path.
+func linkCoordinates(l Link) [][2]float64 {
+	if ArcThresholdKm <= 0 || l.PathKm() <= ArcThresholdKm {
+		return [][2]float64{{l.Tx.Lng, l.Tx.Lat}, {l.Rx.Lng, l.Rx.Lat}}
+	}
+	pts := geo.Arc(l.Tx, l.Rx, arcStepKm)
+	coords := make([][2]float64, len(pts))
+	for i, p := range pts {
+		coords[i] = [2]float64{p.Lng, p.Lat}
+	}
+	return coords
+}
+
+// features builds a GeoJSON feature for each link, or each path if
+// MergeBidirectional is set.
+func features(rows *Rows) ([]feature, error) {
+	links, err := rows.Links()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([][]int, len(links))
+	if MergeBidirectional {
+		paths = PairBidirectional(links)
+	} else {
+		for i := range paths {
+			paths[i] = []int{i}
+		}
+	}
+	fs := []feature{}
+	for _, path := range paths {
+		// A merged path takes its properties from the first direction.
+		rec := rows.Records[path[0]]
+		props := make(map[string]string)
+		for j, h := range rows.Header {
+			switch h {
+			case colTxLat, colTxLng, colRxLat, colRxLng:
+				continue
+			}
+			if j < len(rec) {
+				props[h] = rec[j]
+			}
+		}
+		mid, angle := labelAnchor(links[path[0]])
+		props["mid_lat"] = strconv.FormatFloat(mid.Lat, 'f', 6, 64)
+		props["mid_lng"] = strconv.FormatFloat(mid.Lng, 'f', 6, 64)
+		props["label_angle"] = strconv.FormatFloat(angle, 'f', 1, 64)
+		if len(path) > 1 {
+			props["reverse_licenceid"] = links[path[1]].LicenceID
+			props["reverse_frequency"] = rows.Get(rows.Records[path[1]], "frequency")
+		}
+		fs = append(fs, feature{
+			Type:       "Feature",
+			Geometry:   geometry{Type: "LineString", Coordinates: linkCoordinates(links[path[0]])},
+			Properties: props,
+		})
+	}
+	return fs, nil
+}
+
+func (g GeoJSON) Convert(rows *Rows) (io.Reader, error) {
+	fs, err := features(rows)
+	if err != nil {
+		return nil, err
+	}
+	fc := featureCollection{Type: "FeatureCollection", Features: fs}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(fc); err != nil {
+		return nil, fmt.Errorf("couldn't encode GeoJSON: %v", err)
+	}
+	return &buf, nil
+}
@@ -0,0 +1,35 @@
+package convert
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+func TestLabelAnchor(t *testing.T) {
+	tests := []struct {
+		name   string
+		tx, rx geo.Point
+		angle  float64
+	}{
+		{name: "west to east", tx: geo.Point{Lat: -41, Lng: 174}, rx: geo.Point{Lat: -41, Lng: 175}, angle: 0},
+		{name: "east to west", tx: geo.Point{Lat: -41, Lng: 175}, rx: geo.Point{Lat: -41, Lng: 174}, angle: 0},
+		{name: "south to north", tx: geo.Point{Lat: -41, Lng: 175}, rx: geo.Point{Lat: -40, Lng: 175}, angle: -90},
+		{name: "north to south", tx: geo.Point{Lat: -40, Lng: 175}, rx: geo.Point{Lat: -41, Lng: 175}, angle: 90},
+		// Mercator is conformal, so equal small steps in latitude and
+		// longitude on the equator make a 45° line.
+		{name: "south-west to north-east", tx: geo.Point{Lat: 0, Lng: 0}, rx: geo.Point{Lat: 0.01, Lng: 0.01}, angle: -45},
+		{name: "north-east to south-west", tx: geo.Point{Lat: 0.01, Lng: 0.01}, rx: geo.Point{Lat: 0, Lng: 0}, angle: -45},
+		{name: "north-west to south-east", tx: geo.Point{Lat: 0.01, Lng: 0}, rx: geo.Point{Lat: 0, Lng: 0.01}, angle: 45},
+	}
+	for _, tt := range tests {
+		mid, angle := labelAnchor(Link{Tx: tt.tx, Rx: tt.rx})
+		if want := geo.Intermediate(tt.tx, tt.rx, 0.5); mid != want {
+			t.Errorf("%v: labelAnchor() mid = %v, want %v", tt.name, mid, want)
+		}
+		if math.Abs(angle-tt.angle) > 0.1 {
+			t.Errorf("%v: labelAnchor() angle = %v, want %v", tt.name, angle, tt.angle)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/apache/arrow/go/v16/arrow"
+)
+
+func init() {
+	Register(GeoPackage{})
+}
+
+// GeoPackage renders rows as an OGC GeoPackage with a "links" layer of
+// LineStrings in WGS84, for QGIS and ArcGIS. It's built by sqlite3, like
+// the intermediate database.
+type GeoPackage struct{}
+
+func (GeoPackage) Name() string        { return "gpkg" }
+func (GeoPackage) ContentType() string { return "application/geopackage+sqlite3" }
+func (GeoPackage) usesSqlite3()        {}
+
+// gpkgSchema creates the GeoPackage metadata tables, per the GeoPackage
+// 1.3 specification.
+const gpkgSchema = `PRAGMA application_id = 1196444487;
+PRAGMA user_version = 10300;
+CREATE TABLE gpkg_spatial_ref_sys (
+  srs_name TEXT NOT NULL,
+  srs_id INTEGER PRIMARY KEY,
+  organization TEXT NOT NULL,
+  organization_coordsys_id INTEGER NOT NULL,
+  definition TEXT NOT NULL,
+  description TEXT
+);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+  ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+  ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+  ('WGS 84 geodetic', 4326, 'EPSG', 4326, '` + wgs84WKT + `', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid');
+CREATE TABLE gpkg_contents (
+  table_name TEXT NOT NULL PRIMARY KEY,
+  data_type TEXT NOT NULL,
+  identifier TEXT UNIQUE,
+  description TEXT DEFAULT '',
+  last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE,
+  srs_id INTEGER,
+  CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+CREATE TABLE gpkg_geometry_columns (
+  table_name TEXT NOT NULL,
+  column_name TEXT NOT NULL,
+  geometry_type_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL,
+  z TINYINT NOT NULL,
+  m TINYINT NOT NULL,
+  CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+  CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+  CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id)
+);
+`
+
+// wgs84WKT defines EPSG:4326, for the .prj of shapefiles too.
+const wgs84WKT = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]`
+
+func (GeoPackage) Convert(rows *Rows) (io.Reader, error) {
+	f, err := TempFile("prism.gpkg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var script bytes.Buffer
+	if err := writeGeoPackageSQL(&script, rows); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	c := exec.Command(Sqlite3Path, f.Name())
+	c.Stdin = &script
+	c.Stderr = &stderr
+	log.Printf("Writing GeoPackage: running %v\n", c.String())
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("couldn't write GeoPackage: %v, stderr: %v", err, stderr.String())
+	}
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read GeoPackage: %v", err)
+	}
+	return bytes.NewReader(out), nil
+}
+
+// writeGeoPackageSQL writes a sqlite3 script that builds the GeoPackage.
+func writeGeoPackageSQL(w io.Writer, rows *Rows) error {
+	links, err := rows.Links()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(gpkgSchema)
+
+	types := make([]arrow.DataType, len(rows.Header))
+	cols := []string{"fid INTEGER PRIMARY KEY AUTOINCREMENT", "geom LINESTRING"}
+	for i, h := range rows.Header {
+		types[i] = columnType(rows, i)
+		sqlType := "TEXT"
+		switch types[i] {
+		case arrow.PrimitiveTypes.Int64:
+			sqlType = "INTEGER"
+		case arrow.PrimitiveTypes.Float64:
+			sqlType = "REAL"
+		}
+		cols = append(cols, sqlIdent(h)+" "+sqlType)
+	}
+	fmt.Fprintf(bw, "BEGIN;\nCREATE TABLE links (%v);\n", strings.Join(cols, ", "))
+
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for i, rec := range rows.Records {
+		coords := linkCoordinates(links[i])
+		for _, c := range coords {
+			minX, maxX = math.Min(minX, c[0]), math.Max(maxX, c[0])
+			minY, maxY = math.Min(minY, c[1]), math.Max(maxY, c[1])
+		}
+		vals := []string{"X'" + hex.EncodeToString(gpkgLineString(coords)) + "'"}
+		for j, v := range rec {
+			switch {
+			case v == "":
+				vals = append(vals, "NULL")
+			case types[j] == arrow.BinaryTypes.String:
+				vals = append(vals, sqlString(v))
+			default:
+				vals = append(vals, v)
+			}
+		}
+		fmt.Fprintf(bw, "INSERT INTO links (geom, %v) VALUES (%v);\n", sqlIdents(rows.Header), strings.Join(vals, ", "))
+	}
+	if len(links) == 0 {
+		minX, minY, maxX, maxY = 0, 0, 0, 0
+	}
+	fmt.Fprintf(bw, "INSERT INTO gpkg_contents (table_name, data_type, identifier, description, min_x, min_y, max_x, max_y, srs_id) VALUES ('links', 'features', 'links', 'Point-to-point radio links from the RSM PRISM database', %v, %v, %v, %v, 4326);\n", minX, minY, maxX, maxY)
+	bw.WriteString("INSERT INTO gpkg_geometry_columns VALUES ('links', 'geom', 'LINESTRING', 4326, 0, 0);\nCOMMIT;\n")
+	bw.WriteString(optimizeSQL())
+	return bw.Flush()
+}
+
+// gpkgLineString encodes a LineString as a GeoPackage geometry blob: a
+// header with the envelope, then little-endian WKB.
+func gpkgLineString(coords [][2]float64) []byte {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, c := range coords {
+		minX, maxX = math.Min(minX, c[0]), math.Max(maxX, c[0])
+		minY, maxY = math.Min(minY, c[1]), math.Max(maxY, c[1])
+	}
+	var b bytes.Buffer
+	// Magic, version 0, then flags: little-endian, with an XY envelope.
+	b.Write([]byte{'G', 'P', 0, 0x03})
+	binary.Write(&b, binary.LittleEndian, int32(4326))
+	binary.Write(&b, binary.LittleEndian, []float64{minX, maxX, minY, maxY})
+	// WKB: little-endian LineString.
+	b.WriteByte(1)
+	binary.Write(&b, binary.LittleEndian, uint32(2))
+	binary.Write(&b, binary.LittleEndian, uint32(len(coords)))
+	for _, c := range coords {
+		binary.Write(&b, binary.LittleEndian, c)
+	}
+	return b.Bytes()
+}
+
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func sqlIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func sqlIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = sqlIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,32 @@
+package convert
+
+import (
+	"strconv"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+// AddGridRefs adds the NZTM2000 easting and northing of each link's
+// endpoints, in whole metres, for people who work from Topo50 maps rather
+// than in degrees.
+func AddGridRefs(rows *Rows) error {
+	links, err := rows.Links()
+	if err != nil {
+		return err
+	}
+	cols := make([][]string, 4)
+	for i := range cols {
+		cols[i] = make([]string, len(links))
+	}
+	for i, l := range links {
+		for j, p := range []geo.Point{l.Tx, l.Rx} {
+			e, n := geo.NZTM(p)
+			cols[2*j][i] = strconv.FormatFloat(e, 'f', 0, 64)
+			cols[2*j+1][i] = strconv.FormatFloat(n, 'f', 0, 64)
+		}
+	}
+	for i, name := range []string{"tx_nztm_e", "tx_nztm_n", "rx_nztm_e", "rx_nztm_n"} {
+		rows.SetColumn(name, cols[i])
+	}
+	return nil
+}
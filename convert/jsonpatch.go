@@ -0,0 +1,201 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxPatchEdits bounds how many rows JSONPatch will add or remove. Past
+// this, the snapshots are too different for a patch to be worth it, and
+// finding the diff would take quadratic memory.
+var MaxPatchEdits = 5000
+
+// ErrTooDifferent is returned by JSONPatch when the snapshots differ by more
+// than MaxPatchEdits rows.
+var ErrTooDifferent = errors.New("snapshots are too different to patch")
+
+// PatchOp is an RFC 6902 JSON Patch operation.
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch returns a JSON Patch that turns prev into cur, both lists of
+// rows as in prism.json. Rows are matched up with a Myers diff, and rows
+// that changed in place are patched field by field.
+func JSONPatch(prev, cur []map[string]string) ([]PatchOp, error) {
+	a, b := make([]string, len(prev)), make([]string, len(cur))
+	for i, row := range prev {
+		a[i] = rowKey(row)
+	}
+	for i, row := range cur {
+		b[i] = rowKey(row)
+	}
+	script, err := myers(a, b, MaxPatchEdits)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []PatchOp{}
+	// pos is the index in the array as patched so far.
+	pos, i, j := 0, 0, 0
+	for k := 0; k < len(script); {
+		if script[k] == editEqual {
+			pos, i, j, k = pos+1, i+1, j+1, k+1
+			continue
+		}
+		// A hunk of deletions and insertions. Pair them up as changes in
+		// place, then remove or add whatever's left over.
+		var dels, ins int
+		for ; k < len(script) && script[k] != editEqual; k++ {
+			if script[k] == editDelete {
+				dels++
+			} else {
+				ins++
+			}
+		}
+		for n := 0; n < min(dels, ins); n++ {
+			ops = append(ops, fieldOps(pos, prev[i], cur[j])...)
+			pos, i, j = pos+1, i+1, j+1
+		}
+		for n := ins; n < dels; n++ {
+			ops = append(ops, PatchOp{Op: "remove", Path: fmt.Sprintf("/%v", pos)})
+			i++
+		}
+		for n := dels; n < ins; n++ {
+			v, err := json.Marshal(cur[j])
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, PatchOp{Op: "add", Path: fmt.Sprintf("/%v", pos), Value: v})
+			pos, j = pos+1, j+1
+		}
+	}
+	return ops, nil
+}
+
+// rowKey identifies a row's contents. Map keys are marshalled in order.
+func rowKey(row map[string]string) string {
+	b, _ := json.Marshal(row)
+	return string(b)
+}
+
+// fieldOps patches the row at index pos from one row to another.
+func fieldOps(pos int, from, to map[string]string) []PatchOp {
+	var keys []string
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var ops []PatchOp
+	for _, k := range keys {
+		path := fmt.Sprintf("/%v/%v", pos, escapePointer(k))
+		old, inFrom := from[k]
+		v, inTo := to[k]
+		switch {
+		case !inTo:
+			ops = append(ops, PatchOp{Op: "remove", Path: path})
+		case !inFrom:
+			b, _ := json.Marshal(v)
+			ops = append(ops, PatchOp{Op: "add", Path: path, Value: b})
+		case old != v:
+			b, _ := json.Marshal(v)
+			ops = append(ops, PatchOp{Op: "replace", Path: path, Value: b})
+		}
+	}
+	return ops
+}
+
+// escapePointer escapes a key for use in a JSON Pointer (RFC 6901).
+func escapePointer(k string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+}
+
+type edit int8
+
+const (
+	editEqual edit = iota
+	editDelete
+	editInsert
+)
+
+// myers returns the shortest edit script turning a into b, failing if it's
+// longer than max edits.
+func myers(a, b []string, max int) ([]edit, error) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] is v after d edits, for backtracking.
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > max {
+			return nil, ErrTooDifferent
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(trace, n, m), nil
+			}
+		}
+		// Only the diagonals reachable in d edits are needed later.
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+	return nil, ErrTooDifferent
+}
+
+// backtrack recovers the edit script from the trace of a Myers search.
+// trace[d] holds diagonals -d..d.
+func backtrack(trace [][]int, n, m int) []edit {
+	var script []edit
+	x, y := n, m
+	get := func(d, k int) int { return trace[d][k+d] }
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(d-1, k-1) < get(d-1, k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(d-1, prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			script = append(script, editEqual)
+			x, y = x-1, y-1
+		}
+		if x == prevX {
+			script = append(script, editInsert)
+		} else {
+			script = append(script, editDelete)
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		script = append(script, editEqual)
+		x, y = x-1, y-1
+	}
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
@@ -0,0 +1,135 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyPatch applies the add, remove and replace operations JSONPatch
+// emits to rows, as an RFC 6902 implementation would.
+func applyPatch(rows []map[string]string, ops []PatchOp) ([]map[string]string, error) {
+	out := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		out[i] = make(map[string]string)
+		for k, v := range row {
+			out[i][k] = v
+		}
+	}
+	for _, op := range ops {
+		parts := strings.Split(strings.TrimPrefix(op.Path, "/"), "/")
+		i, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad path %q", op.Path)
+		}
+		switch {
+		case len(parts) == 1 && op.Op == "add":
+			var row map[string]string
+			if err := json.Unmarshal(op.Value, &row); err != nil {
+				return nil, err
+			}
+			if i > len(out) {
+				return nil, fmt.Errorf("add at %v past the end", i)
+			}
+			out = append(out[:i], append([]map[string]string{row}, out[i:]...)...)
+		case len(parts) == 1 && op.Op == "remove":
+			if i >= len(out) {
+				return nil, fmt.Errorf("remove at %v past the end", i)
+			}
+			out = append(out[:i], out[i+1:]...)
+		case len(parts) == 2:
+			if i >= len(out) {
+				return nil, fmt.Errorf("%v at %v past the end", op.Op, op.Path)
+			}
+			k := strings.ReplaceAll(strings.ReplaceAll(parts[1], "~1", "/"), "~0", "~")
+			_, exists := out[i][k]
+			switch op.Op {
+			case "remove":
+				if !exists {
+					return nil, fmt.Errorf("remove of missing %v", op.Path)
+				}
+				delete(out[i], k)
+			case "add", "replace":
+				if exists != (op.Op == "replace") {
+					return nil, fmt.Errorf("%v of %v, which exists: %v", op.Op, op.Path, exists)
+				}
+				var v string
+				if err := json.Unmarshal(op.Value, &v); err != nil {
+					return nil, err
+				}
+				out[i][k] = v
+			default:
+				return nil, fmt.Errorf("unknown op %q", op.Op)
+			}
+		default:
+			return nil, fmt.Errorf("unknown op %q at %q", op.Op, op.Path)
+		}
+	}
+	return out, nil
+}
+
+func link(id, freq string) map[string]string {
+	return map[string]string{"licenceid": id, "frequency": freq}
+}
+
+func TestJSONPatchRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur []map[string]string
+		// maxOps bounds the size of the patch, to check that rows are
+		// matched up rather than replaced wholesale.
+		maxOps int
+	}{
+		{name: "identical", prev: []map[string]string{link("1", "7500"), link("2", "7600")}, cur: []map[string]string{link("1", "7500"), link("2", "7600")}, maxOps: 0},
+		{name: "from nothing", prev: nil, cur: []map[string]string{link("1", "7500")}, maxOps: 1},
+		{name: "to nothing", prev: []map[string]string{link("1", "7500"), link("2", "7600")}, cur: nil, maxOps: 2},
+		{name: "appended", prev: []map[string]string{link("1", "7500")}, cur: []map[string]string{link("1", "7500"), link("2", "7600")}, maxOps: 1},
+		{name: "inserted in the middle", prev: []map[string]string{link("1", "a"), link("3", "c")}, cur: []map[string]string{link("1", "a"), link("2", "b"), link("3", "c")}, maxOps: 1},
+		{name: "removed from the middle", prev: []map[string]string{link("1", "a"), link("2", "b"), link("3", "c")}, cur: []map[string]string{link("1", "a"), link("3", "c")}, maxOps: 1},
+		{name: "changed in place", prev: []map[string]string{link("1", "a"), link("2", "b"), link("3", "c")}, cur: []map[string]string{link("1", "a"), link("2", "x"), link("3", "c")}, maxOps: 1},
+		{name: "field added and removed", prev: []map[string]string{{"licenceid": "1", "old": "v"}}, cur: []map[string]string{{"licenceid": "1", "new": "v"}}, maxOps: 2},
+		{name: "keys needing escapes", prev: []map[string]string{{"a/b": "1", "c~d": "2"}}, cur: []map[string]string{{"a/b": "3", "c~d": "4"}}, maxOps: 2},
+		{name: "reordered", prev: []map[string]string{link("1", "a"), link("2", "b"), link("3", "c")}, cur: []map[string]string{link("3", "c"), link("1", "a"), link("2", "b")}, maxOps: 2},
+		{name: "more removed than added", prev: []map[string]string{link("1", "a"), link("2", "b"), link("3", "c")}, cur: []map[string]string{link("4", "d")}, maxOps: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, err := JSONPatch(tt.prev, tt.cur)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ops) > tt.maxOps {
+				t.Errorf("patch has %v ops, want at most %v: %+v", len(ops), tt.maxOps, ops)
+			}
+			got, err := applyPatch(tt.prev, ops)
+			if err != nil {
+				t.Fatalf("couldn't apply %+v: %v", ops, err)
+			}
+			want := tt.cur
+			if want == nil {
+				want = []map[string]string{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patched to %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchTooDifferent(t *testing.T) {
+	defer func(n int) { MaxPatchEdits = n }(MaxPatchEdits)
+	MaxPatchEdits = 2
+	prev := []map[string]string{link("1", "a"), link("2", "b")}
+	cur := []map[string]string{link("3", "c"), link("4", "d")}
+	if _, err := JSONPatch(prev, cur); !errors.Is(err, ErrTooDifferent) {
+		t.Errorf("JSONPatch() = %v, want ErrTooDifferent", err)
+	}
+	// Within the limit, it still works.
+	if _, err := JSONPatch(prev, prev[:1]); err != nil {
+		t.Errorf("JSONPatch() = %v, want a patch", err)
+	}
+}
@@ -0,0 +1,78 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+func init() {
+	Register(LicenseeSummary{})
+}
+
+// LicenseeSummary groups links by licensee, to answer questions like who
+// operates the most links.
+type LicenseeSummary struct{}
+
+func (LicenseeSummary) Name() string        { return "licensees.json" }
+func (LicenseeSummary) ContentType() string { return "application/json" }
+
+type licensee struct {
+	Name  string `json:"name"`
+	Links int    `json:"links"`
+	// Licences counts distinct licences: a licence can cover several links.
+	Licences    int      `json:"licences"`
+	Bands       []string `json:"bands"`
+	TotalPathKm float64  `json:"total_path_km"`
+
+	licences map[string]bool
+	bands    map[string]bool
+}
+
+// Convert outputs a JSON array of licensees, most links first.
+func (LicenseeSummary) Convert(rows *Rows) (io.Reader, error) {
+	links, err := rows.Links()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*licensee)
+	for _, l := range links {
+		s := byName[l.ClientName]
+		if s == nil {
+			s = &licensee{Name: l.ClientName, licences: make(map[string]bool), bands: make(map[string]bool)}
+			byName[l.ClientName] = s
+		}
+		s.Links++
+		s.licences[l.LicenceID] = true
+		s.bands[l.Band()] = true
+		s.TotalPathKm += l.PathKm()
+	}
+
+	out := []*licensee{}
+	for _, s := range byName {
+		s.Licences = len(s.licences)
+		// Keep bands in frequency order.
+		for _, b := range BandNames() {
+			if s.bands[b] {
+				s.Bands = append(s.Bands, b)
+			}
+		}
+		s.TotalPathKm = math.Round(s.TotalPathKm*100) / 100
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Links != out[j].Links {
+			return out[i].Links > out[j].Links
+		}
+		return out[i].Name < out[j].Name
+	})
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(out); err != nil {
+		return nil, fmt.Errorf("couldn't encode licensees: %v", err)
+	}
+	return &buf, nil
+}
@@ -0,0 +1,69 @@
+package convert
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+// Link is a typed view of one row of the query's output.
+type Link struct {
+	LicenceID  string
+	ClientName string
+	// FrequencyMHz is 0 if the row has no frequency.
+	FrequencyMHz float64
+	Tx, Rx       geo.Point
+}
+
+// PathKm is the great-circle length of the link.
+func (l Link) PathKm() float64 {
+	return geo.DistanceKm(l.Tx, l.Rx)
+}
+
+// Band names the frequency band the link is in.
+func (l Link) Band() string {
+	return BandOf(l.FrequencyMHz)
+}
+
+// Links parses every row into a Link.
+func (r *Rows) Links() ([]Link, error) {
+	links := make([]Link, len(r.Records))
+	for i, rec := range r.Records {
+		l, err := r.Link(rec)
+		if err != nil {
+			return nil, fmt.Errorf("row %v: %v", i+1, err)
+		}
+		links[i] = l
+	}
+	return links, nil
+}
+
+// Link parses rec into a Link.
+func (r *Rows) Link(rec []string) (Link, error) {
+	l := Link{
+		LicenceID:  r.Get(rec, "licenceid"),
+		ClientName: r.Get(rec, "clientname"),
+	}
+	if f := r.Get(rec, "frequency"); f != "" {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return Link{}, fmt.Errorf("bad frequency: %v", err)
+		}
+		l.FrequencyMHz = v
+	}
+	for _, c := range []struct {
+		col string
+		v   *float64
+	}{
+		{colTxLat, &l.Tx.Lat}, {colTxLng, &l.Tx.Lng},
+		{colRxLat, &l.Rx.Lat}, {colRxLng, &l.Rx.Lng},
+	} {
+		v, err := strconv.ParseFloat(r.Get(rec, c.col), 64)
+		if err != nil {
+			return Link{}, fmt.Errorf("bad %v: %v", c.col, err)
+		}
+		*c.v = v
+	}
+	return l, nil
+}
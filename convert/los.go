@@ -0,0 +1,166 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/mhansen/nzwirelessmap-fetch/dem"
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+const (
+	// losStepKm is the spacing of terrain samples along a path, and
+	// losMaxSamples caps how many a long path gets.
+	losStepKm     = 0.1
+	losMaxSamples = 200
+	// losBatch is how many links' samples are looked up at once, to bound
+	// memory.
+	losBatch = 500
+	// kFactor is the standard effective Earth radius factor for
+	// refraction.
+	kFactor = 4.0 / 3
+	// fresnelClearance is the fraction of the first Fresnel zone that must
+	// be clear for a path to count as clear.
+	fresnelClearance = 0.6
+)
+
+// Line of sight verdicts.
+const (
+	LOSClear      = "clear"
+	LOSPartial    = "fresnel_obstructed"
+	LOSObstructed = "obstructed"
+	LOSUnknown    = "unknown"
+)
+
+// LineOfSight checks each link's path against terrain from a DEM, for RF
+// planners. It's only available when a DEM is configured, so it isn't
+// registered by default.
+type LineOfSight struct {
+	DEM dem.Source
+}
+
+func (LineOfSight) Name() string        { return "los.csv" }
+func (LineOfSight) ContentType() string { return "text/csv" }
+
+// losResult is the terrain clearance of one link.
+type losResult struct {
+	// minClearanceM is the least height of the line of sight above the
+	// terrain, allowing for the Earth's curvature.
+	minClearanceM float64
+	// fresnelRatio is the least clearance as a fraction of the first
+	// Fresnel zone's radius.
+	fresnelRatio float64
+	verdict      string
+}
+
+// Convert outputs a CSV row for each link, with its clearance and verdict.
+// Links without antenna heights, a frequency or terrain data get an
+// "unknown" verdict.
+func (c LineOfSight) Convert(rows *Rows) (io.Reader, error) {
+	links, err := rows.Links()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"licenceid", "tx_name", "rx_name", "path_km", "frequency", "min_clearance_m", "fresnel_clearance", "verdict"})
+	for start := 0; start < len(links); start += losBatch {
+		end := min(start+losBatch, len(links))
+		results, err := c.check(context.Background(), rows, links[start:end], rows.Records[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for i, res := range results {
+			l, rec := links[start+i], rows.Records[start+i]
+			out := []string{
+				l.LicenceID, rows.Get(rec, "tx_name"), rows.Get(rec, "rx_name"),
+				strconv.FormatFloat(l.PathKm(), 'f', 2, 64), rows.Get(rec, "frequency"),
+				"", "", res.verdict,
+			}
+			if res.verdict != LOSUnknown {
+				out[5] = strconv.FormatFloat(res.minClearanceM, 'f', 1, 64)
+				out[6] = strconv.FormatFloat(res.fresnelRatio, 'f', 2, 64)
+			}
+			w.Write(out)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("couldn't write line of sight analysis: %v", err)
+	}
+	return &buf, nil
+}
+
+// check looks up the terrain along each link's path and checks it.
+func (c LineOfSight) check(ctx context.Context, rows *Rows, links []Link, recs [][]string) ([]losResult, error) {
+	paths := make([][]geo.Point, len(links))
+	var pts []geo.Point
+	for i, l := range links {
+		n := min(losMaxSamples, max(2, int(math.Ceil(l.PathKm()/losStepKm))+1))
+		for j := 0; j < n; j++ {
+			paths[i] = append(paths[i], geo.Intermediate(l.Tx, l.Rx, float64(j)/float64(n-1)))
+		}
+		pts = append(pts, paths[i]...)
+	}
+	elev, err := c.DEM.Elevations(ctx, pts)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't look up terrain: %v", err)
+	}
+	results := make([]losResult, len(links))
+	for i, l := range links {
+		terrain := elev[:len(paths[i])]
+		elev = elev[len(paths[i]):]
+		results[i] = checkPath(l, rows.Get(recs[i], "tx_antenna_height"), rows.Get(recs[i], "rx_antenna_height"), terrain)
+	}
+	return results, nil
+}
+
+// checkPath works out the clearance of a link over terrain, which is sampled
+// evenly from the transmitter to the receiver.
+func checkPath(l Link, txHeight, rxHeight string, terrain []float64) losResult {
+	unknown := losResult{verdict: LOSUnknown}
+	txH, err1 := strconv.ParseFloat(txHeight, 64)
+	rxH, err2 := strconv.ParseFloat(rxHeight, 64)
+	if err1 != nil || err2 != nil || l.FrequencyMHz <= 0 {
+		return unknown
+	}
+	for _, t := range terrain {
+		if math.IsNaN(t) {
+			return unknown
+		}
+	}
+	d := l.PathKm()
+	n := len(terrain)
+	txAlt, rxAlt := terrain[0]+txH, terrain[n-1]+rxH
+	res := losResult{minClearanceM: math.Inf(1), fresnelRatio: math.Inf(1)}
+	// The endpoints are the antennas themselves, so only check between them.
+	for j := 1; j < n-1; j++ {
+		d1 := d * float64(j) / float64(n-1)
+		d2 := d - d1
+		bulge := d1 * d2 * 1000 / (2 * kFactor * geo.EarthRadiusKm)
+		line := txAlt + (rxAlt-txAlt)*d1/d
+		clearance := line - terrain[j] - bulge
+		// The first Fresnel zone radius in metres, with distances in km
+		// and frequency in GHz.
+		r1 := 17.32 * math.Sqrt(d1*d2/(l.FrequencyMHz/1000*d))
+		res.minClearanceM = math.Min(res.minClearanceM, clearance)
+		res.fresnelRatio = math.Min(res.fresnelRatio, clearance/r1)
+	}
+	switch {
+	case n <= 2:
+		// Too short to have terrain in the way.
+		res.minClearanceM, res.fresnelRatio, res.verdict = math.Min(txH, rxH), 1, LOSClear
+	case res.minClearanceM < 0:
+		res.verdict = LOSObstructed
+	case res.fresnelRatio < fresnelClearance:
+		res.verdict = LOSPartial
+	default:
+		res.verdict = LOSClear
+	}
+	return res
+}
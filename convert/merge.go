@@ -0,0 +1,36 @@
+package convert
+
+import "github.com/mhansen/nzwirelessmap-fetch/geo"
+
+// MergeBidirectional makes the GeoJSON converters draw a path once when
+// it's licensed in both directions, A→B and B→A, rather than as two
+// overlapping features.
+var MergeBidirectional = false
+
+// PairBidirectional groups links into paths: each link is paired with at
+// most one link going the opposite way between the same endpoints. It
+// returns the indexes of the links in each path, in order of their first
+// link.
+func PairBidirectional(links []Link) [][]int {
+	type path struct{ from, to geo.Point }
+	// unpaired holds the links in each direction still waiting for a
+	// partner.
+	unpaired := make(map[path][]int)
+	pathOf := make([]int, len(links))
+	var paths [][]int
+	for i, l := range links {
+		rev := path{l.Rx, l.Tx}
+		if waiting := unpaired[rev]; len(waiting) > 0 {
+			j := waiting[0]
+			unpaired[rev] = waiting[1:]
+			paths[pathOf[j]] = append(paths[pathOf[j]], i)
+			pathOf[i] = pathOf[j]
+			continue
+		}
+		fwd := path{l.Tx, l.Rx}
+		unpaired[fwd] = append(unpaired[fwd], i)
+		pathOf[i] = len(paths)
+		paths = append(paths, []int{i})
+	}
+	return paths
+}
@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+func TestPairBidirectional(t *testing.T) {
+	a, b, c := geo.Point{Lat: -41, Lng: 174}, geo.Point{Lat: -41.1, Lng: 174.5}, geo.Point{Lat: -40, Lng: 175}
+	ab, ba, bc, ac := Link{Tx: a, Rx: b}, Link{Tx: b, Rx: a}, Link{Tx: b, Rx: c}, Link{Tx: a, Rx: c}
+	tests := []struct {
+		name  string
+		links []Link
+		want  [][]int
+	}{
+		{name: "none", links: nil, want: nil},
+		{name: "one way", links: []Link{ab}, want: [][]int{{0}}},
+		{name: "both ways", links: []Link{ab, ba}, want: [][]int{{0, 1}}},
+		{name: "reverse first", links: []Link{ba, ab}, want: [][]int{{0, 1}}},
+		{name: "other links between", links: []Link{ab, bc, ac, ba}, want: [][]int{{0, 3}, {1}, {2}}},
+		{name: "same way twice", links: []Link{ab, ab}, want: [][]int{{0}, {1}}},
+		// Each link pairs with at most one going the other way, in order.
+		{name: "two each way", links: []Link{ab, ab, ba, ba}, want: [][]int{{0, 2}, {1, 3}}},
+		{name: "three one way", links: []Link{ab, ba, ab}, want: [][]int{{0, 1}, {2}}},
+		{name: "loop", links: []Link{{Tx: a, Rx: a}, {Tx: a, Rx: a}}, want: [][]int{{0, 1}}},
+	}
+	for _, tt := range tests {
+		if got := PairBidirectional(tt.links); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: PairBidirectional() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFeaturesMergeBidirectional(t *testing.T) {
+	rows := &Rows{
+		Header: []string{"licenceid", "frequency", colTxLat, colTxLng, colRxLat, colRxLng},
+		Records: [][]string{
+			{"1", "7000", "-41", "174", "-41.1", "174.5"},
+			{"2", "18000", "-41", "174", "-40", "175"},
+			{"3", "7100", "-41.1", "174.5", "-41", "174"},
+		},
+	}
+	tests := []struct {
+		merge bool
+		// want are the licence and reverse licence of each feature.
+		want [][2]string
+	}{
+		{merge: false, want: [][2]string{{"1", ""}, {"2", ""}, {"3", ""}}},
+		{merge: true, want: [][2]string{{"1", "3"}, {"2", ""}}},
+	}
+	defer func(m bool) { MergeBidirectional = m }(MergeBidirectional)
+	for _, tt := range tests {
+		MergeBidirectional = tt.merge
+		fs, err := features(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][2]string
+		for _, f := range fs {
+			got = append(got, [2]string{f.Properties["licenceid"], f.Properties["reverse_licenceid"]})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("merge %v: features() = %v, want %v", tt.merge, got, tt.want)
+		}
+		if tt.merge && fs[0].Properties["reverse_frequency"] != "7100" {
+			t.Errorf("merge %v: reverse_frequency = %q, want 7100", tt.merge, fs[0].Properties["reverse_frequency"])
+		}
+	}
+}
@@ -0,0 +1,433 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/mhansen/nzwirelessmap-fetch/mdb"
+)
+
+// The native conversion reads prism.mdb with package mdb and runs the
+// point-to-point links query in Go, instead of converting to sqlite3 with
+// mdb-sqlite and querying with the sqlite3 CLI. Its output is meant to
+// match the legacy pipeline's: values are formatted as sqlite3 prints them
+// after mdb-sqlite's conversion, and the JSON is laid out as Python's
+// json.dump lays it out. It's opt-in, with server.Config.NativeConvert,
+// until package mdb has been checked against a real prism.mdb.
+
+// queryLinksSQLHash is the QueryHash of the QueryFile that QueryLinks
+// implements. TestQueryLinksImplementsQueryFile fails when QueryFile is
+// edited, until QueryLinks is changed to match and this is updated.
+const queryLinksSQLHash = "fdcd7320d54da218ecf7bcb3968ab255c5bd2a012e89ece5bbdaf2d8d9bffcc1"
+
+// NativeQueryHash identifies what QueryLinks extracts. It's the hash of the
+// SQL it implements, so manifests don't change, and /reprocess doesn't
+// rebuild every snapshot, when switching between conversions.
+func NativeQueryHash() string {
+	return queryLinksSQLHash
+}
+
+// linkTables are the columns of each PRISM table that QueryLinks reads.
+var linkTables = map[string][]string{
+	"receiveconfiguration":  {"licenceid", "locationid", "rxantennaheight"},
+	"transmitconfiguration": {"licenceid", "locationid", "txantennaheight"},
+	"location":              {"locationid", "locationname"},
+	"geographicreference":   {"locationid", "georeferencetypeid", "easting", "northing"},
+	"licence":               {"licenceid", "clientid", "licencetype", "licencecode"},
+	"clientname":            {"clientid", "name"},
+	"spectrum":              {"licenceid", "spectrumstatus", "frequency", "power", "polarisation"},
+	"emission":              {"licenceid", "emission"},
+}
+
+// linkHeader names QueryLinks' columns, as QueryFile names its own.
+var linkHeader = []string{
+	"licenceid", "clientname", "licencetype", "frequency", "power",
+	"tx_name", "tx_lng", "tx_lat",
+	"rx_name", "rx_lng", "rx_lat",
+	"polarisation", "emission", "status", "tx_antenna_height", "rx_antenna_height",
+}
+
+// Table holds some of the columns of a PRISM table. Values are as package
+// mdb reads them.
+type Table struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Tables maps the names of PRISM tables, in lower case, to their contents.
+type Tables map[string]*Table
+
+// col returns the index of the named column, or -1 if there isn't one.
+func (t *Table) col(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// index groups rows by their value of col, skipping nulls and rows keep
+// rejects.
+func (t *Table) index(col string, keep func(row []interface{}) bool) map[string][][]interface{} {
+	i := t.col(col)
+	idx := make(map[string][][]interface{})
+	for _, row := range t.Rows {
+		k, ok := joinKey(row[i])
+		if ok && (keep == nil || keep(row)) {
+			idx[k] = append(idx[k], row)
+		}
+	}
+	return idx
+}
+
+// ReadTables reads the columns QueryLinks needs from prism.mdb. Only those
+// columns are held in memory.
+func ReadTables(db *mdb.DB) (Tables, error) {
+	tables := make(Tables)
+	for name, want := range linkTables {
+		t, err := db.Table(name)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read prism.mdb: %v", err)
+		}
+		cols := make([]*mdb.Column, len(want))
+		for i, c := range want {
+			if cols[i] = t.Column(c); cols[i] == nil {
+				return nil, fmt.Errorf("couldn't read prism.mdb: no column %v.%v", name, c)
+			}
+		}
+		tbl := &Table{Columns: want}
+		if err := t.Scan(cols, func(vals []interface{}) error {
+			tbl.Rows = append(tbl.Rows, append([]interface{}(nil), vals...))
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("couldn't read prism.mdb: %v", err)
+		}
+		log.Printf("read %v rows from %v", len(tbl.Rows), name)
+		tables[name] = tbl
+	}
+	return tables, nil
+}
+
+// site is a location with WGS84 coordinates.
+type site struct {
+	name, lng, lat string
+}
+
+// licenceRow is the licence, client and spectrum columns of a link.
+type licenceRow struct {
+	id, client, typ, status, frequency, power, polarisation string
+}
+
+// QueryLinks finds the point-to-point links in PRISM's tables, as the SQL in
+// QueryFile does: see it for what's selected and why.
+func QueryLinks(t Tables) (*Rows, error) {
+	for name, want := range linkTables {
+		tbl, ok := t[name]
+		if !ok {
+			return nil, fmt.Errorf("couldn't query links: no table %v", name)
+		}
+		for _, c := range want {
+			if tbl.col(c) < 0 {
+				return nil, fmt.Errorf("couldn't query links: no column %v.%v", name, c)
+			}
+		}
+	}
+	rx, tx := t["receiveconfiguration"], t["transmitconfiguration"]
+	lic, client, spec := t["licence"], t["clientname"], t["spectrum"]
+	loc, geo, em := t["location"], t["geographicreference"], t["emission"]
+
+	// Each location joined with its WGS84 coordinates (georeferencetypeid
+	// 3), leaving out those on the equator.
+	geoType, northing := geo.col("georeferencetypeid"), geo.col("northing")
+	geoByLoc := geo.index("locationid", func(row []interface{}) bool {
+		n, ok := number(row[northing])
+		g, gok := number(row[geoType])
+		return gok && g == 3 && ok && n != 0
+	})
+	sites := make(map[string][]site)
+	for k, locs := range loc.index("locationid", nil) {
+		for _, l := range locs {
+			for _, g := range geoByLoc[k] {
+				sites[k] = append(sites[k], site{
+					name: trim(l[loc.col("locationname")]),
+					lng:  text(g[geo.col("easting")]),
+					lat:  text(g[geo.col("northing")]),
+				})
+			}
+		}
+	}
+
+	// Each fixed ("F") licence joined with its client and spectrum.
+	code := lic.col("licencecode")
+	clients := client.index("clientid", nil)
+	specs := spec.index("licenceid", nil)
+	licences := make(map[string][]licenceRow)
+	for k, ls := range lic.index("licenceid", func(row []interface{}) bool {
+		s, ok := sqliteText(row[code])
+		return ok && (strings.HasPrefix(s, "F") || strings.HasPrefix(s, "f"))
+	}) {
+		for _, l := range ls {
+			ck, ok := joinKey(l[lic.col("clientid")])
+			if !ok {
+				continue
+			}
+			for _, c := range clients[ck] {
+				for _, s := range specs[k] {
+					licences[k] = append(licences[k], licenceRow{
+						id:           text(l[lic.col("licenceid")]),
+						client:       trim(c[client.col("name")]),
+						typ:          trim(l[lic.col("licencetype")]),
+						status:       trim(s[spec.col("spectrumstatus")]),
+						frequency:    text(s[spec.col("frequency")]),
+						power:        text(s[spec.col("power")]),
+						polarisation: trim(s[spec.col("polarisation")]),
+					})
+				}
+			}
+		}
+	}
+
+	// Each licence's emission designators, sorted and space-separated.
+	emissions := make(map[string]string)
+	for k, es := range em.index("licenceid", nil) {
+		var ds []string
+		for _, e := range es {
+			if d, ok := sqliteText(e[em.col("emission")]); ok {
+				ds = append(ds, strings.Trim(d, " "))
+			}
+		}
+		sort.Strings(ds)
+		emissions[k] = strings.Join(ds, " ")
+	}
+
+	rows := &Rows{Header: linkHeader}
+	txByLicence := tx.index("licenceid", nil)
+	for _, r := range rx.Rows {
+		k, ok := joinKey(r[rx.col("licenceid")])
+		if !ok || len(licences[k]) == 0 {
+			continue
+		}
+		rxLoc, ok := joinKey(r[rx.col("locationid")])
+		if !ok {
+			continue
+		}
+		for _, x := range txByLicence[k] {
+			txLoc, ok := joinKey(x[tx.col("locationid")])
+			if !ok {
+				continue
+			}
+			for _, rs := range sites[rxLoc] {
+				for _, ts := range sites[txLoc] {
+					for _, l := range licences[k] {
+						rows.Records = append(rows.Records, []string{
+							l.id, l.client, l.typ, l.frequency, l.power,
+							ts.name, ts.lng, ts.lat,
+							rs.name, rs.lng, rs.lat,
+							l.polarisation, emissions[k], l.status,
+							text(x[tx.col("txantennaheight")]), text(r[rx.col("rxantennaheight")]),
+						})
+					}
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// sqliteText formats v as sqlite3 prints the value mdb-sqlite converts it
+// to, or returns false if it's null. Integers stay integers; Float, Double
+// and Numeric become REAL, printed with %!.15g; Money becomes TEXT; and
+// DateTime becomes milliseconds since the Unix epoch.
+func sqliteText(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	case uint8:
+		return strconv.Itoa(int(v)), true
+	case int16:
+		return strconv.Itoa(int(v)), true
+	case int32:
+		return strconv.Itoa(int(v)), true
+	case int:
+		return strconv.Itoa(v), true
+	case float32:
+		return sqliteReal(float64(v)), true
+	case float64:
+		return sqliteReal(v), true
+	case time.Time:
+		return strconv.FormatInt(v.UnixMilli(), 10), true
+	case []byte:
+		return string(v), true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	return fmt.Sprint(v), true
+}
+
+// sqliteReal formats f as sqlite3's %!.15g does: like %.15g, but always with
+// a decimal point.
+func sqliteReal(f float64) string {
+	s := strconv.FormatFloat(f, 'g', 15, 64)
+	if strings.ContainsAny(s, ".NI") {
+		return s
+	}
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		return s[:i] + ".0" + s[i:]
+	}
+	return s + ".0"
+}
+
+// text is sqliteText, with nulls as empty strings, as sqlite3's CSV mode
+// prints them.
+func text(v interface{}) string {
+	s, _ := sqliteText(v)
+	return s
+}
+
+// trim is the SQL trim(): it strips spaces, not all whitespace.
+func trim(v interface{}) string {
+	return strings.Trim(text(v), " ")
+}
+
+// number returns v as a number, as sqlite3 compares it with a numeric
+// literal.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case nil:
+		return 0, false
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	f, err := strconv.ParseFloat(text(v), 64)
+	return f, err == nil
+}
+
+// joinKey returns a key that's equal for values that are equal in a join,
+// so 3 and 3.0 join, or false for nulls, which join nothing.
+func joinKey(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return "s" + v, true
+	case []byte:
+		return "s" + string(v), true
+	}
+	if f, ok := number(v); ok {
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+	return "s" + text(v), true
+}
+
+// WriteJSON writes rows as a JSON list of objects keyed by column name, as
+// CSVToJSON does. All values are strings.
+func WriteJSON(rows *Rows, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	for i, rec := range rows.Records {
+		if i > 0 {
+			bw.WriteString(", ")
+		}
+		bw.WriteByte('{')
+		for j, h := range rows.Header {
+			if j > 0 {
+				bw.WriteString(", ")
+			}
+			writeJSONString(bw, h)
+			bw.WriteString(": ")
+			if j < len(rec) {
+				writeJSONString(bw, rec[j])
+			} else {
+				bw.WriteString("null")
+			}
+		}
+		bw.WriteByte('}')
+	}
+	bw.WriteByte(']')
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("couldn't write JSON: %v", err)
+	}
+	return nil
+}
+
+// writeJSONString quotes s as Python's json module does by default, with
+// everything outside printable ASCII escaped.
+func writeJSONString(bw *bufio.Writer, s string) {
+	bw.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			bw.WriteString(`\"`)
+		case '\\':
+			bw.WriteString(`\\`)
+		case '\n':
+			bw.WriteString(`\n`)
+		case '\r':
+			bw.WriteString(`\r`)
+		case '\t':
+			bw.WriteString(`\t`)
+		case '\b':
+			bw.WriteString(`\b`)
+		case '\f':
+			bw.WriteString(`\f`)
+		default:
+			switch {
+			case r < 0x20 || (r >= 0x80 && r <= 0xffff):
+				fmt.Fprintf(bw, `\u%04x`, r)
+			case r > 0xffff:
+				r1, r2 := utf16.EncodeRune(r)
+				fmt.Fprintf(bw, `\u%04x\u%04x`, r1, r2)
+			default:
+				bw.WriteRune(r)
+			}
+		}
+	}
+	bw.WriteByte('"')
+}
+
+// sqliteTypes are the sqlite3 column types mdb-sqlite converts Access types
+// to.
+var sqliteTypes = map[mdb.Type]string{
+	mdb.Bool: "INTEGER", mdb.Byte: "INTEGER", mdb.Int: "INTEGER", mdb.Long: "INTEGER",
+	mdb.Complex: "INTEGER", mdb.DateTime: "DATETIME",
+	mdb.Float: "DOUBLE", mdb.Double: "DOUBLE", mdb.Numeric: "DOUBLE",
+	mdb.Text: "TEXT", mdb.Memo: "TEXT", mdb.GUID: "TEXT", mdb.Money: "TEXT",
+	mdb.Binary: "BLOB", mdb.OLE: "BLOB",
+}
+
+// ReadMDBSchema lists the tables and columns of prism.mdb, with the types
+// mdb-sqlite gives them, so that ReadSchema's record of earlier snapshots
+// still applies.
+func ReadMDBSchema(db *mdb.DB) (Schema, error) {
+	s := make(Schema)
+	for _, name := range db.Tables() {
+		t, err := db.Table(name)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read schema: %v", err)
+		}
+		cols := make(map[string]string)
+		for _, c := range t.Columns {
+			typ, ok := sqliteTypes[c.Type]
+			if !ok {
+				typ = "BLOB"
+			}
+			cols[strings.ToLower(c.Name)] = typ
+		}
+		s[strings.ToLower(name)] = cols
+	}
+	return s, nil
+}
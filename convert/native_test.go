@@ -0,0 +1,178 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// linksTables mirrors testdata/links.sql, with values typed as package mdb
+// reads them.
+var linksTables = Tables{
+	"licence": {
+		Columns: []string{"licenceid", "clientid", "licencetype", "licencecode"},
+		Rows: [][]interface{}{
+			{int32(1), int32(1), " Point to Point ", "F1"},
+			{int32(2), int32(2), "Point to Point", "f2"},
+			{int32(3), int32(1), "Broadcast", "R1"},
+			{int32(4), int32(1), "Point to Point", "F1"},
+			{int32(5), int32(2), "Point to Point", "F3"},
+			{int32(6), int32(9), "Point to Point", "F1"},
+		},
+	},
+	"clientname": {
+		Columns: []string{"clientid", "name"},
+		Rows:    [][]interface{}{{int32(1), "Example Ltd  "}, {int32(2), " Other Ltd"}},
+	},
+	"spectrum": {
+		Columns: []string{"licenceid", "spectrumstatus", "frequency", "power", "polarisation"},
+		Rows: [][]interface{}{
+			{int32(1), "Current", 7500.0, 30.0, "V"},
+			{int32(2), "Current", 18000.5, -3.25, "H"},
+			{int32(2), " Expired ", 18100.0, nil, "H"},
+			{int32(3), "Current", 100.0, 40.0, "V"},
+			{int32(4), "Current", 7500.0, 30.0, "V"},
+			{int32(5), "Current", 14000.0, 50.0, "V"},
+			{int32(6), "Current", 7500.0, 30.0, "V"},
+		},
+	},
+	"emission": {
+		Columns: []string{"licenceid", "emission"},
+		Rows:    [][]interface{}{{int32(1), " 56M0D7W "}, {int32(1), "28M0D7W"}, {int32(3), "200KF8E"}},
+	},
+	"location": {
+		Columns: []string{"locationid", "locationname"},
+		Rows: [][]interface{}{
+			{int32(1), " Mt Victoria"}, {int32(2), "Mt Kaukau"}, {int32(3), "Hill  "}, {int32(4), "Old Site"}, {int32(5), "Satellite"},
+		},
+	},
+	"geographicreference": {
+		Columns: []string{"locationid", "georeferencetypeid", "easting", "northing"},
+		Rows: [][]interface{}{
+			{int32(1), int32(2), 2659926.0, 5989566.0},
+			{int32(1), int32(3), 174.7943, -41.2963},
+			{int32(2), int32(3), 174.7753, -41.2476},
+			{int32(3), int32(3), 175.1, -40.95},
+			{int32(4), int32(2), 2660000.0, 5990000.0},
+			{int32(5), int32(3), 174.0, 0.0},
+		},
+	},
+	"transmitconfiguration": {
+		Columns: []string{"licenceid", "locationid", "txantennaheight"},
+		Rows: [][]interface{}{
+			{int32(1), int32(1), 20.0}, {int32(2), int32(2), 12.5}, {int32(3), int32(1), 50.0},
+			{int32(4), int32(4), 10.0}, {int32(5), int32(1), 5.0}, {int32(6), int32(1), 20.0},
+		},
+	},
+	"receiveconfiguration": {
+		Columns: []string{"licenceid", "locationid", "rxantennaheight"},
+		Rows: [][]interface{}{
+			{int32(1), int32(2), 15.0}, {int32(2), int32(3), nil}, {int32(3), int32(2), 50.0},
+			{int32(4), int32(2), 10.0}, {int32(5), int32(5), 5.0}, {int32(6), int32(2), 15.0},
+		},
+	},
+}
+
+// legacyLinks runs QueryFile over testdata/links.sql with sqlite3, as the
+// legacy conversion does after mdb-sqlite.
+func legacyLinks(t *testing.T) *Rows {
+	t.Helper()
+	if err := checkRunnable(context.Background(), Sqlite3Path, "-version"); err != nil {
+		t.Skip(err)
+	}
+	fixture, err := os.ReadFile("testdata/links.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := os.Create(t.TempDir() + "/links.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c := exec.Command(Sqlite3Path, db.Name())
+	c.Stdin = bytes.NewReader(fixture)
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("couldn't load fixture: %v, output: %s", err, out)
+	}
+	var buf bytes.Buffer
+	if err := QuerySqliteToCSV(db, "../"+QueryFile, &buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ParseCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// sortRecords orders records, since neither QueryFile nor QueryLinks
+// promises an order.
+func sortRecords(rows *Rows) {
+	slices.SortFunc(rows.Records, func(a, b []string) int {
+		return slices.Compare(a, b)
+	})
+}
+
+func TestQueryLinksMatchesLegacy(t *testing.T) {
+	want := legacyLinks(t)
+	got, err := QueryLinks(linksTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortRecords(want)
+	sortRecords(got)
+	if !slices.Equal(got.Header, want.Header) {
+		t.Errorf("header = %q, legacy has %q", got.Header, want.Header)
+	}
+	// Licences 1 and 2, the latter with a row for each spectrum record.
+	if len(want.Records) != 3 {
+		t.Errorf("legacy query found %v links, want 3: the fixture has changed", len(want.Records))
+	}
+	if !slices.EqualFunc(got.Records, want.Records, slices.Equal[[]string]) {
+		t.Errorf("records differ from the legacy query's:\ngot  %q\nwant %q", got.Records, want.Records)
+	}
+}
+
+func TestQueryLinksImplementsQueryFile(t *testing.T) {
+	got, err := QueryHash("../" + QueryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != NativeQueryHash() {
+		t.Errorf("%v has changed: its hash is %v, but QueryLinks implements %v. Change QueryLinks to match, check TestQueryLinksMatchesLegacy passes, then update queryLinksSQLHash", QueryFile, got, NativeQueryHash())
+	}
+}
+
+func TestWriteJSONMatchesLegacy(t *testing.T) {
+	if err := checkRunnable(context.Background(), Python3Path, "--version"); err != nil {
+		t.Skip(err)
+	}
+	rows, err := QueryLinks(linksTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Records = append(rows.Records, []string{"7", `quote "and" comma,`, "ünïcode", "", "", "", "", "tab\there", "", "", "", "", "", "", "", "new\nline"})
+	var in bytes.Buffer
+	w := csv.NewWriter(&in)
+	w.Write(rows.Header)
+	w.WriteAll(rows.Records)
+
+	var want, stderr bytes.Buffer
+	c := exec.Command(Python3Path, "../"+CSV2JSONPy)
+	c.Stdin, c.Stdout, c.Stderr = &in, &want, &stderr
+	if err := c.Run(); err != nil {
+		t.Fatalf("couldn't run %v: %v, stderr: %v", CSV2JSONPy, err, stderr.String())
+	}
+	var got bytes.Buffer
+	if err := WriteJSON(rows, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("WriteJSON differs from %v:\ngot  %v\nwant %v", CSV2JSONPy, strings.TrimSpace(got.String()), strings.TrimSpace(want.String()))
+	}
+}
@@ -0,0 +1,83 @@
+package convert
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// External programs and files the conversion shells out to or reads.
+const (
+	JavaPath     = "/usr/bin/java"
+	Sqlite3Path  = "/usr/bin/sqlite3"
+	Python3Path  = "/usr/bin/python3"
+	MDBSqliteJar = "mdb-sqlite.jar"
+	CSV2JSONPy   = "csv2json2.py"
+)
+
+// preflightTimeout bounds how long each dependency check may take.
+const preflightTimeout = 10 * time.Second
+
+// sqlite3Converter is implemented by converters that build their output
+// with the sqlite3 CLI.
+type sqlite3Converter interface {
+	usesSqlite3()
+}
+
+// Preflight checks that everything the conversion and formats depend on is
+// present and runnable, returning a description of each problem found. The
+// native conversion needs nothing, and only formats such as gpkg need
+// sqlite3; the legacy one needs everything.
+func Preflight(ctx context.Context, legacy bool, formats []Converter) []string {
+	var problems []string
+	needSqlite3 := legacy
+	for _, c := range formats {
+		if _, ok := c.(sqlite3Converter); ok {
+			needSqlite3 = true
+		}
+	}
+	if needSqlite3 {
+		if err := checkRunnable(ctx, Sqlite3Path, "-version"); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if !legacy {
+		return problems
+	}
+	for _, c := range []struct {
+		path string
+		args []string
+	}{
+		{JavaPath, []string{"-version"}},
+		{Python3Path, []string{"--version"}},
+	} {
+		if err := checkRunnable(ctx, c.path, c.args...); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, f := range []string{MDBSqliteJar, CSV2JSONPy, QueryFile} {
+		if err := checkReadable(f); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+func checkRunnable(ctx context.Context, path string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, path, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v is not runnable: %v, output: %s", path, err, out)
+	}
+	return nil
+}
+
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%v is not readable: %v", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,51 @@
+package convert
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// Converter turns the extracted rows into a published format. Each enabled
+// converter's output is stored at prism.{Name}/{timestamp}.
+type Converter interface {
+	// Name identifies the converter in configuration and object names.
+	Name() string
+	// ContentType is the MIME type of the output.
+	ContentType() string
+	// Convert renders rows in the converter's format.
+	Convert(rows *Rows) (io.Reader, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Converter)
+)
+
+// Register makes a converter available by name, replacing any existing
+// converter with the same name.
+func Register(c Converter) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.Name()] = c
+}
+
+// Lookup returns the converter registered with the given name.
+func Lookup(name string) (Converter, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[name]
+	return c, ok
+}
+
+// Names returns the names of all registered converters, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for n := range registry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
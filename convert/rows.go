@@ -0,0 +1,51 @@
+package convert
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// Rows is a table of the links extracted by the query. All values are
+// strings, as they come out of sqlite3's CSV mode.
+type Rows struct {
+	Header  []string
+	Records [][]string
+}
+
+// ParseCSV reads rows from CSV with a header line.
+func ParseCSV(r io.Reader) (*Rows, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return &Rows{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse CSV header: %v", err)
+	}
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse CSV: %v", err)
+	}
+	return &Rows{Header: header, Records: records}, nil
+}
+
+// Column returns the index of the named column, or -1 if there isn't one.
+func (r *Rows) Column(name string) int {
+	for i, h := range r.Header {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of the named column in rec, or "" if there's no such
+// column.
+func (r *Rows) Get(rec []string, name string) string {
+	i := r.Column(name)
+	if i < 0 || i >= len(rec) {
+		return ""
+	}
+	return rec[i]
+}
@@ -0,0 +1,236 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrCorruptArchive is wrapped by ExtractMDB's errors when prism.zip is
+// damaged, rather than merely unexpected.
+var ErrCorruptArchive = errors.New("corrupt archive")
+
+const (
+	localHeaderSig    = 0x04034b50
+	dataDescriptorSig = 0x08074b50
+	localHeaderLen    = 30
+	// flagDataDescriptor is set when the sizes and CRC follow the data,
+	// instead of being in the local header.
+	flagDataDescriptor = 0x8
+	zip64ExtraID       = 0x0001
+)
+
+// salvageWindow is how much of the archive SalvageMDB scans at once.
+const salvageWindow = 1 << 20
+
+// SalvageMDB extracts prism.mdb from a zip of size bytes whose central
+// directory is damaged or missing, by scanning for its local file header
+// instead. The entry's CRC is checked whenever the zip records it, so a
+// damaged entry isn't mistaken for a salvaged one. It's the caller's
+// responsibility to close and delete the file.
+func SalvageMDB(archive io.ReaderAt, size int64) (*os.File, error) {
+	sig := binary.LittleEndian.AppendUint32(nil, localHeaderSig)
+	buf := make([]byte, salvageWindow)
+	var errs []error
+	// last is the last offset tried, which the next window may see again.
+	last := int64(-1)
+	// Windows overlap by len(sig)-1 bytes, so no signature straddles two.
+	for start := int64(0); start < size; start += int64(len(buf) - len(sig) + 1) {
+		n, err := archive.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("salvage: couldn't read archive: %v", err)
+		}
+		for off := 0; off < n; off++ {
+			i := bytes.Index(buf[off:n], sig)
+			if i < 0 {
+				break
+			}
+			off += i
+			at := start + int64(off)
+			if at <= last {
+				continue
+			}
+			last = at
+			h, ok := readLocalHeader(archive, at)
+			if !ok || !strings.EqualFold(path.Base(h.name), "prism.mdb") {
+				continue
+			}
+			log.Printf("salvage: found a local header for %v at offset %v", h.name, at)
+			data := at + int64(h.dataOffset)
+			f, err := salvageEntry(io.NewSectionReader(archive, data, size-data), h)
+			if err != nil {
+				log.Printf("salvage: couldn't extract the entry at offset %v: %v", at, err)
+				errs = append(errs, fmt.Errorf("offset %v: %v", at, err))
+				continue
+			}
+			return f, nil
+		}
+		if int64(n) < int64(len(buf)) {
+			break
+		}
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("salvage: no local header for prism.mdb")
+	}
+	return nil, fmt.Errorf("salvage: %w", errors.Join(errs...))
+}
+
+// readLocalHeader reads and parses the local file header at off.
+func readLocalHeader(archive io.ReaderAt, off int64) (localHeader, bool) {
+	// The name and extra field lengths are 16 bits each.
+	b := make([]byte, localHeaderLen+2*0xffff)
+	n, err := archive.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		return localHeader{}, false
+	}
+	return parseLocalHeader(b[:n])
+}
+
+// localHeader is a zip local file header.
+type localHeader struct {
+	name         string
+	flags        uint16
+	method       uint16
+	crc          uint32
+	compressed   uint64
+	uncompressed uint64
+	// dataOffset is where the entry's data starts, from the header.
+	dataOffset int
+}
+
+// parseLocalHeader parses the local file header at the start of b.
+func parseLocalHeader(b []byte) (localHeader, bool) {
+	var h localHeader
+	if len(b) < localHeaderLen {
+		return h, false
+	}
+	le := binary.LittleEndian
+	h.flags = le.Uint16(b[6:])
+	h.method = le.Uint16(b[8:])
+	h.crc = le.Uint32(b[14:])
+	h.compressed = uint64(le.Uint32(b[18:]))
+	h.uncompressed = uint64(le.Uint32(b[22:]))
+	nameLen, extraLen := int(le.Uint16(b[26:])), int(le.Uint16(b[28:]))
+	h.dataOffset = localHeaderLen + nameLen + extraLen
+	if len(b) < h.dataOffset {
+		return h, false
+	}
+	h.name = string(b[localHeaderLen : localHeaderLen+nameLen])
+	// Zip64 entries keep their sizes in an extra field, uncompressed
+	// first, each only if the header's own field is saturated.
+	extra := b[localHeaderLen+nameLen : h.dataOffset]
+	for len(extra) >= 4 {
+		id, size := le.Uint16(extra), int(le.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		if id == zip64ExtraID {
+			if h.uncompressed == 0xffffffff && len(field) >= 8 {
+				h.uncompressed, field = le.Uint64(field), field[8:]
+			}
+			if h.compressed == 0xffffffff && len(field) >= 8 {
+				h.compressed = le.Uint64(field)
+			}
+		}
+		extra = extra[4+size:]
+	}
+	return h, true
+}
+
+// countingReader counts the bytes read through it. It's an io.ByteReader,
+// so flate doesn't read past the end of a deflate stream through it.
+type countingReader struct {
+	*bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.Reader.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// salvageEntry decompresses the entry whose data is at the start of data
+// into a temporary file, checking its CRC.
+func salvageEntry(data *io.SectionReader, h localHeader) (*os.File, error) {
+	hasDescriptor := h.flags&flagDataDescriptor != 0
+	src := &countingReader{Reader: bufio.NewReader(data)}
+	var r io.Reader
+	switch h.method {
+	case 0: // stored
+		if hasDescriptor {
+			return nil, errors.New("stored entry without sizes in its header")
+		}
+		if h.compressed > uint64(data.Size()) {
+			return nil, fmt.Errorf("entry is truncated: want %v bytes, have %v", h.compressed, data.Size())
+		}
+		r = io.LimitReader(src, int64(h.compressed))
+	case 8: // deflated
+		// Deflate streams mark their own end, so the compressed size isn't
+		// needed. src counts what flate consumed, which is where the data
+		// descriptor starts.
+		r = flate.NewReader(src)
+	default:
+		return nil, fmt.Errorf("unsupported compression method %v", h.method)
+	}
+
+	f, err := TempFile("prism.mdb")
+	if err != nil {
+		return nil, err
+	}
+	crc := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(f, crc), r)
+	if err == nil && !hasDescriptor && n != int64(h.uncompressed) {
+		err = fmt.Errorf("got %v bytes, want %v", n, h.uncompressed)
+	}
+	want, known := h.crc, !hasDescriptor
+	if err == nil && hasDescriptor {
+		d := make([]byte, 8)
+		m, _ := data.ReadAt(d, src.n)
+		want, known = readDescriptorCRC(d[:m])
+	}
+	if err == nil && known && crc.Sum32() != want {
+		err = fmt.Errorf("CRC is %08x, want %08x", crc.Sum32(), want)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if !known {
+		log.Printf("salvage: prism.mdb has no readable CRC to check")
+	}
+	log.Printf("salvage: recovered %v bytes of prism.mdb", n)
+	return f, nil
+}
+
+// readDescriptorCRC reads the CRC from the data descriptor at the start of
+// b, whose signature is optional.
+func readDescriptorCRC(b []byte) (uint32, bool) {
+	le := binary.LittleEndian
+	if len(b) >= 8 && le.Uint32(b) == dataDescriptorSig {
+		return le.Uint32(b[4:]), true
+	}
+	if len(b) >= 4 {
+		return le.Uint32(b), true
+	}
+	return 0, false
+}
@@ -0,0 +1,122 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+)
+
+// mdbContent stands in for prism.mdb: compressible, but not trivially.
+var mdbContent = bytes.Repeat([]byte("\x00\x01Standard Jet DB prism.mdb "), 500)
+
+// zipOf returns a zip with a single entry, as written by add.
+func zipOf(t *testing.T, add func(*zip.Writer) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := add(zw); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// deflated writes name deflated, with its CRC and sizes in a data
+// descriptor after it, as streaming zip writers do.
+func deflated(name string, content []byte) func(*zip.Writer) error {
+	return func(zw *zip.Writer) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+}
+
+// stored writes name uncompressed, with its CRC and sizes in its local
+// header.
+func stored(name string, content []byte) func(*zip.Writer) error {
+	return func(zw *zip.Writer) error {
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               name,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE(content),
+			CompressedSize64:   uint64(len(content)),
+			UncompressedSize64: uint64(len(content)),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+}
+
+// withoutCentralDirectory cuts a zip off where its central directory
+// starts, as an interrupted download would.
+func withoutCentralDirectory(t *testing.T, z []byte) []byte {
+	t.Helper()
+	i := bytes.LastIndex(z, []byte("PK\x01\x02"))
+	if i < 0 {
+		t.Fatal("zip has no central directory")
+	}
+	return z[:i]
+}
+
+func TestSalvageMDB(t *testing.T) {
+	deflatedZip := zipOf(t, deflated("prism.mdb", mdbContent))
+	storedZip := zipOf(t, stored("export/PRISM.MDB", mdbContent))
+	damaged := withoutCentralDirectory(t, storedZip)
+	damaged = append([]byte(nil), damaged...)
+	damaged[len(damaged)-100] ^= 0xff
+
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr bool
+	}{
+		{name: "deflated, no central directory", archive: withoutCentralDirectory(t, deflatedZip)},
+		{name: "stored in a directory, no central directory", archive: withoutCentralDirectory(t, storedZip)},
+		{name: "intact", archive: deflatedZip},
+		{name: "junk before the entry", archive: append([]byte("PK\x03\x04 not a header"), withoutCentralDirectory(t, deflatedZip)...)},
+		// The signature straddles the first two windows of the scan.
+		{name: "header across a scan window", archive: append(make([]byte, salvageWindow-2), withoutCentralDirectory(t, deflatedZip)...)},
+		{name: "bad CRC", archive: damaged, wantErr: true},
+		{name: "truncated data", archive: storedZip[:len(storedZip)/2], wantErr: true},
+		{name: "no prism.mdb", archive: withoutCentralDirectory(t, zipOf(t, deflated("readme.txt", mdbContent))), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := SalvageMDB(bytes.NewReader(tt.archive), int64(len(tt.archive)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SalvageMDB() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			got, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<30))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, mdbContent) {
+				t.Errorf("salvaged %v bytes, want the %v of prism.mdb", len(got), len(mdbContent))
+			}
+		})
+	}
+}
+
+func TestExtractMDBCorrupt(t *testing.T) {
+	z := withoutCentralDirectory(t, zipOf(t, deflated("prism.mdb", mdbContent)))
+	if _, err := ExtractMDB(bytes.NewReader(z), int64(len(z))); !errors.Is(err, ErrCorruptArchive) {
+		t.Errorf("ExtractMDB() = %v, want ErrCorruptArchive", err)
+	}
+}
@@ -0,0 +1,106 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// schemaQuery lists every column of every table in a database.
+const schemaQuery = `select m.name, p.name, p.type from sqlite_master m join pragma_table_info(m.name) p where m.type = 'table' order by m.name, p.cid;`
+
+// Schema maps each table in the converted database to its columns' types.
+type Schema map[string]map[string]string
+
+// ReadSchema lists the tables and columns of a sqlite database.
+func ReadSchema(tmpSqlite *os.File) (Schema, error) {
+	var out, stderr bytes.Buffer
+	c := exec.Command(Sqlite3Path, "-csv", tmpSqlite.Name(), schemaQuery)
+	c.Stdout = &out
+	c.Stderr = &stderr
+	log.Printf("Reading schema: running %v\n", c.String())
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("couldn't read schema: %v, stderr: %v", err, stderr.String())
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse schema: %v", err)
+	}
+	s := make(Schema)
+	for _, rec := range records {
+		if len(rec) != 3 {
+			return nil, fmt.Errorf("couldn't parse schema: got %v fields, want 3", len(rec))
+		}
+		table, col := strings.ToLower(rec[0]), strings.ToLower(rec[1])
+		if s[table] == nil {
+			s[table] = make(map[string]string)
+		}
+		s[table][col] = strings.ToUpper(rec[2])
+	}
+	return s, nil
+}
+
+// SchemaChange is a difference between an expected and actual schema.
+type SchemaChange struct {
+	Table string `json:"table"`
+	// Column is empty if the whole table was added or removed.
+	Column string `json:"column,omitempty"`
+	// Change is "added", "removed" or "type_changed".
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+func (c SchemaChange) String() string {
+	name := c.Table
+	if c.Column != "" {
+		name += "." + c.Column
+	}
+	if c.Change == "type_changed" {
+		return fmt.Sprintf("%v changed type from %v to %v", name, c.From, c.To)
+	}
+	return name + " " + c.Change
+}
+
+// DiffSchema lists how actual differs from expected, sorted by table and
+// column.
+func DiffSchema(expected, actual Schema) []SchemaChange {
+	var changes []SchemaChange
+	for table, cols := range actual {
+		want, ok := expected[table]
+		if !ok {
+			changes = append(changes, SchemaChange{Table: table, Change: "added"})
+			continue
+		}
+		for col, typ := range cols {
+			switch wantType, ok := want[col]; {
+			case !ok:
+				changes = append(changes, SchemaChange{Table: table, Column: col, Change: "added", To: typ})
+			case wantType != typ:
+				changes = append(changes, SchemaChange{Table: table, Column: col, Change: "type_changed", From: wantType, To: typ})
+			}
+		}
+		for col, typ := range want {
+			if _, ok := cols[col]; !ok {
+				changes = append(changes, SchemaChange{Table: table, Column: col, Change: "removed", From: typ})
+			}
+		}
+	}
+	for table := range expected {
+		if _, ok := actual[table]; !ok {
+			changes = append(changes, SchemaChange{Table: table, Change: "removed"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Table != changes[j].Table {
+			return changes[i].Table < changes[j].Table
+		}
+		return changes[i].Column < changes[j].Column
+	})
+	return changes
+}
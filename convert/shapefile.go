@@ -0,0 +1,242 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apache/arrow/go/v16/arrow"
+)
+
+func init() {
+	Register(Shapefile{})
+}
+
+// Shapefile renders rows as a zipped ESRI Shapefile of PolyLines, with the
+// same geometry as the GeoJSON, for GIS tools that don't read anything
+// newer. Column names are cut to the ten characters dBASE allows.
+type Shapefile struct{}
+
+func (Shapefile) Name() string        { return "shp.zip" }
+func (Shapefile) ContentType() string { return "application/zip" }
+
+const (
+	shpPolyLine = 3
+	// dbfMaxWidth is the widest a dBASE character field can be.
+	dbfMaxWidth = 254
+)
+
+func (Shapefile) Convert(rows *Rows) (io.Reader, error) {
+	links, err := rows.Links()
+	if err != nil {
+		return nil, err
+	}
+	geoms := make([][][2]float64, len(links))
+	for i, l := range links {
+		geoms[i] = linkCoordinates(l)
+	}
+	shp, shx := writeShp(geoms)
+	dbf, err := writeDbf(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		ext  string
+		data []byte
+	}{
+		{"shp", shp}, {"shx", shx}, {"dbf", dbf},
+		{"prj", []byte(wgs84WKT)}, {"cpg", []byte("UTF-8")},
+	} {
+		w, err := z.Create("links." + f.ext)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't write shapefile: %v", err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, fmt.Errorf("couldn't write shapefile: %v", err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, fmt.Errorf("couldn't write shapefile: %v", err)
+	}
+	return &buf, nil
+}
+
+type bbox struct{ minX, minY, maxX, maxY float64 }
+
+func newBBox() bbox { return bbox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)} }
+
+func (b *bbox) add(c [2]float64) {
+	b.minX, b.maxX = math.Min(b.minX, c[0]), math.Max(b.maxX, c[0])
+	b.minY, b.maxY = math.Min(b.minY, c[1]), math.Max(b.maxY, c[1])
+}
+
+func (b bbox) values() []float64 {
+	if math.IsInf(b.minX, 1) {
+		return []float64{0, 0, 0, 0}
+	}
+	return []float64{b.minX, b.minY, b.maxX, b.maxY}
+}
+
+// writeShpHeader writes the 100-byte header shared by .shp and .shx files.
+// Lengths are in 16-bit words.
+func writeShpHeader(w *bytes.Buffer, words int, box bbox) {
+	binary.Write(w, binary.BigEndian, []int32{9994, 0, 0, 0, 0, 0, int32(words)})
+	binary.Write(w, binary.LittleEndian, []int32{1000, shpPolyLine})
+	binary.Write(w, binary.LittleEndian, box.values())
+	// No Z or M ranges.
+	binary.Write(w, binary.LittleEndian, []float64{0, 0, 0, 0})
+}
+
+// writeShp returns the .shp and .shx files for a PolyLine per geometry.
+func writeShp(geoms [][][2]float64) (shp, shx []byte) {
+	all := newBBox()
+	var records, index bytes.Buffer
+	offset := 50 // Words, after the header.
+	for i, coords := range geoms {
+		box := newBBox()
+		for _, c := range coords {
+			box.add(c)
+			all.add(c)
+		}
+		contentBytes := 44 + 4 + 16*len(coords)
+		binary.Write(&records, binary.BigEndian, []int32{int32(i + 1), int32(contentBytes / 2)})
+		binary.Write(&records, binary.LittleEndian, int32(shpPolyLine))
+		binary.Write(&records, binary.LittleEndian, box.values())
+		// One part, starting at the first point.
+		binary.Write(&records, binary.LittleEndian, []int32{1, int32(len(coords)), 0})
+		for _, c := range coords {
+			binary.Write(&records, binary.LittleEndian, c)
+		}
+		binary.Write(&index, binary.BigEndian, []int32{int32(offset), int32(contentBytes / 2)})
+		offset += 4 + contentBytes/2
+	}
+	var s, x bytes.Buffer
+	writeShpHeader(&s, 50+records.Len()/2, all)
+	s.Write(records.Bytes())
+	writeShpHeader(&x, 50+index.Len()/2, all)
+	x.Write(index.Bytes())
+	return s.Bytes(), x.Bytes()
+}
+
+type dbfField struct {
+	name     string
+	typ      byte
+	width    int
+	decimals int
+}
+
+// dbfFieldNames cuts names to ten characters, keeping them unique.
+func dbfFieldNames(header []string) []string {
+	names := make([]string, len(header))
+	used := make(map[string]bool)
+	for i, h := range header {
+		name := h
+		if len(name) > 10 {
+			name = name[:10]
+		}
+		for n := 1; used[strings.ToLower(name)]; n++ {
+			suffix := strconv.Itoa(n)
+			name = h[:min(len(h), 10-len(suffix))] + suffix
+		}
+		used[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// writeDbf returns the dBASE III attribute table. Integer and decimal
+// columns are numeric fields; the rest are character fields.
+func writeDbf(rows *Rows) ([]byte, error) {
+	names := dbfFieldNames(rows.Header)
+	fields := make([]dbfField, len(rows.Header))
+	for i := range rows.Header {
+		f := dbfField{name: names[i], typ: 'C', width: 1}
+		switch columnType(rows, i) {
+		case arrow.PrimitiveTypes.Int64:
+			f.typ = 'N'
+		case arrow.PrimitiveTypes.Float64:
+			f.typ = 'N'
+			for _, rec := range rows.Records {
+				if _, frac, ok := strings.Cut(rec[i], "."); ok {
+					f.decimals = max(f.decimals, min(len(frac), 15))
+				}
+			}
+		}
+		fields[i] = f
+	}
+	// Format every value first, to size the fields.
+	values := make([][]string, len(rows.Records))
+	for r, rec := range rows.Records {
+		values[r] = make([]string, len(fields))
+		for i, f := range fields {
+			v := rec[i]
+			if f.typ == 'N' && v != "" {
+				n, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return nil, fmt.Errorf("row %v: bad %v: %v", r+1, rows.Header[i], err)
+				}
+				v = strconv.FormatFloat(n, 'f', f.decimals, 64)
+			}
+			v = truncateUTF8(v, dbfMaxWidth)
+			values[r][i] = v
+			fields[i].width = max(fields[i].width, len(v))
+		}
+	}
+
+	var b bytes.Buffer
+	recordLen := 1
+	for _, f := range fields {
+		recordLen += f.width
+	}
+	now := time.Now()
+	b.Write([]byte{0x03, byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(&b, binary.LittleEndian, uint32(len(rows.Records)))
+	binary.Write(&b, binary.LittleEndian, uint16(32+32*len(fields)+1))
+	binary.Write(&b, binary.LittleEndian, uint16(recordLen))
+	b.Write(make([]byte, 20))
+	for _, f := range fields {
+		name := make([]byte, 11)
+		copy(name, f.name)
+		b.Write(name)
+		b.WriteByte(f.typ)
+		b.Write(make([]byte, 4))
+		b.Write([]byte{byte(f.width), byte(f.decimals)})
+		b.Write(make([]byte, 14))
+	}
+	b.WriteByte(0x0D)
+	for _, vals := range values {
+		// Not deleted.
+		b.WriteByte(' ')
+		for i, f := range fields {
+			pad := strings.Repeat(" ", f.width-len(vals[i]))
+			if f.typ == 'N' {
+				b.WriteString(pad + vals[i])
+			} else {
+				b.WriteString(vals[i] + pad)
+			}
+		}
+	}
+	b.WriteByte(0x1A)
+	return b.Bytes(), nil
+}
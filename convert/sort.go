@@ -0,0 +1,50 @@
+package convert
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// SortRows puts rows into a stable order: by licence ID numerically, then by
+// every column in turn. The query's output order otherwise depends on
+// sqlite's query plan, which can change between snapshots.
+func SortRows(rows *Rows) {
+	id := rows.Column("licenceid")
+	sort.SliceStable(rows.Records, func(i, j int) bool {
+		a, b := rows.Records[i], rows.Records[j]
+		if id >= 0 {
+			ai, aerr := strconv.ParseInt(a[id], 10, 64)
+			bi, berr := strconv.ParseInt(b[id], 10, 64)
+			if aerr == nil && berr == nil && ai != bi {
+				return ai < bi
+			}
+		}
+		for k := range a {
+			if k >= len(b) {
+				return false
+			}
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
+// WriteCSV writes rows as CSV with a header, in the same dialect as sqlite3's
+// CSV mode.
+func (r *Rows) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	// sqlite3 ends rows with CRLF.
+	cw.UseCRLF = true
+	if err := cw.Write(r.Header); err != nil {
+		return fmt.Errorf("couldn't write CSV: %v", err)
+	}
+	if err := cw.WriteAll(r.Records); err != nil {
+		return fmt.Errorf("couldn't write CSV: %v", err)
+	}
+	return nil
+}
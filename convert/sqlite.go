@@ -0,0 +1,46 @@
+package convert
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+)
+
+// SqlitePageSize is the page size SQLite databases are rebuilt with before
+// being queried or published: the legacy conversion's database, by
+// OptimizeSqlite, and the gpkg and datasette.sqlite formats, by the sqlite3
+// scripts that build them. 4096 matches most filesystems' block size, and
+// SQLite's default.
+var SqlitePageSize = 4096
+
+// CheckPageSize returns an error if n isn't a page size SQLite supports.
+func CheckPageSize(n int) error {
+	if n < 512 || n > 65536 || n&(n-1) != 0 {
+		return fmt.Errorf("bad SQLite page size %v: want a power of two from 512 to 65536", n)
+	}
+	return nil
+}
+
+// optimizeSQL rebuilds a database with SqlitePageSize pages and no free
+// space, and refreshes the statistics the query planner uses, so that query
+// plans don't drift from one snapshot to the next. VACUUM can't run in a
+// transaction, so this must come after any COMMIT.
+func optimizeSQL() string {
+	return fmt.Sprintf("PRAGMA page_size = %v;\nVACUUM;\nANALYZE;\nPRAGMA optimize;\n", SqlitePageSize)
+}
+
+// OptimizeSqlite runs optimizeSQL against the database at path. Only the
+// legacy conversion uses it; formats built by sqlite3 run optimizeSQL as
+// part of their own scripts, so sqlite3 is still needed to publish them
+// when converting natively.
+func OptimizeSqlite(path string) error {
+	if err := CheckPageSize(SqlitePageSize); err != nil {
+		return err
+	}
+	c := exec.Command(Sqlite3Path, path, optimizeSQL())
+	log.Printf("Optimizing database in sqlite: running %v\n", c.String())
+	if out, err := c.CombinedOutput(); err != nil {
+		return fmt.Errorf("couldn't optimize db: %v, output: %s", err, out)
+	}
+	return nil
+}
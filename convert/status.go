@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Licence statuses, as normalised by StatusOf.
+const (
+	StatusCurrent   = "current"
+	StatusExpired   = "expired"
+	StatusCancelled = "cancelled"
+)
+
+func init() {
+	for _, status := range []string{StatusCurrent, StatusExpired, StatusCancelled} {
+		Register(ByStatus{Status: status, Converter: GeoJSON{}})
+		Register(ByStatus{Status: status, Converter: JSON{}})
+	}
+}
+
+// StatusOf normalises the status column. Snapshots from before the query
+// included it have no status, and are treated as current, since that's how
+// they were published.
+func StatusOf(raw string) string {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	switch {
+	case s == "" || strings.HasPrefix(s, "current"):
+		return StatusCurrent
+	case strings.HasPrefix(s, "expire"):
+		return StatusExpired
+	case strings.HasPrefix(s, "cancel"):
+		return StatusCancelled
+	}
+	return s
+}
+
+// Filter returns the rows for which keep returns true.
+func (r *Rows) Filter(keep func(rec []string) bool) *Rows {
+	out := &Rows{Header: r.Header}
+	for _, rec := range r.Records {
+		if keep(rec) {
+			out.Records = append(out.Records, rec)
+		}
+	}
+	return out
+}
+
+// ByStatus converts only the links whose licence has the given status, so
+// that maps can show just the active links by default. It's named after the
+// status and the underlying converter, e.g. "current.geojson".
+type ByStatus struct {
+	Status string
+	Converter
+}
+
+func (b ByStatus) Name() string { return b.Status + "." + b.Converter.Name() }
+
+func (b ByStatus) Convert(rows *Rows) (io.Reader, error) {
+	return b.Converter.Convert(rows.Filter(func(rec []string) bool {
+		return StatusOf(rows.Get(rec, "status")) == b.Status
+	}))
+}
+
+// JSON renders rows as an array of objects of strings, laid out as
+// WriteJSON lays out prism.json. It isn't registered itself, since
+// prism.json is always published.
+type JSON struct{}
+
+func (JSON) Name() string        { return "json" }
+func (JSON) ContentType() string { return "application/json" }
+
+func (JSON) Convert(rows *Rows) (io.Reader, error) {
+	var buf bytes.Buffer
+	if err := WriteJSON(rows, &buf); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
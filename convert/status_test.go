@@ -0,0 +1,90 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", StatusCurrent},
+		{"  ", StatusCurrent},
+		{"Current", StatusCurrent},
+		{"CURRENT - Renewal Due", StatusCurrent},
+		{"Expired", StatusExpired},
+		{"expires", StatusExpired},
+		{"Cancelled", StatusCancelled},
+		{"Canceled", StatusCancelled},
+		{" Cancelled by Request ", StatusCancelled},
+		{"Suspended", "suspended"},
+	}
+	for _, tt := range tests {
+		if got := StatusOf(tt.raw); got != tt.want {
+			t.Errorf("StatusOf(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestByStatus(t *testing.T) {
+	rows := &Rows{
+		Header: []string{"licenceid", "status"},
+		Records: [][]string{
+			{"1", "Current"},
+			{"2", "Expired"},
+			{"3", ""},
+			{"4", "Cancelled"},
+			{"5", "Expired"},
+		},
+	}
+	// Snapshots from before the query had a status are all current.
+	noStatus := &Rows{Header: []string{"licenceid"}, Records: [][]string{{"1"}, {"2"}}}
+	tests := []struct {
+		rows   *Rows
+		status string
+		want   []string
+	}{
+		{rows, StatusCurrent, []string{"1", "3"}},
+		{rows, StatusExpired, []string{"2", "5"}},
+		{rows, StatusCancelled, []string{"4"}},
+		{noStatus, StatusCurrent, []string{"1", "2"}},
+		{noStatus, StatusExpired, nil},
+	}
+	for _, tt := range tests {
+		c := ByStatus{Status: tt.status, Converter: JSON{}}
+		if name := c.Name(); name != tt.status+".json" {
+			t.Errorf("Name() = %q, want %q", name, tt.status+".json")
+		}
+		r, err := c.Convert(tt.rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// It's laid out like prism.json.
+		var want bytes.Buffer
+		if err := WriteJSON(tt.rows.Filter(func(rec []string) bool { return StatusOf(tt.rows.Get(rec, "status")) == tt.status }), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, want.Bytes()) {
+			t.Errorf("%v: Convert() = %s, want %s", tt.status, b, want.Bytes())
+		}
+		var objs []map[string]string
+		if err := json.Unmarshal(b, &objs); err != nil {
+			t.Fatalf("%v: couldn't parse %q: %v", tt.status, b, err)
+		}
+		var got []string
+		for _, o := range objs {
+			got = append(got, o["licenceid"])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got licences %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+-- The PRISM tables QueryFile reads, as mdb-sqlite converts them, with links
+-- that exercise each of its joins and filters. native_test.go mirrors these
+-- rows as Tables.
+create table licence (licenceid integer, clientid integer, licencetype text, licencecode text);
+create table clientname (clientid integer, name text);
+create table spectrum (licenceid integer, spectrumstatus text, frequency real, power real, polarisation text);
+create table emission (licenceid integer, emission text);
+create table location (locationid integer, locationname text);
+create table geographicreference (locationid integer, georeferencetypeid integer, easting real, northing real);
+create table transmitconfiguration (licenceid integer, locationid integer, txantennaheight real);
+create table receiveconfiguration (licenceid integer, locationid integer, rxantennaheight real);
+
+-- 1: a link with two emission designators.
+-- 2: a lower-case fixed licence code, two spectrum records and no emission.
+-- 3: a broadcast licence, which isn't a point-to-point link.
+-- 4: a link to a site with no WGS84 reference.
+-- 5: a link to a satellite on the equator.
+-- 6: a link with an unknown client.
+insert into licence values
+  (1, 1, ' Point to Point ', 'F1'),
+  (2, 2, 'Point to Point', 'f2'),
+  (3, 1, 'Broadcast', 'R1'),
+  (4, 1, 'Point to Point', 'F1'),
+  (5, 2, 'Point to Point', 'F3'),
+  (6, 9, 'Point to Point', 'F1');
+insert into clientname values (1, 'Example Ltd  '), (2, ' Other Ltd');
+insert into spectrum values
+  (1, 'Current', 7500, 30, 'V'),
+  (2, 'Current', 18000.5, -3.25, 'H'),
+  (2, ' Expired ', 18100, null, 'H'),
+  (3, 'Current', 100, 40, 'V'),
+  (4, 'Current', 7500, 30, 'V'),
+  (5, 'Current', 14000, 50, 'V'),
+  (6, 'Current', 7500, 30, 'V');
+insert into emission values (1, ' 56M0D7W '), (1, '28M0D7W'), (3, '200KF8E');
+insert into location values
+  (1, ' Mt Victoria'), (2, 'Mt Kaukau'), (3, 'Hill  '), (4, 'Old Site'), (5, 'Satellite');
+insert into geographicreference values
+  (1, 2, 2659926, 5989566),
+  (1, 3, 174.7943, -41.2963),
+  (2, 3, 174.7753, -41.2476),
+  (3, 3, 175.1, -40.95),
+  (4, 2, 2660000, 5990000),
+  (5, 3, 174.0, 0);
+insert into transmitconfiguration values
+  (1, 1, 20), (2, 2, 12.5), (3, 1, 50), (4, 4, 10), (5, 1, 5), (6, 1, 20);
+insert into receiveconfiguration values
+  (1, 2, 15), (2, 3, null), (3, 2, 50), (4, 2, 10), (5, 5, 5), (6, 2, 15);
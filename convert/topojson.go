@@ -0,0 +1,103 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+func init() {
+	Register(TopoJSON{})
+}
+
+// TopoJSONQuantization is how many distinct positions each axis is rounded
+// to in TopoJSON. Higher is more precise but bigger: 1e5 across New Zealand
+// is roughly 15m.
+var TopoJSONQuantization = 1e5
+
+// TopoJSON renders the same features as GeoJSON as a quantized, delta
+// encoded TopoJSON topology with a "links" object, which is much smaller.
+type TopoJSON struct{}
+
+func (TopoJSON) Name() string        { return "topojson" }
+func (TopoJSON) ContentType() string { return "application/json" }
+
+type topology struct {
+	Type      string                    `json:"type"`
+	Transform topoTransform             `json:"transform"`
+	Objects   map[string]topoCollection `json:"objects"`
+	Arcs      [][][2]int64              `json:"arcs"`
+}
+
+type topoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+type topoCollection struct {
+	Type       string         `json:"type"`
+	Geometries []topoGeometry `json:"geometries"`
+}
+
+type topoGeometry struct {
+	Type       string            `json:"type"`
+	Arcs       []int             `json:"arcs"`
+	Properties map[string]string `json:"properties"`
+}
+
+func (TopoJSON) Convert(rows *Rows) (io.Reader, error) {
+	fs, err := features(rows)
+	if err != nil {
+		return nil, err
+	}
+	box := newBBox()
+	for _, f := range fs {
+		for _, c := range f.Geometry.Coordinates {
+			box.add(c)
+		}
+	}
+	b := box.values()
+	q := math.Max(2, TopoJSONQuantization)
+	// Avoid dividing by zero if everything is in one place.
+	kx, ky := math.Max(b[2]-b[0], 1e-9)/(q-1), math.Max(b[3]-b[1], 1e-9)/(q-1)
+
+	topo := topology{
+		Type:      "Topology",
+		Transform: topoTransform{Scale: [2]float64{kx, ky}, Translate: [2]float64{b[0], b[1]}},
+		Arcs:      [][][2]int64{},
+	}
+	links := topoCollection{Type: "GeometryCollection", Geometries: []topoGeometry{}}
+	for _, f := range fs {
+		// Each arc's first position is absolute, the rest are deltas from
+		// the previous one. Repeated positions are dropped.
+		var arc [][2]int64
+		var px, py int64
+		for i, c := range f.Geometry.Coordinates {
+			x := int64(math.Round((c[0] - b[0]) / kx))
+			y := int64(math.Round((c[1] - b[1]) / ky))
+			if i > 0 && x == px && y == py && i != len(f.Geometry.Coordinates)-1 {
+				continue
+			}
+			if i == 0 {
+				arc = append(arc, [2]int64{x, y})
+			} else {
+				arc = append(arc, [2]int64{x - px, y - py})
+			}
+			px, py = x, y
+		}
+		links.Geometries = append(links.Geometries, topoGeometry{
+			Type:       "LineString",
+			Arcs:       []int{len(topo.Arcs)},
+			Properties: f.Properties,
+		})
+		topo.Arcs = append(topo.Arcs, arc)
+	}
+	topo.Objects = map[string]topoCollection{"links": links}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(topo); err != nil {
+		return nil, fmt.Errorf("couldn't encode TopoJSON: %v", err)
+	}
+	return &buf, nil
+}
@@ -0,0 +1,96 @@
+package dem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+	"golang.org/x/time/rate"
+)
+
+// apiBatch is the most locations the public Open Topo Data API accepts per
+// request.
+const apiBatch = 100
+
+// API looks up elevations with an Open Topo Data compatible HTTP API.
+type API struct {
+	URL    string
+	Client *http.Client
+	// limiter keeps within the public API's limit of a request a second.
+	limiter *rate.Limiter
+}
+
+// NewAPI returns an API source for the dataset at url.
+func NewAPI(url string) *API {
+	return &API{
+		URL:     url,
+		Client:  http.DefaultClient,
+		limiter: rate.NewLimiter(rate.Every(time.Second), 1),
+	}
+}
+
+type apiResponse struct {
+	Status  string `json:"status"`
+	Error   string `json:"error"`
+	Results []struct {
+		// Elevation is null outside the dataset.
+		Elevation *float64 `json:"elevation"`
+	} `json:"results"`
+}
+
+func (a *API) Elevations(ctx context.Context, pts []geo.Point) ([]float64, error) {
+	out := make([]float64, 0, len(pts))
+	for start := 0; start < len(pts); start += apiBatch {
+		end := min(start+apiBatch, len(pts))
+		elev, err := a.batch(ctx, pts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, elev...)
+	}
+	return out, nil
+}
+
+func (a *API) batch(ctx context.Context, pts []geo.Point) ([]float64, error) {
+	if err := a.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	locs := make([]string, len(pts))
+	for i, p := range pts {
+		locs[i] = fmt.Sprintf("%.6f,%.6f", p.Lat, p.Lng)
+	}
+	form := url.Values{"locations": {strings.Join(locs, "|")}}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query DEM: %v", err)
+	}
+	defer resp.Body.Close()
+	var r apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("couldn't decode DEM response (HTTP %v): %v", resp.StatusCode, err)
+	}
+	if r.Status != "OK" {
+		return nil, fmt.Errorf("DEM query failed: %v %v", r.Status, r.Error)
+	}
+	if len(r.Results) != len(pts) {
+		return nil, fmt.Errorf("DEM returned %v results for %v points", len(r.Results), len(pts))
+	}
+	out := make([]float64, len(pts))
+	for i, res := range r.Results {
+		out[i] = NoData
+		if res.Elevation != nil {
+			out[i] = *res.Elevation
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,32 @@
+// Package dem looks up ground elevations in a digital elevation model, such
+// as LINZ's 8m NZ DEM.
+package dem
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+// Source is a digital elevation model.
+type Source interface {
+	// Elevations returns the ground elevation of each point, in metres
+	// above sea level, or NaN where the model has no data.
+	Elevations(ctx context.Context, pts []geo.Point) ([]float64, error)
+}
+
+// Open returns the source described by spec: an http(s) URL of an
+// Open Topo Data compatible API, such as
+// https://api.opentopodata.org/v1/nzdem8m, or a directory of ESRI ASCII
+// grids in WGS84.
+func Open(spec string) (Source, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return NewAPI(spec), nil
+	}
+	return LoadGrids(spec)
+}
+
+// NoData is the elevation of points a model doesn't cover.
+var NoData = math.NaN()
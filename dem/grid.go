@@ -0,0 +1,193 @@
+package dem
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mhansen/nzwirelessmap-fetch/geo"
+)
+
+// Grid is an ESRI ASCII grid whose cells are in degrees of WGS84. LINZ
+// publishes its DEMs in NZTM2000, so reproject them first, e.g. with
+// `gdalwarp -t_srs EPSG:4326 -of AAIGrid`.
+type Grid struct {
+	ncols, nrows int
+	// xll and yll are the centre of the lower-left cell.
+	xll, yll float64
+	cellsize float64
+	nodata   float64
+	// cells are row-major, top row first, as in the file.
+	cells []float32
+}
+
+// Grids is a set of grids, such as a directory of tiles.
+type Grids []*Grid
+
+// LoadGrids reads every .asc file in dir.
+func LoadGrids(dir string) (Grids, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.asc"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no .asc grids in %v", dir)
+	}
+	var gs Grids
+	for _, name := range names {
+		g, err := LoadGrid(name)
+		if err != nil {
+			return nil, err
+		}
+		gs = append(gs, g)
+	}
+	return gs, nil
+}
+
+// LoadGrid reads an ESRI ASCII grid file.
+func LoadGrid(name string) (*Grid, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g := &Grid{nodata: -9999}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	sc.Split(bufio.ScanWords)
+	word := func() (string, error) {
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("unexpected end of file")
+		}
+		return sc.Text(), nil
+	}
+	var corner bool
+	var first string
+	// The header is key-value pairs, then the cells start.
+	for {
+		k, err := word()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %v: %v", name, err)
+		}
+		if _, err := strconv.ParseFloat(k, 64); err == nil {
+			first = k
+			break
+		}
+		v, err := word()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %v: %v", name, err)
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %v: bad %v: %v", name, k, err)
+		}
+		switch strings.ToLower(k) {
+		case "ncols":
+			g.ncols = int(n)
+		case "nrows":
+			g.nrows = int(n)
+		case "xllcorner":
+			g.xll, corner = n, true
+		case "yllcorner":
+			g.yll, corner = n, true
+		case "xllcenter":
+			g.xll = n
+		case "yllcenter":
+			g.yll = n
+		case "cellsize":
+			g.cellsize = n
+		case "nodata_value":
+			g.nodata = n
+		}
+	}
+	if g.ncols <= 0 || g.nrows <= 0 || g.cellsize <= 0 {
+		return nil, fmt.Errorf("couldn't read %v: missing ncols, nrows or cellsize", name)
+	}
+	if corner {
+		g.xll += g.cellsize / 2
+		g.yll += g.cellsize / 2
+	}
+	g.cells = make([]float32, 0, g.ncols*g.nrows)
+	for w := first; ; {
+		v, err := strconv.ParseFloat(w, 32)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read %v: bad cell: %v", name, err)
+		}
+		g.cells = append(g.cells, float32(v))
+		if len(g.cells) == cap(g.cells) {
+			break
+		}
+		if w, err = word(); err != nil {
+			return nil, fmt.Errorf("couldn't read %v: %v", name, err)
+		}
+	}
+	return g, nil
+}
+
+// at returns the cell at column i and row j from the bottom, or NaN.
+func (g *Grid) at(i, j int) float64 {
+	if i < 0 || j < 0 || i >= g.ncols || j >= g.nrows {
+		return NoData
+	}
+	v := float64(g.cells[(g.nrows-1-j)*g.ncols+i])
+	if v == g.nodata {
+		return NoData
+	}
+	return v
+}
+
+// Elevation interpolates the elevation at p bilinearly, or returns NaN if p
+// is outside the grid or has no data around it.
+func (g *Grid) Elevation(p geo.Point) float64 {
+	x := (p.Lng - g.xll) / g.cellsize
+	y := (p.Lat - g.yll) / g.cellsize
+	i, j := int(math.Floor(x)), int(math.Floor(y))
+	fx, fy := x-float64(i), y-float64(j)
+	// On the last row or column, use the nearest cell.
+	if i == g.ncols-1 && fx == 0 {
+		i, fx = i-1, 1
+	}
+	if j == g.nrows-1 && fy == 0 {
+		j, fy = j-1, 1
+	}
+	// Weight the four surrounding cells, skipping any without data.
+	var sum, weight float64
+	for _, c := range []struct {
+		i, j int
+		w    float64
+	}{
+		{i, j, (1 - fx) * (1 - fy)}, {i + 1, j, fx * (1 - fy)},
+		{i, j + 1, (1 - fx) * fy}, {i + 1, j + 1, fx * fy},
+	} {
+		if v := g.at(c.i, c.j); c.w > 0 && !math.IsNaN(v) {
+			sum += v * c.w
+			weight += c.w
+		}
+	}
+	if weight == 0 {
+		return NoData
+	}
+	return sum / weight
+}
+
+func (gs Grids) Elevations(ctx context.Context, pts []geo.Point) ([]float64, error) {
+	out := make([]float64, len(pts))
+	for i, p := range pts {
+		out[i] = NoData
+		for _, g := range gs {
+			if v := g.Elevation(p); !math.IsNaN(v) {
+				out[i] = v
+				break
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,153 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache keeps recently downloaded zips on local disk, keyed by snapshot
+// timestamp, so retries, reprocessing and backfills on the same instance
+// don't download them again. It's safe for use by several goroutines, but
+// not by several processes sharing Dir.
+type Cache struct {
+	Dir string
+	// Max is how many zips to keep. The least recently used are removed
+	// first. If 0, DefaultCacheSize are kept.
+	Max int
+}
+
+// DefaultCacheSize is how many zips a Cache keeps if Max isn't set.
+const DefaultCacheSize = 3
+
+// cacheMeta is stored next to each cached zip.
+type cacheMeta struct {
+	Snapshot string `json:"snapshot"`
+	// ETag is the upstream ETag the zip was downloaded with, if known.
+	ETag   string `json:"etag,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+// path returns where the zip for snapshot is kept, without an extension.
+// Timestamps are hashed, since they have colons in them.
+func (c *Cache) path(snapshot string) string {
+	h := sha256.Sum256([]byte(snapshot))
+	return filepath.Join(c.Dir, hex.EncodeToString(h[:8]))
+}
+
+// Get opens the cached zip of snapshot, which the caller must close. If etag
+// isn't empty, the cached zip must have been downloaded with the same ETag.
+// Corrupt entries are removed and reported as missing.
+func (c *Cache) Get(snapshot, etag string) (*os.File, bool) {
+	p := c.path(snapshot)
+	mb, err := os.ReadFile(p + ".json")
+	if err != nil {
+		return nil, false
+	}
+	var m cacheMeta
+	if err := json.Unmarshal(mb, &m); err != nil || m.Snapshot != snapshot {
+		return nil, false
+	}
+	if etag != "" && m.ETag != "" && etag != m.ETag {
+		log.Printf("cache: %v has ETag %v, not %v", snapshot, m.ETag, etag)
+		return nil, false
+	}
+	zip, err := os.Open(p + ".zip")
+	if err != nil {
+		return nil, false
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, zip)
+	if err == nil {
+		_, err = zip.Seek(0, io.SeekStart)
+	}
+	if err != nil || hex.EncodeToString(h.Sum(nil)) != m.SHA256 {
+		log.Printf("cache: %v is corrupt, removing it", snapshot)
+		zip.Close()
+		os.Remove(p + ".zip")
+		os.Remove(p + ".json")
+		return nil, false
+	}
+	// The modification time orders entries for eviction.
+	now := time.Now()
+	os.Chtimes(p+".json", now, now)
+	log.Printf("cache: using %v bytes cached for %v", n, snapshot)
+	return zip, true
+}
+
+// Put caches a copy of the zip of snapshot, evicting old entries if the
+// cache is full.
+func (c *Cache) Put(snapshot, etag string, zip *os.File) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return fmt.Errorf("couldn't create cache dir: %v", err)
+	}
+	p := c.path(snapshot)
+	h := sha256.New()
+	// Reading with ReadAt leaves zip's offset alone.
+	if err := writeFileAtomic(p+".zip", io.TeeReader(io.NewSectionReader(zip, 0, math.MaxInt64), h)); err != nil {
+		return fmt.Errorf("couldn't cache %v: %v", snapshot, err)
+	}
+	mb, err := json.Marshal(cacheMeta{Snapshot: snapshot, ETag: etag, SHA256: hex.EncodeToString(h.Sum(nil))})
+	if err != nil {
+		return err
+	}
+	// The metadata goes last, since it's what makes an entry visible.
+	if err := writeFileAtomic(p+".json", bytes.NewReader(mb)); err != nil {
+		return fmt.Errorf("couldn't cache %v: %v", snapshot, err)
+	}
+	c.evict()
+	return nil
+}
+
+// evict removes the least recently used entries beyond Max.
+func (c *Cache) evict() {
+	max := c.Max
+	if max <= 0 {
+		max = DefaultCacheSize
+	}
+	metas, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil || len(metas) <= max {
+		return
+	}
+	mtime := func(name string) time.Time {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	sort.Slice(metas, func(i, j int) bool { return mtime(metas[i]).After(mtime(metas[j])) })
+	for _, m := range metas[max:] {
+		base := strings.TrimSuffix(m, ".json")
+		os.Remove(m)
+		os.Remove(base + ".zip")
+	}
+}
+
+// writeFileAtomic writes r to name by way of a temporary file, so readers
+// never see it half-written.
+func writeFileAtomic(name string, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,89 @@
+// Package fetch downloads the PRISM export from RSM.
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/redact"
+	"github.com/mhansen/nzwirelessmap-fetch/tracing"
+)
+
+// DefaultURL is where RSM publishes the PRISM export.
+const DefaultURL = "https://www.rsm.govt.nz/assets/Uploads/documents/prism/prism.zip"
+
+// Client makes upstream requests. It passes on the trace context of the
+// request's context, if any.
+var Client = &http.Client{Transport: &tracing.Transport{}}
+
+// Response is an upstream response whose headers have arrived. The caller
+// must close Body.
+type Response struct {
+	*http.Response
+	// LastModified identifies the snapshot: it's parsed from the
+	// Last-Modified header.
+	LastModified time.Time
+	// Upstream records the exchange, for archiving.
+	Upstream *UpstreamRecord
+}
+
+// StatusError is returned by Get when upstream responds with a status
+// other than 2xx, besides those a RetryAfterError reports.
+type StatusError struct {
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream responded %v", e.Status)
+}
+
+// Get requests url and parses the headers of the response, without reading
+// the body. If upstream responds 429 or 503, the error is a
+// *RetryAfterError, and if it responds with any other status but 2xx, a
+// *StatusError, so an error page is never taken for the export.
+func Get(ctx context.Context, url string) (*Response, error) {
+	log.Printf("fetching %v\n", url)
+
+	requested := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	upstream := NewUpstreamRecord(url, resp, requested, time.Now())
+
+	log.Printf("Headers: %+v\n", redact.Headers(resp.Header))
+
+	if err := checkRetryAfter(resp, time.Now()); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &StatusError{Status: resp.Status}
+	}
+
+	t, err := LastModifiedTime(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	log.Printf("Last Modified time: %v\n", t)
+	return &Response{Response: resp, LastModified: t, Upstream: upstream}, nil
+}
+
+// LastModifiedTime parses the Last-Modified header of resp.
+func LastModifiedTime(resp *http.Response) (lmt time.Time, err error) {
+	lm := resp.Header.Get("Last-Modified")
+	log.Printf("Last Modified: %v\n", lm)
+	if lmt, err = time.Parse(http.TimeFormat, lm); err != nil {
+		err = fmt.Errorf("Couldn't parse Last-Modified header %q: %v", lm, err)
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package fetch
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	downloadBytes      = expvar.NewInt("download_bytes")
+	downloadTotalBytes = expvar.NewInt("download_total_bytes")
+)
+
+// progressLogInterval is how often download progress is logged.
+const progressLogInterval = 10 * time.Second
+
+// Progress is a snapshot of how far through a download we are.
+type Progress struct {
+	Bytes   int64   `json:"bytes"`
+	Total   int64   `json:"total_bytes,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+	ETA     string  `json:"eta,omitempty"`
+	Elapsed string  `json:"elapsed"`
+}
+
+func (p Progress) String() string {
+	if p.Total <= 0 {
+		return fmt.Sprintf("%v bytes after %v", p.Bytes, p.Elapsed)
+	}
+	return fmt.Sprintf("%v/%v bytes (%.1f%%) after %v, ETA %v", p.Bytes, p.Total, p.Percent, p.Elapsed, p.ETA)
+}
+
+// ProgressReader counts bytes read through it, logging and exporting progress
+// as it goes.
+type ProgressReader struct {
+	r     io.Reader
+	total int64
+	start time.Time
+
+	mu      sync.Mutex
+	read    int64
+	lastLog time.Time
+}
+
+// NewProgressReader wraps r, which is expected to yield total bytes. total
+// is -1 if unknown.
+func NewProgressReader(r io.Reader, total int64) *ProgressReader {
+	now := time.Now()
+	downloadBytes.Set(0)
+	downloadTotalBytes.Set(total)
+	return &ProgressReader{r: r, total: total, start: now, lastLog: now}
+}
+
+func (p *ProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.mu.Lock()
+	p.read += int64(n)
+	downloadBytes.Set(p.read)
+	logNow := time.Since(p.lastLog) >= progressLogInterval || err == io.EOF
+	if logNow {
+		p.lastLog = time.Now()
+	}
+	p.mu.Unlock()
+	if logNow {
+		log.Printf("download progress: %v", p.Progress())
+	}
+	return n, err
+}
+
+// Progress reports how much has been read so far.
+func (p *ProgressReader) Progress() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.start)
+	dp := Progress{
+		Bytes:   p.read,
+		Elapsed: elapsed.Round(time.Second).String(),
+	}
+	if p.total > 0 {
+		dp.Total = p.total
+		dp.Percent = 100 * float64(p.read) / float64(p.total)
+		if p.read > 0 {
+			remaining := time.Duration(float64(elapsed) * float64(p.total-p.read) / float64(p.read))
+			dp.ETA = remaining.Round(time.Second).String()
+		}
+	}
+	return dp
+}
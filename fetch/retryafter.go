@@ -0,0 +1,49 @@
+package fetch
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryAfterError is returned by Get when upstream is overloaded or down for
+// maintenance, and asks to be left alone for a while.
+type RetryAfterError struct {
+	Status string
+	// RetryAfter is how long upstream asked us to wait, or 0 if it didn't
+	// say.
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	if e.RetryAfter <= 0 {
+		return fmt.Sprintf("upstream responded %v", e.Status)
+	}
+	return fmt.Sprintf("upstream responded %v, asking us to retry after %v", e.Status, e.RetryAfter)
+}
+
+// checkRetryAfter returns a RetryAfterError if resp is a 429 or 503.
+func checkRetryAfter(resp *http.Response, now time.Time) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	return &RetryAfterError{Status: resp.Status, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now)}
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date. It returns 0 if v is empty, unparseable or in
+// the past.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0
+	}
+	return max(t.Sub(now), 0)
+}
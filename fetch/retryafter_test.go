@@ -0,0 +1,68 @@
+package fetch
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"1.5", 0},
+		{"soon", 0},
+		{"Mon, 01 Jan 2024 12:05:00 GMT", 5 * time.Minute},
+		{"Monday, 01-Jan-24 12:00:30 GMT", 30 * time.Second},
+		{"Mon Jan  1 12:01:00 2024", time.Minute},
+		{"Mon, 01 Jan 2024 11:00:00 GMT", 0},
+		{"Mon, 01 Jan 2024 12:00:00 GMT", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status     int
+		retryAfter string
+		wantErr    bool
+		want       time.Duration
+	}{
+		{status: http.StatusOK, retryAfter: "60"},
+		{status: http.StatusInternalServerError, retryAfter: "60"},
+		{status: http.StatusTooManyRequests, retryAfter: "60", wantErr: true, want: time.Minute},
+		{status: http.StatusServiceUnavailable, retryAfter: "Mon, 01 Jan 2024 13:00:00 GMT", wantErr: true, want: time.Hour},
+		{status: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status), Header: http.Header{}}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+		err := checkRetryAfter(resp, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkRetryAfter(%v) = %v, want error %v", tt.status, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		rerr, ok := err.(*RetryAfterError)
+		if !ok {
+			t.Errorf("checkRetryAfter(%v) = %T, want *RetryAfterError", tt.status, err)
+		} else if rerr.RetryAfter != tt.want {
+			t.Errorf("checkRetryAfter(%v).RetryAfter = %v, want %v", tt.status, rerr.RetryAfter, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package fetch
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// throttledReader limits how fast bytes can be read from r.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+// Throttle wraps r so it reads no faster than bytesPerSec. If bytesPerSec
+// isn't positive, r is returned unchanged.
+func Throttle(ctx context.Context, r io.Reader, bytesPerSec int) io.Reader {
+	if bytesPerSec <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)}
+}
+
+func (t *throttledReader) Read(b []byte) (int, error) {
+	// Never ask for more than the limiter can grant at once.
+	if len(b) > t.limiter.Burst() {
+		b = b[:t.limiter.Burst()]
+	}
+	n, err := t.r.Read(b)
+	if n > 0 {
+		if werr := t.limiter.WaitN(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
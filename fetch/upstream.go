@@ -0,0 +1,40 @@
+package fetch
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/redact"
+)
+
+// UpstreamRecord is what we know about the HTTP exchange that produced a
+// snapshot. It's archived at runs/{{timestamp}}/upstream.json so that
+// oddities (e.g. a clock-skewed Last-Modified) can be investigated later.
+// Only the response's headers are recorded, not the request's. Passwords
+// and sensitive headers and query parameters are masked, as redact does.
+type UpstreamRecord struct {
+	RequestURL    string      `json:"request_url"`
+	FinalURL      string      `json:"final_url"`
+	Method        string      `json:"method"`
+	RequestTime   time.Time   `json:"request_time"`
+	ResponseTime  time.Time   `json:"response_time"`
+	Status        string      `json:"status"`
+	Proto         string      `json:"proto"`
+	ContentLength int64       `json:"content_length"`
+	Header        http.Header `json:"header"`
+}
+
+// NewUpstreamRecord describes the exchange that requested url and got resp.
+func NewUpstreamRecord(url string, resp *http.Response, requested, responded time.Time) *UpstreamRecord {
+	return &UpstreamRecord{
+		RequestURL:    redact.URL(url),
+		FinalURL:      redact.URL(resp.Request.URL.String()),
+		Method:        resp.Request.Method,
+		RequestTime:   requested.UTC(),
+		ResponseTime:  responded.UTC(),
+		Status:        resp.Status,
+		Proto:         resp.Proto,
+		ContentLength: resp.ContentLength,
+		Header:        redact.Headers(resp.Header),
+	}
+}
@@ -0,0 +1,74 @@
+// Package function exposes the fetcher as Google Cloud Functions HTTP
+// functions. Deploy with the Go runtime and --entry-point Fetch (or
+// Reprocess), configured from the environment:
+//
+//   - BUCKET_NAME: the bucket to store snapshots in (default nz-wireless-map)
+//   - PRISM_ZIP_URL: where to download prism.zip from
+//   - FORMATS: comma-separated formats to publish besides CSV and JSON
+//
+// The conversion shells out to java and sqlite3, which the plain Cloud
+// Functions runtime doesn't have, so in practice deploy the Dockerfile's
+// image to Cloud Functions (2nd gen) or Cloud Run instead.
+package function
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/server"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+var (
+	once    sync.Once
+	handler http.Handler
+)
+
+// newHandler builds the server's handler from the environment.
+func newHandler() http.Handler {
+	cfg := server.Config{
+		PrismZipURL: getenv("PRISM_ZIP_URL", fetch.DefaultURL),
+		BucketName:  getenv("BUCKET_NAME", store.DefaultBucket),
+		Parallelism: 2,
+	}
+	for _, f := range strings.Split(os.Getenv("FORMATS"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			cfg.Formats = append(cfg.Formats, f)
+		}
+	}
+	s, err := server.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s.Handler()
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// serve routes r to the server's handler for path, whatever path the
+// function was invoked at.
+func serve(path string, w http.ResponseWriter, r *http.Request) {
+	once.Do(func() { handler = newHandler() })
+	r = r.Clone(r.Context())
+	r.URL.Path = path
+	handler.ServeHTTP(w, r)
+}
+
+// Fetch is the HTTP function equivalent of /fetch.
+func Fetch(w http.ResponseWriter, r *http.Request) {
+	serve("/fetch", w, r)
+}
+
+// Reprocess is the HTTP function equivalent of /reprocess.
+func Reprocess(w http.ResponseWriter, r *http.Request) {
+	serve("/reprocess", w, r)
+}
@@ -0,0 +1,62 @@
+// Package geo does the geodesy the converters need: distances and paths on
+// the Earth's surface, treated as a sphere.
+package geo
+
+import "math"
+
+// EarthRadiusKm is the mean radius of the Earth.
+const EarthRadiusKm = 6371.0088
+
+// Point is a WGS84 latitude and longitude in degrees.
+type Point struct {
+	Lat, Lng float64
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+
+// DistanceKm returns the great-circle distance between a and b.
+func DistanceKm(a, b Point) float64 {
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat := lat2 - lat1
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
+
+// Intermediate returns the point a fraction f of the way along the great
+// circle from a to b.
+func Intermediate(a, b Point, f float64) Point {
+	d := DistanceKm(a, b) / EarthRadiusKm
+	if d == 0 {
+		return a
+	}
+	lat1, lng1 := radians(a.Lat), radians(a.Lng)
+	lat2, lng2 := radians(b.Lat), radians(b.Lng)
+	ka := math.Sin((1-f)*d) / math.Sin(d)
+	kb := math.Sin(f*d) / math.Sin(d)
+	x := ka*math.Cos(lat1)*math.Cos(lng1) + kb*math.Cos(lat2)*math.Cos(lng2)
+	y := ka*math.Cos(lat1)*math.Sin(lng1) + kb*math.Cos(lat2)*math.Sin(lng2)
+	z := ka*math.Sin(lat1) + kb*math.Sin(lat2)
+	return Point{
+		Lat: degrees(math.Atan2(z, math.Hypot(x, y))),
+		Lng: degrees(math.Atan2(y, x)),
+	}
+}
+
+// Arc returns points along the great circle from a to b, including both
+// ends, no more than stepKm apart.
+func Arc(a, b Point, stepKm float64) []Point {
+	n := int(math.Ceil(DistanceKm(a, b) / stepKm))
+	if n < 1 {
+		n = 1
+	}
+	pts := make([]Point, n+1)
+	for i := range pts {
+		pts[i] = Intermediate(a, b, float64(i)/float64(n))
+	}
+	// Avoid rounding error at the ends.
+	pts[0], pts[n] = a, b
+	return pts
+}
@@ -0,0 +1,72 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceKm(t *testing.T) {
+	wellington, auckland := Point{-41.2865, 174.7762}, Point{-36.8485, 174.7633}
+	tests := []struct {
+		name      string
+		a, b      Point
+		want, tol float64
+	}{
+		{name: "same point", a: wellington, b: wellington, want: 0, tol: 0},
+		{name: "quarter meridian", a: Point{0, 0}, b: Point{90, 0}, want: math.Pi / 2 * EarthRadiusKm, tol: 1e-9},
+		{name: "antipodes", a: Point{0, 0}, b: Point{0, 180}, want: math.Pi * EarthRadiusKm, tol: 1e-9},
+		{name: "across the antimeridian", a: Point{0, 179.5}, b: Point{0, -179.5}, want: math.Pi / 180 * EarthRadiusKm, tol: 1e-9},
+		{name: "Wellington to Auckland", a: wellington, b: auckland, want: 493.5, tol: 0.5},
+	}
+	for _, tt := range tests {
+		if got := DistanceKm(tt.a, tt.b); math.Abs(got-tt.want) > tt.tol {
+			t.Errorf("%v: DistanceKm() = %v, want %v", tt.name, got, tt.want)
+		}
+		if got, back := DistanceKm(tt.a, tt.b), DistanceKm(tt.b, tt.a); math.Abs(got-back) > 1e-9 {
+			t.Errorf("%v: DistanceKm() is %v one way and %v the other", tt.name, got, back)
+		}
+	}
+}
+
+func TestArc(t *testing.T) {
+	a, b := Point{-41.2865, 174.7762}, Point{-36.8485, 174.7633}
+	for _, step := range []float64{1000, 100, 7} {
+		pts := Arc(a, b, step)
+		if pts[0] != a || pts[len(pts)-1] != b {
+			t.Errorf("Arc(%v) runs from %v to %v, want %v to %v", step, pts[0], pts[len(pts)-1], a, b)
+		}
+		total := 0.0
+		for i := 1; i < len(pts); i++ {
+			d := DistanceKm(pts[i-1], pts[i])
+			if d > step+1e-9 {
+				t.Errorf("Arc(%v) has points %v km apart", step, d)
+			}
+			total += d
+		}
+		// Points on the great circle add up to its length.
+		if want := DistanceKm(a, b); math.Abs(total-want) > 1e-6 {
+			t.Errorf("Arc(%v) is %v km long, want %v", step, total, want)
+		}
+	}
+}
+
+func TestIntermediate(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		f    float64
+		want Point
+	}{
+		{name: "start", a: Point{-41, 174}, b: Point{-36, 175}, f: 0, want: Point{-41, 174}},
+		{name: "end", a: Point{-41, 174}, b: Point{-36, 175}, f: 1, want: Point{-36, 175}},
+		{name: "midpoint on the equator", a: Point{0, 10}, b: Point{0, 20}, f: 0.5, want: Point{0, 15}},
+		{name: "midpoint on a meridian", a: Point{-40, 175}, b: Point{-30, 175}, f: 0.5, want: Point{-35, 175}},
+		{name: "same point", a: Point{-41, 174}, b: Point{-41, 174}, f: 0.5, want: Point{-41, 174}},
+	}
+	for _, tt := range tests {
+		got := Intermediate(tt.a, tt.b, tt.f)
+		if math.Abs(got.Lat-tt.want.Lat) > 1e-9 || math.Abs(got.Lng-tt.want.Lng) > 1e-9 {
+			t.Errorf("%v: Intermediate() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
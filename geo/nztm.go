@@ -0,0 +1,41 @@
+package geo
+
+import "math"
+
+// NZTM2000 projection parameters: a transverse Mercator projection of the
+// GRS80 ellipsoid.
+const (
+	nztmA             = 6378137.0
+	nztmF             = 1 / 298.257222101
+	nztmCentralMerid  = 173.0
+	nztmScale         = 0.9996
+	nztmFalseEasting  = 1600000.0
+	nztmFalseNorthing = 10000000.0
+)
+
+// NZTM projects p to New Zealand Transverse Mercator 2000 (EPSG:2193)
+// easting and northing in metres, the grid used by Topo50 maps. It's
+// accurate to well under a metre within New Zealand.
+func NZTM(p Point) (easting, northing float64) {
+	e2 := nztmF * (2 - nztmF)
+	e4, e6 := e2*e2, e2*e2*e2
+	ep2 := e2 / (1 - e2)
+
+	lat := radians(p.Lat)
+	sin, cos, tan := math.Sin(lat), math.Cos(lat), math.Tan(lat)
+	n := nztmA / math.Sqrt(1-e2*sin*sin)
+	t := tan * tan
+	c := ep2 * cos * cos
+	a := radians(p.Lng-nztmCentralMerid) * cos
+	// m is the meridional arc length from the equator.
+	m := nztmA * ((1-e2/4-3*e4/64-5*e6/256)*lat -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*lat) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*lat) -
+		(35*e6/3072)*math.Sin(6*lat))
+
+	x := nztmScale * n * (a + (1-t+c)*math.Pow(a, 3)/6 +
+		(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120)
+	y := nztmScale * (m + n*tan*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+
+		(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+	return nztmFalseEasting + x, nztmFalseNorthing + y
+}
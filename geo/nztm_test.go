@@ -0,0 +1,32 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNZTM(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Point
+		e, n float64
+		tol  float64
+	}{
+		// The projection's origin.
+		{name: "origin", p: Point{Lat: 0, Lng: 173}, e: 1600000, n: 10000000, tol: 1e-6},
+		// The example in LINZ's reference implementation, nztm.c.
+		{name: "LINZ example", p: Point{Lat: -34.444066, Lng: 172.739194}, e: 1576041.150, n: 6188574.240, tol: 0.01},
+	}
+	for _, tt := range tests {
+		e, n := NZTM(tt.p)
+		if math.Abs(e-tt.e) > tt.tol || math.Abs(n-tt.n) > tt.tol {
+			t.Errorf("%v: NZTM(%v) = %.3f, %.3f, want %.3f, %.3f", tt.name, tt.p, e, n, tt.e, tt.n)
+		}
+	}
+	// The projection is symmetric about its central meridian.
+	e1, n1 := NZTM(Point{Lat: -41, Lng: 175.5})
+	e2, n2 := NZTM(Point{Lat: -41, Lng: 170.5})
+	if math.Abs((e1-1600000)+(e2-1600000)) > 1e-6 || math.Abs(n1-n2) > 1e-6 {
+		t.Errorf("NZTM isn't symmetric: %.3f, %.3f and %.3f, %.3f", e1, n1, e2, n2)
+	}
+}
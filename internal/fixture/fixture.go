@@ -0,0 +1,101 @@
+// Package fixture generates representative PRISM-shaped data for benchmarks,
+// and fakes of the services tests need.
+package fixture
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// Header is the columns produced by the extraction query.
+var Header = []string{
+	"licenceid", "clientname", "licencetype", "frequency", "power",
+	"tx_name", "tx_lng", "tx_lat",
+	"rx_name", "rx_lng", "rx_lat",
+	"polarisation", "emission", "status", "tx_antenna_height", "rx_antenna_height",
+}
+
+// Links is roughly how many point-to-point links a real snapshot has.
+const Links = 40000
+
+var clients = []string{
+	"Spark New Zealand Trading Limited", "Vodafone New Zealand Limited",
+	"Kordia Limited", "Transpower New Zealand Limited",
+	"Chorus New Zealand Limited", "Two Degrees Mobile Limited",
+	"Wireless Nation Limited", "Fire and Emergency New Zealand",
+}
+
+var (
+	// Most links are current.
+	statuses      = []string{"Current", "Current", "Current", "Current", "Expired", "Cancelled"}
+	polarisations = []string{"H", "V"}
+	emissions     = []string{"28M0D7W", "56M0D7W", "14M0D7W", "7M00G7W"}
+)
+
+// Records returns n link records, deterministically.
+func Records(n int) [][]string {
+	r := rand.New(rand.NewSource(1))
+	records := make([][]string, n)
+	for i := range records {
+		txLat, txLng := -34.5-r.Float64()*12, 166.5+r.Float64()*12
+		rxLat, rxLng := txLat+r.NormFloat64()*0.2, txLng+r.NormFloat64()*0.2
+		records[i] = []string{
+			strconv.Itoa(100000 + i),
+			clients[r.Intn(len(clients))],
+			"Point to point",
+			strconv.FormatFloat(1000+r.Float64()*37000, 'f', 4, 64),
+			strconv.FormatFloat(r.Float64()*40, 'f', 1, 64),
+			fmt.Sprintf("Site %v", r.Intn(5000)),
+			strconv.FormatFloat(txLng, 'f', 6, 64),
+			strconv.FormatFloat(txLat, 'f', 6, 64),
+			fmt.Sprintf("Site %v", r.Intn(5000)),
+			strconv.FormatFloat(rxLng, 'f', 6, 64),
+			strconv.FormatFloat(rxLat, 'f', 6, 64),
+			polarisations[r.Intn(len(polarisations))],
+			emissions[r.Intn(len(emissions))],
+			statuses[r.Intn(len(statuses))],
+			strconv.Itoa(5 + r.Intn(60)),
+			strconv.Itoa(5 + r.Intn(60)),
+		}
+	}
+	return records
+}
+
+// CSV returns n link records as CSV with a header, as sqlite3 outputs them.
+func CSV(n int) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(Header)
+	w.WriteAll(Records(n))
+	return buf.Bytes()
+}
+
+// Zip returns a prism.zip containing a prism.mdb of mdbSize bytes. The
+// contents aren't a real Access database, but compress similarly.
+func Zip(mdbSize int) []byte {
+	r := rand.New(rand.NewSource(1))
+	mdb := make([]byte, mdbSize)
+	// Access pages are mostly padding with islands of record data.
+	for i := 0; i < len(mdb); i += 4096 {
+		end := i + 1024
+		if end > len(mdb) {
+			end = len(mdb)
+		}
+		r.Read(mdb[i:end])
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("prism.mdb")
+	if err != nil {
+		panic(err)
+	}
+	f.Write(mdb)
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
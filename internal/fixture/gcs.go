@@ -0,0 +1,450 @@
+package fixture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCS is an in-memory fake of just the parts of the Cloud Storage JSON and
+// XML APIs that the store package uses: reads, uploads and deletes with
+// generation preconditions, attrs, listing, composes and copies. Every bucket
+// exists.
+type GCS struct {
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]*gcsObject // by bucket + "/" + name
+	gen     int64
+	uploads map[string]gcsUpload // resumable uploads, by ID
+	nextID  int
+	calls   []string
+}
+
+type gcsObject struct {
+	bucket, name string
+	data         []byte
+	gen          int64
+	contentType  string
+	storageClass string
+	updated      time.Time
+}
+
+type gcsUpload struct {
+	bucket string
+	meta   gcsMeta
+	query  url.Values
+	data   []byte
+}
+
+// gcsMeta is the object metadata sent with an upload, compose or copy.
+type gcsMeta struct {
+	Name         string `json:"name"`
+	ContentType  string `json:"contentType"`
+	StorageClass string `json:"storageClass"`
+}
+
+// NewGCS starts a fake GCS server. Close it when done.
+func NewGCS() *GCS {
+	g := &GCS{objects: make(map[string]*gcsObject), uploads: make(map[string]gcsUpload)}
+	g.srv = httptest.NewServer(http.HandlerFunc(g.serve))
+	return g
+}
+
+// Close shuts down the server.
+func (g *GCS) Close() { g.srv.Close() }
+
+// Client returns a client of the fake.
+func (g *GCS) Client(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx, option.WithEndpoint(g.srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+}
+
+// Object returns the contents of an object, and whether it exists.
+func (g *GCS) Object(bucket, name string) ([]byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o, ok := g.objects[bucket+"/"+name]
+	if !ok {
+		return nil, false
+	}
+	return o.data, true
+}
+
+// Put creates or replaces an object.
+func (g *GCS) Put(bucket, name string, data []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.put(bucket, gcsMeta{Name: name}, data)
+}
+
+// Names lists the objects in a bucket, in order.
+func (g *GCS) Names(bucket string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var names []string
+	for _, o := range g.objects {
+		if o.bucket == bucket {
+			names = append(names, o.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Calls returns the requests made so far, each as the method and what it
+// was for, e.g. "GET media prism.json" or "POST upload prism.json", and
+// forgets them.
+func (g *GCS) Calls() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	calls := g.calls
+	g.calls = nil
+	return calls
+}
+
+// put stores an object as a new generation. g.mu must be held.
+func (g *GCS) put(bucket string, meta gcsMeta, data []byte) *gcsObject {
+	g.gen++
+	o := &gcsObject{
+		bucket: bucket, name: meta.Name, data: data, gen: g.gen,
+		contentType: meta.ContentType, storageClass: meta.StorageClass,
+		updated: time.Now().UTC(),
+	}
+	g.objects[bucket+"/"+meta.Name] = o
+	return o
+}
+
+// checkConditions reports whether the object name meets the generation
+// preconditions in q. g.mu must be held.
+func (g *GCS) checkConditions(bucket, name string, q url.Values) bool {
+	var gen int64
+	if o, ok := g.objects[bucket+"/"+name]; ok {
+		gen = o.gen
+	}
+	if v := q.Get("ifGenerationMatch"); v != "" && v != strconv.FormatInt(gen, 10) {
+		return false
+	}
+	if v := q.Get("ifGenerationNotMatch"); v != "" && v == strconv.FormatInt(gen, 10) {
+		return false
+	}
+	return true
+}
+
+func (o *gcsObject) resource() map[string]interface{} {
+	return map[string]interface{}{
+		"kind":           "storage#object",
+		"bucket":         o.bucket,
+		"name":           o.name,
+		"generation":     strconv.FormatInt(o.gen, 10),
+		"metageneration": "1",
+		"size":           strconv.Itoa(len(o.data)),
+		"contentType":    o.contentType,
+		"storageClass":   o.storageClass,
+		"updated":        o.updated.Format(time.RFC3339Nano),
+		"timeCreated":    o.updated.Format(time.RFC3339Nano),
+	}
+}
+
+func writeGCSJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func gcsError(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error": {"code": %v, "message": %q, "errors": [{"reason": %q}]}}`, code, reason, reason)
+}
+
+// segments splits an escaped path into unescaped segments, so object names
+// may contain escaped slashes.
+func segments(escaped string) []string {
+	parts := strings.Split(strings.Trim(escaped, "/"), "/")
+	for i, p := range parts {
+		if u, err := url.PathUnescape(p); err == nil {
+			parts[i] = u
+		}
+	}
+	return parts
+}
+
+func (g *GCS) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := segments(r.URL.EscapedPath())
+	q := r.URL.Query()
+	switch {
+	case len(p) == 6 && p[0] == "upload" && p[3] == "b" && p[5] == "o":
+		g.upload(w, r, p[4], body)
+	case len(p) == 1 && p[0] == "resumable":
+		g.resume(w, r, body)
+	case len(p) == 4 && p[0] == "storage" && p[2] == "b":
+		// Bucket attrs.
+		writeGCSJSON(w, map[string]string{"kind": "storage#bucket", "name": p[3]})
+	case len(p) == 5 && p[0] == "storage" && p[2] == "b" && p[4] == "o":
+		g.list(w, p[3], q)
+	case len(p) == 7 && p[0] == "storage" && p[6] == "compose":
+		g.compose(w, p[3], p[5], q, body)
+	case len(p) == 11 && p[0] == "storage" && p[6] == "rewriteTo":
+		g.rewrite(w, p[3], p[5], p[8], p[10], q, body)
+	case len(p) == 6 && p[0] == "storage" && p[2] == "b" && p[4] == "o":
+		g.object(w, r, p[3], p[5], q)
+	case len(p) == 2 && r.Method == http.MethodGet:
+		// An XML API read.
+		g.xmlRead(w, r, p[0], p[1])
+	default:
+		gcsError(w, http.StatusNotImplemented, "the fake doesn't implement "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (g *GCS) upload(w http.ResponseWriter, r *http.Request, bucket string, body []byte) {
+	var meta gcsMeta
+	var data []byte
+	switch r.URL.Query().Get("uploadType") {
+	case "resumable":
+		json.Unmarshal(body, &meta)
+		g.calls = append(g.calls, "POST upload "+meta.Name)
+		g.nextID++
+		id := strconv.Itoa(g.nextID)
+		g.uploads[id] = gcsUpload{bucket: bucket, meta: meta, query: r.URL.Query()}
+		w.Header().Set("Location", g.srv.URL+"/resumable?upload_id="+id)
+		return
+	case "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			gcsError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		mr := multipart.NewReader(strings.NewReader(string(body)), params["boundary"])
+		for i := 0; i < 2; i++ {
+			part, err := mr.NextPart()
+			if err != nil {
+				gcsError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			b, _ := io.ReadAll(part)
+			if i == 0 {
+				json.Unmarshal(b, &meta)
+			} else {
+				data = b
+			}
+		}
+	default:
+		meta.Name = r.URL.Query().Get("name")
+		data = body
+	}
+	g.calls = append(g.calls, "POST upload "+meta.Name)
+	g.finishUpload(w, bucket, meta, r.URL.Query(), data)
+}
+
+func (g *GCS) resume(w http.ResponseWriter, r *http.Request, body []byte) {
+	id := r.URL.Query().Get("upload_id")
+	u, ok := g.uploads[id]
+	if !ok {
+		gcsError(w, http.StatusNotFound, "no such upload")
+		return
+	}
+	u.data = append(u.data, body...)
+	g.uploads[id] = u
+	// Content-Range is "bytes a-b/*" until the final chunk gives the total.
+	if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%v", len(u.data)-1))
+		if r.Header.Get("X-GUploader-No-308") == "yes" {
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			return
+		}
+		w.WriteHeader(308)
+		return
+	}
+	delete(g.uploads, id)
+	g.finishUpload(w, u.bucket, u.meta, u.query, u.data)
+}
+
+func (g *GCS) finishUpload(w http.ResponseWriter, bucket string, meta gcsMeta, q url.Values, data []byte) {
+	if !g.checkConditions(bucket, meta.Name, q) {
+		gcsError(w, http.StatusPreconditionFailed, "conditionNotMet")
+		return
+	}
+	writeGCSJSON(w, g.put(bucket, meta, data).resource())
+}
+
+func (g *GCS) list(w http.ResponseWriter, bucket string, q url.Values) {
+	g.calls = append(g.calls, "GET list "+q.Get("prefix"))
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	var names []string
+	for _, o := range g.objects {
+		if o.bucket == bucket && strings.HasPrefix(o.name, prefix) {
+			names = append(names, o.name)
+		}
+	}
+	sort.Strings(names)
+	items := []map[string]interface{}{}
+	prefixes := []string{}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if delim != "" {
+			if i := strings.Index(name[len(prefix):], delim); i >= 0 {
+				p := name[:len(prefix)+i+len(delim)]
+				if !seen[p] {
+					seen[p] = true
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+		}
+		items = append(items, g.objects[bucket+"/"+name].resource())
+	}
+	writeGCSJSON(w, map[string]interface{}{"kind": "storage#objects", "items": items, "prefixes": prefixes})
+}
+
+func (g *GCS) compose(w http.ResponseWriter, bucket, name string, q url.Values, body []byte) {
+	g.calls = append(g.calls, "POST compose "+name)
+	var req struct {
+		SourceObjects []struct {
+			Name string `json:"name"`
+		} `json:"sourceObjects"`
+		Destination gcsMeta `json:"destination"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var data []byte
+	for _, src := range req.SourceObjects {
+		o, ok := g.objects[bucket+"/"+src.Name]
+		if !ok {
+			gcsError(w, http.StatusNotFound, "No such object: "+src.Name)
+			return
+		}
+		data = append(data, o.data...)
+	}
+	if !g.checkConditions(bucket, name, q) {
+		gcsError(w, http.StatusPreconditionFailed, "conditionNotMet")
+		return
+	}
+	req.Destination.Name = name
+	writeGCSJSON(w, g.put(bucket, req.Destination, data).resource())
+}
+
+// rewrite copies an object, all in one call.
+func (g *GCS) rewrite(w http.ResponseWriter, srcBucket, srcName, bucket, name string, q url.Values, body []byte) {
+	g.calls = append(g.calls, "POST copy "+srcName+" "+name)
+	var meta gcsMeta
+	if err := json.Unmarshal(body, &meta); err != nil {
+		gcsError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	src, ok := g.objects[srcBucket+"/"+srcName]
+	if !ok {
+		gcsError(w, http.StatusNotFound, "No such object: "+srcName)
+		return
+	}
+	if !g.checkConditions(bucket, name, q) {
+		gcsError(w, http.StatusPreconditionFailed, "conditionNotMet")
+		return
+	}
+	meta.Name = name
+	o := g.put(bucket, meta, src.data)
+	size := strconv.Itoa(len(o.data))
+	writeGCSJSON(w, map[string]interface{}{
+		"kind":                "storage#rewriteResponse",
+		"totalBytesRewritten": size,
+		"objectSize":          size,
+		"done":                true,
+		"resource":            o.resource(),
+	})
+}
+
+func (g *GCS) object(w http.ResponseWriter, r *http.Request, bucket, name string, q url.Values) {
+	o, ok := g.objects[bucket+"/"+name]
+	switch r.Method {
+	case http.MethodGet:
+		if q.Get("alt") == "media" {
+			g.calls = append(g.calls, "GET media "+name)
+		} else {
+			g.calls = append(g.calls, "GET attrs "+name)
+		}
+		if !ok {
+			gcsError(w, http.StatusNotFound, "No such object: "+name)
+			return
+		}
+		if q.Get("alt") == "media" {
+			w.Header().Set("X-Goog-Generation", strconv.FormatInt(o.gen, 10))
+			w.Write(o.data)
+			return
+		}
+		writeGCSJSON(w, o.resource())
+	case http.MethodDelete:
+		g.calls = append(g.calls, "DELETE "+name)
+		if !ok {
+			gcsError(w, http.StatusNotFound, "No such object: "+name)
+			return
+		}
+		if !g.checkConditions(bucket, name, q) {
+			gcsError(w, http.StatusPreconditionFailed, "conditionNotMet")
+			return
+		}
+		delete(g.objects, bucket+"/"+name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		gcsError(w, http.StatusNotImplemented, "the fake doesn't implement "+r.Method+" of objects")
+	}
+}
+
+func (g *GCS) xmlRead(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	g.calls = append(g.calls, "GET media "+name)
+	o, ok := g.objects[bucket+"/"+name]
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(o.gen, 10))
+	w.Header().Set("X-Goog-Metageneration", "1")
+	w.Header().Set("Last-Modified", o.updated.Format(http.TimeFormat))
+	if o.contentType != "" {
+		w.Header().Set("Content-Type", o.contentType)
+	}
+	start, end := 0, len(o.data)
+	spec, ranged := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if ranged {
+		from, to, _ := strings.Cut(spec, "-")
+		if from == "" {
+			n, _ := strconv.Atoi(to)
+			start = max(len(o.data)-n, 0)
+		} else {
+			start, _ = strconv.Atoi(from)
+			if to != "" {
+				e, _ := strconv.Atoi(to)
+				end = min(e+1, len(o.data))
+			}
+		}
+		if start > len(o.data) {
+			start = len(o.data)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", start, end-1, len(o.data)))
+		w.Header().Set("Content-Length", strconv.Itoa(end-start))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+	}
+	w.Write(o.data[start:end])
+}
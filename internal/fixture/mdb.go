@@ -0,0 +1,387 @@
+package fixture
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+// MDBTable is a table for MDB to add to a database. Each column's type is
+// that of its values, which are int32 (a Long), float64 (a Double) or
+// string (a Text of up to 255 characters). There are no nulls.
+type MDBTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// PRISMTables are the PRISM tables the links query reads, with a single
+// point-to-point link between two sites.
+var PRISMTables = []MDBTable{
+	{
+		Name:    "licence",
+		Columns: []string{"licenceid", "clientid", "licencetype", "licencecode"},
+		Rows:    [][]interface{}{{int32(1), int32(1), "Point to Point", "F1"}},
+	},
+	{
+		Name:    "clientname",
+		Columns: []string{"clientid", "name"},
+		Rows:    [][]interface{}{{int32(1), "Self Test Ltd"}},
+	},
+	{
+		Name:    "spectrum",
+		Columns: []string{"licenceid", "spectrumstatus", "frequency", "power", "polarisation"},
+		Rows:    [][]interface{}{{int32(1), "Current", 7500.0, 30.0, "V"}},
+	},
+	{
+		Name:    "emission",
+		Columns: []string{"licenceid", "emission"},
+		Rows:    [][]interface{}{{int32(1), "28M0D7W"}},
+	},
+	{
+		Name:    "location",
+		Columns: []string{"locationid", "locationname"},
+		Rows:    [][]interface{}{{int32(1), "Mt Victoria"}, {int32(2), "Mt Kaukau"}},
+	},
+	{
+		Name:    "geographicreference",
+		Columns: []string{"locationid", "georeferencetypeid", "easting", "northing"},
+		Rows:    [][]interface{}{{int32(1), int32(3), 174.7943, -41.2963}, {int32(2), int32(3), 174.7753, -41.2476}},
+	},
+	{
+		Name:    "transmitconfiguration",
+		Columns: []string{"licenceid", "locationid", "txantennaheight"},
+		Rows:    [][]interface{}{{int32(1), int32(1), 20.0}},
+	},
+	{
+		Name:    "receiveconfiguration",
+		Columns: []string{"licenceid", "locationid", "rxantennaheight"},
+		Rows:    [][]interface{}{{int32(1), int32(2), 15.0}},
+	},
+}
+
+// PRISMZip returns a prism.zip whose prism.mdb is template with
+// PRISMTables added. See MDB.
+func PRISMZip(template []byte) ([]byte, error) {
+	mdb, err := MDB(template, PRISMTables)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// A fixed time, so the zip is the same every time it's made.
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "prism.mdb", Method: zip.Deflate, Modified: mdbCreated})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(mdb); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The layout of a Jet 4 database, as Jackcess writes it.
+const (
+	pageSize = 4096
+	// catalogDataPage holds the template's MSysObjects rows, and catalogPage
+	// is MSysObjects' table definition.
+	catalogPage     = 2
+	catalogDataPage = 14
+	// tablesID is the id of the catalog's "Tables" container.
+	tablesID = 0x0f000001
+	// magicTableNumber is in every table and column definition.
+	magicTableNumber = 1625
+	// A usage map row is a type byte, a first page and a bitmap of pages.
+	usageMapSize = 1 + 4 + 64
+)
+
+// mdbCreated is when the tables MDB adds were created.
+var mdbCreated = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// MDB returns a copy of template, the empty Jet 4 database Jackcess creates
+// new databases from, with tables added. Each table gets a definition page,
+// a page of usage maps, and as many data pages as its rows need. Indexes
+// aren't written, so it's only suitable for reading tables in full, as
+// package mdb and Jackcess do.
+func MDB(template []byte, tables []MDBTable) ([]byte, error) {
+	if len(template)%pageSize != 0 || len(template) <= catalogDataPage*pageSize {
+		return nil, errors.New("template isn't a Jet 4 database")
+	}
+	db := append([]byte(nil), template...)
+	var catalog [][]byte
+	for _, t := range tables {
+		tdef := len(db) / pageSize
+		types, err := columnTypes(t)
+		if err != nil {
+			return nil, err
+		}
+		var rows [][]byte
+		for _, r := range t.Rows {
+			row, err := tableRow(types, r)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", t.Name, err)
+			}
+			rows = append(rows, row)
+		}
+		data := dataPages(tdef, rows)
+		if tdef+2+len(data) > 8*(usageMapSize-5) {
+			return nil, fmt.Errorf("%v: too many pages for an inline usage map", t.Name)
+		}
+		db = append(db, tableDef(tdef, t, types, len(rows))...)
+		db = append(db, usageMaps(tdef+2, len(data))...)
+		for _, pg := range data {
+			db = append(db, pg...)
+		}
+		catalog = append(catalog, catalogRow(tdef, t.Name))
+	}
+	if err := addRows(db[catalogDataPage*pageSize:(catalogDataPage+1)*pageSize], catalog); err != nil {
+		return nil, fmt.Errorf("couldn't add tables to the catalog: %v", err)
+	}
+	// The catalog's definition has its row count.
+	n := db[catalogPage*pageSize+16:]
+	binary.LittleEndian.PutUint32(n, binary.LittleEndian.Uint32(n)+uint32(len(catalog)))
+	return db, nil
+}
+
+// Column types.
+const (
+	typeLong   = 0x04
+	typeDouble = 0x07
+	typeText   = 0x0a
+)
+
+// columnTypes returns the types of t's columns, from the values in its
+// first row.
+func columnTypes(t MDBTable) ([]byte, error) {
+	if len(t.Rows) == 0 {
+		return nil, fmt.Errorf("%v: no rows to take the column types from", t.Name)
+	}
+	types := make([]byte, len(t.Columns))
+	for i := range t.Columns {
+		switch t.Rows[0][i].(type) {
+		case int32:
+			types[i] = typeLong
+		case float64:
+			types[i] = typeDouble
+		case string:
+			types[i] = typeText
+		default:
+			return nil, fmt.Errorf("%v.%v: unsupported value %T", t.Name, t.Columns[i], t.Rows[0][i])
+		}
+	}
+	return types, nil
+}
+
+// tableDef returns the definition page of a table with no indexes, whose
+// usage maps are on the following page.
+func tableDef(page int, t MDBTable, types []byte, rows int) []byte {
+	pg := make([]byte, pageSize)
+	pg[0], pg[1] = 0x02, 0x01
+	le := binary.LittleEndian
+	le.PutUint32(pg[12:], magicTableNumber)
+	le.PutUint32(pg[16:], uint32(rows))
+	pg[24] = 1
+	pg[40] = 0x4e // A user table.
+	vars := 0
+	for _, typ := range types {
+		if typ == typeText {
+			vars++
+		}
+	}
+	le.PutUint16(pg[41:], uint16(len(types)))
+	le.PutUint16(pg[43:], uint16(vars))
+	le.PutUint16(pg[45:], uint16(len(types)))
+	// The owned pages map is row 0 of the next page, and the free space
+	// map row 1.
+	le.PutUint32(pg[55:], uint32(page+1)<<8)
+	le.PutUint32(pg[59:], uint32(page+1)<<8|1)
+	off := 63
+	fixed, varNum := 0, 0
+	for i, typ := range types {
+		c := pg[off : off+25]
+		c[0] = typ
+		le.PutUint32(c[1:], magicTableNumber)
+		le.PutUint16(c[5:], uint16(i))
+		le.PutUint16(c[9:], uint16(i))
+		if typ == typeText {
+			le.PutUint16(c[7:], uint16(varNum))
+			varNum++
+			// The general sort order.
+			le.PutUint16(c[11:], 1033)
+			c[15] = 0x02
+			le.PutUint16(c[23:], 510)
+		} else {
+			c[15] = 0x03
+			le.PutUint16(c[21:], uint16(fixed))
+			le.PutUint16(c[23:], uint16(fixedSize(typ)))
+			fixed += fixedSize(typ)
+		}
+		off += 25
+	}
+	for _, name := range t.Columns {
+		u := ucs2(name)
+		le.PutUint16(pg[off:], uint16(len(u)))
+		off += 2 + copy(pg[off+2:], u)
+	}
+	pg[off], pg[off+1] = 0xff, 0xff
+	off += 2
+	le.PutUint32(pg[8:], uint32(off))
+	le.PutUint16(pg[2:], uint16(pageSize-off-8))
+	return pg
+}
+
+func fixedSize(typ byte) int {
+	if typ == typeLong {
+		return 4
+	}
+	return 8
+}
+
+// tableRow encodes a row of a table whose columns are of types.
+func tableRow(types []byte, vals []interface{}) ([]byte, error) {
+	if len(vals) != len(types) {
+		return nil, fmt.Errorf("row has %v values, want %v", len(vals), len(types))
+	}
+	var fixed []byte
+	var vars [][]byte
+	for i, typ := range types {
+		switch v := vals[i].(type) {
+		case int32:
+			if typ != typeLong {
+				return nil, fmt.Errorf("column %v: %T in a column of another type", i, v)
+			}
+			fixed = binary.LittleEndian.AppendUint32(fixed, uint32(v))
+		case float64:
+			if typ != typeDouble {
+				return nil, fmt.Errorf("column %v: %T in a column of another type", i, v)
+			}
+			fixed = binary.LittleEndian.AppendUint64(fixed, math.Float64bits(v))
+		case string:
+			if typ != typeText || len(utf16.Encode([]rune(v))) > 255 {
+				return nil, fmt.Errorf("column %v: %q doesn't fit", i, v)
+			}
+			vars = append(vars, ucs2(v))
+		default:
+			return nil, fmt.Errorf("column %v: unsupported value %T", i, v)
+		}
+	}
+	mask := make([]byte, (len(types)+7)/8)
+	for i := range mask {
+		mask[i] = 0xff
+	}
+	if n := len(types) % 8; n != 0 {
+		mask[len(mask)-1] = 1<<n - 1
+	}
+	return encodeRow(len(types), fixed, vars, mask), nil
+}
+
+// encodeRow lays out a Jet 4 row: its column count, fixed-length data,
+// variable-length data, then the offsets of the variable-length columns
+// in reverse, their count, and the null mask.
+func encodeRow(cols int, fixed []byte, vars [][]byte, mask []byte) []byte {
+	le := binary.LittleEndian
+	row := le.AppendUint16(nil, uint16(cols))
+	row = append(row, fixed...)
+	offsets := make([]uint16, 0, len(vars)+1)
+	for _, v := range vars {
+		offsets = append(offsets, uint16(len(row)))
+		row = append(row, v...)
+	}
+	offsets = append(offsets, uint16(len(row)))
+	if len(vars) > 0 {
+		for i := len(offsets) - 1; i >= 0; i-- {
+			row = le.AppendUint16(row, offsets[i])
+		}
+		row = le.AppendUint16(row, uint16(len(vars)))
+	}
+	return append(row, mask...)
+}
+
+// catalogRow is the MSysObjects row for a table defined on page tdef. Of
+// MSysObjects' columns, only Id, ParentId, Type, DateCreate, DateUpdate,
+// Flags, Name and Owner are set.
+func catalogRow(tdef int, name string) []byte {
+	le := binary.LittleEndian
+	fixed := le.AppendUint32(nil, uint32(tdef))
+	fixed = le.AppendUint32(fixed, tablesID)
+	fixed = le.AppendUint16(fixed, 1) // A local table.
+	created := math.Float64bits(mdbCreated.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	fixed = le.AppendUint64(fixed, created)
+	fixed = le.AppendUint64(fixed, created)
+	fixed = le.AppendUint32(fixed, 0)
+	// MSysObjects has 11 variable-length columns, of which Name and Owner
+	// are first.
+	vars := make([][]byte, 11)
+	vars[0] = ucs2(name)
+	vars[1] = []byte{0xa6, 0x33}
+	return encodeRow(17, fixed, vars, []byte{0xff, 0x00, 0x00})
+}
+
+// usageMaps returns a page of a table's usage maps: which n pages, from
+// first, it owns, and which have free space, which is none of them.
+func usageMaps(first, n int) []byte {
+	owned := make([]byte, usageMapSize)
+	for p := first; p < first+n; p++ {
+		owned[5+p/8] |= 1 << (p % 8)
+	}
+	pg := make([]byte, pageSize)
+	pg[0], pg[1] = 0x01, 0x01
+	binary.LittleEndian.PutUint16(pg[2:], pageSize-14)
+	addRows(pg, [][]byte{owned, make([]byte, usageMapSize)})
+	return pg
+}
+
+// dataPages lays out rows in data pages of the table defined on page tdef.
+func dataPages(tdef int, rows [][]byte) [][]byte {
+	var pages [][]byte
+	var pg []byte
+	for _, r := range rows {
+		if pg == nil || int(binary.LittleEndian.Uint16(pg[2:])) < len(r)+2 {
+			pg = make([]byte, pageSize)
+			pg[0], pg[1] = 0x01, 0x01
+			binary.LittleEndian.PutUint16(pg[2:], pageSize-14)
+			binary.LittleEndian.PutUint32(pg[4:], uint32(tdef))
+			pages = append(pages, pg)
+		}
+		addRows(pg, [][]byte{r})
+	}
+	return pages
+}
+
+// addRows adds rows to a data page, below the ones already in it.
+func addRows(pg []byte, rows [][]byte) error {
+	le := binary.LittleEndian
+	for _, r := range rows {
+		free, n := int(le.Uint16(pg[2:])), int(le.Uint16(pg[12:]))
+		if len(r)+2 > free {
+			return errors.New("page is full")
+		}
+		end := pageSize
+		if n > 0 {
+			end = int(le.Uint16(pg[14+2*(n-1):]) & 0x1fff)
+		}
+		start := end - len(r)
+		copy(pg[start:], r)
+		le.PutUint16(pg[14+2*n:], uint16(start))
+		le.PutUint16(pg[12:], uint16(n+1))
+		le.PutUint16(pg[2:], uint16(free-len(r)-2))
+	}
+	return nil
+}
+
+// ucs2 encodes s as Jet 4 stores uncompressed text.
+func ucs2(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
@@ -0,0 +1,89 @@
+// Package lambda runs a handler under the AWS Lambda custom runtime API, so
+// the fetcher can be deployed as a Lambda function on the provided.al2023
+// runtime without depending on the AWS SDK.
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Handler handles one invocation, given its JSON event, and returns the JSON
+// response.
+type Handler func(ctx context.Context, event json.RawMessage) ([]byte, error)
+
+// InRuntime reports whether the process was started by the Lambda runtime.
+func InRuntime() bool {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
+}
+
+// Start handles invocations until the runtime API fails, which it only does
+// when the function is being shut down.
+func Start(h Handler) error {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		return fmt.Errorf("AWS_LAMBDA_RUNTIME_API isn't set: not running under Lambda")
+	}
+	base := "http://" + api + "/2018-06-01/runtime/invocation/"
+	for {
+		if err := invoke(base, h); err != nil {
+			return err
+		}
+	}
+}
+
+// invoke waits for the next invocation, handles it, and reports the result.
+func invoke(base string, h Handler) error {
+	// The next invocation can be a long time coming, so there's no timeout.
+	resp, err := http.Get(base + "next")
+	if err != nil {
+		return fmt.Errorf("couldn't get next invocation: %v", err)
+	}
+	event, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't read invocation: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("couldn't get next invocation: %v", resp.Status)
+	}
+	id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+
+	ctx := context.Background()
+	if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(ms))
+		defer cancel()
+	}
+
+	out, err := h(ctx, event)
+	if err != nil {
+		log.Printf("invocation %v failed: %v", id, err)
+		body, _ := json.Marshal(struct {
+			ErrorMessage string `json:"errorMessage"`
+			ErrorType    string `json:"errorType"`
+		}{err.Error(), "FetchError"})
+		return post(base+id+"/error", body)
+	}
+	return post(base+id+"/response", out)
+}
+
+func post(url string, body []byte) error {
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("couldn't post to runtime API: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("couldn't post to runtime API: %v", resp.Status)
+	}
+	return nil
+}
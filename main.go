@@ -0,0 +1,282 @@
+// Command nzwirelessmap-fetch serves an HTTP API that fetches RSM's PRISM
+// database of radio licences, extracts the point-to-point links, and stores
+// them in Google Cloud Storage for the NZ wireless map.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/alert"
+	"github.com/mhansen/nzwirelessmap-fetch/bq"
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/dem"
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/lambda"
+	"github.com/mhansen/nzwirelessmap-fetch/redact"
+	"github.com/mhansen/nzwirelessmap-fetch/server"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+var (
+	prismZipURL          = flag.String("prism_zip_url", fetch.DefaultURL, "URL of zip to fetch")
+	bucketName           = flag.String("bucket_name", store.DefaultBucket, "Google Cloud Storage bucket name")
+	datasets             = flag.String("datasets", "", `JSON file listing other datasets to serve at /fetch/{name} and /latest/{name}, like [{"name": "...", "prism_zip_url": "...", "bucket_name": "...", "query_file": "..."}]. Each needs a bucket of its own`)
+	parallelism          = flag.Int("parallelism", 2, "Number of snapshots to process at once when reprocessing")
+	downloadRateLimit    = flag.Int("download_rate_limit", 0, "Maximum upstream download rate in bytes per second, or 0 for unlimited")
+	downloadCache        = flag.String("download_cache", "", "Directory to keep recently downloaded zips in, so retries and reprocessing on this instance don't download them again. Empty disables the cache")
+	downloadCacheSize    = flag.Int("download_cache_size", fetch.DefaultCacheSize, "How many zips to keep in -download_cache")
+	idempotencyTTL       = flag.Duration("idempotency_ttl", time.Hour, "How long to remember the response to a request with an Idempotency-Key header, unless it was a server error")
+	formats              = flag.String("formats", "geojson,current.geojson,current.json,clusters.json,bands.csv,licensees.json,arrow,gpkg,topojson,datasette.sqlite", "Comma-separated formats to publish besides CSV and JSON. gpkg and datasette.sqlite are built with the sqlite3 CLI, even with -native_convert")
+	jsonPatch            = flag.Bool("json_patch", false, "Publish a JSON Patch from the previous snapshot's prism.json to each new one, so mirrors can update incrementally")
+	sortRows             = flag.Bool("sort_rows", false, "Sort rows into a stable order, so snapshots can be diffed byte by byte")
+	uploadChunkSize      = flag.Int("upload_chunk_size", -1, "Bytes per request when uploading to GCS: 0 uploads in one request, negative uses the client library default")
+	composePartSize      = flag.Int("compose_part_size", 0, "Upload artifacts bigger than this many bytes as parts in parallel, composed into one object. 0 uploads each artifact in one stream")
+	arcThresholdKm       = flag.Float64("arc_threshold_km", convert.ArcThresholdKm, "Draw links longer than this as great-circle arcs in GeoJSON; 0 draws straight lines")
+	mergeBidirectional   = flag.Bool("merge_bidirectional", false, "In GeoJSON, draw paths licensed in both directions as one feature with both licence IDs")
+	eventsTable          = flag.String("bigquery_events_table", "", `BigQuery table to stream licence change events into, as "project.dataset.table". It's created if it doesn't exist. Empty disables streaming`)
+	staleAfter           = flag.Duration("stale_after", 14*24*time.Hour, "Alert if RSM hasn't published a new snapshot for this long; 0 disables")
+	alertWebhook         = flag.String("alert_webhook", "", "URL to POST alerts to as JSON. If empty, alerts are only logged")
+	nativeConvert        = flag.Bool("native_convert", false, "Convert prism.mdb in Go, rather than with mdb-sqlite, the sqlite3 CLI and csv2json2.py. Experimental: the Go reader hasn't yet been checked against a real prism.mdb. Ignored for datasets with a query_file of their own. The gpkg and datasette.sqlite formats still need the sqlite3 CLI")
+	sqlitePageSize       = flag.Int("sqlite_page_size", 4096, "Page size SQLite databases are rebuilt with, by VACUUM, before they're queried or published: the legacy conversion's, and the gpkg and datasette.sqlite formats")
+	salvageZip           = flag.Bool("salvage_zip", true, "If upstream's zip is damaged, try to recover prism.mdb from its local file header. The damaged zip is kept under forensics/ either way")
+	archiveZip           = flag.String("archive_zip", server.ArchiveZipAlways, `When to archive the upstream zip: "always"; "new", only if no earlier snapshot had the same content; or "never", keeping only the derived files. Snapshots without an archived zip can't be reprocessed`)
+	contentAddressed     = flag.Bool("content_addressed", false, "Upload each distinct timestamped artifact once, to blobs/sha256/ by content hash, and copy it server-side to the timestamped names of identical artifacts")
+	archiveCompression   = flag.String("archive_compression", "", `Compress archived zips and CSVs with "gzip" or "zstd"; empty stores them as is`)
+	csvColumns           = flag.String("csv_columns", "", `Publish curated.csv with these columns, in order, each optionally renamed with "=", e.g. "licenceid,clientname=licensee"`)
+	csvDelimiter         = flag.String("csv_delimiter", ",", `Field delimiter of curated.csv: a single character, or "tab"`)
+	csvQuoteAll          = flag.Bool("csv_quote_all", false, "Quote every field of curated.csv, not just those that need it")
+	csvCRLF              = flag.Bool("csv_crlf", false, "End lines of curated.csv with CRLF")
+	csvBOM               = flag.Bool("csv_bom", false, "Start curated.csv with a UTF-8 byte order mark, so Excel reads it as UTF-8")
+	topoJSONQuantization = flag.Float64("topojson_quantization", convert.TopoJSONQuantization, "Positions per axis to quantize TopoJSON coordinates to")
+	demSpec              = flag.String("dem", "", "Digital elevation model to add endpoint ground elevations from: an Open Topo Data API URL such as https://api.opentopodata.org/v1/nzdem8m, or a directory of WGS84 ESRI ASCII grids. Empty disables elevations and the los.csv format")
+	failureCooldown      = flag.Duration("failure_cooldown", 15*time.Minute, "After upstream fails, skip fetches for this long rather than retry it, or longer if upstream sent Retry-After; 0 disables all but Retry-After")
+	minFetchInterval     = flag.Duration("min_fetch_interval", 5*time.Minute, "Request upstream at most this often, however often fetches are triggered; 0 disables")
+	requireAPIKeys       = flag.Bool("require_api_keys", false, "Require an API key, issued through /admin/keys, for the query API, over HTTP and gRPC")
+	defaultKeyRate       = flag.Int("default_key_rate", 60, "Requests a minute allowed to an API key issued without a rate of its own")
+	operatorToken        = flag.String("operator_token", os.Getenv("OPERATOR_TOKEN"), "Bearer token needed to trigger runs and manage API keys. Defaults to $OPERATOR_TOKEN; if empty, runs can be triggered by anyone and keys can't be managed")
+	maintenance          = flag.String("maintenance", "", "If set, pause fetches on this process with this reason, as PUT /admin/maintenance does for every replica")
+	schedule             = flag.Duration("schedule", 0, "Fetch this often from a built-in scheduler, instead of relying on an external one to call /fetch. With several replicas, only the one holding the lease in the bucket fetches. 0 disables")
+	leaderLease          = flag.Duration("leader_lease", 30*time.Second, "How long the scheduler's leader holds its lease without renewing it")
+	grpcListenAddr       = flag.String("grpc_listen", "", "Address to serve the gRPC API on, as for -listen. Empty disables gRPC")
+	mode                 = flag.String("mode", "", `How to run: "serve" to serve HTTP, "job" to run -job_task once and exit (as a Cloud Run job), or "lambda" to handle AWS Lambda invocations. Defaults to "job" under Cloud Run jobs, "lambda" under Lambda, and "serve" otherwise`)
+	jobTask              = flag.String("job_task", "fetch", `What to run in job mode, and in Lambda invocations that don't say: "fetch" or "reprocess"`)
+	trustIdentityHeaders = flag.Bool("trust_identity_headers", false, "Record the caller Cloud IAP or an OIDC token identifies as who triggered each run. Only set behind IAP or Cloud Run authentication, since the headers aren't verified here")
+	redactKeys           = flag.String("redact_keys", "", "Comma-separated header, JSON and query parameter names, beyond Authorization, Cookie, Set-Cookie, X-API-Key and key, whose values are redacted from logs and archived headers")
+	listenAddr           = flag.String("listen", "", `Address to serve on: "host:port", "unix:///path/to/socket", or "systemd" to use a socket passed by systemd socket activation. Defaults to ":$PORT", or ":8080" if PORT is unset`)
+)
+
+// logPreflight checks the conversion's dependencies at startup. Problems are
+// logged rather than fatal, so /readyz can still report them.
+func logPreflight(s *server.Server) {
+	problems := s.Preflight(context.Background())
+	for _, p := range problems {
+		log.Printf("preflight: %v", p)
+	}
+	if len(problems) == 0 {
+		log.Print("preflight: all dependencies present")
+	}
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// runMode returns the -mode flag, or the mode the environment implies.
+func runMode() string {
+	switch {
+	case *mode != "":
+		return *mode
+	case os.Getenv("CLOUD_RUN_JOB") != "":
+		return "job"
+	case lambda.InRuntime():
+		return "lambda"
+	default:
+		return "serve"
+	}
+}
+
+// runTask runs a fetch or a reprocess once, returning what it produced.
+func runTask(ctx context.Context, s *server.Server, task string) ([]byte, error) {
+	switch task {
+	case "fetch":
+		return s.Fetch(ctx)
+	case "reprocess":
+		var report strings.Builder
+		err := s.Reprocess(ctx, &report)
+		return []byte(report.String()), err
+	default:
+		return nil, fmt.Errorf(`unknown task %q: want "fetch" or "reprocess"`, task)
+	}
+}
+
+// handleLambda handles a Lambda invocation. The event may name the task to
+// run, as {"task": "reprocess"}; otherwise -job_task is run.
+func handleLambda(s *server.Server) lambda.Handler {
+	return func(ctx context.Context, event json.RawMessage) ([]byte, error) {
+		var e struct {
+			Task string `json:"task"`
+		}
+		// Scheduled events and the like aren't ours to parse, so a bad event
+		// just runs the default task.
+		_ = json.Unmarshal(event, &e)
+		if e.Task == "" {
+			e.Task = *jobTask
+		}
+		out, err := runTask(ctx, s, e.Task)
+		if err != nil {
+			return nil, err
+		}
+		if e.Task == "fetch" {
+			return out, nil
+		}
+		return json.Marshal(map[string]string{"report": string(out)})
+	}
+}
+
+func main() {
+	flag.Parse()
+	redact.AddKeys(splitList(*redactKeys)...)
+	redact.AddSecret(*operatorToken)
+	redact.AddSecret(*alertWebhook)
+	log.SetOutput(redact.NewWriter(os.Stderr))
+	log.Print("Fetch server started.")
+
+	store.ChunkSize = *uploadChunkSize
+	store.ComposePartSize = *composePartSize
+	if err := store.CheckCompression(*archiveCompression); err != nil {
+		log.Fatal(err)
+	}
+	store.Compression = *archiveCompression
+	store.ContentAddressed = *contentAddressed
+	if err := convert.CheckPageSize(*sqlitePageSize); err != nil {
+		log.Fatal(err)
+	}
+	convert.SqlitePageSize = *sqlitePageSize
+	convert.ArcThresholdKm = *arcThresholdKm
+	convert.MergeBidirectional = *mergeBidirectional
+	convert.TopoJSONQuantization = *topoJSONQuantization
+	cfg := server.Config{
+		PrismZipURL:          *prismZipURL,
+		BucketName:           *bucketName,
+		Parallelism:          *parallelism,
+		DownloadRateLimit:    *downloadRateLimit,
+		IdempotencyTTL:       *idempotencyTTL,
+		Formats:              splitList(*formats),
+		SalvageZip:           *salvageZip,
+		NativeConvert:        *nativeConvert,
+		ArchiveZip:           *archiveZip,
+		JSONPatch:            *jsonPatch,
+		SortRows:             *sortRows,
+		StaleAfter:           *staleAfter,
+		FailureCooldown:      *failureCooldown,
+		MinFetchInterval:     *minFetchInterval,
+		RequireAPIKeys:       *requireAPIKeys,
+		DefaultKeyRate:       *defaultKeyRate,
+		TrustIdentityHeaders: *trustIdentityHeaders,
+		OperatorToken:        *operatorToken,
+		Maintenance:          *maintenance,
+		Schedule:             *schedule,
+		LeaseTTL:             *leaderLease,
+	}
+	if *downloadCache != "" {
+		cfg.Cache = &fetch.Cache{Dir: *downloadCache, Max: *downloadCacheSize}
+	}
+	if *eventsTable != "" {
+		events, err := bq.Open(context.Background(), *eventsTable)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Events = events
+	}
+	if *alertWebhook != "" {
+		cfg.Alerts = alert.Webhook{URL: *alertWebhook}
+	}
+	comma, err := convert.ParseDelimiter(*csvDelimiter)
+	if err != nil {
+		log.Fatalf("bad -csv_delimiter: %v", err)
+	}
+	dialect := convert.Dialect{Comma: comma, QuoteAll: *csvQuoteAll, CRLF: *csvCRLF, BOM: *csvBOM}
+	if *csvColumns != "" || dialect != (convert.Dialect{Comma: ','}) {
+		c := convert.CuratedCSV{Dialect: dialect}
+		if *csvColumns != "" {
+			if c.Columns, err = convert.ParseColumns(*csvColumns); err != nil {
+				log.Fatalf("bad -csv_columns: %v", err)
+			}
+		}
+		convert.Register(c)
+		if !slices.Contains(cfg.Formats, c.Name()) {
+			cfg.Formats = append(cfg.Formats, c.Name())
+		}
+	}
+	if *demSpec != "" {
+		src, err := dem.Open(*demSpec)
+		if err != nil {
+			log.Fatalf("couldn't open DEM: %v", err)
+		}
+		cfg.DEM = src
+		convert.Register(convert.LineOfSight{DEM: src})
+	}
+	if *datasets != "" {
+		if cfg.Datasets, err = server.ReadDatasets(*datasets); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s, err := server.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logPreflight(s)
+
+	switch m := runMode(); m {
+	case "serve":
+	case "job":
+		out, err := runTask(context.Background(), s, *jobTask)
+		os.Stdout.Write(out)
+		if err := s.Close(); err != nil {
+			log.Printf("couldn't close: %v", err)
+		}
+		if err != nil {
+			log.Fatalf("%v failed: %v", *jobTask, err)
+		}
+		return
+	case "lambda":
+		log.Fatal(lambda.Start(handleLambda(s)))
+	default:
+		log.Fatalf(`unknown -mode %q: want "serve", "job" or "lambda"`, m)
+	}
+
+	if *schedule > 0 {
+		go s.RunScheduler(context.Background())
+	}
+	if *grpcListenAddr != "" {
+		gl, err := server.Listen(*grpcListenAddr)
+		if err != nil {
+			log.Fatalf("couldn't listen for gRPC: %v", err)
+		}
+		log.Printf("serving gRPC on %v", gl.Addr())
+		go func() { log.Fatal(s.GRPCServer().Serve(gl)) }()
+	}
+
+	l, err := server.Listen(*listenAddr)
+	if err != nil {
+		log.Fatalf("couldn't listen: %v", err)
+	}
+	log.Printf("listening on %v", l.Addr())
+	log.Fatal(http.Serve(l, s.Handler()))
+}
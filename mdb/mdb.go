@@ -0,0 +1,248 @@
+// Package mdb reads tables from Microsoft Access databases, like PRISM's
+// prism.mdb, without a JRE or mdbtools.
+//
+// Jet 3 (Access 97) and Jet 4 (Access 2000-2003) files are supported,
+// including those with RC4-encoded pages. It only reads: tables are
+// full-scanned a data page at a time, and indexes are ignored. The format
+// is as described by mdbtools' HACKING and implemented by Jackcess.
+package mdb
+
+import (
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page types.
+const (
+	pageData = 0x01
+	pageTDef = 0x02
+)
+
+// catalogPage is the table definition of MSysObjects, which lists every
+// other table.
+const catalogPage = 2
+
+// Flags in a row's offset in a data page.
+const (
+	rowDeleted  = 0x8000
+	rowOverflow = 0x4000
+	offsetMask  = 0x1fff
+)
+
+// format describes the layout of one version of the Jet format. Offsets
+// are from the start of the page or the structure they're in.
+type format struct {
+	jet4     bool
+	pageSize int
+	// rowCount is the offset in a data page of its row count, which is
+	// followed by the row offsets.
+	rowCount int
+	// Table definitions.
+	tdefNumVarCols int
+	tdefNumCols    int
+	tdefNumRealIdx int
+	tdefUsageMap   int
+	tdefColsStart  int
+	realIdxSize    int
+	// Column definitions.
+	colSize        int
+	colNum         int
+	colVarNum      int
+	colFlags       int
+	colFixedOffset int
+	colLen         int
+	colPrecision   int
+	colScale       int
+}
+
+var (
+	jet3 = &format{
+		pageSize:       2048,
+		rowCount:       0x08,
+		tdefNumVarCols: 23,
+		tdefNumCols:    25,
+		tdefNumRealIdx: 31,
+		tdefUsageMap:   35,
+		tdefColsStart:  43,
+		realIdxSize:    8,
+		colSize:        18,
+		colNum:         1,
+		colVarNum:      3,
+		colFlags:       13,
+		colFixedOffset: 14,
+		colLen:         16,
+		colPrecision:   11,
+		colScale:       12,
+	}
+	jet4 = &format{
+		jet4:           true,
+		pageSize:       4096,
+		rowCount:       0x0c,
+		tdefNumVarCols: 43,
+		tdefNumCols:    45,
+		tdefNumRealIdx: 51,
+		tdefUsageMap:   55,
+		tdefColsStart:  63,
+		realIdxSize:    12,
+		colSize:        25,
+		colNum:         5,
+		colVarNum:      7,
+		colFlags:       15,
+		colFixedOffset: 21,
+		colLen:         23,
+		colPrecision:   11,
+		colScale:       12,
+	}
+)
+
+// headerKey is the RC4 key the database header is encoded with.
+var headerKey = []byte{0xc7, 0xda, 0x39, 0x6b}
+
+// ErrNotAccess is returned by Open for files that aren't Access databases.
+var ErrNotAccess = errors.New("not an Access database")
+
+// DB is an open Access database.
+type DB struct {
+	r   io.ReaderAt
+	f   *format
+	key uint32
+	// tables maps the lowercased names of user tables to their
+	// definitions' pages. names is in catalog order.
+	tables map[string]int
+	names  []string
+}
+
+// Open reads the header and table catalog of the database in r.
+func Open(r io.ReaderAt) (*DB, error) {
+	hdr := make([]byte, jet3.pageSize)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("couldn't read header: %v", err)
+	}
+	if hdr[0] != 0 || !strings.HasPrefix(string(hdr[4:20]), "Standard ") {
+		return nil, ErrNotAccess
+	}
+	db := &DB{r: r, f: jet3}
+	encLen := 126
+	if hdr[0x14] >= 1 {
+		db.f = jet4
+		encLen = 128
+	}
+	c, _ := rc4.NewCipher(headerKey)
+	c.XORKeyStream(hdr[0x18:0x18+encLen], hdr[0x18:0x18+encLen])
+	// If this isn't zero, pages are RC4-encoded, with a key derived from
+	// it and the page number. See page.
+	db.key = binary.LittleEndian.Uint32(hdr[0x3e:])
+	if err := db.readCatalog(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// page reads page n.
+func (db *DB) page(n int) ([]byte, error) {
+	b := make([]byte, db.f.pageSize)
+	if _, err := db.r.ReadAt(b, int64(n)*int64(db.f.pageSize)); err != nil {
+		return nil, fmt.Errorf("couldn't read page %v: %v", n, err)
+	}
+	if n != 0 && db.key != 0 {
+		var k [4]byte
+		binary.LittleEndian.PutUint32(k[:], db.key^uint32(n))
+		c, _ := rc4.NewCipher(k[:])
+		c.XORKeyStream(b, b)
+	}
+	return b, nil
+}
+
+// row finds row n of page p, returning the page and the row's bounds in
+// it. Overflow rows are followed to where their data is.
+func (db *DB) row(p, n int) (pg []byte, start, end int, err error) {
+	for hops := 0; ; hops++ {
+		if pg, err = db.page(p); err != nil {
+			return nil, 0, 0, err
+		}
+		var flags int
+		if start, end, flags, err = db.rowBounds(pg, n); err != nil {
+			return nil, 0, 0, fmt.Errorf("page %v: %v", p, err)
+		}
+		if flags&rowOverflow == 0 {
+			return pg, start, end, nil
+		}
+		if end-start < 4 || hops > 8 {
+			return nil, 0, 0, fmt.Errorf("page %v: bad overflow row %v", p, n)
+		}
+		n, p = int(pg[start]), int(get24(pg[start+1:]))
+	}
+}
+
+// rowBounds returns where row n of a data page starts and ends, and its
+// flags.
+func (db *DB) rowBounds(pg []byte, n int) (start, end, flags int, err error) {
+	rc := db.f.rowCount
+	if n >= int(binary.LittleEndian.Uint16(pg[rc:])) || rc+4+2*n > len(pg) {
+		return 0, 0, 0, fmt.Errorf("no row %v", n)
+	}
+	off := int(binary.LittleEndian.Uint16(pg[rc+2+2*n:]))
+	end = len(pg)
+	if n > 0 {
+		end = int(binary.LittleEndian.Uint16(pg[rc+2*n:])) & offsetMask
+	}
+	start = off & offsetMask
+	if start > end || end > len(pg) {
+		return 0, 0, 0, fmt.Errorf("row %v is out of bounds", n)
+	}
+	return start, end, off &^ offsetMask, nil
+}
+
+// readCatalog lists the user tables from MSysObjects.
+func (db *DB) readCatalog() error {
+	cat, err := db.table("MSysObjects", catalogPage)
+	if err != nil {
+		return fmt.Errorf("couldn't read catalog: %v", err)
+	}
+	id, name, typ, flags := cat.Column("Id"), cat.Column("Name"), cat.Column("Type"), cat.Column("Flags")
+	if id == nil || name == nil || typ == nil || flags == nil {
+		return errors.New("couldn't read catalog: MSysObjects is missing columns")
+	}
+	db.tables = make(map[string]int)
+	err = cat.Scan([]*Column{id, name, typ, flags}, func(vals []interface{}) error {
+		// Type 1 is a local table. System tables are flagged 0x80000000
+		// and hidden ones 0x2.
+		if t, _ := vals[2].(int16); t != 1 {
+			return nil
+		}
+		if f, _ := vals[3].(int32); uint32(f)&0x80000002 != 0 {
+			return nil
+		}
+		n, _ := vals[1].(string)
+		i, _ := vals[0].(int32)
+		db.tables[strings.ToLower(n)] = int(i & 0xffffff)
+		db.names = append(db.names, n)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't read catalog: %v", err)
+	}
+	return nil
+}
+
+// Tables lists the database's user tables.
+func (db *DB) Tables() []string {
+	return append([]string(nil), db.names...)
+}
+
+// Table reads the definition of a user table. Names aren't case-sensitive.
+func (db *DB) Table(name string) (*Table, error) {
+	p, ok := db.tables[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("no table %v", name)
+	}
+	return db.table(name, p)
+}
+
+func get24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
@@ -0,0 +1,257 @@
+package mdb
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// empty.mdb is the empty Jet 4 database Jackcess creates new databases
+// from, which the selftest's prism.mdb adds tables to. It has no user
+// tables, but its system tables have rows of most column types.
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile("testdata/empty.mdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOpen(t *testing.T) {
+	fixture := readFixture(t)
+	tests := []struct {
+		name    string
+		b       []byte
+		wantErr bool
+		notMDB  bool
+	}{
+		{name: "jet4", b: fixture},
+		{name: "encoded pages", b: encodePages(fixture, 0x12345678)},
+		{name: "zip", b: append([]byte("PK\x03\x04"), make([]byte, 4096)...), wantErr: true, notMDB: true},
+		{name: "empty", b: nil, wantErr: true},
+		{name: "header only", b: fixture[:jet4.pageSize], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(bytes.NewReader(tt.b))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrNotAccess) != tt.notMDB {
+				t.Errorf("Open() = %v, want ErrNotAccess %v", err, tt.notMDB)
+			}
+			if err != nil {
+				return
+			}
+			if !db.f.jet4 {
+				t.Errorf("Open() read Jet 3, want Jet 4")
+			}
+			if got := db.Tables(); len(got) != 0 {
+				t.Errorf("Tables() = %v, want no user tables", got)
+			}
+			if _, err := db.Table("MSysObjects"); err == nil {
+				t.Errorf("Table(MSysObjects) succeeded, want system tables hidden")
+			}
+		})
+	}
+}
+
+// encodePages returns a copy of the database in b with its pages
+// RC4-encoded with key, as Access does.
+func encodePages(b []byte, key uint32) []byte {
+	out := append([]byte(nil), b...)
+	hdr := out[0x18 : 0x18+128]
+	c, _ := rc4.NewCipher(headerKey)
+	c.XORKeyStream(hdr, hdr)
+	binary.LittleEndian.PutUint32(out[0x3e:], key)
+	c, _ = rc4.NewCipher(headerKey)
+	c.XORKeyStream(hdr, hdr)
+	for n := 1; (n+1)*jet4.pageSize <= len(out); n++ {
+		var k [4]byte
+		binary.LittleEndian.PutUint32(k[:], key^uint32(n))
+		c, _ := rc4.NewCipher(k[:])
+		pg := out[n*jet4.pageSize : (n+1)*jet4.pageSize]
+		c.XORKeyStream(pg, pg)
+	}
+	return out
+}
+
+func TestCatalog(t *testing.T) {
+	fixture := readFixture(t)
+	for _, encoded := range []bool{false, true} {
+		b := fixture
+		if encoded {
+			b = encodePages(b, 0xcafef00d)
+		}
+		db, err := Open(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cat, err := db.table("MSysObjects", catalogPage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []struct {
+			name string
+			typ  Type
+		}{
+			{"Id", Long}, {"ParentId", Long}, {"Name", Text}, {"Type", Int},
+			{"DateCreate", DateTime}, {"DateUpdate", DateTime}, {"Owner", Binary},
+			{"Flags", Long}, {"Database", Memo}, {"Connect", Memo},
+			{"ForeignName", Text}, {"RmtInfoShort", Binary}, {"RmtInfoLong", OLE},
+			{"Lv", OLE}, {"LvProp", OLE}, {"LvModule", OLE}, {"LvExtra", OLE},
+		}
+		if len(cat.Columns) != len(want) {
+			t.Fatalf("MSysObjects has %v columns, want %v", len(cat.Columns), len(want))
+		}
+		for i, w := range want {
+			if c := cat.Columns[i]; c.Name != w.name || c.Type != w.typ {
+				t.Errorf("column %v is %v %v, want %v %v", i, c.Name, c.Type, w.name, w.typ)
+			}
+		}
+
+		type object struct {
+			id      int32
+			typ     int16
+			created time.Time
+			props   int
+		}
+		got := make(map[string]object)
+		cols := []*Column{cat.Column("name"), cat.Column("id"), cat.Column("type"), cat.Column("datecreate"), cat.Column("lvprop")}
+		err = cat.Scan(cols, func(vals []interface{}) error {
+			props, _ := vals[4].([]byte)
+			got[vals[0].(string)] = object{vals[1].(int32), vals[2].(int16), vals[3].(time.Time), len(props)}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 18 {
+			t.Errorf("MSysObjects has %v rows, want 18", len(got))
+		}
+		created := time.Date(2003, 10, 30, 13, 19, 45, 843e6, time.UTC)
+		for name, w := range map[string]object{
+			"MSysObjects":       {id: 2, typ: 1, created: created},
+			"MSysACEs":          {id: 3, typ: 1, created: created},
+			"Tables":            {id: 0x0f000001, typ: 3, created: created},
+			"MSysDb":            {id: 0x10000000, typ: 2, created: created, props: 92},
+			"SummaryInfo":       {id: -0x7ffffff9, typ: -32757, created: time.Date(2003, 10, 30, 13, 19, 45, 858e6, time.UTC), props: 162},
+			"MSysAccessObjects": {id: 15, typ: 1, created: time.Date(2004, 6, 5, 13, 6, 59, 655e6, time.UTC)},
+		} {
+			if g, ok := got[name]; !ok {
+				t.Errorf("encoded %v: MSysObjects has no %v", encoded, name)
+			} else if g != w {
+				t.Errorf("encoded %v: %v is %+v, want %+v", encoded, name, g, w)
+			}
+		}
+	}
+}
+
+func TestScanTypes(t *testing.T) {
+	db, err := Open(bytes.NewReader(readFixture(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// MSysACEs has Long, Binary and Bool columns.
+	aces, err := db.table("MSysACEs", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows, inheritable, sidBytes int
+	err = aces.Scan(aces.Columns, func(vals []interface{}) error {
+		rows++
+		if vals[3].(bool) {
+			inheritable++
+		}
+		sidBytes += len(vals[1].([]byte))
+		if acm := vals[2].(int32); acm == 0 {
+			t.Errorf("row %v has no access mask", rows)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 52 || inheritable == 0 || sidBytes == 0 {
+		t.Errorf("MSysACEs has %v rows, %v inheritable, with %v bytes of SIDs; want 52 rows, some inheritable and SIDs", rows, inheritable, sidBytes)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	db := &DB{f: jet4}
+	tbl := &Table{db: db}
+	le := binary.LittleEndian
+	tests := []struct {
+		typ   Type
+		scale int
+		b     []byte
+		want  interface{}
+	}{
+		{typ: Byte, b: []byte{200}, want: uint8(200)},
+		{typ: Int, b: le.AppendUint16(nil, 0xfffe), want: int16(-2)},
+		{typ: Long, b: le.AppendUint32(nil, 70000), want: int32(70000)},
+		{typ: Money, b: le.AppendUint64(nil, 12345678), want: Currency(12345678)},
+		{typ: Double, b: le.AppendUint64(nil, math.Float64bits(math.Pi)), want: math.Pi},
+		{typ: DateTime, b: le.AppendUint64(nil, math.Float64bits(36526)), want: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Noon on day -1: the fraction is the time even before the epoch.
+		{typ: DateTime, b: le.AppendUint64(nil, math.Float64bits(-1.5)), want: time.Date(1899, 12, 29, 12, 0, 0, 0, time.UTC)},
+		{typ: Text, b: []byte{'h', 0, 'i', 0}, want: "hi"},
+		{typ: GUID, b: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, want: "{04030201-0605-0807-090A-0B0C0D0E0F10}"},
+		{typ: Numeric, scale: 2, b: []byte{0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x39, 0x30, 0, 0}, want: -123.45},
+		// A memo short enough to be stored in the row.
+		{typ: Memo, b: []byte{4, 0, 0, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 'o', 0, 'k', 0}, want: "ok"},
+	}
+	for _, tt := range tests {
+		got, err := tbl.decode(&Column{Type: tt.typ, Scale: tt.scale}, tt.b)
+		if err != nil {
+			t.Errorf("decode(%v, % x) failed: %v", tt.typ, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decode(%v, % x) = %#v, want %#v", tt.typ, tt.b, got, tt.want)
+		}
+	}
+	if _, err := tbl.decode(&Column{Type: Long}, []byte{1, 2}); err == nil {
+		t.Errorf("decode of a short Long succeeded")
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		jet4 bool
+		want string
+	}{
+		{"ucs-2", []byte{'R', 0, 'S', 0, 'M', 0}, true, "RSM"},
+		{"ucs-2 non-latin", []byte{0x01, 0x01, 'a', 0}, true, "āa"},
+		{"compressed", []byte{0xff, 0xfe, 'M', 't', ' ', 'V', 'i', 'c'}, true, "Mt Vic"},
+		{"compressed then ucs-2", []byte{0xff, 0xfe, 'T', 0, 0x01, 0x01, 0, 'x'}, true, "Tāx"},
+		{"empty", nil, true, ""},
+		{"jet3 latin-1", []byte{'M', 0xe4, 'o'}, false, "Mäo"},
+	}
+	for _, tt := range tests {
+		if got := decodeText(tt.b, tt.jet4); got != tt.want {
+			t.Errorf("%v: decodeText(% x) = %q, want %q", tt.name, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCurrencyString(t *testing.T) {
+	for c, want := range map[Currency]string{
+		0:        "0.0000",
+		12345678: "1234.5678",
+		-5:       "-0.0005",
+		10000:    "1.0000",
+	} {
+		if got := c.String(); got != want {
+			t.Errorf("Currency(%d).String() = %q, want %q", int64(c), got, want)
+		}
+	}
+}
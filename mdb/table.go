@@ -0,0 +1,536 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Type is the type of a column.
+type Type byte
+
+// Column types.
+const (
+	Bool     Type = 0x01
+	Byte     Type = 0x02
+	Int      Type = 0x03
+	Long     Type = 0x04
+	Money    Type = 0x05
+	Float    Type = 0x06
+	Double   Type = 0x07
+	DateTime Type = 0x08
+	Binary   Type = 0x09
+	Text     Type = 0x0a
+	OLE      Type = 0x0b
+	Memo     Type = 0x0c
+	GUID     Type = 0x0f
+	Numeric  Type = 0x10
+	Complex  Type = 0x12
+)
+
+var typeNames = map[Type]string{
+	Bool: "Bool", Byte: "Byte", Int: "Int", Long: "Long", Money: "Money",
+	Float: "Float", Double: "Double", DateTime: "DateTime", Binary: "Binary",
+	Text: "Text", OLE: "OLE", Memo: "Memo", GUID: "GUID", Numeric: "Numeric",
+	Complex: "Complex",
+}
+
+func (t Type) String() string {
+	if n, ok := typeNames[t]; ok {
+		return n
+	}
+	return fmt.Sprintf("Type(%#x)", byte(t))
+}
+
+// Column is a column of a table.
+//
+// Values are read as Go types: Bool as bool, Byte as uint8, Int as int16,
+// Long and Complex as int32, Money as Currency, Float as float32, Double
+// and Numeric as float64, DateTime as a UTC time.Time, Text, Memo and GUID
+// as string, and anything else as []byte. Nulls are nil.
+type Column struct {
+	Name string
+	Type Type
+	// Precision and Scale are set for Numeric columns.
+	Precision, Scale int
+
+	// num is the column's bit in the null mask, and varNum its index in
+	// the variable-length columns.
+	num, varNum int
+	fixed       bool
+	// fixedIdx is the column's index among the fixed-length columns, and
+	// fixedOffset where its data is among theirs.
+	fixedIdx, fixedOffset int
+	size                  int
+}
+
+// Currency is a Money value, in ten-thousandths.
+type Currency int64
+
+func (c Currency) String() string {
+	sign, v := "", int64(c)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%v%d.%04d", sign, v/10000, v%10000)
+}
+
+// Table is a table's definition.
+type Table struct {
+	Name    string
+	Columns []*Column
+
+	db         *DB
+	page       int
+	numVarCols int
+	// usageMap locates the row listing the table's data pages, as its
+	// page shifted left 8 bits, plus its row number.
+	usageMap uint32
+}
+
+var errTruncated = errors.New("truncated table definition")
+
+// table reads the definition starting on page p.
+func (db *DB) table(name string, p int) (*Table, error) {
+	f := db.f
+	var def []byte
+	for n, pages := p, 0; n != 0; pages++ {
+		pg, err := db.page(n)
+		if err != nil {
+			return nil, err
+		}
+		if pg[0] != pageTDef || pages > 256 {
+			return nil, fmt.Errorf("%v: page %v isn't a table definition", name, n)
+		}
+		if def == nil {
+			def = pg
+		} else {
+			// Continuation pages have an 8 byte header.
+			def = append(def, pg[8:]...)
+		}
+		n = int(binary.LittleEndian.Uint32(pg[4:]))
+	}
+	t := &Table{
+		Name:       name,
+		db:         db,
+		page:       p,
+		numVarCols: int(binary.LittleEndian.Uint16(def[f.tdefNumVarCols:])),
+		usageMap:   binary.LittleEndian.Uint32(def[f.tdefUsageMap:]),
+	}
+	numCols := int(binary.LittleEndian.Uint16(def[f.tdefNumCols:]))
+	numRealIdx := int(binary.LittleEndian.Uint32(def[f.tdefNumRealIdx:]))
+	off := f.tdefColsStart + numRealIdx*f.realIdxSize
+	if numRealIdx > len(def) || off+numCols*f.colSize > len(def) {
+		return nil, fmt.Errorf("%v: %v", name, errTruncated)
+	}
+	for i := 0; i < numCols; i++ {
+		b := def[off+i*f.colSize:]
+		c := &Column{
+			Type:        Type(b[0]),
+			num:         int(binary.LittleEndian.Uint16(b[f.colNum:])),
+			varNum:      int(binary.LittleEndian.Uint16(b[f.colVarNum:])),
+			fixed:       b[f.colFlags]&0x01 != 0,
+			fixedOffset: int(binary.LittleEndian.Uint16(b[f.colFixedOffset:])),
+			size:        int(binary.LittleEndian.Uint16(b[f.colLen:])),
+		}
+		if c.Type == Numeric {
+			c.Precision, c.Scale = int(b[f.colPrecision]), int(b[f.colScale])
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	off += numCols * f.colSize
+	for _, c := range t.Columns {
+		var n int
+		if f.jet4 {
+			if off+2 > len(def) {
+				return nil, fmt.Errorf("%v: %v", name, errTruncated)
+			}
+			n = int(binary.LittleEndian.Uint16(def[off:]))
+			off += 2
+		} else {
+			if off+1 > len(def) {
+				return nil, fmt.Errorf("%v: %v", name, errTruncated)
+			}
+			n = int(def[off])
+			off++
+		}
+		if off+n > len(def) {
+			return nil, fmt.Errorf("%v: %v", name, errTruncated)
+		}
+		c.Name = decodeText(def[off:off+n], f.jet4)
+		off += n
+	}
+	sort.SliceStable(t.Columns, func(i, j int) bool { return t.Columns[i].num < t.Columns[j].num })
+	fixed := 0
+	for _, c := range t.Columns {
+		if c.fixed {
+			c.fixedIdx = fixed
+			fixed++
+		}
+	}
+	return t, nil
+}
+
+// Column returns the named column, or nil if there isn't one. Names aren't
+// case-sensitive.
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// dataPages lists the pages the table's usage map says hold its rows.
+func (t *Table) dataPages() ([]int, error) {
+	pg, start, end, err := t.db.row(int(t.usageMap>>8), int(t.usageMap&0xff))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read usage map: %v", err)
+	}
+	m := pg[start:end]
+	if len(m) < 5 {
+		return nil, errors.New("couldn't read usage map: too short")
+	}
+	var pages []int
+	switch m[0] {
+	case 0:
+		// The map is a bitmap of pages, starting from a page number.
+		first := int(binary.LittleEndian.Uint32(m[1:]))
+		pages = appendBits(pages, m[5:], first)
+	case 1:
+		// The map lists pages which are each a bitmap of a range of pages.
+		per := (t.db.f.pageSize - 4) * 8
+		for i := 0; 1+4*i+4 <= len(m); i++ {
+			mp := int(binary.LittleEndian.Uint32(m[1+4*i:]))
+			if mp == 0 {
+				continue
+			}
+			b, err := t.db.page(mp)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read usage map: %v", err)
+			}
+			pages = appendBits(pages, b[4:], i*per)
+		}
+	default:
+		return nil, fmt.Errorf("couldn't read usage map: unknown type %v", m[0])
+	}
+	return pages, nil
+}
+
+// appendBits appends first plus the index of each bit set in bitmap.
+func appendBits(pages []int, bitmap []byte, first int) []int {
+	for i, b := range bitmap {
+		for j := 0; j < 8; j++ {
+			if b&(1<<j) != 0 {
+				pages = append(pages, first+8*i+j)
+			}
+		}
+	}
+	return pages
+}
+
+// Scan calls fn with the values of cols in each row of the table, in the
+// order they're stored. vals is reused between calls.
+func (t *Table) Scan(cols []*Column, fn func(vals []interface{}) error) error {
+	pages, err := t.dataPages()
+	if err != nil {
+		return fmt.Errorf("%v: %v", t.Name, err)
+	}
+	vals := make([]interface{}, len(cols))
+	for _, p := range pages {
+		pg, err := t.db.page(p)
+		if err != nil {
+			return fmt.Errorf("%v: %v", t.Name, err)
+		}
+		// The usage map can be stale: only scan the table's own data pages.
+		if pg[0] != pageData || int(binary.LittleEndian.Uint32(pg[4:])) != t.page {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint16(pg[t.db.f.rowCount:]))
+		for i := 0; i < n; i++ {
+			start, end, flags, err := t.db.rowBounds(pg, i)
+			if err != nil {
+				return fmt.Errorf("%v: page %v: %v", t.Name, p, err)
+			}
+			if flags&rowDeleted != 0 {
+				continue
+			}
+			rpg := pg
+			if flags&rowOverflow != 0 {
+				if end-start < 4 {
+					return fmt.Errorf("%v: page %v: bad overflow row %v", t.Name, p, i)
+				}
+				if rpg, start, end, err = t.db.row(int(get24(pg[start+1:])), int(pg[start])); err != nil {
+					return fmt.Errorf("%v: %v", t.Name, err)
+				}
+			}
+			r, err := t.crack(rpg, start, end)
+			if err != nil {
+				return fmt.Errorf("%v: page %v row %v: %v", t.Name, p, i, err)
+			}
+			for j, c := range cols {
+				if vals[j], err = r.value(c); err != nil {
+					return fmt.Errorf("%v: page %v row %v: %v: %v", t.Name, p, i, c.Name, err)
+				}
+			}
+			if err := fn(vals); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// row is where a row's columns are in its page.
+type row struct {
+	t          *Table
+	pg         []byte
+	start, end int
+	// countSize is the size of the column count the row starts with.
+	countSize  int
+	nullMask   []byte
+	fixedCols  int
+	varOffsets []int
+}
+
+var errBadRow = errors.New("bad row")
+
+// crack finds the columns of the row between start and end of pg.
+func (t *Table) crack(pg []byte, start, end int) (*row, error) {
+	r := &row{t: t, pg: pg, start: start, end: end, countSize: 1}
+	var cols int
+	if t.db.f.jet4 {
+		r.countSize = 2
+		if end-start < 2 {
+			return nil, errBadRow
+		}
+		cols = int(binary.LittleEndian.Uint16(pg[start:]))
+	} else {
+		if end-start < 1 {
+			return nil, errBadRow
+		}
+		cols = int(pg[start])
+	}
+	bm := (cols + 7) / 8
+	if end-start < r.countSize+bm {
+		return nil, errBadRow
+	}
+	r.nullMask = pg[end-bm : end]
+	varCols := 0
+	if t.numVarCols > 0 {
+		var err error
+		if t.db.f.jet4 {
+			varCols, err = r.varOffsets4(bm)
+		} else {
+			varCols, err = r.varOffsets3(bm)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.fixedCols = cols - varCols
+	return r, nil
+}
+
+// varOffsets4 reads a Jet 4 row's variable-length column offsets, which
+// precede its count of them, which precedes the null mask.
+func (r *row) varOffsets4(bm int) (int, error) {
+	p := r.end - bm - 2
+	if p < r.start+r.countSize {
+		return 0, errBadRow
+	}
+	n := int(binary.LittleEndian.Uint16(r.pg[p:]))
+	if p-2*(n+1) < r.start+r.countSize {
+		return 0, errBadRow
+	}
+	r.varOffsets = make([]int, n+1)
+	for i := range r.varOffsets {
+		r.varOffsets[i] = int(binary.LittleEndian.Uint16(r.pg[p-2-2*i:]))
+	}
+	return n, nil
+}
+
+// varOffsets3 reads a Jet 3 row's variable-length column offsets. They're
+// single bytes, so rows over 256 bytes long also have a table of which
+// columns start each further 256 bytes.
+func (r *row) varOffsets3(bm int) (int, error) {
+	last := r.end - 1
+	if last-bm < r.start+r.countSize {
+		return 0, errBadRow
+	}
+	n := int(r.pg[last-bm])
+	jumps := (r.end - r.start - 1) / 256
+	ptr := last - bm - jumps - 1
+	if ptr-n < r.start+r.countSize {
+		return 0, errBadRow
+	}
+	// The last jump may be a dummy.
+	if (ptr-r.start-n)/256 < jumps {
+		jumps--
+	}
+	used := 0
+	r.varOffsets = make([]int, n+1)
+	for i := range r.varOffsets {
+		for used < jumps && i == int(r.pg[last-bm-used-1]) {
+			used++
+		}
+		r.varOffsets[i] = int(r.pg[ptr-i]) + used*256
+	}
+	return n, nil
+}
+
+// value reads the value of c in the row.
+func (r *row) value(c *Column) (interface{}, error) {
+	// Set bits in the null mask are values that aren't null. For booleans,
+	// they're the value itself.
+	set := false
+	if i := c.num / 8; i < len(r.nullMask) {
+		set = r.nullMask[i]&(1<<(c.num%8)) != 0
+	}
+	if c.Type == Bool {
+		return set, nil
+	}
+	if !set {
+		return nil, nil
+	}
+	var b []byte
+	if c.fixed {
+		// Columns added after the row was written aren't in it.
+		if c.fixedIdx >= r.fixedCols {
+			return nil, nil
+		}
+		s := r.start + r.countSize + c.fixedOffset
+		if s+c.size > r.end {
+			return nil, errBadRow
+		}
+		b = r.pg[s : s+c.size]
+	} else {
+		if c.varNum+1 >= len(r.varOffsets) {
+			return nil, nil
+		}
+		s, e := r.start+r.varOffsets[c.varNum], r.start+r.varOffsets[c.varNum+1]
+		if s > e || e > r.end {
+			return nil, errBadRow
+		}
+		b = r.pg[s:e]
+	}
+	return r.t.decode(c, b)
+}
+
+// sizes are the sizes of fixed-length types.
+var sizes = map[Type]int{
+	Byte: 1, Int: 2, Long: 4, Complex: 4, Money: 8, Float: 4, Double: 8,
+	DateTime: 8, GUID: 16, Numeric: 17,
+}
+
+// epoch is day 0 of DateTime values.
+var epoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// decode converts a column's bytes to a Go value.
+func (t *Table) decode(c *Column, b []byte) (interface{}, error) {
+	if n, ok := sizes[c.Type]; ok && len(b) < n {
+		return nil, fmt.Errorf("%v is %v bytes, want %v", c.Type, len(b), n)
+	}
+	switch c.Type {
+	case Byte:
+		return b[0], nil
+	case Int:
+		return int16(binary.LittleEndian.Uint16(b)), nil
+	case Long, Complex:
+		return int32(binary.LittleEndian.Uint32(b)), nil
+	case Money:
+		return Currency(binary.LittleEndian.Uint64(b)), nil
+	case Float:
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case Double:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case DateTime:
+		// Days since the epoch. The fraction is the time of day, even
+		// before the epoch.
+		days, frac := math.Modf(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		d := time.Duration(math.Abs(frac) * float64(24*time.Hour)).Round(time.Millisecond)
+		return epoch.AddDate(0, 0, int(days)).Add(d), nil
+	case GUID:
+		return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}",
+			binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]), binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16]), nil
+	case Numeric:
+		return numeric(b, c.Scale), nil
+	case Text:
+		return decodeText(b, t.db.f.jet4), nil
+	case Memo:
+		v, err := t.db.longValue(b)
+		if err != nil {
+			return nil, err
+		}
+		return decodeText(v, t.db.f.jet4), nil
+	case OLE:
+		return t.db.longValue(b)
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// numeric decodes a Numeric value: a sign byte, then a 128 bit integer
+// stored as four little-endian words, most significant first.
+func numeric(b []byte, scale int) float64 {
+	var be [16]byte
+	for w := 0; w < 4; w++ {
+		for i := 0; i < 4; i++ {
+			be[4*w+i] = b[1+4*w+3-i]
+		}
+	}
+	n := new(big.Int).SetBytes(be[:])
+	if b[0]&0x80 != 0 {
+		n.Neg(n)
+	}
+	f, _ := new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)).Float64()
+	return f
+}
+
+// longValue reads a Memo or OLE value. Short ones are stored in the row;
+// longer ones in a row of their own, or a chain of them.
+func (db *DB) longValue(b []byte) ([]byte, error) {
+	if len(b) < 12 {
+		return nil, fmt.Errorf("long value header is %v bytes, want 12", len(b))
+	}
+	h := binary.LittleEndian.Uint32(b)
+	n := int(h & 0x3fffffff)
+	switch {
+	case h&0x80000000 != 0:
+		if 12+n > len(b) {
+			return nil, errBadRow
+		}
+		return append([]byte(nil), b[12:12+n]...), nil
+	case h&0x40000000 != 0:
+		pg, start, end, err := db.row(int(get24(b[5:])), int(b[4]))
+		if err != nil {
+			return nil, err
+		}
+		if end-start < n {
+			return nil, errBadRow
+		}
+		return append([]byte(nil), pg[start:start+n]...), nil
+	}
+	v := make([]byte, 0, n)
+	for p, r, rows := int(get24(b[5:])), int(b[4]), 0; p != 0 && len(v) < n; rows++ {
+		pg, start, end, err := db.row(p, r)
+		if err != nil {
+			return nil, err
+		}
+		if end-start < 4 || rows > n {
+			return nil, errBadRow
+		}
+		// Each row starts with a pointer to the next.
+		r, p = int(pg[start]), int(get24(pg[start+1:]))
+		v = append(v, pg[start+4:end]...)
+	}
+	if len(v) < n {
+		return nil, errBadRow
+	}
+	return v[:n], nil
+}
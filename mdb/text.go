@@ -0,0 +1,44 @@
+package mdb
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+// decodeText decodes text. Jet 4 stores it as UCS-2, optionally compressed:
+// if it starts with 0xff 0xfe, it alternates between runs of single bytes,
+// for characters below 256, and of UCS-2, with a 0 byte between runs. Jet 3
+// stores it in the database's code page, which is assumed to be Latin-1.
+func decodeText(b []byte, jet4 bool) string {
+	if !jet4 {
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r)
+	}
+	u := make([]uint16, 0, len(b)/2)
+	if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+		compressed := true
+		for i := 2; i < len(b); {
+			switch {
+			case b[i] == 0:
+				compressed = !compressed
+				i++
+			case compressed:
+				u = append(u, uint16(b[i]))
+				i++
+			case i+1 < len(b):
+				u = append(u, binary.LittleEndian.Uint16(b[i:]))
+				i += 2
+			default:
+				i++
+			}
+		}
+		return string(utf16.Decode(u))
+	}
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, binary.LittleEndian.Uint16(b[i:]))
+	}
+	return string(utf16.Decode(u))
+}
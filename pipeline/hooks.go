@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"time"
+)
+
+// Logging logs the start and end of each stage.
+func Logging() Hook {
+	return func(name string, next Func) Func {
+		return func(ctx context.Context) error {
+			log.Printf("stage %v: starting", name)
+			start := time.Now()
+			err := next(ctx)
+			switch {
+			case err == nil:
+				log.Printf("stage %v: done in %v", name, time.Since(start))
+			case errors.Is(err, ErrStop):
+				log.Printf("stage %v: stopped pipeline after %v", name, time.Since(start))
+			default:
+				log.Printf("stage %v: failed after %v: %v", name, time.Since(start), err)
+			}
+			return err
+		}
+	}
+}
+
+// Timing calls record with how long each stage took, and its error.
+func Timing(record func(name string, d time.Duration, err error)) Hook {
+	return func(name string, next Func) Func {
+		return func(ctx context.Context) error {
+			start := time.Now()
+			err := next(ctx)
+			record(name, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Retry reruns a stage up to attempts times in total while it fails with an
+// error for which retryable returns true, waiting backoff before the first
+// retry and doubling the wait each time.
+func Retry(attempts int, backoff time.Duration, retryable func(error) bool) Hook {
+	return func(name string, next Func) Func {
+		return func(ctx context.Context) error {
+			wait := backoff
+			for attempt := 1; ; attempt++ {
+				err := next(ctx)
+				if err == nil || errors.Is(err, ErrStop) || attempt >= attempts || !retryable(err) {
+					return err
+				}
+				log.Printf("stage %v: attempt %v failed, retrying in %v: %v", name, attempt, wait, err)
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return err
+				}
+				wait *= 2
+			}
+		}
+	}
+}
+
+// Metrics counts runs and errors of each stage, and the total time spent in
+// it, as "{stage}.runs", "{stage}.errors" and "{stage}.millis" in m.
+func Metrics(m *expvar.Map) Hook {
+	return func(name string, next Func) Func {
+		return func(ctx context.Context) error {
+			start := time.Now()
+			err := next(ctx)
+			m.Add(name+".runs", 1)
+			m.Add(name+".millis", time.Since(start).Milliseconds())
+			if err != nil && !errors.Is(err, ErrStop) {
+				m.Add(name+".errors", 1)
+			}
+			return err
+		}
+	}
+}
+
+// Only applies hook to the named stages, leaving others unwrapped.
+func Only(hook Hook, names ...string) Hook {
+	return func(name string, next Func) Func {
+		for _, n := range names {
+			if n == name {
+				return hook(name, next)
+			}
+		}
+		return next
+	}
+}
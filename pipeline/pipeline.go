@@ -0,0 +1,126 @@
+// Package pipeline runs a sequence of named stages, with hooks wrapped around
+// every stage for cross-cutting concerns like timing, logging, retries and
+// metrics.
+//
+// Stages share state by closing over it:
+//
+//	var zipBytes []byte
+//	p := pipeline.New(pipeline.Logging())
+//	p.Add("download", func(ctx context.Context) error { ... zipBytes = ... })
+//	p.Add("convert", func(ctx context.Context) error { ... use zipBytes ... })
+//	err := p.Run(ctx)
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Func is the body of a stage.
+type Func func(ctx context.Context) error
+
+// Stage is one named step of a pipeline.
+type Stage struct {
+	Name string
+	Run  Func
+}
+
+// Hook wraps a stage's Func, e.g. to time or retry it. name is the stage
+// being wrapped.
+type Hook func(name string, next Func) Func
+
+// ErrStop may be returned by a stage to end the pipeline early without
+// error, e.g. when there turns out to be no work to do.
+var ErrStop = errors.New("pipeline stopped")
+
+// Pipeline is a sequence of stages. The zero value is an empty pipeline with
+// no hooks.
+type Pipeline struct {
+	stages []Stage
+	hooks  []Hook
+}
+
+// New returns an empty pipeline. Hooks are applied to every stage, the first
+// hook outermost.
+func New(hooks ...Hook) *Pipeline {
+	return &Pipeline{hooks: hooks}
+}
+
+// Use adds hooks, inside any existing ones.
+func (p *Pipeline) Use(hooks ...Hook) *Pipeline {
+	p.hooks = append(p.hooks, hooks...)
+	return p
+}
+
+// Add appends a stage.
+func (p *Pipeline) Add(name string, run Func) *Pipeline {
+	p.stages = append(p.stages, Stage{Name: name, Run: run})
+	return p
+}
+
+// Append appends all of the stages of other, but not its hooks.
+func (p *Pipeline) Append(other *Pipeline) *Pipeline {
+	p.stages = append(p.stages, other.stages...)
+	return p
+}
+
+// InsertAfter adds a stage immediately after the stage called after.
+func (p *Pipeline) InsertAfter(after, name string, run Func) error {
+	for i, s := range p.stages {
+		if s.Name == after {
+			p.stages = append(p.stages[:i+1], append([]Stage{{Name: name, Run: run}}, p.stages[i+1:]...)...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no stage %q to insert %q after", after, name)
+}
+
+// Stages returns the names of the stages, in order.
+func (p *Pipeline) Stages() []string {
+	var names []string
+	for _, s := range p.stages {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// Run runs each stage in turn, stopping at the first error. If a stage
+// returns ErrStop, Run stops and returns nil.
+func (p *Pipeline) Run(ctx context.Context) error {
+	for _, s := range p.stages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		run := s.Run
+		for i := len(p.hooks) - 1; i >= 0; i-- {
+			run = p.hooks[i](s.Name, run)
+		}
+		if err := run(ctx); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Slice returns a pipeline of the stages from first to last inclusive, with
+// the same hooks, so part of a pipeline can be run on its own.
+func (p *Pipeline) Slice(first, last string) (*Pipeline, error) {
+	start, end := -1, -1
+	for i, s := range p.stages {
+		if s.Name == first && start < 0 {
+			start = i
+		}
+		if s.Name == last {
+			end = i
+		}
+	}
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no stages %q to %q in %v", first, last, p.Stages())
+	}
+	return &Pipeline{stages: slices.Clone(p.stages[start : end+1]), hooks: slices.Clone(p.hooks)}, nil
+}
@@ -0,0 +1,145 @@
+// Package redact keeps secrets out of logs and archived header dumps.
+//
+// Header values are redacted by name: Authorization, Set-Cookie and the
+// like, plus any added with AddKeys. Secret values, like tokens read from
+// flags, are registered with AddSecret and masked wherever they appear in
+// log output.
+package redact
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces redacted values.
+const Mask = "REDACTED"
+
+var (
+	mu   sync.RWMutex
+	keys = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		// Key is the API key query parameter, which may be logged as part
+		// of a URL.
+		"Key":                        true,
+		"Set-Cookie":                 true,
+		"X-Api-Key":                  true,
+		"X-Goog-Iap-Jwt-Assertion":   true,
+		"X-Serverless-Authorization": true,
+	}
+	secrets []string
+	// keyPattern matches a sensitive key followed by its value, as logged
+	// with %v ("Key:[value]"), in JSON ("key": "value"), or as "key=value".
+	keyPattern *regexp.Regexp
+)
+
+func init() {
+	compile()
+}
+
+// compile rebuilds keyPattern from keys. mu must be held, or not yet
+// shared.
+func compile() {
+	var names []string
+	for k := range keys {
+		names = append(names, regexp.QuoteMeta(k))
+	}
+	sort.Strings(names)
+	keyPattern = regexp.MustCompile(`(?i)("?\b(?:` + strings.Join(names, "|") + `)\b"?\s*(?::\s*\[|:\s*"|=|:\s*))([^\]"\s,&]+(?: [^\]"\s,&]+)*)`)
+}
+
+// AddKeys adds header, JSON and query parameter names whose values are
+// secret.
+func AddKeys(names ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			keys[http.CanonicalHeaderKey(n)] = true
+		}
+	}
+	compile()
+}
+
+// AddSecret registers a value to mask wherever it's logged. Empty values
+// are ignored.
+func AddSecret(s string) {
+	if s == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	secrets = append(secrets, s)
+}
+
+// Headers returns a copy of h with the values of sensitive headers masked.
+func Headers(h http.Header) http.Header {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		if keys[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{Mask}
+			continue
+		}
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
+
+// URL returns u with any password and sensitive query parameters masked.
+func URL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	mu.RLock()
+	q := parsed.Query()
+	changed := false
+	for k := range q {
+		if keys[http.CanonicalHeaderKey(k)] {
+			q.Set(k, Mask)
+			changed = true
+		}
+	}
+	mu.RUnlock()
+	if changed {
+		parsed.RawQuery = q.Encode()
+	}
+	return parsed.Redacted()
+}
+
+// String masks secrets and the values of sensitive keys in s.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return keyPattern.ReplaceAllString(s, "${1}"+Mask)
+}
+
+// writer redacts everything written through it.
+type writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a writer that redacts each write before passing it on to
+// w. It's meant for log.SetOutput: the log package writes each entry in one
+// call, so secrets aren't split across writes.
+func NewWriter(w io.Writer) io.Writer {
+	return writer{w}
+}
+
+func (rw writer) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, String(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,46 @@
+package redact
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyInURL(t *testing.T) {
+	const key = "nzwm_0123456789abcdef"
+	u := "https://fetch.example/api/licence/123?key=" + key + "&format=json"
+	if got := URL(u); strings.Contains(got, key) || !strings.Contains(got, "key="+Mask) {
+		t.Errorf("URL(%q) = %q, want the key masked", u, got)
+	}
+
+	var buf bytes.Buffer
+	l := log.New(NewWriter(&buf), "", 0)
+	l.Printf("GET %v", u)
+	if got := buf.String(); strings.Contains(got, key) || !strings.Contains(got, "format=json") {
+		t.Errorf("logged %q, want the key masked and the rest kept", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"nothing here", "nothing here"},
+		{"map[Authorization:[Bearer abc123] Accept:[*/*]]", "map[Authorization:[REDACTED] Accept:[*/*]]"},
+		{"Set-Cookie:[id=1; Path=/]", "Set-Cookie:[REDACTED]"},
+		{`{"authorization": "Bearer abc", "licenceid": "1"}`, `{"authorization": "REDACTED", "licenceid": "1"}`},
+		{`{"Cookie":"a=b"}`, `{"Cookie":"REDACTED"}`},
+		{"GET /api?key=abc123&format=json", "GET /api?key=REDACTED&format=json"},
+		{"Key: abc123", "Key: REDACTED"},
+		{"x-api-key: zzz, next", "x-api-key: REDACTED, next"},
+		// Only whole names match.
+		{"monkey=banana", "monkey=banana"},
+		{"keyword=foo", "keyword=foo"},
+	}
+	for _, tt := range tests {
+		if got := String(tt.in); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
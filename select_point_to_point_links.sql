@@ -38,7 +38,7 @@ txgeoref.northing as tx_lat,
 -- Receive Attributes
 trim(rxlocation.locationname) as rx_name,
 rxgeoref.easting as rx_lng,
-rxgeoref.northing as rx_lat
+rxgeoref.northing as rx_lat,
 -- rxlocation.locationheight as rx_alt,
 -- trim(receiveconfiguration.rxantennamake) as rxantennamake,
 -- trim(receiveconfiguration.rxantennatype) as rxantennatype,
@@ -46,6 +46,22 @@ rxgeoref.northing as rx_lat
 -- receiveconfiguration.rxazimuth as rxazimuth,
 -- trim(receiveconfiguration.rxequipment) as rxequipment
 
+-- Columns added later go last, so that readers of the CSV that go by
+-- position keep working.
+trim(spectrum.polarisation) as polarisation,
+-- A licence can have several emission designators (e.g. "28M0D7W"), so they
+-- are joined with spaces rather than joined in, which would duplicate links.
+-- They're sorted, so the order doesn't depend on how PRISM stores them.
+(select group_concat(emission, ' ')
+ from (select trim(emission.emission) as emission from emission
+       where emission.licenceid = licence.licenceid order by 1)) as emission,
+-- Current, Expired or Cancelled. Not filtered here, so the history is kept,
+-- but see convert.StatusOf.
+trim(spectrum.spectrumstatus) as status,
+-- Antenna heights are in metres above ground.
+transmitconfiguration.txantennaheight as tx_antenna_height,
+receiveconfiguration.rxantennaheight as rx_antenna_height
+
 from receiveconfiguration 
 
 join transmitconfiguration using (licenceid) 
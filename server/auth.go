@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"golang.org/x/time/rate"
+)
+
+// access is who may call a route.
+type access int
+
+const (
+	// accessPublic routes are open to anyone.
+	accessPublic access = iota
+	// accessQuery routes need an API key if cfg.RequireAPIKeys is set.
+	accessQuery
+	// accessOperator routes need the operator token if cfg.OperatorToken
+	// is set.
+	accessOperator
+)
+
+// apiKeyRefresh is how long issued keys are cached before being read from
+// the bucket again, and so how long a revoked key keeps working.
+const apiKeyRefresh = time.Minute
+
+// apiKeys validates API keys against those in the bucket, and rate-limits
+// each key.
+type apiKeys struct {
+	mu       sync.Mutex
+	keys     map[string]store.APIKey // by hash
+	fetched  time.Time
+	limiters map[string]*rate.Limiter // by key ID
+}
+
+// hashKey returns the hash an API key is stored as.
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// lookupKey returns the unrevoked key with the given hash, refreshing the
+// cached keys if they're old.
+func (s *Server) lookupKey(ctx context.Context, hash string) (*store.APIKey, *rate.Limiter, error) {
+	k := &s.keys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Since(k.fetched) > apiKeyRefresh {
+		bkt, err := s.bucket(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		issued, err := store.ReadAPIKeys(ctx, bkt)
+		if err != nil {
+			return nil, nil, err
+		}
+		k.keys = make(map[string]store.APIKey)
+		for _, key := range issued.Keys {
+			if key.Revoked == nil {
+				k.keys[key.Hash] = key
+			}
+		}
+		k.fetched = time.Now()
+	}
+	key, ok := k.keys[hash]
+	if !ok {
+		return nil, nil, nil
+	}
+	if k.limiters == nil {
+		k.limiters = make(map[string]*rate.Limiter)
+	}
+	l := k.limiters[key.ID]
+	if l == nil || l.Burst() != key.RatePerMinute {
+		l = rate.NewLimiter(rate.Limit(float64(key.RatePerMinute)/60), key.RatePerMinute)
+		k.limiters[key.ID] = l
+	}
+	return &key, l, nil
+}
+
+// checkAPIKey validates the API key raw and takes a request from its rate
+// limit. If it fails, code is the HTTP status to respond with, and
+// retryAfter is when a rate-limited key may try again.
+func (s *Server) checkAPIKey(ctx context.Context, raw string) (code int, retryAfter time.Duration, err error) {
+	if raw == "" {
+		return http.StatusUnauthorized, 0, &stageError{Code: codeUnauthorized, Err: errors.New("an API key is required, in the X-API-Key header or the key parameter")}
+	}
+	key, limiter, err := s.lookupKey(ctx, hashKey(raw))
+	if err != nil {
+		log.Printf("couldn't read API keys: %v", err)
+		return http.StatusServiceUnavailable, 0, storageErr(fmt.Errorf("couldn't check API key: %v", err))
+	}
+	if key == nil {
+		return http.StatusUnauthorized, 0, &stageError{Code: codeUnauthorized, Err: errors.New("unknown or revoked API key")}
+	}
+	res := limiter.Reserve()
+	if delay := res.Delay(); delay > 0 {
+		res.Cancel()
+		return http.StatusTooManyRequests, delay, &stageError{Code: codeRateLimited, Err: fmt.Errorf("API key %v is limited to %v requests a minute", key.ID, key.RatePerMinute)}
+	}
+	return http.StatusOK, 0, nil
+}
+
+// requireAPIKey wraps h so that requests need a valid API key, in the
+// X-API-Key header or the key query parameter, and are limited to the key's
+// rate.
+func (s *Server) requireAPIKey(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.RequireAPIKeys {
+			h.ServeHTTP(w, r)
+			return
+		}
+		raw := r.Header.Get("X-API-Key")
+		if raw == "" {
+			raw = r.URL.Query().Get("key")
+		}
+		code, retryAfter, err := s.checkAPIKey(r.Context(), raw)
+		if err != nil {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+			}
+			writeError(w, code, "", err)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requireOperator wraps h so that requests need the operator token as a
+// bearer token, if one is configured.
+func (s *Server) requireOperator(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.OperatorToken == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.OperatorToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "", &stageError{Code: codeUnauthorized, Err: errors.New("this endpoint needs the operator token")})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// issueKeyRequest is the body of POST /admin/keys.
+type issueKeyRequest struct {
+	Name string `json:"name"`
+	// RatePerMinute defaults to cfg.DefaultKeyRate.
+	RatePerMinute int `json:"rate_per_minute,omitempty"`
+}
+
+// issuedKey is the response to POST /admin/keys. The key itself is only
+// ever shown here.
+type issuedKey struct {
+	store.APIKey
+	Key string `json:"key"`
+}
+
+// withAdmin refuses admin requests without an operator token, since anyone
+// could otherwise, say, issue themselves an API key.
+func (s *Server) withAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.OperatorToken == "" {
+			writeError(w, http.StatusForbidden, "", &stageError{Code: codeUnauthorized, Err: errors.New("admin endpoints can only be used when an operator token is configured")})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) issueKey(w http.ResponseWriter, r *http.Request) {
+	var req issueKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: errors.New(`want a JSON body like {"name": "...", "rate_per_minute": 60}`)})
+		return
+	}
+	if req.RatePerMinute <= 0 {
+		req.RatePerMinute = s.cfg.DefaultKeyRate
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	out := issuedKey{Key: "nzwm_" + hex.EncodeToString(b)}
+	out.APIKey = store.APIKey{
+		ID:            newRunID(),
+		Name:          req.Name,
+		Hash:          hashKey(out.Key),
+		RatePerMinute: req.RatePerMinute,
+		Created:       time.Now().UTC(),
+	}
+	bkt, err := s.bucket(r.Context())
+	if err == nil {
+		err = store.UpdateAPIKeys(r.Context(), bkt, func(keys *store.APIKeys) {
+			keys.Keys = append(keys.Keys, out.APIKey)
+		})
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	log.Printf("issued API key %v for %q", out.ID, out.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("couldn't write issued key: %v", err)
+	}
+}
+
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	bkt, err := s.bucket(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	keys, err := store.ReadAPIKeys(r.Context(), bkt)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		log.Printf("couldn't write keys: %v", err)
+	}
+}
+
+func (s *Server) revokeKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	bkt, err := s.bucket(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	found := false
+	err = store.UpdateAPIKeys(r.Context(), bkt, func(keys *store.APIKeys) {
+		found = false
+		for i := range keys.Keys {
+			if k := &keys.Keys[i]; k.ID == id && k.Revoked == nil {
+				now := time.Now().UTC()
+				k.Revoked = &now
+				found = true
+			}
+		}
+	})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "", &stageError{Code: codeNotFound, Err: fmt.Errorf("no unrevoked API key %v", id)})
+		return
+	}
+	log.Printf("revoked API key %v; it keeps working for up to %v", id, apiKeyRefresh)
+	w.WriteHeader(http.StatusNoContent)
+}
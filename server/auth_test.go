@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// withKeys returns a server whose cached API keys are keys, fresh enough
+// that the bucket isn't read.
+func withKeys(cfg Config, keys ...store.APIKey) *Server {
+	s := &Server{cfg: cfg}
+	s.keys.keys = make(map[string]store.APIKey)
+	for _, k := range keys {
+		s.keys.keys[k.Hash] = k
+	}
+	s.keys.fetched = time.Now()
+	return s
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	keys := []store.APIKey{
+		{ID: "a", Hash: hashKey("nzwm_a"), RatePerMinute: 2},
+		{ID: "b", Hash: hashKey("nzwm_b"), RatePerMinute: 60},
+	}
+	type request struct {
+		header, param string
+	}
+	tests := []struct {
+		name     string
+		disabled bool
+		requests []request
+		want     []int
+	}{
+		{name: "header", requests: []request{{header: "nzwm_b"}}, want: []int{200}},
+		{name: "query parameter", requests: []request{{param: "nzwm_b"}}, want: []int{200}},
+		{name: "header over parameter", requests: []request{{header: "nzwm_b", param: "nzwm_x"}}, want: []int{200}},
+		{name: "missing", requests: []request{{}}, want: []int{401}},
+		{name: "unknown", requests: []request{{header: "nzwm_x"}}, want: []int{401}},
+		{name: "not required", disabled: true, requests: []request{{}, {header: "nzwm_x"}}, want: []int{200, 200}},
+		{
+			name:     "rate limited",
+			requests: []request{{header: "nzwm_a"}, {param: "nzwm_a"}, {header: "nzwm_a"}},
+			want:     []int{200, 200, 429},
+		},
+		{
+			name:     "limited per key",
+			requests: []request{{header: "nzwm_a"}, {header: "nzwm_a"}, {header: "nzwm_b"}},
+			want:     []int{200, 200, 200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := withKeys(Config{RequireAPIKeys: !tt.disabled}, keys...)
+			h := s.requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			for i, req := range tt.requests {
+				target := "/api/licence/1"
+				if req.param != "" {
+					target += "?key=" + req.param
+				}
+				r := httptest.NewRequest("GET", target, nil)
+				if req.header != "" {
+					r.Header.Set("X-API-Key", req.header)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				if w.Code != tt.want[i] {
+					t.Errorf("request %v responded %v, want %v", i, w.Code, tt.want[i])
+				}
+				if retry := w.Header().Get("Retry-After"); (w.Code == http.StatusTooManyRequests) != (retry != "") {
+					t.Errorf("request %v responded %v with Retry-After %q", i, w.Code, retry)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireOperator(t *testing.T) {
+	tests := []struct {
+		name, token, authorization string
+		want                       int
+	}{
+		{name: "no token configured", want: 200},
+		{name: "no token configured, any header", authorization: "Bearer x", want: 200},
+		{name: "right token", token: "op", authorization: "Bearer op", want: 200},
+		{name: "wrong token", token: "op", authorization: "Bearer nope", want: 401},
+		{name: "not bearer", token: "op", authorization: "op", want: 401},
+		{name: "missing", token: "op", want: 401},
+	}
+	for _, tt := range tests {
+		s := &Server{cfg: Config{OperatorToken: tt.token}}
+		h := s.requireOperator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("POST", "/fetch", nil)
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%v: responded %v, want %v", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// snapshotResult is the outcome of processing one snapshot in a backfill.
+type snapshotResult struct {
+	Timestamp string
+	Duration  time.Duration
+	Err       error
+}
+
+// backfillReport summarises a backfill run.
+type backfillReport struct {
+	Results []snapshotResult
+}
+
+func (r *backfillReport) failures() []snapshotResult {
+	var failed []snapshotResult
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// writeTo writes a human-readable summary of the report.
+func (r *backfillReport) writeTo(w io.Writer) {
+	failed := r.failures()
+	fmt.Fprintf(w, "%v snapshots processed: %v succeeded, %v failed\n", len(r.Results), len(r.Results)-len(failed), len(failed))
+	for _, res := range failed {
+		fmt.Fprintf(w, "%v: %v\n", res.Timestamp, res.Err)
+	}
+}
+
+// runBackfill calls fn for each snapshot, with at most n running at once.
+// Snapshots are isolated from each other: an error or panic processing one
+// snapshot is recorded in the report and doesn't stop the others.
+func runBackfill(ctx context.Context, snapshots []string, n int, fn func(ctx context.Context, ts string) error) *backfillReport {
+	if n < 1 {
+		n = 1
+	}
+	todo := make(chan string)
+	results := make(chan snapshotResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ts := range todo {
+				results <- runSnapshot(ctx, ts, fn)
+			}
+		}()
+	}
+	go func() {
+		defer close(todo)
+		for _, ts := range snapshots {
+			select {
+			case todo <- ts:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := &backfillReport{}
+	for res := range results {
+		report.Results = append(report.Results, res)
+		if res.Err != nil {
+			log.Printf("snapshot %v failed after %v (%v/%v): %v", res.Timestamp, res.Duration, len(report.Results), len(snapshots), res.Err)
+		} else {
+			log.Printf("snapshot %v done in %v (%v/%v)", res.Timestamp, res.Duration, len(report.Results), len(snapshots))
+		}
+	}
+	// Snapshots that were never started because the context was cancelled
+	// count as failures too.
+	seen := make(map[string]bool)
+	for _, res := range report.Results {
+		seen[res.Timestamp] = true
+	}
+	for _, ts := range snapshots {
+		if !seen[ts] {
+			report.Results = append(report.Results, snapshotResult{Timestamp: ts, Err: ctx.Err()})
+		}
+	}
+	sort.Slice(report.Results, func(i, j int) bool { return report.Results[i].Timestamp < report.Results[j].Timestamp })
+	return report
+}
+
+func runSnapshot(ctx context.Context, ts string, fn func(ctx context.Context, ts string) error) (res snapshotResult) {
+	start := time.Now()
+	res.Timestamp = ts
+	defer func() {
+		if p := recover(); p != nil {
+			res.Err = fmt.Errorf("panic: %v", p)
+		}
+		res.Duration = time.Since(start)
+	}()
+	res.Err = fn(ctx, ts)
+	return res
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// incompressible lists content types that are already compressed, so
+// compressing them again would only waste CPU.
+var incompressible = map[string]bool{
+	"application/zip":  true,
+	"application/gzip": true,
+	"application/zstd": true,
+}
+
+// acceptedEncoding returns "gzip" or "deflate", whichever the client
+// prefers of those it accepts, or "" if it accepts neither. As in RFC 9110,
+// "*" only stands for the encodings that aren't listed explicitly, so
+// "gzip;q=0, *" refuses gzip.
+func acceptedEncoding(header string) string {
+	explicit := make(map[string]float64)
+	wildcard, haveWildcard := 0.0, false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch name {
+		case "gzip", "deflate":
+			explicit[name] = q
+		case "*":
+			wildcard, haveWildcard = q, true
+		}
+	}
+	best, bestQ := "", 0.0
+	// gzip is first so it's preferred on ties: it's more widely supported.
+	for _, name := range []string{"gzip", "deflate"} {
+		q, ok := explicit[name]
+		if !ok {
+			if !haveWildcard {
+				continue
+			}
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses a response, once its headers show it's worth
+// compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	w           io.WriteCloser
+	wroteHeader bool
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	h := c.Header()
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && !incompressible[ct] {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		if c.encoding == "gzip" {
+			c.w = gzip.NewWriter(c.ResponseWriter)
+		} else {
+			c.w, _ = flate.NewWriter(c.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.w == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	return c.w.Write(b)
+}
+
+// close flushes the compressed stream, if there is one.
+func (c *compressWriter) close() {
+	if c.w == nil {
+		return
+	}
+	if err := c.w.Close(); err != nil {
+		log.Printf("couldn't finish compressed response: %v", err)
+	}
+}
+
+// withCompression wraps h to compress its responses with gzip or deflate,
+// if the client accepts them.
+func withCompression(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"br", ""},
+		{"identity", ""},
+		{"GZIP", "gzip"},
+		{"gzip, deflate, br", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip; q=0.9, deflate;q=0.8", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip;q=0, deflate;q=0.1", "deflate"},
+		{"*", "gzip"},
+		{"br, *;q=0.1", "gzip"},
+		{"gzip;q=0, *", "deflate"},
+		{"*, gzip;q=0", "deflate"},
+		{"gzip;q=0, deflate;q=0, *", ""},
+		{"deflate;q=0.5, *", "gzip"},
+		{"gzip;q=0.2, *;q=0.5", "deflate"},
+		{"*;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := acceptedEncoding(tt.header); got != tt.want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	body := strings.Repeat(`{"licenceid": "1"},`, 100)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		method         string
+		contentType    string
+		status         int
+		wantEncoding   string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", contentType: "application/json", wantEncoding: "gzip"},
+		{name: "deflate", acceptEncoding: "deflate", contentType: "application/json", wantEncoding: "deflate"},
+		{name: "not accepted", acceptEncoding: "", contentType: "application/json"},
+		{name: "already compressed", acceptEncoding: "gzip", contentType: "application/zip"},
+		{name: "head", acceptEncoding: "gzip", method: http.MethodHead, contentType: "application/json"},
+		{name: "not modified", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				io.WriteString(w, body)
+			}))
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/latest", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			if tt.status != 0 {
+				return
+			}
+			var r io.Reader = rec.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = zr
+			case "deflate":
+				r = flate.NewReader(rec.Body)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("body = %q, want %q", got, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/pipeline"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// checkCooldown ends the run early, without error, if upstream failed
+// within the last cfg.FailureCooldown, asked us to retry later, or was
+// requested within the last cfg.MinFetchInterval. Schedulers retry failed
+// triggers, and without this each retry would hit upstream while it's still
+// down.
+//
+// If requests are limited to one every cfg.MinFetchInterval, a run that may
+// go ahead records that it's requesting upstream, so the next one waits.
+// Runs racing to do so re-check once they've lost, so only one goes ahead.
+func (s *Server) checkCooldown(ctx context.Context, r *run) error {
+	c, err := store.ReadCooldown(ctx, r.bkt)
+	if err != nil {
+		return storageErr(err)
+	}
+	now := time.Now().UTC()
+	if sk := s.cooldownSkip(r, c, now); sk != nil {
+		return sk.stop(r)
+	}
+	// A step continuing a run that's already requested upstream doesn't
+	// count as a new fetch.
+	if s.cfg.MinFetchInterval <= 0 || r.followUp {
+		return nil
+	}
+	var sk *cooldownSkip
+	if err := store.UpdateCooldown(ctx, r.bkt, func(c *store.Cooldown) {
+		if sk = s.cooldownSkip(r, c, now); sk == nil {
+			c.LastRequest = now
+		}
+	}); err != nil {
+		return storageErr(fmt.Errorf("couldn't record upstream request: %v", err))
+	}
+	if sk != nil {
+		return sk.stop(r)
+	}
+	return nil
+}
+
+// cooldownSkip is why a run backs off, and until when.
+type cooldownSkip struct {
+	reason string
+	msg    string
+	until  time.Time
+}
+
+// cooldownSkip returns why r should back off, given the stored cool-down c,
+// or nil if it needn't.
+func (s *Server) cooldownSkip(r *run, c *store.Cooldown, now time.Time) *cooldownSkip {
+	if c == nil {
+		return nil
+	}
+	if now.Before(c.Until) {
+		return &cooldownSkip{
+			reason: "backing_off",
+			msg:    fmt.Sprintf("upstream failed at %v (%v), backing off until %v", c.Failed.Format(time.RFC3339), c.Error, c.Until.Format(time.RFC3339)),
+			until:  c.Until,
+		}
+	}
+	if next := c.LastRequest.Add(s.cfg.MinFetchInterval); s.cfg.MinFetchInterval > 0 && !r.followUp && now.Before(next) {
+		return &cooldownSkip{
+			reason: "too_soon",
+			msg:    fmt.Sprintf("upstream was last requested at %v, and may be requested again from %v", c.LastRequest.Format(time.RFC3339), next.Format(time.RFC3339)),
+			until:  next,
+		}
+	}
+	return nil
+}
+
+// stop records sk in r's summary and ends the run.
+func (sk *cooldownSkip) stop(r *run) error {
+	log.Printf("exiting early: %v", sk.msg)
+	r.sum.Skipped = true
+	r.sum.SkipReason = sk.reason
+	r.sum.BackoffUntil = sk.until.UTC().Format(time.RFC3339)
+	return pipeline.ErrStop
+}
+
+// recordFailure starts a cool-down if err is an upstream failure. If
+// upstream sent Retry-After, the cool-down lasts at least that long, even if
+// cfg.FailureCooldown is 0. Failing to record it is only logged: the run has
+// failed already.
+func (s *Server) recordFailure(ctx context.Context, r *run, err error) {
+	var se *stageError
+	if r.bkt == nil || !errors.As(err, &se) || se.Code != codeUpstreamUnavailable {
+		return
+	}
+	wait := s.cfg.FailureCooldown
+	var ra *fetch.RetryAfterError
+	if errors.As(err, &ra) {
+		wait = max(wait, ra.RetryAfter)
+	}
+	if wait <= 0 {
+		return
+	}
+	now := time.Now().UTC()
+	until := now.Add(wait)
+	if err := store.UpdateCooldown(ctx, r.bkt, func(c *store.Cooldown) {
+		c.Failed = now
+		c.Until = until
+		c.Error = err.Error()
+	}); err != nil {
+		log.Printf("couldn't record upstream failure: %v", err)
+		return
+	}
+	log.Printf("upstream failed: backing off until %v", until.Format(time.RFC3339))
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/internal/fixture"
+	"github.com/mhansen/nzwirelessmap-fetch/pipeline"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// fakeServer returns a server whose bucket is in a fake GCS.
+func fakeServer(t *testing.T, cfg Config) (*Server, *fixture.GCS) {
+	t.Helper()
+	gcs := fixture.NewGCS()
+	t.Cleanup(gcs.Close)
+	client, err := gcs.Client(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BucketName = "b"
+	s := &Server{cfg: cfg}
+	s.storage.client = client
+	t.Cleanup(func() { s.Close() })
+	return s, gcs
+}
+
+func TestRecordFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		err      error
+		// want is how long to back off for, or 0 if the failure isn't
+		// recorded.
+		want time.Duration
+	}{
+		{name: "upstream", cooldown: 10 * time.Minute, err: upstreamErr(errors.New("down")), want: 10 * time.Minute},
+		{name: "no cool-down", err: upstreamErr(errors.New("down"))},
+		{
+			name:     "longer Retry-After",
+			cooldown: 10 * time.Minute,
+			err:      upstreamErr(&fetch.RetryAfterError{Status: "503", RetryAfter: time.Hour}),
+			want:     time.Hour,
+		},
+		{
+			name:     "shorter Retry-After",
+			cooldown: 10 * time.Minute,
+			err:      upstreamErr(&fetch.RetryAfterError{Status: "429", RetryAfter: time.Minute}),
+			want:     10 * time.Minute,
+		},
+		{
+			name: "Retry-After without a cool-down",
+			err:  upstreamErr(&fetch.RetryAfterError{Status: "429", RetryAfter: time.Minute}),
+			want: time.Minute,
+		},
+		{name: "not upstream", cooldown: 10 * time.Minute, err: conversionErr(errors.New("bad mdb"))},
+		{name: "not a stage error", cooldown: 10 * time.Minute, err: errors.New("oops")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, _ := fakeServer(t, Config{FailureCooldown: tt.cooldown})
+			bkt, err := s.bucket(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &run{bkt: bkt, sum: &runSummary{}}
+			start := time.Now()
+			s.recordFailure(ctx, r, tt.err)
+			c, err := store.ReadCooldown(ctx, bkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == 0 {
+				if c != nil {
+					t.Errorf("recorded %+v, want nothing", c)
+				}
+				if err := s.checkCooldown(ctx, r); err != nil {
+					t.Errorf("checkCooldown() = %v, want nil", err)
+				}
+				return
+			}
+			if c == nil {
+				t.Fatal("recorded nothing")
+			}
+			if c.Until.Before(start.Add(tt.want)) || c.Until.After(time.Now().Add(tt.want)) {
+				t.Errorf("backing off until %v, want %v from now", c.Until, tt.want)
+			}
+			if c.Error != tt.err.Error() {
+				t.Errorf("recorded error %q, want %q", c.Error, tt.err)
+			}
+
+			// The next run backs off.
+			r = &run{bkt: bkt, sum: &runSummary{}}
+			if err := s.checkCooldown(ctx, r); err != pipeline.ErrStop {
+				t.Errorf("checkCooldown() = %v, want ErrStop", err)
+			}
+			if r.sum.SkipReason != "backing_off" {
+				t.Errorf("skipped for %q, want backing_off", r.sum.SkipReason)
+			}
+		})
+	}
+}
+
+func TestCheckCooldownMinFetchInterval(t *testing.T) {
+	ctx := context.Background()
+	s, _ := fakeServer(t, Config{MinFetchInterval: time.Hour})
+	bkt, err := s.bucket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Of runs triggered together, only one requests upstream.
+	const runs = 4
+	errs := make(chan error, runs)
+	for i := 0; i < runs; i++ {
+		go func() {
+			errs <- s.checkCooldown(ctx, &run{bkt: bkt, sum: &runSummary{}})
+		}()
+	}
+	var ahead int
+	for i := 0; i < runs; i++ {
+		switch err := <-errs; err {
+		case nil:
+			ahead++
+		case pipeline.ErrStop:
+		default:
+			t.Errorf("checkCooldown() = %v", err)
+		}
+	}
+	if ahead != 1 {
+		t.Errorf("%v of %v concurrent runs went ahead, want 1", ahead, runs)
+	}
+
+	r := &run{bkt: bkt, sum: &runSummary{}}
+	if err := s.checkCooldown(ctx, r); err != pipeline.ErrStop {
+		t.Errorf("checkCooldown() = %v, want ErrStop", err)
+	}
+	if r.sum.SkipReason != "too_soon" {
+		t.Errorf("skipped for %q, want too_soon", r.sum.SkipReason)
+	}
+	// A step continuing a run isn't a new request.
+	if err := s.checkCooldown(ctx, &run{bkt: bkt, sum: &runSummary{}, followUp: true}); err != nil {
+		t.Errorf("checkCooldown() = %v for a follow-up, want nil", err)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+)
+
+// datasetMetrics holds the metrics of each dataset besides the default, by
+// name, laid out like the default's.
+var datasetMetrics = expvar.NewMap("datasets")
+
+// datasetName is what a dataset's name must look like, to be a path
+// segment and a metric name.
+var datasetName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// A Dataset is fetched and published independently of the default dataset
+// and every other, into a bucket of its own. Fields that aren't set here,
+// such as Formats, are as for the default dataset.
+type Dataset struct {
+	Name        string `json:"name"`
+	PrismZipURL string `json:"prism_zip_url"`
+	BucketName  string `json:"bucket_name"`
+	// QueryFile is the SQL that extracts rows. Empty means
+	// convert.QueryFile.
+	QueryFile string `json:"query_file,omitempty"`
+}
+
+// ReadDatasets reads a JSON list of datasets from the file name.
+func ReadDatasets(name string) ([]Dataset, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read datasets: %v", err)
+	}
+	var ds []Dataset
+	if err := json.Unmarshal(b, &ds); err != nil {
+		return nil, fmt.Errorf("couldn't parse datasets in %v: %v", name, err)
+	}
+	return ds, nil
+}
+
+// addDatasets creates a Server for each dataset, with state and metrics of
+// its own. Buckets can't be shared, since that's where most of a dataset's
+// state is kept.
+func (s *Server) addDatasets(ds []Dataset) error {
+	buckets := map[string]string{s.cfg.BucketName: "the default dataset"}
+	s.datasets = make(map[string]*Server)
+	for _, d := range ds {
+		if !datasetName.MatchString(d.Name) {
+			return fmt.Errorf("bad dataset name %q: want lowercase letters, digits, - and _", d.Name)
+		}
+		if _, ok := s.datasets[d.Name]; ok {
+			return fmt.Errorf("dataset %q is configured twice", d.Name)
+		}
+		if d.PrismZipURL == "" || d.BucketName == "" {
+			return fmt.Errorf("dataset %q needs a prism_zip_url and a bucket_name", d.Name)
+		}
+		if other, ok := buckets[d.BucketName]; ok {
+			return fmt.Errorf("dataset %q can't share bucket %v with %v", d.Name, d.BucketName, other)
+		}
+		buckets[d.BucketName] = fmt.Sprintf("dataset %q", d.Name)
+		if d.QueryFile != "" {
+			if _, err := os.Stat(d.QueryFile); err != nil {
+				return fmt.Errorf("dataset %q: %v", d.Name, err)
+			}
+		}
+
+		cfg := s.cfg
+		cfg.Datasets = nil
+		cfg.PrismZipURL = d.PrismZipURL
+		cfg.BucketName = d.BucketName
+		cfg.QueryFile = d.QueryFile
+		// Cached zips are keyed by snapshot, which datasets can share.
+		if cfg.Cache != nil {
+			cfg.Cache = &fetch.Cache{Dir: filepath.Join(cfg.Cache.Dir, d.Name), Max: cfg.Cache.Max}
+		}
+		// The events table is the default dataset's.
+		cfg.Events = nil
+		// Datasets are fetched when triggered, not by the scheduler.
+		cfg.Schedule = 0
+
+		m := new(expvar.Map).Init()
+		dm := serverMetrics{stages: new(expvar.Map).Init(), churn: new(expvar.Map).Init(), upstream: new(expvar.Map).Init()}
+		m.Set("pipeline_stages", dm.stages)
+		m.Set("churn", dm.churn)
+		m.Set("upstream", dm.upstream)
+		datasetMetrics.Set(d.Name, m)
+		ds, err := newServer(cfg, dm)
+		if err != nil {
+			return fmt.Errorf("dataset %q: %v", d.Name, err)
+		}
+		s.datasets[d.Name] = ds
+	}
+	return nil
+}
+
+// withDataset serves a request for /.../{dataset} with the handler h
+// returns for the named dataset's Server.
+func (s *Server) withDataset(h func(d *Server) http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("dataset")
+		d, ok := s.datasets[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "", &stageError{Code: codeNotFound, Err: fmt.Errorf("no dataset %q", name)})
+			return
+		}
+		h(d)(w, r)
+	}
+}
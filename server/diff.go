@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"golang.org/x/sync/errgroup"
+)
+
+// snapshotList is the response of /api/snapshots.
+type snapshotList struct {
+	// Snapshots are the timestamps of every published prism.json, oldest
+	// first.
+	Snapshots []string `json:"snapshots"`
+}
+
+// snapshotDiff is how licences changed from one snapshot to another. It's
+// the response of /api/diff, and what's published at prism.diff/{timestamp}.
+type snapshotDiff struct {
+	From  string        `json:"from"`
+	To    string        `json:"to"`
+	Churn convert.Churn `json:"churn"`
+	// Licences are ordered by licence ID.
+	Licences []licenceDiff `json:"licences"`
+}
+
+// licenceDiff is how one licence's rows changed between two snapshots.
+type licenceDiff struct {
+	LicenceID string `json:"licenceid"`
+	// Type is "added", "removed" or "modified".
+	Type string `json:"type"`
+	// Fields lists the columns that changed, if a modified licence has a
+	// single row before and after.
+	Fields map[string]fieldChange `json:"fields,omitempty"`
+	// Before and After are the licence's rows in each snapshot.
+	Before []map[string]string `json:"before"`
+	After  []map[string]string `json:"after"`
+}
+
+// diffSnapshots compares two snapshots by licence ID. As with
+// convert.LicenceEvents, only columns in both snapshots are compared.
+func diffSnapshots(from, to *snapshotRows) *snapshotDiff {
+	d := &snapshotDiff{
+		From:     from.tSuffix,
+		To:       to.tSuffix,
+		Churn:    convert.CompareLicences(from.rows, to.rows),
+		Licences: []licenceDiff{},
+	}
+	events := convert.LicenceEvents(from.rows, to.rows)
+	if len(events) == 0 {
+		return d
+	}
+	before, after := rowsByLicence(from.rows), rowsByLicence(to.rows)
+	for _, e := range events {
+		ld := licenceDiff{LicenceID: e.LicenceID, Type: e.Type, Before: before[e.LicenceID], After: after[e.LicenceID]}
+		if ld.Before == nil {
+			ld.Before = []map[string]string{}
+		}
+		if ld.After == nil {
+			ld.After = []map[string]string{}
+		}
+		if len(e.Fields) > 0 {
+			ld.Fields = make(map[string]fieldChange)
+			for _, f := range e.Fields {
+				ld.Fields[f] = fieldChange{From: ld.Before[0][f], To: ld.After[0][f]}
+			}
+		}
+		d.Licences = append(d.Licences, ld)
+	}
+	return d
+}
+
+// rowsByLicence groups the rows of r by licence, each sorted as by sortRows.
+func rowsByLicence(r *convert.Rows) map[string][]map[string]string {
+	m := make(map[string][]map[string]string)
+	for _, rec := range r.Records {
+		row := make(map[string]string, len(r.Header))
+		for i, h := range r.Header {
+			if i < len(rec) {
+				row[h] = rec[i]
+			}
+		}
+		id := row["licenceid"]
+		m[id] = append(m[id], row)
+	}
+	for _, rows := range m {
+		sortRows(rows)
+	}
+	return m
+}
+
+// publishDiff writes how licences changed since the previous snapshot to
+// prism.diff/{{timestamp}}. There's nothing to write for the first snapshot.
+func publishDiff(ctx context.Context, r *run) error {
+	prev, err := r.previous(ctx)
+	if err != nil || prev == nil {
+		return err
+	}
+	o := r.bkt.Object("prism.diff/" + r.tSuffix)
+	if err := store.WriteJSON(ctx, o, diffSnapshots(prev, &snapshotRows{tSuffix: r.tSuffix, rows: r.rows})); err != nil {
+		return storageErr(err)
+	}
+	r.sum.Artifacts = append(r.sum.Artifacts, store.URI(o))
+	return nil
+}
+
+func (s *Server) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	bkt, err := s.bucket(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	snapshots, err := store.List(r.Context(), bkt, "prism.json/")
+	if err != nil {
+		log.Printf("couldn't list snapshots: %v", err)
+		writeError(w, http.StatusInternalServerError, "", storageErr(err))
+		return
+	}
+	if snapshots == nil {
+		snapshots = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshotList{Snapshots: snapshots}); err != nil {
+		log.Printf("couldn't write snapshots: %v", err)
+	}
+}
+
+// diffEndpoints resolves the from and to parameters of /api/diff against
+// the published snapshots. to defaults to the newest snapshot, and from to
+// the one before to.
+func diffEndpoints(snapshots []string, from, to string) (string, string, error) {
+	if to == "" {
+		if len(snapshots) == 0 {
+			return "", "", errors.New("there are no snapshots")
+		}
+		to = snapshots[len(snapshots)-1]
+	}
+	i, ok := slices.BinarySearch(snapshots, to)
+	if !ok {
+		return "", "", fmt.Errorf("no snapshot %v", to)
+	}
+	if from == "" {
+		if i == 0 {
+			return "", "", fmt.Errorf("%v is the first snapshot", to)
+		}
+		return snapshots[i-1], to, nil
+	}
+	if _, ok := slices.BinarySearch(snapshots, from); !ok {
+		return "", "", fmt.Errorf("no snapshot %v", from)
+	}
+	return from, to, nil
+}
+
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("from") != "" && q.Get("from") == q.Get("to") {
+		writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: errors.New("from and to are the same snapshot")})
+		return
+	}
+	ctx := r.Context()
+	bkt, err := s.bucket(ctx)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	snapshots, err := store.List(ctx, bkt, "prism.json/")
+	if err != nil {
+		log.Printf("couldn't list snapshots: %v", err)
+		writeError(w, http.StatusInternalServerError, "", storageErr(err))
+		return
+	}
+	from, to, err := diffEndpoints(snapshots, q.Get("from"), q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "", &stageError{Code: codeNotFound, Err: err})
+		return
+	}
+	var prev, cur *snapshotRows
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		prev, err = readSnapshotRows(gctx, bkt, from)
+		return err
+	})
+	g.Go(func() (err error) {
+		cur, err = readSnapshotRows(gctx, bkt, to)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		log.Printf("couldn't diff %v and %v: %v", from, to, err)
+		writeError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diffSnapshots(prev, cur)); err != nil {
+		log.Printf("couldn't write diff: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// Fetch runs the fetch pipeline once, as /fetch does, and returns the run
+// summary as JSON. It's for entrypoints that aren't a long-running HTTP
+// server, such as a Cloud Run job or a Lambda function.
+func (s *Server) Fetch(ctx context.Context) ([]byte, error) {
+	if m := s.maintenance(ctx); m != nil {
+		return nil, maintenanceErr(m)
+	}
+	sum := &runSummary{RunID: newRunID(), TriggeredBy: &store.Caller{Via: "job"}}
+	log.Printf("starting run %v", sum.RunID)
+	if err := s.runFetch(ctx, sum); err != nil {
+		log.Printf("run %v failed: %v", sum.RunID, err)
+		return nil, fmt.Errorf("run %v: %w", sum.RunID, err)
+	}
+	log.Printf("OK: %+v", sum)
+	return json.Marshal(sum)
+}
+
+// Reprocess reconverts stale snapshots, as /reprocess does, writing the
+// report to w. It returns an error if any snapshot failed.
+func (s *Server) Reprocess(ctx context.Context, w io.Writer) error {
+	if m := s.maintenance(ctx); m != nil {
+		return maintenanceErr(m)
+	}
+	report, err := s.reprocessInternal(ctx, &store.Caller{Via: "job"})
+	if err != nil {
+		return err
+	}
+	report.writeTo(w)
+	if failed := report.failures(); len(failed) > 0 {
+		return fmt.Errorf("%v of %v snapshots failed", len(failed), len(report.Results))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// errorCode is a machine-readable classification of a failed run, for
+// callers and alerting to react to.
+type errorCode string
+
+const (
+	codeUpstreamUnavailable errorCode = "upstream_unavailable"
+	codeConversionFailed    errorCode = "conversion_failed"
+	codeStorageError        errorCode = "storage_error"
+	codeNotFound            errorCode = "not_found"
+	codeBadRequest          errorCode = "bad_request"
+	codeUnauthorized        errorCode = "unauthorized"
+	codeRateLimited         errorCode = "rate_limited"
+	codeMaintenance         errorCode = "maintenance"
+	codeBusy                errorCode = "busy"
+	codeInternal            errorCode = "internal"
+)
+
+// stageError is an error from a particular pipeline stage.
+type stageError struct {
+	Code  errorCode
+	Stage string
+	Err   error
+}
+
+func (e *stageError) Error() string {
+	if e.Stage == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %v", e.Stage, e.Err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.Err
+}
+
+// upstreamErr, conversionErr and storageErr classify an error. The stage is
+// filled in by the stageErrors hook.
+func upstreamErr(err error) error {
+	return &stageError{Code: codeUpstreamUnavailable, Err: err}
+}
+
+func conversionErr(err error) error {
+	return &stageError{Code: codeConversionFailed, Err: err}
+}
+
+func storageErr(err error) error {
+	return &stageError{Code: codeStorageError, Err: err}
+}
+
+func isStorageErr(err error) bool {
+	var se *stageError
+	return errors.As(err, &se) && se.Code == codeStorageError
+}
+
+// newRunID returns a random identifier for a run, to correlate responses
+// with logs.
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("couldn't generate run ID: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    errorCode `json:"code"`
+	Stage   string    `json:"stage,omitempty"`
+	Message string    `json:"message"`
+	RunID   string    `json:"run_id,omitempty"`
+}
+
+// writeError writes err as a JSON error envelope.
+func writeError(w http.ResponseWriter, status int, runID string, err error) {
+	body := errorBody{Code: codeInternal, Message: err.Error(), RunID: runID}
+	var se *stageError
+	if errors.As(err, &se) {
+		body.Code = se.Code
+		body.Stage = se.Stage
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse{Error: body}); err != nil {
+		log.Printf("couldn't write error response: %v", err)
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCServer returns a gRPC server for the Fetcher service described in
+// fetcher.proto. There's no generated code: the service is registered by
+// hand, with messages built from the descriptors parsed from fetcher.proto.
+func (s *Server) GRPCServer() *grpc.Server {
+	g := grpc.NewServer(grpc.UnaryInterceptor(s.grpcUnaryAuth), grpc.StreamInterceptor(s.grpcStreamAuth))
+	g.RegisterService(&fetcherServiceDesc, &grpcService{s: s})
+	return g
+}
+
+// grpcAccess is who may call each method, as a route's access is for HTTP.
+// Methods that aren't listed need the operator token.
+var grpcAccess = map[string]access{
+	"/nzwirelessmap.fetch.v1.Fetcher/TriggerRun":    accessOperator,
+	"/nzwirelessmap.fetch.v1.Fetcher/GetRun":        accessQuery,
+	"/nzwirelessmap.fetch.v1.Fetcher/ListSnapshots": accessQuery,
+	"/nzwirelessmap.fetch.v1.Fetcher/QueryLinks":    accessQuery,
+}
+
+// grpcAuth checks a call to method as requireOperator and requireAPIKey
+// check HTTP requests. Operators send their token as a bearer token in the
+// authorization metadata, and API keys go in x-api-key.
+func (s *Server) grpcAuth(ctx context.Context, method string) error {
+	a, ok := grpcAccess[method]
+	if !ok {
+		a = accessOperator
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	switch a {
+	case accessOperator:
+		if s.cfg.OperatorToken == "" {
+			return nil
+		}
+		for _, v := range md.Get("authorization") {
+			got, ok := strings.CutPrefix(v, "Bearer ")
+			if ok && subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.OperatorToken)) == 1 {
+				return nil
+			}
+		}
+		return status.Errorf(codes.Unauthenticated, "%v needs the operator token", path.Base(method))
+	case accessQuery:
+		if !s.cfg.RequireAPIKeys {
+			return nil
+		}
+		var raw string
+		if v := md.Get("x-api-key"); len(v) > 0 {
+			raw = v[0]
+		}
+		code, retryAfter, err := s.checkAPIKey(ctx, raw)
+		if err == nil {
+			return nil
+		}
+		c := codes.Unauthenticated
+		switch code {
+		case http.StatusTooManyRequests:
+			c = codes.ResourceExhausted
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", fmt.Sprint(int(math.Ceil(retryAfter.Seconds())))))
+		case http.StatusServiceUnavailable:
+			c = codes.Unavailable
+		}
+		return status.Error(c, err.Error())
+	}
+	return nil
+}
+
+func (s *Server) grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.grpcAuth(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) grpcStreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.grpcAuth(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+//go:embed fetcher.proto
+var fetcherProto string
+
+// fetcherService is the Fetcher service of fetcher.proto, whose messages are
+// built with dynamicpb.
+var fetcherService = func() protoreflect.ServiceDescriptor {
+	fd, err := parseProto("server/fetcher.proto", fetcherProto)
+	if err != nil {
+		panic(err)
+	}
+	return fd.Services().ByName("Fetcher")
+}()
+
+// fetcherServer is the interface the service's handlers need.
+type fetcherServer interface {
+	triggerRun(ctx context.Context, req *dynamicpb.Message) (interface{}, error)
+	getRun(ctx context.Context, req *dynamicpb.Message) (interface{}, error)
+	listSnapshots(ctx context.Context, req *dynamicpb.Message) (interface{}, error)
+	queryLinks(req *dynamicpb.Message, stream grpc.ServerStream) error
+}
+
+var fetcherServiceDesc = grpc.ServiceDesc{
+	ServiceName: string(fetcherService.FullName()),
+	HandlerType: (*fetcherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "TriggerRun", Handler: unary("TriggerRun", fetcherServer.triggerRun)},
+		{MethodName: "GetRun", Handler: unary("GetRun", fetcherServer.getRun)},
+		{MethodName: "ListSnapshots", Handler: unary("ListSnapshots", fetcherServer.listSnapshots)},
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "QueryLinks",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := dynamicpb.NewMessage(fetcherService.Methods().ByName("QueryLinks").Input())
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(fetcherServer).queryLinks(req, stream)
+		},
+	}},
+	Metadata: "server/fetcher.proto",
+}
+
+// unary adapts a method that returns a JSON-encodable value to a gRPC
+// handler that returns the method's output message.
+func unary(name string, method func(fetcherServer, context.Context, *dynamicpb.Message) (interface{}, error)) grpc.MethodHandler {
+	md := fetcherService.Methods().ByName(protoreflect.Name(name))
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := dynamicpb.NewMessage(md.Input())
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			v, err := method(srv.(fetcherServer), ctx, req.(*dynamicpb.Message))
+			if err != nil {
+				return nil, err
+			}
+			return toMessage(v, md.Output())
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + string(fetcherService.FullName()) + "/" + name}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// toMessage converts v to a message of type md via its JSON encoding, whose
+// fields must all be in md.
+func toMessage(v interface{}, md protoreflect.MessageDescriptor) (*dynamicpb.Message, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "couldn't encode response: %v", err)
+	}
+	m := dynamicpb.NewMessage(md)
+	if err := protojson.Unmarshal(b, m); err != nil {
+		return nil, status.Errorf(codes.Internal, "couldn't encode response as %v: %v", md.FullName(), err)
+	}
+	return m, nil
+}
+
+// field returns the field name of m.
+func field(m *dynamicpb.Message, name string) protoreflect.Value {
+	return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+}
+
+// grpcStatus converts a pipeline error to a gRPC status.
+func grpcStatus(err error) error {
+	code := codes.Internal
+	var se *stageError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case codeUpstreamUnavailable:
+			code = codes.Unavailable
+		case codeNotFound:
+			code = codes.NotFound
+		}
+	}
+	return status.Error(code, err.Error())
+}
+
+type grpcService struct {
+	s *Server
+}
+
+func (g *grpcService) triggerRun(ctx context.Context, req *dynamicpb.Message) (interface{}, error) {
+	if m := g.s.maintenance(ctx); m != nil {
+		return nil, status.Error(codes.Unavailable, maintenanceErr(m).Error())
+	}
+	// Claiming the job before starting makes a concurrent trigger see it.
+	if !g.s.job.tryStart() {
+		return nil, status.Error(codes.Aborted, "a run is already in progress")
+	}
+	sum := &runSummary{RunID: newRunID(), TriggeredBy: g.s.caller(grpcHeader(ctx))}
+	log.Printf("starting run %v over gRPC", sum.RunID)
+	// The run outlives a client that gives up waiting, as with /fetch, but
+	// stays in its trace.
+	runCtx := withTrace(context.Background(), grpcHeader(ctx))
+	if field(req, "wait").Bool() {
+		if err := g.s.runFetch(runCtx, sum); err != nil {
+			log.Printf("run %v failed: %v", sum.RunID, err)
+		}
+		return g.s.runs.get(sum.RunID), nil
+	}
+	go func() {
+		if err := g.s.runFetch(runCtx, sum); err != nil {
+			log.Printf("run %v failed: %v", sum.RunID, err)
+		}
+	}()
+	return &runRecord{RunID: sum.RunID, State: "running"}, nil
+}
+
+func (g *grpcService) getRun(ctx context.Context, req *dynamicpb.Message) (interface{}, error) {
+	id := field(req, "run_id").String()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "run_id is required")
+	}
+	rec := g.s.runs.get(id)
+	if rec == nil {
+		return nil, status.Errorf(codes.NotFound, "no recent run %v", id)
+	}
+	return rec, nil
+}
+
+func (g *grpcService) listSnapshots(ctx context.Context, req *dynamicpb.Message) (interface{}, error) {
+	bkt, err := g.s.bucket(ctx)
+	if err != nil {
+		return nil, grpcStatus(storageErr(err))
+	}
+	idx, _, err := store.ReadIndex(ctx, bkt)
+	if err != nil {
+		return nil, grpcStatus(storageErr(err))
+	}
+	return idx, nil
+}
+
+func (g *grpcService) queryLinks(req *dynamicpb.Message, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	snapshot := field(req, "snapshot").String()
+	if snapshot == "" {
+		snapshot = "latest"
+	}
+	licenceID := field(req, "licenceid").String()
+	client := strings.ToLower(field(req, "clientname").String())
+	band := field(req, "band").String()
+	wantStatus := field(req, "status").String()
+	link := fetcherService.Methods().ByName("QueryLinks").Output()
+	columns := link.Fields().ByName("columns")
+
+	bkt, err := g.s.bucket(ctx)
+	if err != nil {
+		return grpcStatus(storageErr(err))
+	}
+	err = store.ScanJSONRows(ctx, bkt, "prism.json/"+snapshot, func(row map[string]string) error {
+		switch {
+		case licenceID != "" && row["licenceid"] != licenceID,
+			client != "" && !strings.Contains(strings.ToLower(row["clientname"]), client),
+			wantStatus != "" && convert.StatusOf(row["status"]) != wantStatus:
+			return nil
+		}
+		if band != "" {
+			mhz, _ := strconv.ParseFloat(row["frequency"], 64)
+			if convert.BandOf(mhz) != band {
+				return nil
+			}
+		}
+		m := dynamicpb.NewMessage(link)
+		cols := m.Mutable(columns).Map()
+		for k, v := range row {
+			cols.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+		}
+		return stream.SendMsg(m)
+	})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return status.Errorf(codes.NotFound, "no snapshot %v", snapshot)
+	}
+	if err != nil {
+		return grpcStatus(err)
+	}
+	return nil
+}
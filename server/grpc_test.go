@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestFetcherProto(t *testing.T) {
+	methods := fetcherService.Methods()
+	var registered []string
+	for _, m := range fetcherServiceDesc.Methods {
+		registered = append(registered, m.MethodName)
+	}
+	for _, st := range fetcherServiceDesc.Streams {
+		registered = append(registered, st.StreamName)
+		if m := methods.ByName(protoreflect.Name(st.StreamName)); m == nil || !m.IsStreamingServer() {
+			t.Errorf("%v is registered as server-streaming, but fetcher.proto doesn't say so", st.StreamName)
+		}
+	}
+	if len(registered) != methods.Len() {
+		t.Errorf("registered %q, but fetcher.proto has %v methods", registered, methods.Len())
+	}
+	for _, name := range registered {
+		if methods.ByName(protoreflect.Name(name)) == nil {
+			t.Errorf("registered %v, which isn't in fetcher.proto", name)
+		}
+		if _, ok := grpcAccess["/"+fetcherServiceDesc.ServiceName+"/"+name]; !ok {
+			t.Errorf("%v has no entry in grpcAccess", name)
+		}
+	}
+}
+
+// fill sets every field of v, recursively, so that JSON encoding it
+// includes every field.
+func fill(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem())
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			v.Set(reflect.ValueOf(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			fill(v.Field(i))
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fill(v.Index(0))
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	}
+}
+
+func TestFetcherMessages(t *testing.T) {
+	// Every field of what the methods return must be in their messages.
+	tests := []struct {
+		method string
+		v      interface{}
+	}{
+		{"TriggerRun", &runRecord{}},
+		{"GetRun", &runRecord{}},
+		{"ListSnapshots", &store.Index{}},
+	}
+	for _, tt := range tests {
+		fill(reflect.ValueOf(tt.v).Elem())
+		if _, err := toMessage(tt.v, fetcherService.Methods().ByName(protoreflect.Name(tt.method)).Output()); err != nil {
+			t.Errorf("%v: %v", tt.method, err)
+		}
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	s := &Server{cfg: Config{OperatorToken: "op", RequireAPIKeys: true}}
+	lis := bufconn.Listen(1 << 20)
+	g := s.GRPCServer()
+	go g.Serve(lis)
+	defer g.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		method string
+		md     metadata.MD
+		want   codes.Code
+	}{
+		{method: "TriggerRun", want: codes.Unauthenticated},
+		{method: "TriggerRun", md: metadata.Pairs("authorization", "Bearer wrong"), want: codes.Unauthenticated},
+		{method: "GetRun", want: codes.Unauthenticated},
+		{method: "ListSnapshots", want: codes.Unauthenticated},
+		// An operator token isn't an API key.
+		{method: "GetRun", md: metadata.Pairs("authorization", "Bearer op"), want: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		m := fetcherService.Methods().ByName(protoreflect.Name(tt.method))
+		ctx := metadata.NewOutgoingContext(context.Background(), tt.md)
+		err := conn.Invoke(ctx, "/"+fetcherServiceDesc.ServiceName+"/"+tt.method, dynamicpb.NewMessage(m.Input()), dynamicpb.NewMessage(m.Output()))
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("%v with %v = %v, want %v", tt.method, tt.md, err, tt.want)
+		}
+	}
+
+	// QueryLinks is streamed, so it's authorised by the stream interceptor.
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/"+fetcherServiceDesc.ServiceName+"/QueryLinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := fetcherService.Methods().ByName("QueryLinks")
+	if err := stream.SendMsg(dynamicpb.NewMessage(m.Input())); err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(dynamicpb.NewMessage(m.Output())); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("QueryLinks without an API key = %v, want Unauthenticated", err)
+	}
+}
+
+func TestJobTryStart(t *testing.T) {
+	var j jobStatus
+	if !j.tryStart() {
+		t.Fatal("tryStart() = false for an idle job")
+	}
+	if j.tryStart() {
+		t.Error("tryStart() = true for a running job")
+	}
+	j.setStage("request")
+	if j.tryStart() {
+		t.Error("tryStart() = true once the run has reached a stage")
+	}
+	j.finish()
+	if !j.tryStart() {
+		t.Error("tryStart() = false after the job finished")
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"golang.org/x/sync/errgroup"
+)
+
+// licenceHistory is how one licence's rows changed across snapshots.
+type licenceHistory struct {
+	LicenceID string `json:"licenceid"`
+	FirstSeen string `json:"first_seen,omitempty"`
+	LastSeen  string `json:"last_seen,omitempty"`
+	// Current is whether the licence is in the newest snapshot.
+	Current bool            `json:"current"`
+	Changes []licenceChange `json:"changes"`
+}
+
+// licenceChange is a snapshot in which a licence appeared, changed or
+// disappeared.
+type licenceChange struct {
+	Timestamp string `json:"timestamp"`
+	// Type is "added", "modified" or "removed".
+	Type string `json:"type"`
+	// Fields lists the columns that changed, if the licence has a single row
+	// before and after.
+	Fields map[string]fieldChange `json:"fields,omitempty"`
+	// Rows is the licence's rows as of this snapshot.
+	Rows []map[string]string `json:"rows"`
+}
+
+type fieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// historyCacheSize bounds how many licences' rows historyCache keeps.
+const historyCacheSize = 1024
+
+// historyCache remembers the rows of recently requested licences in each
+// snapshot, so a repeated request only scans snapshots published since.
+// Snapshots don't change once published, except when reprocessed, which
+// resets the cache.
+type historyCache struct {
+	mu      sync.Mutex
+	entries map[string]*licenceSnapshots
+	// order lists the licences in entries, least recently added first.
+	order []string
+	// building is held while building the licence index, so concurrent
+	// requests wait for one build rather than each reading every snapshot.
+	building sync.Mutex
+}
+
+// licenceSnapshots is a licence's rows in each of snapshots.
+type licenceSnapshots struct {
+	snapshots []string
+	rows      [][]map[string]string
+}
+
+func (c *historyCache) get(id string) *licenceSnapshots {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[id]
+}
+
+func (c *historyCache) put(id string, ls *licenceSnapshots) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*licenceSnapshots)
+	}
+	if _, ok := c.entries[id]; !ok {
+		c.order = append(c.order, id)
+	}
+	c.entries[id] = ls
+	for len(c.order) > historyCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+func (c *historyCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries, c.order = nil, nil
+}
+
+// licenceRows returns the rows for licence id in each snapshot, each
+// snapshot's sorted by sortRows. Only the snapshots the licence index says
+// it changed in, and any the index doesn't cover yet, are read, in parallel:
+// in the rest, its rows are the same as in the snapshot before.
+func (s *Server) licenceRows(ctx context.Context, id string) (snapshots []string, rows [][]map[string]string, err error) {
+	bkt, err := s.bucket(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshots, err = store.List(ctx, bkt, "prism.json/")
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := s.licenceIndex(ctx, bkt, snapshots)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed := make(map[string]bool)
+	for _, ts := range idx.Changes[id] {
+		changed[ts] = true
+	}
+	rows = make([][]map[string]string, len(snapshots))
+	start := 0
+	if c := s.history.get(id); c != nil && len(c.snapshots) <= len(snapshots) && slices.Equal(c.snapshots, snapshots[:len(c.snapshots)]) {
+		start = copy(rows, c.rows)
+	}
+	read := make([]bool, len(snapshots))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(s.cfg.Parallelism, 1))
+	for i := start; i < len(snapshots); i++ {
+		ts := snapshots[i]
+		if !changed[ts] && ts <= idx.Through {
+			continue
+		}
+		read[i] = true
+		g.Go(func() error {
+			var found []map[string]string
+			err := store.ScanJSONRows(gctx, bkt, "prism.json/"+ts, func(row map[string]string) error {
+				if row["licenceid"] == id {
+					found = append(found, row)
+				}
+				return nil
+			})
+			sortRows(found)
+			rows[i] = found
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	for i := max(start, 1); i < len(snapshots); i++ {
+		if !read[i] {
+			rows[i] = rows[i-1]
+		}
+	}
+	s.history.put(id, &licenceSnapshots{snapshots: snapshots, rows: rows})
+	return snapshots, rows, nil
+}
+
+// licenceIndex returns the licence index, first building it from snapshots
+// if it hasn't been, which reads each of them once.
+func (s *Server) licenceIndex(ctx context.Context, bkt *storage.BucketHandle, snapshots []string) (*store.LicenceIndex, error) {
+	idx, err := store.ReadLicenceIndex(ctx, bkt)
+	if err != nil || idx != nil {
+		return idx, err
+	}
+	s.history.building.Lock()
+	defer s.history.building.Unlock()
+	// Another request may have built it while we waited.
+	if idx, err := store.ReadLicenceIndex(ctx, bkt); err != nil || idx != nil {
+		return idx, err
+	}
+	log.Printf("building the licence index from %v snapshots", len(snapshots))
+	idx = &store.LicenceIndex{}
+	var prev map[string]string
+	// Snapshots are read Parallelism at a time, and compared in order.
+	batch := max(s.cfg.Parallelism, 1)
+	for first := 0; first < len(snapshots); first += batch {
+		chunk := snapshots[first:min(first+batch, len(snapshots))]
+		keys := make([]map[string]string, len(chunk))
+		g, gctx := errgroup.WithContext(ctx)
+		for i, ts := range chunk {
+			g.Go(func() error {
+				var rows []map[string]string
+				err := store.ScanJSONRows(gctx, bkt, "prism.json/"+ts, func(row map[string]string) error {
+					rows = append(rows, row)
+					return nil
+				})
+				keys[i] = licenceKeys(rows)
+				return err
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+		for i, ts := range chunk {
+			idx.Add(ts, changedLicences(prev, keys[i]))
+			prev = keys[i]
+		}
+	}
+	err = store.UpdateLicenceIndex(ctx, bkt, func(stored *store.LicenceIndex) {
+		// Don't replace an index another replica built meanwhile, which a
+		// publish may have added to since.
+		if stored.Through == "" {
+			*stored = *idx
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// licenceKeys maps each licence in a snapshot's rows to a hash of its rows,
+// in the order sortRows puts them, so that comparing two snapshots' keys
+// finds the same changes buildHistory does.
+func licenceKeys(rows []map[string]string) map[string]string {
+	byID := make(map[string][]map[string]string)
+	for _, row := range rows {
+		byID[row["licenceid"]] = append(byID[row["licenceid"]], row)
+	}
+	keys := make(map[string]string, len(byID))
+	for id, rs := range byID {
+		sortRows(rs)
+		b, _ := json.Marshal(rs)
+		h := sha256.Sum256(b)
+		keys[id] = string(h[:])
+	}
+	return keys
+}
+
+// changedLicences returns the licences added, changed or removed between
+// two snapshots' licenceKeys, in order.
+func changedLicences(prev, cur map[string]string) []string {
+	var ids []string
+	for id, k := range cur {
+		if prev[id] != k {
+			ids = append(ids, id)
+		}
+	}
+	for id := range prev {
+		if _, ok := cur[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// rowMaps returns rows as they're published in prism.json.
+func rowMaps(rows *convert.Rows) []map[string]string {
+	out := make([]map[string]string, len(rows.Records))
+	for i, rec := range rows.Records {
+		m := make(map[string]string, len(rows.Header))
+		for j, h := range rows.Header {
+			if j < len(rec) {
+				m[h] = rec[j]
+			} else {
+				m[h] = ""
+			}
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// buildHistory turns a licence's rows in each snapshot, as returned by
+// licenceRows, into a list of changes.
+func buildHistory(id string, snapshots []string, rows [][]map[string]string) *licenceHistory {
+	h := &licenceHistory{LicenceID: id, Changes: []licenceChange{}}
+	var prev []map[string]string
+	for i, ts := range snapshots {
+		cur := rows[i]
+		switch {
+		case len(prev) == 0 && len(cur) > 0:
+			h.Changes = append(h.Changes, licenceChange{Timestamp: ts, Type: "added", Rows: cur})
+		case len(prev) > 0 && len(cur) == 0:
+			h.Changes = append(h.Changes, licenceChange{Timestamp: ts, Type: "removed", Rows: []map[string]string{}})
+		case len(cur) > 0 && !reflect.DeepEqual(prev, cur):
+			c := licenceChange{Timestamp: ts, Type: "modified", Rows: cur}
+			if len(prev) == 1 && len(cur) == 1 {
+				c.Fields = diffFields(prev[0], cur[0])
+			}
+			h.Changes = append(h.Changes, c)
+		}
+		if len(cur) > 0 {
+			if h.FirstSeen == "" {
+				h.FirstSeen = ts
+			}
+			h.LastSeen = ts
+		}
+		prev = cur
+	}
+	h.Current = len(prev) > 0
+	return h
+}
+
+// sortRows orders a licence's rows canonically, so that reordering between
+// snapshots doesn't look like a change.
+func sortRows(rows []map[string]string) {
+	key := func(row map[string]string) string {
+		b, _ := json.Marshal(row) // Map keys are marshalled in order.
+		return string(b)
+	}
+	sort.Slice(rows, func(i, j int) bool { return key(rows[i]) < key(rows[j]) })
+}
+
+func diffFields(from, to map[string]string) map[string]fieldChange {
+	changes := make(map[string]fieldChange)
+	for k, v := range to {
+		if from[k] != v {
+			changes[k] = fieldChange{From: from[k], To: v}
+		}
+	}
+	for k, v := range from {
+		if _, ok := to[k]; !ok {
+			changes[k] = fieldChange{From: v}
+		}
+	}
+	return changes
+}
+
+func (s *Server) licenceHistory(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	snapshots, rows, err := s.licenceRows(r.Context(), id)
+	if err != nil {
+		log.Printf("couldn't get history of licence %v: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "", storageErr(err))
+		return
+	}
+	h := buildHistory(id, snapshots, rows)
+	if h.FirstSeen == "" {
+		writeError(w, http.StatusNotFound, "", &stageError{Code: codeNotFound, Err: fmt.Errorf("licence %v isn't in any snapshot", id)})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h); err != nil {
+		log.Printf("couldn't write history: %v", err)
+	}
+}
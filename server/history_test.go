@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+func TestLicenceHistory(t *testing.T) {
+	snapshots := []struct {
+		ts, json string
+	}{
+		{"2024-01-01T00:00:00Z", `[{"licenceid": "1", "clientname": "A"}, {"licenceid": "2", "clientname": "B"}]`},
+		{"2024-02-01T00:00:00Z", `[{"licenceid": "2", "clientname": "B"}, {"licenceid": "1", "clientname": "A"}]`},
+		{"2024-03-01T00:00:00Z", `[{"licenceid": "1", "clientname": "A2"}, {"licenceid": "2", "clientname": "B"}]`},
+		{"2024-04-01T00:00:00Z", `[{"licenceid": "1", "clientname": "A2"}]`},
+	}
+	s, gcs := fakeServer(t, Config{Parallelism: 2})
+	for _, snap := range snapshots {
+		gcs.Put("b", "prism.json/"+snap.ts, []byte(snap.json))
+	}
+	// history requests a licence's history, and returns the changes and
+	// which snapshots were read.
+	history := func(id string) (int, []string, []string) {
+		t.Helper()
+		s.history.reset()
+		gcs.Calls()
+		r := httptest.NewRequest("GET", "/api/licence/"+id+"/history", nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		s.licenceHistory(w, r)
+		var read []string
+		for _, c := range gcs.Calls() {
+			if ts, ok := strings.CutPrefix(c, "GET media prism.json/"); ok {
+				read = append(read, ts)
+			}
+		}
+		var h licenceHistory
+		json.Unmarshal(w.Body.Bytes(), &h)
+		var changes []string
+		for _, c := range h.Changes {
+			changes = append(changes, c.Type+" "+c.Timestamp)
+		}
+		return w.Code, changes, read
+	}
+
+	// The first request builds the index, reading every snapshot.
+	if _, _, read := history("1"); len(read) != len(snapshots)+2 {
+		t.Errorf("building the index read %v, want every snapshot, then the two licence 1 changed in", read)
+	}
+	tests := []struct {
+		id          string
+		wantCode    int
+		wantChanges []string
+		wantRead    []string
+	}{
+		{
+			id:          "1",
+			wantCode:    http.StatusOK,
+			wantChanges: []string{"added 2024-01-01T00:00:00Z", "modified 2024-03-01T00:00:00Z"},
+			wantRead:    []string{"2024-01-01T00:00:00Z", "2024-03-01T00:00:00Z"},
+		},
+		{
+			id:          "2",
+			wantCode:    http.StatusOK,
+			wantChanges: []string{"added 2024-01-01T00:00:00Z", "removed 2024-04-01T00:00:00Z"},
+			wantRead:    []string{"2024-01-01T00:00:00Z", "2024-04-01T00:00:00Z"},
+		},
+		// Unknown licences aren't in the index, so no snapshots are read.
+		{id: "3", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		code, changes, read := history(tt.id)
+		if code != tt.wantCode {
+			t.Errorf("licence %v: responded %v, want %v", tt.id, code, tt.wantCode)
+		}
+		if !reflect.DeepEqual(changes, tt.wantChanges) {
+			t.Errorf("licence %v: changes = %q, want %q", tt.id, changes, tt.wantChanges)
+		}
+		// Reads are in parallel, so in no particular order.
+		if len(read) != len(tt.wantRead) {
+			t.Errorf("licence %v: read %q, want %q", tt.id, read, tt.wantRead)
+		}
+	}
+
+	// A snapshot the index doesn't cover yet is read too.
+	gcs.Put("b", "prism.json/2024-05-01T00:00:00Z", []byte(`[{"licenceid": "3", "clientname": "C"}]`))
+	code, changes, read := history("3")
+	if want := []string{"added 2024-05-01T00:00:00Z"}; code != http.StatusOK || !reflect.DeepEqual(changes, want) {
+		t.Errorf("licence 3: responded %v with changes %q, want 200 with %q", code, changes, want)
+	}
+	if want := []string{"2024-05-01T00:00:00Z"}; !reflect.DeepEqual(read, want) {
+		t.Errorf("licence 3: read %q, want %q", read, want)
+	}
+}
+
+func TestUpdateLicenceIndex(t *testing.T) {
+	prev := &snapshotRows{tSuffix: "2024-01-01T00:00:00Z", rows: &convert.Rows{
+		Header:  []string{"licenceid", "clientname"},
+		Records: [][]string{{"1", "A"}, {"2", "B"}},
+	}}
+	cur := &convert.Rows{
+		Header:  []string{"licenceid", "clientname"},
+		Records: [][]string{{"1", "A2"}, {"3", "C"}},
+	}
+	const ts = "2024-02-01T00:00:00Z"
+	tests := []struct {
+		name string
+		idx  *store.LicenceIndex
+		prev *snapshotRows
+		want *store.LicenceIndex
+	}{
+		{
+			name: "first snapshot",
+			want: &store.LicenceIndex{Through: ts, Changes: map[string][]string{"1": {ts}, "3": {ts}}},
+		},
+		{name: "not built", prev: prev},
+		{
+			name: "up to date",
+			idx:  &store.LicenceIndex{Through: prev.tSuffix, Changes: map[string][]string{"1": {prev.tSuffix}, "2": {prev.tSuffix}}},
+			prev: prev,
+			want: &store.LicenceIndex{Through: ts, Changes: map[string][]string{"1": {prev.tSuffix, ts}, "2": {prev.tSuffix, ts}, "3": {ts}}},
+		},
+		{
+			name: "missing a snapshot",
+			idx:  &store.LicenceIndex{Through: "2023-12-01T00:00:00Z", Changes: map[string][]string{"1": {"2023-12-01T00:00:00Z"}}},
+			prev: prev,
+			want: &store.LicenceIndex{Through: "2023-12-01T00:00:00Z", Changes: map[string][]string{"1": {"2023-12-01T00:00:00Z"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, _ := fakeServer(t, Config{})
+			bkt, err := s.bucket(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.idx != nil {
+				if err := store.UpdateLicenceIndex(ctx, bkt, func(idx *store.LicenceIndex) { *idx = *tt.idx }); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &run{bkt: bkt, tSuffix: ts, rows: cur, prev: tt.prev, prevRead: true, sum: &runSummary{}}
+			if err := updateLicenceIndex(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.ReadLicenceIndex(ctx, bkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("index = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cachedResponse is a response remembered for an Idempotency-Key. done is
+// closed once the response has been recorded, so duplicate requests that
+// arrive while the first is still running wait for its result.
+type cachedResponse struct {
+	done    chan struct{}
+	expires time.Time
+	status  int
+	header  http.Header
+	body    []byte
+}
+
+// idempotencyCache remembers responses by Idempotency-Key, so retried
+// scheduler invocations don't trigger duplicate runs.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+// withIdempotency wraps h so that requests from the same caller carrying the
+// same Idempotency-Key within the TTL get the first request's response
+// instead of running h again. Server errors are only replayed to duplicates
+// that were waiting for them: a retry after that runs h again.
+func (s *Server) withIdempotency(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h(w, r)
+			return
+		}
+		// Keys are scoped to the caller, so one caller can't replay, or
+		// block, another's run by reusing its key.
+		c := s.caller(r.Header)
+		key = c.Via + " " + c.Subject + " " + c.Email + " " + r.Method + " " + r.URL.Path + " " + key
+
+		cache := &s.idempotent
+		cache.mu.Lock()
+		now := time.Now()
+		for k, e := range cache.entries {
+			if isExpired(e, now) {
+				delete(cache.entries, k)
+			}
+		}
+		if e, ok := cache.entries[key]; ok {
+			cache.mu.Unlock()
+			<-e.done
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+			return
+		}
+		e := &cachedResponse{done: make(chan struct{})}
+		cache.entries[key] = e
+		cache.mu.Unlock()
+
+		rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			cache.mu.Lock()
+			e.status = rec.status
+			e.header = w.Header().Clone()
+			e.body = rec.body.Bytes()
+			e.expires = time.Now().Add(s.cfg.IdempotencyTTL)
+			if e.status >= 500 && cache.entries[key] == e {
+				delete(cache.entries, key)
+			}
+			cache.mu.Unlock()
+			close(e.done)
+		}()
+		h(rec, r)
+	}
+}
+
+// isExpired reports whether e has been recorded and is past its TTL. Entries
+// still being recorded never expire.
+func isExpired(e *cachedResponse, now time.Time) bool {
+	select {
+	case <-e.done:
+		return now.After(e.expires)
+	default:
+		return false
+	}
+}
+
+// recordingResponseWriter passes a response through while keeping a copy.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recordingResponseWriter) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recordingResponseWriter) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	type request struct {
+		key, token string
+		// status is what the handler responds with, if it's run.
+		status int
+	}
+	tests := []struct {
+		name     string
+		requests []request
+		// wantRuns is how many times the handler runs.
+		wantRuns int
+	}{
+		{
+			name:     "replayed",
+			requests: []request{{key: "a", status: 200}, {key: "a", status: 200}},
+			wantRuns: 1,
+		},
+		{
+			name:     "no key",
+			requests: []request{{status: 200}, {status: 200}},
+			wantRuns: 2,
+		},
+		{
+			name:     "different keys",
+			requests: []request{{key: "a", status: 200}, {key: "b", status: 200}},
+			wantRuns: 2,
+		},
+		{
+			name:     "client errors are replayed",
+			requests: []request{{key: "a", status: 409}, {key: "a", status: 200}},
+			wantRuns: 1,
+		},
+		{
+			name:     "server errors are retried",
+			requests: []request{{key: "a", status: 500}, {key: "a", status: 503}, {key: "a", status: 200}, {key: "a", status: 200}},
+			wantRuns: 3,
+		},
+		{
+			name:     "scoped to the caller",
+			requests: []request{{key: "a", status: 200}, {key: "a", token: "op", status: 200}, {key: "a", token: "op", status: 200}},
+			wantRuns: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				cfg:        Config{OperatorToken: "op", IdempotencyTTL: time.Hour},
+				idempotent: idempotencyCache{entries: make(map[string]*cachedResponse)},
+			}
+			runs := 0
+			var status int
+			h := s.withIdempotency(func(w http.ResponseWriter, r *http.Request) {
+				runs++
+				w.WriteHeader(status)
+				fmt.Fprintf(w, "run %v", runs)
+			})
+			// first is the first response for each key and token.
+			first := make(map[request]string)
+			for i, req := range tt.requests {
+				status = req.status
+				r := httptest.NewRequest("POST", "/fetch", nil)
+				if req.key != "" {
+					r.Header.Set("Idempotency-Key", req.key)
+				}
+				if req.token != "" {
+					r.Header.Set("Authorization", "Bearer "+req.token)
+				}
+				w := httptest.NewRecorder()
+				h(w, r)
+				scope := request{key: req.key, token: req.token}
+				if w.Header().Get("Idempotent-Replayed") != "true" {
+					first[scope] = w.Body.String()
+				} else if w.Body.String() != first[scope] {
+					t.Errorf("request %v replayed %q, want its caller's first response, %q", i, w.Body.String(), first[scope])
+				}
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("handler ran %v times, want %v", runs, tt.wantRuns)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"github.com/mhansen/nzwirelessmap-fetch/tracing"
+)
+
+// caller says who sent a request with headers h, from what's already
+// authenticated them: Cloud IAP, an OIDC token checked by Cloud Run, or the
+// operator token.
+func (s *Server) caller(h http.Header) *store.Caller {
+	if s.cfg.TrustIdentityHeaders {
+		if email := h.Get("X-Goog-Authenticated-User-Email"); email != "" {
+			return &store.Caller{
+				Via:     "iap",
+				Email:   strings.TrimPrefix(email, "accounts.google.com:"),
+				Subject: strings.TrimPrefix(h.Get("X-Goog-Authenticated-User-Id"), "accounts.google.com:"),
+			}
+		}
+	}
+	bearer, _ := strings.CutPrefix(h.Get("Authorization"), "Bearer ")
+	if s.cfg.OperatorToken != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(s.cfg.OperatorToken)) == 1 {
+		return &store.Caller{Via: "operator_token"}
+	}
+	if s.cfg.TrustIdentityHeaders {
+		// Cloud Run takes the token from X-Serverless-Authorization if it's
+		// there, leaving Authorization for the app.
+		for _, tok := range []string{strings.TrimPrefix(h.Get("X-Serverless-Authorization"), "Bearer "), bearer} {
+			if c := oidcCaller(tok); c != nil {
+				return c
+			}
+		}
+	}
+	return &store.Caller{Via: "anonymous"}
+}
+
+// oidcCaller reads the caller from the claims of an OIDC ID token, without
+// checking its signature. It returns nil if tok isn't a JWT.
+func oidcCaller(tok string) *store.Caller {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	var claims struct {
+		Email   string `json:"email"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil || (claims.Email == "" && claims.Subject == "") {
+		return nil
+	}
+	return &store.Caller{Via: "oidc", Email: claims.Email, Subject: claims.Subject}
+}
+
+// audit records that c triggered run runID with action, in the log and the
+// bucket. Failing to write to the bucket is logged, but doesn't stop the
+// run.
+func (s *Server) audit(ctx context.Context, action, runID string, c *store.Caller) {
+	log.Printf("audit: %v run %v triggered by %v via %v", action, runID, who(c), c.Via)
+	e := &store.AuditEntry{
+		Time:   time.Now().UTC(),
+		Action: action,
+		RunID:  runID,
+		Caller: c,
+	}
+	if sc, ok := tracing.FromContext(ctx); ok {
+		e.TraceID = sc.TraceIDString()
+	}
+	bkt, err := s.bucket(ctx)
+	if err == nil {
+		err = store.WriteAudit(ctx, bkt, e)
+	}
+	if err != nil {
+		log.Printf("audit: couldn't record %v run %v: %v", action, runID, err)
+	}
+}
+
+// who names c for the log.
+func who(c *store.Caller) string {
+	switch {
+	case c.Email != "":
+		return c.Email
+	case c.Subject != "":
+		return c.Subject
+	default:
+		return "someone"
+	}
+}
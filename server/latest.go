@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+)
+
+// latest serves the newest snapshot in the format named by the format
+// parameter: "json", the default, or any published format.
+func (s *Server) latest(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType := "application/json"
+	if format != "json" {
+		found := false
+		for _, c := range s.formats {
+			if c.Name() == format {
+				contentType, found = c.ContentType(), true
+			}
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, "", &stageError{Code: codeNotFound, Err: fmt.Errorf("format %q isn't published", format)})
+			return
+		}
+	}
+
+	bkt, err := s.bucket(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	o := bkt.Object("prism." + format + "/latest")
+	or, err := o.NewReader(r.Context())
+	if err == storage.ErrObjectNotExist {
+		writeError(w, http.StatusNotFound, "", &stageError{Code: codeNotFound, Err: fmt.Errorf("no snapshot has been published as %v yet", format)})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(fmt.Errorf("couldn't open %v: %v", o.ObjectName(), err)))
+		return
+	}
+	defer or.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Last-Modified", or.Attrs.LastModified.UTC().Format(http.TimeFormat))
+	if _, err := io.Copy(w, or); err != nil {
+		log.Printf("couldn't serve %v: %v", o.ObjectName(), err)
+	}
+}
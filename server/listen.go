@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sdListenFDsStart is the first file descriptor systemd passes to activated
+// services.
+const sdListenFDsStart = 3
+
+// Listen opens a listener for addr, which is one of:
+//
+//   - "host:port", to listen on TCP
+//   - "unix:///path/to/socket", to listen on a Unix socket
+//   - "systemd", to use the socket passed by systemd socket activation
+//   - "", to listen on TCP port $PORT, or 8080 if PORT is unset
+func Listen(addr string) (net.Listener, error) {
+	if addr == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		addr = ":" + port
+	}
+	switch {
+	case addr == "systemd":
+		return systemdListener()
+	case strings.HasPrefix(addr, "unix://"):
+		path := strings.TrimPrefix(addr, "unix://")
+		// A socket left over from a previous run would make Listen fail.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("couldn't remove stale socket %v: %v", path, err)
+		}
+		return net.Listen("unix", path)
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+// systemdListener returns the first socket passed by systemd, as described in
+// sd_listen_fds(3).
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd: LISTEN_PID isn't this process")
+	}
+	if n, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets passed by systemd: LISTEN_FDS=%q", os.Getenv("LISTEN_FDS"))
+	}
+	f := os.NewFile(sdListenFDsStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// maintenance returns the maintenance in effect, or nil if fetches may run.
+// cfg.Maintenance pauses this replica whatever the bucket says. If the
+// bucket can't be read, fetches aren't paused: they'll fail on the bucket
+// anyway, with a more useful error.
+func (s *Server) maintenance(ctx context.Context) *store.Maintenance {
+	if s.cfg.Maintenance != "" {
+		return &store.Maintenance{Reason: s.cfg.Maintenance, Since: s.started}
+	}
+	bkt, err := s.bucket(ctx)
+	if err != nil {
+		log.Printf("couldn't check for maintenance: %v", err)
+		return nil
+	}
+	m, err := store.ReadMaintenance(ctx, bkt)
+	if err != nil {
+		log.Printf("couldn't check for maintenance: %v", err)
+		return nil
+	}
+	return m
+}
+
+// maintenanceErr is the error for a fetch refused during m.
+func maintenanceErr(m *store.Maintenance) error {
+	msg := fmt.Sprintf("fetches are paused for maintenance: %v", m.Reason)
+	if m.Until != nil {
+		msg += fmt.Sprintf(" (until %v)", m.Until.Format(time.RFC3339))
+	}
+	return &stageError{Code: codeMaintenance, Err: errors.New(msg)}
+}
+
+// withMaintenance wraps a handler that starts fetches so that it's refused
+// with a 503 during maintenance.
+func (s *Server) withMaintenance(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := s.maintenance(r.Context())
+		if m == nil {
+			h(w, r)
+			return
+		}
+		if m.Until != nil {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(time.Until(*m.Until).Seconds()))))
+		}
+		writeError(w, http.StatusServiceUnavailable, "", maintenanceErr(m))
+	}
+}
+
+// maintenanceRequest is the body of PUT /admin/maintenance.
+type maintenanceRequest struct {
+	Reason string `json:"reason"`
+	// Duration, if set, is how long until fetches resume by themselves, as
+	// a Go duration like "2h".
+	Duration string `json:"duration,omitempty"`
+}
+
+// maintenanceStatus is the response of the /admin/maintenance endpoints.
+type maintenanceStatus struct {
+	Paused      bool               `json:"paused"`
+	Maintenance *store.Maintenance `json:"maintenance,omitempty"`
+}
+
+func (s *Server) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	m := s.maintenance(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(maintenanceStatus{Paused: m != nil, Maintenance: m}); err != nil {
+		log.Printf("couldn't write maintenance: %v", err)
+	}
+}
+
+func (s *Server) startMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+		writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: errors.New(`want a JSON body like {"reason": "...", "duration": "2h"}`)})
+		return
+	}
+	m := &store.Maintenance{Reason: req.Reason, Since: time.Now().UTC(), By: s.caller(r.Header)}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: fmt.Errorf("bad duration %q: want a positive Go duration like 2h", req.Duration)})
+			return
+		}
+		until := m.Since.Add(d)
+		m.Until = &until
+	}
+	bkt, err := s.bucket(r.Context())
+	if err == nil {
+		err = store.WriteMaintenance(r.Context(), bkt, m)
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	log.Printf("maintenance: fetches paused by %v: %v", who(m.By), m.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(maintenanceStatus{Paused: true, Maintenance: m}); err != nil {
+		log.Printf("couldn't write maintenance: %v", err)
+	}
+}
+
+func (s *Server) endMaintenance(w http.ResponseWriter, r *http.Request) {
+	bkt, err := s.bucket(r.Context())
+	if err == nil {
+		err = store.EndMaintenance(r.Context(), bkt)
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	log.Printf("maintenance: fetches resumed by %v", who(s.caller(r.Header)))
+	if s.cfg.Maintenance != "" {
+		log.Printf("maintenance: this replica stays paused by -maintenance")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
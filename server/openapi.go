@@ -0,0 +1,198 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// openAPIDoc is an OpenAPI 3 document, with only the parts this server
+// needs.
+type openAPIDoc struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    map[string]string                      `json:"info"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+	// Components holds the security schemes.
+	Components map[string]interface{} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Summary     string                     `json:"summary,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string                 `json:"name"`
+	In       string                 `json:"in"`
+	Required bool                   `json:"required"`
+	Schema   map[string]interface{} `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Content map[string]map[string]interface{} `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                            `json:"description"`
+	Content     map[string]map[string]interface{} `json:"content,omitempty"`
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPISpec describes the routes as an OpenAPI document.
+func openAPISpec(routes []route) *openAPIDoc {
+	doc := &openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info: map[string]string{
+			"title":   "nzwirelessmap-fetch",
+			"version": "1",
+			"description": "Fetches RSM's PRISM database of radio licences and publishes the point-to-point links " +
+				"for the NZ wireless map.",
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+		Components: map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"apiKey":   map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"operator": map[string]string{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	for _, rt := range routes {
+		if rt.hidden {
+			continue
+		}
+		op := openAPIOperation{Summary: rt.summary, Responses: make(map[string]openAPIResponse)}
+		for _, m := range pathParam.FindAllStringSubmatch(rt.pattern, -1) {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name: m[1], In: "path", Required: true, Schema: map[string]interface{}{"type": "string"},
+			})
+		}
+		if rt.request != nil {
+			op.RequestBody = &openAPIRequestBody{Content: map[string]map[string]interface{}{
+				"application/json": {"schema": jsonSchema(reflect.TypeOf(rt.request))},
+			}}
+		}
+		ok := openAPIResponse{Description: "OK"}
+		if rt.response != nil {
+			ok.Content = map[string]map[string]interface{}{
+				"application/json": {"schema": jsonSchema(reflect.TypeOf(rt.response))},
+			}
+		} else {
+			ok.Content = map[string]map[string]interface{}{
+				"text/plain": {"schema": map[string]interface{}{"type": "string"}},
+			}
+		}
+		op.Responses["200"] = ok
+		if rt.errors {
+			op.Responses["default"] = openAPIResponse{
+				Description: "Error",
+				Content: map[string]map[string]interface{}{
+					"application/json": {"schema": jsonSchema(reflect.TypeOf(errorResponse{}))},
+				},
+			}
+		}
+		switch rt.access {
+		case accessQuery:
+			op.Security = []map[string][]string{{"apiKey": {}}}
+		case accessOperator:
+			op.Security = []map[string][]string{{"operator": {}}}
+		}
+		// Routes may share a pattern with different methods.
+		ops := doc.Paths[rt.pattern]
+		if ops == nil {
+			ops = make(map[string]openAPIOperation)
+		}
+		for _, m := range rt.methods {
+			ops[strings.ToLower(m)] = op
+		}
+		doc.Paths[rt.pattern] = ops
+	}
+	return doc
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema describes how encoding/json encodes values of type t.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		var required []string
+		addStructFields(t, props, &required)
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// Interfaces could be anything.
+	return map[string]interface{}{}
+}
+
+// addStructFields adds the JSON fields of struct type t to props, flattening
+// embedded structs as encoding/json does.
+func addStructFields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = jsonSchema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(openAPISpec(s.routes())); err != nil {
+		log.Printf("couldn't write OpenAPI document: %v", err)
+	}
+}
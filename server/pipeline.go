@@ -0,0 +1,945 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/alert"
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/mdb"
+	"github.com/mhansen/nzwirelessmap-fetch/pipeline"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// stageMetrics counts runs, errors and time spent in each pipeline stage.
+var stageMetrics = expvar.NewMap("pipeline_stages")
+
+// churnMetrics holds the licence churn of the most recent snapshot.
+var churnMetrics = expvar.NewMap("churn")
+
+// runSummary describes what a /fetch run did.
+type runSummary struct {
+	RunID      string `json:"run_id"`
+	Snapshot   string `json:"snapshot,omitempty"`
+	Skipped    bool   `json:"skipped"`
+	SkipReason string `json:"skip_reason,omitempty"`
+	// TraceID is the distributed trace the run's upstream requests belong
+	// to.
+	TraceID string `json:"trace_id,omitempty"`
+	// TriggeredBy is who asked for the run.
+	TriggeredBy *store.Caller `json:"triggered_by,omitempty"`
+	// BackoffUntil is when triggers will next try upstream, if this one
+	// was skipped because upstream recently failed.
+	BackoffUntil    string         `json:"backoff_until,omitempty"`
+	BytesDownloaded int64          `json:"bytes_downloaded"`
+	Rows            int            `json:"rows"`
+	Churn           *convert.Churn `json:"churn,omitempty"`
+	Artifacts       []string       `json:"artifacts,omitempty"`
+	Duration        string         `json:"duration"`
+}
+
+// runStats is stored at runs/{{timestamp}}/stats.json.
+type runStats struct {
+	Timestamp string `json:"timestamp"`
+	Rows      int    `json:"rows"`
+	// Previous is the snapshot Churn is relative to, if there is one.
+	Previous string         `json:"previous,omitempty"`
+	Churn    *convert.Churn `json:"churn,omitempty"`
+}
+
+// run is the state shared by the stages of one pipeline run.
+type run struct {
+	bkt     *storage.BucketHandle
+	tSuffix string
+	// followUp is set for steps that continue a run, which has already
+	// checked upstream, so their requests don't count against
+	// cfg.MinFetchInterval.
+	followUp bool
+	// publishLatest is whether to overwrite prism.json/latest, and the
+	// latest of each other format.
+	publishLatest bool
+	sum           *runSummary
+
+	resp *fetch.Response
+	idx  *store.Index
+	// zip is the upstream export, which is deleted at cleanup if zipTemp
+	// is set. See setZip.
+	zip     *os.File
+	zipSize int64
+	zipTemp bool
+	zipHash string
+	mdb     *os.File
+	sqlite  *os.File
+	db      *mdb.DB
+	qHash   string
+	rows    *convert.Rows
+	// json is prism.json, written from rows. jsonFile holds it if it had
+	// to be converted by another program.
+	json     store.Source
+	jsonFile *os.File
+	// backfill is set when reprocessing history rather than publishing a
+	// new snapshot.
+	backfill bool
+	// prev is the snapshot before this one, if there is one. See previous.
+	prev     *snapshotRows
+	prevRead bool
+}
+
+// cleanup releases the run's connections and temporary files.
+func (r *run) cleanup() {
+	if r.resp != nil {
+		r.resp.Body.Close()
+	}
+	for _, f := range []*os.File{r.mdb, r.sqlite, r.jsonFile} {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if r.zip != nil {
+		r.zip.Close()
+		if r.zipTemp {
+			os.Remove(r.zip.Name())
+		}
+	}
+}
+
+// setZip makes f the run's zip. If temp is set, f is deleted at cleanup.
+func (r *run) setZip(f *os.File, temp bool) error {
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		if temp {
+			os.Remove(f.Name())
+		}
+		return fmt.Errorf("couldn't stat zip: %v", err)
+	}
+	r.zip, r.zipSize, r.zipTemp = f, fi.Size(), temp
+	return nil
+}
+
+// hashZip sets r.zipHash to the SHA-256 of r.zip.
+func (r *run) hashZip() error {
+	h := sha256.New()
+	if err := store.File(r.zip)(h); err != nil {
+		return fmt.Errorf("couldn't hash zip: %v", err)
+	}
+	r.zipHash = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// spool copies src to a temporary file named like pattern, which the caller
+// must close and delete.
+func spool(pattern string, src io.Reader) (*os.File, int64, error) {
+	f, err := convert.TempFile(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(f, src)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, n, err
+	}
+	return f, n, nil
+}
+
+// previous returns the rows of the snapshot before this one, reading them
+// the first time they're asked for. It returns nil for the first snapshot.
+func (r *run) previous(ctx context.Context) (*snapshotRows, error) {
+	if !r.prevRead {
+		prev, err := previousRows(ctx, r.bkt, r.tSuffix)
+		if err != nil {
+			return nil, err
+		}
+		r.prev, r.prevRead = prev, true
+	}
+	return r.prev, nil
+}
+
+// hooks are wrapped around every stage of every pipeline.
+func (s *Server) hooks() []pipeline.Hook {
+	return []pipeline.Hook{
+		pipeline.Logging(),
+		pipeline.Metrics(s.metrics.stages),
+		stageErrors,
+		// Uploads are safe to retry: they're re-read from rows or temporary
+		// files each time.
+		// Streamed events have insert IDs, so BigQuery drops duplicates.
+		pipeline.Only(pipeline.Retry(3, 2*time.Second, isStorageErr),
+			"archive_zip", "publish_csv", "publish_json", "publish_formats", "publish_patch", "manifest", "timeseries", "stats", "publish_diff", "events", "licence_index", "index", "schema"),
+	}
+}
+
+// fetchPipeline downloads the upstream zip and, if it's new, converts and
+// publishes it.
+func (s *Server) fetchPipeline(r *run) *pipeline.Pipeline {
+	p := pipeline.New(s.hooks()...)
+	p.Use(func(name string, next pipeline.Func) pipeline.Func {
+		return func(ctx context.Context) error {
+			s.job.setStage(name)
+			return next(ctx)
+		}
+	})
+	p.Add("connect", func(ctx context.Context) error {
+		bkt, err := s.bucket(ctx)
+		if err != nil {
+			return storageErr(err)
+		}
+		r.bkt = bkt
+		return nil
+	})
+	p.Add("cooldown", func(ctx context.Context) error {
+		return s.checkCooldown(ctx, r)
+	})
+	p.Add("request", func(ctx context.Context) error {
+		resp, err := fetch.Get(ctx, s.cfg.PrismZipURL)
+		if err != nil {
+			return upstreamErr(err)
+		}
+		r.resp = resp
+		r.tSuffix = resp.LastModified.Format(time.RFC3339)
+		r.sum.Snapshot = r.tSuffix
+		return nil
+	})
+	p.Add("check", func(ctx context.Context) error {
+		// The index entry is written last, so if this snapshot is indexed
+		// we've already done all the work. prism.json/{{timestamp}} isn't
+		// enough: it's published before the index is updated, so a run
+		// that failed in between would never be retried. This depends on
+		// the Last-Modified-Time in RSM's web server working, but it
+		// should work.
+		idx, _, err := store.ReadIndex(ctx, r.bkt)
+		if err != nil {
+			return storageErr(err)
+		}
+		if e := idx.Find(r.tSuffix); e != nil {
+			if e.AliasOf != "" {
+				// Timestamps that were aliases of an earlier identical zip
+				// don't get their own prism.json, but the index remembers
+				// them.
+				log.Printf("exiting early: %v is already indexed as an alias of %v", r.tSuffix, e.AliasOf)
+			} else {
+				log.Printf("exiting early: we have already indexed %v, no need to redo", r.tSuffix)
+			}
+			r.sum.Skipped = true
+			r.sum.SkipReason = "already_processed"
+			return pipeline.ErrStop
+		}
+		r.idx = idx
+		log.Printf("%v is not indexed yet: fetching...", r.tSuffix)
+		return nil
+	})
+	p.Add("archive_upstream", func(ctx context.Context) error {
+		if err := store.WriteUpstream(ctx, r.bkt, r.tSuffix, r.resp.Upstream); err != nil {
+			return storageErr(err)
+		}
+		return nil
+	})
+	p.Add("download", func(ctx context.Context) error {
+		etag := r.resp.Header.Get("ETag")
+		if s.cfg.Cache != nil {
+			if zip, ok := s.cfg.Cache.Get(r.tSuffix, etag); ok {
+				return r.setZip(zip, false)
+			}
+		}
+		// Read in the response body: now that we've confirmed this is new data, we should load it in.
+		body := fetch.NewProgressReader(fetch.Throttle(ctx, r.resp.Body, s.cfg.DownloadRateLimit), r.resp.ContentLength)
+		s.job.setDownload(body)
+		defer s.job.setDownload(nil)
+		zip, n, err := spool("prism.zip", body)
+		if err != nil {
+			return upstreamErr(err)
+		}
+		log.Printf("fetched %v bytes\n", n)
+		r.sum.BytesDownloaded = n
+		if err := r.setZip(zip, true); err != nil {
+			return conversionErr(err)
+		}
+		if s.cfg.Cache != nil {
+			// A run that can't cache still has what it needs.
+			if err := s.cfg.Cache.Put(r.tSuffix, etag, r.zip); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+		return nil
+	})
+	p.Add("dedupe", func(ctx context.Context) error {
+		// RSM sometimes re-posts an identical file with a new Last-Modified. If
+		// so, there's nothing new to convert: just remember the new timestamp.
+		if err := r.hashZip(); err != nil {
+			return conversionErr(err)
+		}
+		prev := r.idx.Latest()
+		if prev == nil || prev.SHA256 != r.zipHash {
+			return nil
+		}
+		aliasOf := prev.Timestamp
+		if prev.AliasOf != "" {
+			aliasOf = prev.AliasOf
+		}
+		log.Printf("%v is identical to %v: recording an alias and skipping conversion", r.tSuffix, aliasOf)
+		if err := store.UpdateIndex(ctx, r.bkt, func(idx *store.Index) {
+			idx.Snapshots = append(idx.Snapshots, store.IndexEntry{Timestamp: r.tSuffix, SHA256: r.zipHash, AliasOf: aliasOf})
+		}); err != nil {
+			return storageErr(err)
+		}
+		r.sum.Skipped = true
+		r.sum.SkipReason = "identical_content"
+		return pipeline.ErrStop
+	})
+	p.Add("archive_zip", func(ctx context.Context) error {
+		switch s.cfg.ArchiveZip {
+		case ArchiveZipNever:
+			log.Printf("not archiving the zip of %v", r.tSuffix)
+			return nil
+		case ArchiveZipNew:
+			if e := r.idx.FindContent(r.zipHash); e != nil {
+				log.Printf("not archiving the zip of %v: it's identical to %v's", r.tSuffix, e.Timestamp)
+				return nil
+			}
+		}
+		// Save the export to a timestamped file on GCS, named for how it's
+		// packaged. One that can't be identified is kept as a zip: see
+		// convert.DetectArchive.
+		format, err := convert.DetectExport(r.zip, r.zipSize)
+		if err != nil {
+			format = convert.FormatZip
+		}
+		blobZIP, err := store.WriteArchive(ctx, r.bkt, store.ExportName(string(format), r.tSuffix), store.File(r.zip), "NEARLINE", format.ContentType())
+		if err != nil {
+			return storageErr(err)
+		}
+		r.sum.Artifacts = append(r.sum.Artifacts, store.URI(blobZIP))
+		return nil
+	})
+	p.Append(s.conversionPipeline(r))
+	// The index entry marks the snapshot as done, so this is the last stage:
+	// see check.
+	p.Add("index", func(ctx context.Context) error {
+		if err := store.UpdateIndex(ctx, r.bkt, func(idx *store.Index) {
+			// A retried workflow step may have indexed this already.
+			if idx.Find(r.tSuffix) != nil {
+				return
+			}
+			idx.Snapshots = append(idx.Snapshots, store.IndexEntry{Timestamp: r.tSuffix, SHA256: r.zipHash, Rows: r.sum.Rows})
+		}); err != nil {
+			return storageErr(err)
+		}
+		return nil
+	})
+	return p
+}
+
+// conversionPipeline derives prism.csv/{{timestamp}} and
+// prism.json/{{timestamp}} from r.zip, and records the run in
+// runs/{{timestamp}}/manifest.json. If r.publishLatest is set,
+// prism.json/latest is also overwritten.
+//
+// Everything is written before prism.json/{{timestamp}}, which readers take
+// to mean the snapshot is complete, except the manifest, which is written
+// last. A snapshot whose manifest doesn't match the query is reprocessed,
+// and a fetch isn't done until the snapshot is indexed, so if any stage
+// fails the whole pipeline is run again.
+func (s *Server) conversionPipeline(r *run) *pipeline.Pipeline {
+	p := pipeline.New(s.hooks()...)
+	p.Add("unzip", func(ctx context.Context) error {
+		mdb, err := convert.ExtractMDB(r.zip, r.zipSize)
+		if errors.Is(err, convert.ErrCorruptArchive) {
+			mdb, err = s.salvage(ctx, r, err)
+		}
+		if err != nil {
+			return conversionErr(err)
+		}
+		r.mdb = mdb
+		return nil
+	})
+	if s.legacyConvert() {
+		p.Add("mdb_to_sqlite", func(ctx context.Context) error {
+			// Make an output tmpfile for the sqlite3 database. stdout isn't enough.
+			tmpSqlite, err := convert.TempFile("prism.sqlite3")
+			if err != nil {
+				return conversionErr(err)
+			}
+			r.sqlite = tmpSqlite
+			if err := convert.MDBToSqlite(r.mdb, r.sqlite); err != nil {
+				return conversionErr(err)
+			}
+			return nil
+		})
+	} else {
+		p.Add("read_mdb", func(ctx context.Context) error {
+			db, err := mdb.Open(r.mdb)
+			if err != nil {
+				return conversionErr(fmt.Errorf("couldn't open prism.mdb: %v", err))
+			}
+			r.db = db
+			return nil
+		})
+	}
+	p.Add("schema", func(ctx context.Context) error {
+		return s.checkSchema(ctx, r)
+	})
+	p.Add("query", func(ctx context.Context) error {
+		qHash, err := s.queryHash()
+		if err != nil {
+			return conversionErr(err)
+		}
+		r.qHash = qHash
+		if err := s.query(r); err != nil {
+			return conversionErr(err)
+		}
+		log.Printf("extracted %v rows\n", len(r.rows.Records))
+		r.sum.Rows = len(r.rows.Records)
+		return nil
+	})
+	p.Add("grid_refs", func(ctx context.Context) error {
+		if err := convert.AddGridRefs(r.rows); err != nil {
+			return conversionErr(err)
+		}
+		return nil
+	})
+	if s.cfg.DEM != nil {
+		p.Add("elevation", func(ctx context.Context) error {
+			if err := convert.AddElevations(ctx, s.cfg.DEM, r.rows); err != nil {
+				return upstreamErr(err)
+			}
+			return nil
+		})
+	}
+	if s.cfg.SortRows {
+		p.Add("sort", func(ctx context.Context) error {
+			// Everything else is written from the rows, so it all comes out
+			// in the new order.
+			convert.SortRows(r.rows)
+			return nil
+		})
+	}
+	p.Add("publish_csv", func(ctx context.Context) error {
+		blobCSV, err := store.WriteArchive(ctx, r.bkt, "prism.csv/"+r.tSuffix, r.rows.WriteCSV, "NEARLINE", "text/csv")
+		if err != nil {
+			return storageErr(err)
+		}
+		r.sum.Artifacts = append(r.sum.Artifacts, store.URI(blobCSV))
+		return nil
+	})
+	p.Add("csv_to_json", func(ctx context.Context) error {
+		if err := s.toJSON(r); err != nil {
+			return conversionErr(err)
+		}
+		return nil
+	})
+	// Other formats go before the timestamped JSON, since that marks the
+	// snapshot as complete.
+	p.Add("publish_formats", func(ctx context.Context) error {
+		for _, c := range s.formats {
+			if err := publishFormat(ctx, r, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if s.cfg.JSONPatch {
+		p.Add("publish_patch", func(ctx context.Context) error {
+			return publishPatch(ctx, r)
+		})
+	}
+	p.Add("timeseries", func(ctx context.Context) error {
+		links, err := r.rows.Links()
+		if err != nil {
+			return conversionErr(err)
+		}
+		pt := store.TimeseriesPoint{
+			Timestamp: r.tSuffix,
+			Links:     len(links),
+			Bands:     convert.CountByBand(links),
+		}
+		if err := store.AddTimeseriesPoint(ctx, r.bkt, pt); err != nil {
+			return storageErr(err)
+		}
+		return nil
+	})
+	p.Add("stats", func(ctx context.Context) error {
+		stats := &runStats{Timestamp: r.tSuffix, Rows: r.sum.Rows}
+		prev, err := r.previous(ctx)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			churn := convert.CompareLicences(prev.rows, r.rows)
+			log.Printf("since %v: %v licences added, %v removed, %v modified", prev.tSuffix, churn.Added, churn.Removed, churn.Modified)
+			stats.Previous = prev.tSuffix
+			stats.Churn = &churn
+			r.sum.Churn = &churn
+			if r.publishLatest {
+				for k, v := range map[string]int{"added": churn.Added, "removed": churn.Removed, "modified": churn.Modified} {
+					n := new(expvar.Int)
+					n.Set(int64(v))
+					s.metrics.churn.Set(k, n)
+				}
+			}
+		}
+		if err := store.WriteStats(ctx, r.bkt, r.tSuffix, stats); err != nil {
+			return storageErr(err)
+		}
+		return nil
+	})
+	p.Add("publish_diff", func(ctx context.Context) error {
+		return publishDiff(ctx, r)
+	})
+	// Events are streamed only for new snapshots: reprocessing history
+	// would insert every snapshot's events again.
+	if s.cfg.Events != nil && !r.backfill {
+		p.Add("events", func(ctx context.Context) error {
+			prev, err := r.previous(ctx)
+			if err != nil || prev == nil {
+				return err
+			}
+			events := convert.LicenceEvents(prev.rows, r.rows)
+			if err := s.cfg.Events.Insert(ctx, r.tSuffix, prev.tSuffix, events); err != nil {
+				return storageErr(err)
+			}
+			return nil
+		})
+	}
+	p.Add("publish_json", func(ctx context.Context) error {
+		if r.publishLatest {
+			blobJSONLatest := r.bkt.Object("prism.json/latest")
+			if err := store.WriteObject(ctx, r.bkt, blobJSONLatest, r.json, "STANDARD", ""); err != nil {
+				return storageErr(err)
+			}
+			r.sum.Artifacts = append(r.sum.Artifacts, store.URI(blobJSONLatest))
+		}
+		// Finally save to a timestamped JSON file. This is a history, and
+		// readers take it to mean the snapshot is complete.
+		blobJSON := r.bkt.Object("prism.json/" + r.tSuffix)
+		if err := store.WriteArtifact(ctx, r.bkt, blobJSON, r.json, "NEARLINE", ""); err != nil {
+			return storageErr(err)
+		}
+		r.sum.Artifacts = append(r.sum.Artifacts, store.URI(blobJSON))
+		return nil
+	})
+	// Reprocessing rebuilds the licence index instead: snapshots are
+	// reprocessed in parallel, not in order.
+	if !r.backfill {
+		p.Add("licence_index", func(ctx context.Context) error {
+			return updateLicenceIndex(ctx, r)
+		})
+	}
+	p.Add("manifest", func(ctx context.Context) error {
+		// Record which query produced these files, so a changed query can be
+		// detected and the snapshot reprocessed later. This must be the last
+		// stage, so that /reprocess retries snapshots it didn't finish.
+		m := &store.Manifest{
+			Timestamp:   r.tSuffix,
+			QueryHash:   r.qHash,
+			Rows:        r.sum.Rows,
+			Processed:   time.Now().UTC(),
+			TriggeredBy: r.sum.TriggeredBy,
+		}
+		if err := store.WriteManifest(ctx, r.bkt, m); err != nil {
+			return storageErr(err)
+		}
+		return nil
+	})
+	return p
+}
+
+// legacyConvert is whether to convert with the legacy pipeline. See
+// Config.NativeConvert.
+func (s *Server) legacyConvert() bool {
+	return !s.cfg.NativeConvert || s.cfg.QueryFile != convert.QueryFile
+}
+
+// queryHash identifies how rows are extracted, for manifests.
+func (s *Server) queryHash() (string, error) {
+	if s.legacyConvert() {
+		return convert.QueryHash(s.cfg.QueryFile)
+	}
+	return convert.NativeQueryHash(), nil
+}
+
+// query extracts r.rows from the converted database.
+func (s *Server) query(r *run) error {
+	if s.legacyConvert() {
+		csv := store.Open(func(w io.Writer) error {
+			return convert.QuerySqliteToCSV(r.sqlite, s.cfg.QueryFile, w)
+		})
+		defer csv.Close()
+		rows, err := convert.ParseCSV(csv)
+		if err != nil {
+			return err
+		}
+		r.rows = rows
+		return nil
+	}
+	tables, err := convert.ReadTables(r.db)
+	if err != nil {
+		return err
+	}
+	return r.queryTables(tables)
+}
+
+// queryTables extracts r.rows from tables.
+func (r *run) queryTables(tables convert.Tables) error {
+	rows, err := convert.QueryLinks(tables)
+	if err != nil {
+		return err
+	}
+	r.rows = rows
+	return nil
+}
+
+// toJSON sets r.json to r.rows as JSON. The legacy conversion runs
+// csv2json2.py once, into r.jsonFile; otherwise the JSON is written from the
+// rows whenever it's read.
+func (s *Server) toJSON(r *run) error {
+	if !s.legacyConvert() {
+		rows := r.rows
+		r.json = func(w io.Writer) error { return convert.WriteJSON(rows, w) }
+		return nil
+	}
+	f, err := convert.TempFile("prism.json")
+	if err != nil {
+		return err
+	}
+	r.jsonFile = f
+	csv := store.Open(r.rows.WriteCSV)
+	defer csv.Close()
+	if err := convert.CSVToJSON(csv, f); err != nil {
+		return err
+	}
+	r.json = store.File(f)
+	return nil
+}
+
+// checkSchema compares the converted database's schema with schema.json,
+// and alerts if it has drifted. Drift doesn't stop the run: the zip is
+// already archived, and the query may still work. If there's no
+// schema.json, the current schema becomes the expected one; delete it to
+// accept a new schema.
+func (s *Server) checkSchema(ctx context.Context, r *run) error {
+	var actual convert.Schema
+	var err error
+	if s.legacyConvert() {
+		actual, err = convert.ReadSchema(r.sqlite)
+	} else {
+		actual, err = convert.ReadMDBSchema(r.db)
+	}
+	if err != nil {
+		return conversionErr(err)
+	}
+	var expected convert.Schema
+	ok, err := store.ReadSchema(ctx, r.bkt, &expected)
+	if err != nil {
+		return storageErr(err)
+	}
+	if !ok {
+		log.Printf("no expected schema: recording this snapshot's %v tables", len(actual))
+		if err := store.WriteSchema(ctx, r.bkt, actual); err != nil {
+			return storageErr(err)
+		}
+		return nil
+	}
+	changes := convert.DiffSchema(expected, actual)
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := store.WriteSchemaDrift(ctx, r.bkt, r.tSuffix, changes); err != nil {
+		return storageErr(err)
+	}
+	msgs := make([]string, len(changes))
+	for i, c := range changes {
+		msgs[i] = c.String()
+	}
+	a := alert.Alert{
+		Name:    "schema_drift",
+		Firing:  true,
+		Message: fmt.Sprintf("upstream schema of %v has changed: %v", r.tSuffix, strings.Join(msgs, "; ")),
+		Details: map[string]interface{}{"snapshot": r.tSuffix, "changes": changes},
+		Time:    time.Now().UTC(),
+	}
+	if err := s.alerts.Notify(ctx, a); err != nil {
+		log.Printf("couldn't alert: %v", err)
+	}
+	return nil
+}
+
+// updateLicenceIndex adds the licences changed since the previous snapshot
+// to the licence index, if it's built up to that snapshot. Otherwise, the
+// next history request builds it, or reads the snapshots it's missing.
+func updateLicenceIndex(ctx context.Context, r *run) error {
+	idx, err := store.ReadLicenceIndex(ctx, r.bkt)
+	if err != nil {
+		return storageErr(err)
+	}
+	prev, err := r.previous(ctx)
+	if err != nil {
+		return err
+	}
+	// The first snapshot starts the index.
+	var from string
+	var prevKeys map[string]string
+	if prev != nil {
+		from = prev.tSuffix
+		prevKeys = licenceKeys(rowMaps(prev.rows))
+	}
+	if (idx == nil && prev != nil) || (idx != nil && idx.Through != from) {
+		return nil
+	}
+	changed := changedLicences(prevKeys, licenceKeys(rowMaps(r.rows)))
+	err = store.UpdateLicenceIndex(ctx, r.bkt, func(idx *store.LicenceIndex) {
+		if idx.Through == from {
+			idx.Add(r.tSuffix, changed)
+		}
+	})
+	if err != nil {
+		return storageErr(err)
+	}
+	return nil
+}
+
+type snapshotRows struct {
+	tSuffix string
+	rows    *convert.Rows
+}
+
+// previousRows reads the CSV of the snapshot before tSuffix, or returns nil
+// if tSuffix is the first.
+func previousRows(ctx context.Context, bkt *storage.BucketHandle, tSuffix string) (*snapshotRows, error) {
+	snapshots, err := store.List(ctx, bkt, "prism.csv/")
+	if err != nil {
+		return nil, storageErr(err)
+	}
+	var prev string
+	for _, ts := range snapshots {
+		if ts < tSuffix {
+			prev = ts
+		}
+	}
+	if prev == "" {
+		return nil, nil
+	}
+	return readSnapshotRows(ctx, bkt, prev)
+}
+
+// readSnapshotRows reads the CSV of the snapshot at tSuffix.
+func readSnapshotRows(ctx context.Context, bkt *storage.BucketHandle, tSuffix string) (*snapshotRows, error) {
+	f, err := store.OpenArchive(ctx, bkt, "prism.csv/"+tSuffix)
+	if err != nil {
+		return nil, storageErr(err)
+	}
+	defer f.Close()
+	rows, err := convert.ParseCSV(f)
+	if err != nil {
+		return nil, conversionErr(fmt.Errorf("couldn't parse %v: %v", tSuffix, err))
+	}
+	return &snapshotRows{tSuffix: tSuffix, rows: rows}, nil
+}
+
+// publishPatch writes a JSON Patch from the previous snapshot's prism.json to
+// this one's at prism.json-patch/{{previous}}/{{timestamp}}, so mirrors can
+// find the patch to apply by listing under their own snapshot. If the
+// snapshots are too different, there's no patch, and mirrors should fetch
+// the whole snapshot.
+func publishPatch(ctx context.Context, r *run) error {
+	snapshots, err := store.List(ctx, r.bkt, "prism.json/")
+	if err != nil {
+		return storageErr(err)
+	}
+	var prev string
+	for _, ts := range snapshots {
+		if ts < r.tSuffix {
+			prev = ts
+		}
+	}
+	if prev == "" {
+		return nil
+	}
+	fromR, err := r.bkt.Object("prism.json/" + prev).NewReader(ctx)
+	if err != nil {
+		return storageErr(fmt.Errorf("couldn't open prism.json/%v: %v", prev, err))
+	}
+	defer fromR.Close()
+	var from, to []map[string]string
+	if err := json.NewDecoder(fromR).Decode(&from); err != nil {
+		return conversionErr(fmt.Errorf("couldn't parse prism.json/%v: %v", prev, err))
+	}
+	toR := store.Open(r.json)
+	defer toR.Close()
+	if err := json.NewDecoder(toR).Decode(&to); err != nil {
+		return conversionErr(fmt.Errorf("couldn't parse converted JSON: %v", err))
+	}
+	ops, err := convert.JSONPatch(from, to)
+	if errors.Is(err, convert.ErrTooDifferent) {
+		log.Printf("not publishing a patch from %v: %v", prev, err)
+		return nil
+	}
+	if err != nil {
+		return conversionErr(err)
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return conversionErr(err)
+	}
+	o := r.bkt.Object("prism.json-patch/" + prev + "/" + r.tSuffix)
+	if err := store.WriteArtifact(ctx, r.bkt, o, store.Bytes(patch), "STANDARD", "application/json-patch+json"); err != nil {
+		return storageErr(err)
+	}
+	log.Printf("patch from %v is %v operations, %v bytes", prev, len(ops), len(patch))
+	r.sum.Artifacts = append(r.sum.Artifacts, store.URI(o))
+	return nil
+}
+
+// publishFormat writes the output of c to prism.{format}/{timestamp} and,
+// if it's the newest snapshot, prism.{format}/latest.
+func publishFormat(ctx context.Context, r *run, c convert.Converter) error {
+	out, err := c.Convert(r.rows)
+	if err != nil {
+		return conversionErr(fmt.Errorf("couldn't convert to %v: %v", c.Name(), err))
+	}
+	// Converters' output can only be read once, but it's written twice,
+	// and again for each retry.
+	f, _, err := spool("prism."+c.Name(), out)
+	if err != nil {
+		return conversionErr(fmt.Errorf("couldn't convert to %v: %v", c.Name(), err))
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	prefix := "prism." + c.Name() + "/"
+	o := r.bkt.Object(prefix + r.tSuffix)
+	if err := store.WriteArtifact(ctx, r.bkt, o, store.File(f), "NEARLINE", c.ContentType()); err != nil {
+		return storageErr(err)
+	}
+	r.sum.Artifacts = append(r.sum.Artifacts, store.URI(o))
+	if r.publishLatest {
+		latest := r.bkt.Object(prefix + "latest")
+		if err := store.WriteObject(ctx, r.bkt, latest, store.File(f), "STANDARD", c.ContentType()); err != nil {
+			return storageErr(err)
+		}
+		r.sum.Artifacts = append(r.sum.Artifacts, store.URI(latest))
+	}
+	return nil
+}
+
+// stageErrors labels errors with the stage they came from.
+func stageErrors(name string, next pipeline.Func) pipeline.Func {
+	return func(ctx context.Context) error {
+		err := next(ctx)
+		if err == nil || errors.Is(err, pipeline.ErrStop) {
+			return err
+		}
+		var se *stageError
+		if !errors.As(err, &se) {
+			return &stageError{Code: codeInternal, Stage: name, Err: err}
+		}
+		if se.Stage == "" {
+			se.Stage = name
+		}
+		return err
+	}
+}
+
+func (s *Server) fetch(w http.ResponseWriter, r *http.Request) {
+	sum := &runSummary{RunID: newRunID(), TriggeredBy: s.caller(r.Header)}
+	log.Printf("starting run %v", sum.RunID)
+	// The run outlives a client that gives up waiting, but stays in its
+	// trace.
+	if err := s.runFetch(withTrace(context.Background(), r.Header), sum); err != nil {
+		log.Printf("run %v failed: %v", sum.RunID, err)
+		writeError(w, 500, sum.RunID, err)
+		return
+	}
+	log.Printf("OK: %+v", sum)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sum); err != nil {
+		log.Printf("couldn't write run summary: %v", err)
+	}
+}
+
+// reprocessInternal re-derives the CSV and JSON for every snapshot whose
+// manifest doesn't match the current query. Each snapshot's manifest is
+// written once it's done, so if this is interrupted, running it again picks up
+// where it left off. c is who asked for it.
+func (s *Server) reprocessInternal(ctx context.Context, c *store.Caller) (*backfillReport, error) {
+	runID := newRunID()
+	s.audit(ctx, "reprocess", runID, c)
+	bkt, err := s.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	qHash, err := s.queryHash()
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := store.ListSnapshots(ctx, bkt, exportFormats())
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for _, ts := range snapshots {
+		m, err := store.ReadManifest(ctx, bkt, ts)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil || m.QueryHash != qHash {
+			stale = append(stale, ts)
+		}
+	}
+	log.Printf("%v of %v snapshots need reprocessing for query %v", len(stale), len(snapshots), qHash)
+	// Reprocessed snapshots' rows may change.
+	defer s.history.reset()
+
+	report := runBackfill(ctx, stale, s.cfg.Parallelism, func(ctx context.Context, ts string) error {
+		r := &run{
+			bkt:     bkt,
+			tSuffix: ts,
+			// Only the newest snapshot is allowed to replace prism.json/latest.
+			publishLatest: ts == snapshots[len(snapshots)-1],
+			backfill:      true,
+			sum:           &runSummary{RunID: runID, TriggeredBy: c},
+		}
+		defer r.cleanup()
+		if err := s.archivedZip(ctx, r, ""); err != nil {
+			return err
+		}
+		return s.conversionPipeline(r).Run(ctx)
+	})
+	if len(stale) > 0 {
+		// The next history request rebuilds it.
+		if err := store.DeleteLicenceIndex(ctx, bkt); err != nil {
+			log.Printf("couldn't delete the licence index: %v", err)
+		}
+	}
+	return report, nil
+}
+
+func (s *Server) reprocess(w http.ResponseWriter, r *http.Request) {
+	report, err := s.reprocessInternal(r.Context(), s.caller(r.Header))
+	if err != nil {
+		w.WriteHeader(500)
+		log.Printf("%v", err)
+		fmt.Fprintf(w, "/reprocess failed: %v", err)
+		return
+	}
+	if len(report.failures()) > 0 {
+		w.WriteHeader(500)
+	}
+	report.writeTo(log.Writer())
+	report.writeTo(w)
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoScalars are the field types parseProto understands, besides messages
+// and maps.
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+}
+
+// protoTokens splits proto source into identifiers, numbers and
+// punctuation, dropping comments.
+func protoTokens(src string) []string {
+	var toks []string
+	for _, line := range strings.Split(src, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		for _, f := range strings.Fields(line) {
+			start := 0
+			for i, c := range f {
+				if strings.ContainsRune("{}()<>=;,", c) {
+					if i > start {
+						toks = append(toks, f[start:i])
+					}
+					toks = append(toks, string(c))
+					start = i + 1
+				}
+			}
+			if start < len(f) {
+				toks = append(toks, f[start:])
+			}
+		}
+	}
+	return toks
+}
+
+// protoParser parses the subset of proto3 that fetcher.proto uses: a
+// package, one service of unary and server-streaming methods, and messages
+// of scalar, message, repeated and map fields.
+type protoParser struct {
+	toks []string
+	pos  int
+	file *descriptorpb.FileDescriptorProto
+}
+
+func (p *protoParser) next() string {
+	if p.pos >= len(p.toks) {
+		return ""
+	}
+	p.pos++
+	return p.toks[p.pos-1]
+}
+
+func (p *protoParser) expect(want ...string) error {
+	for _, w := range want {
+		if got := p.next(); got != w {
+			return fmt.Errorf("got %q, want %q", got, w)
+		}
+	}
+	return nil
+}
+
+// ident returns the next token, if it's an identifier.
+func (p *protoParser) ident() (string, error) {
+	t := p.next()
+	if t == "" || !(unicode.IsLetter(rune(t[0])) || t[0] == '_') {
+		return "", fmt.Errorf("got %q, want a name", t)
+	}
+	return t, nil
+}
+
+// typeName qualifies a message name with the file's package.
+func (p *protoParser) typeName(name string) string {
+	return "." + p.file.GetPackage() + "." + name
+}
+
+// parseProto parses src into a file descriptor.
+func parseProto(name, src string) (protoreflect.FileDescriptor, error) {
+	p := &protoParser{toks: protoTokens(src), file: &descriptorpb.FileDescriptorProto{Name: proto.String(name)}}
+	for p.pos < len(p.toks) {
+		var err error
+		switch t := p.next(); t {
+		case "syntax":
+			if err = p.expect("="); err == nil {
+				p.file.Syntax = proto.String(strings.Trim(p.next(), `"`))
+				err = p.expect(";")
+			}
+		case "package":
+			var v string
+			if v, err = p.ident(); err == nil {
+				p.file.Package = proto.String(v)
+				err = p.expect(";")
+			}
+		case "service":
+			err = p.service()
+		case "message":
+			var m *descriptorpb.DescriptorProto
+			if m, err = p.message(); err == nil {
+				p.file.MessageType = append(p.file.MessageType, m)
+			}
+		default:
+			err = fmt.Errorf("unexpected %q", t)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse %v, at token %v: %v", name, p.pos, err)
+		}
+	}
+	fd, err := protodesc.NewFile(p.file, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", name, err)
+	}
+	return fd, nil
+}
+
+func (p *protoParser) service() error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	s := &descriptorpb.ServiceDescriptorProto{Name: proto.String(name)}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for {
+		switch t := p.next(); t {
+		case "}":
+			p.file.Service = append(p.file.Service, s)
+			return nil
+		case "rpc":
+		default:
+			return fmt.Errorf("got %q, want rpc", t)
+		}
+		m := &descriptorpb.MethodDescriptorProto{}
+		if name, err = p.ident(); err != nil {
+			return err
+		}
+		m.Name = proto.String(name)
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		if name, err = p.ident(); err != nil {
+			return err
+		}
+		m.InputType = proto.String(p.typeName(name))
+		if err := p.expect(")", "returns", "("); err != nil {
+			return err
+		}
+		if name, err = p.ident(); err != nil {
+			return err
+		}
+		if name == "stream" {
+			m.ServerStreaming = proto.Bool(true)
+			if name, err = p.ident(); err != nil {
+				return err
+			}
+		}
+		m.OutputType = proto.String(p.typeName(name))
+		if err := p.expect(")", ";"); err != nil {
+			return err
+		}
+		s.Method = append(s.Method, m)
+	}
+}
+
+func (p *protoParser) message() (*descriptorpb.DescriptorProto, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		t := p.next()
+		if t == "}" {
+			return m, nil
+		}
+		f := &descriptorpb.FieldDescriptorProto{Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+		var entry *descriptorpb.DescriptorProto
+		switch t {
+		case "repeated":
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			t, err = p.ident()
+		case "map":
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			entry, err = p.mapEntry()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.field(f); err != nil {
+			return nil, err
+		}
+		typ, scalar := protoScalars[t]
+		switch {
+		case entry != nil:
+			// Map entries are named after their field.
+			entry.Name = proto.String(mapEntryName(f.GetName()))
+			m.NestedType = append(m.NestedType, entry)
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			f.TypeName = proto.String(p.typeName(name + "." + entry.GetName()))
+		case scalar:
+			f.Type = typ.Enum()
+		default:
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			f.TypeName = proto.String(p.typeName(t))
+		}
+		m.Field = append(m.Field, f)
+	}
+}
+
+// field parses the name and number of a field whose type has been parsed.
+func (p *protoParser) field(f *descriptorpb.FieldDescriptorProto) error {
+	name, err := p.ident()
+	if err != nil {
+		return err
+	}
+	f.Name = proto.String(name)
+	f.JsonName = proto.String(protoJSONName(name))
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(p.next())
+	if err != nil {
+		return fmt.Errorf("bad number for field %v: %v", name, err)
+	}
+	f.Number = proto.Int32(int32(n))
+	return p.expect(";")
+}
+
+// mapEntry parses the <key, value> of a map field into its entry message,
+// which the caller names once the field's name is known.
+func (p *protoParser) mapEntry() (*descriptorpb.DescriptorProto, error) {
+	if err := p.expect("<"); err != nil {
+		return nil, err
+	}
+	key := p.next()
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	value := p.next()
+	if err := p.expect(">"); err != nil {
+		return nil, err
+	}
+	entry := &descriptorpb.DescriptorProto{Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}}
+	for i, t := range []string{key, value} {
+		typ, ok := protoScalars[t]
+		if !ok {
+			return nil, fmt.Errorf("map fields of %v aren't supported", t)
+		}
+		name := []string{"key", "value"}[i]
+		entry.Field = append(entry.Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(int32(i + 1)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		})
+	}
+	return entry, nil
+}
+
+// mapEntryName is what protoc names the entry message of a map field.
+func mapEntryName(field string) string {
+	return protoJSONName("_"+field) + "Entry"
+}
+
+// protoJSONName is the lowerCamelCase name protoc gives a field in JSON.
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
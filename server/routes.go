@@ -0,0 +1,185 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// route is an endpoint of the API. The routes are both registered and
+// described in /openapi.json from the same table, so the two can't drift
+// apart.
+type route struct {
+	// methods the route accepts. Any method is accepted if there's more
+	// than one, for schedulers that can only send one kind of request.
+	methods []string
+	pattern string
+	handler http.Handler
+	summary string
+	// request is a value of the type of the JSON request body, or nil if
+	// there's no body.
+	request interface{}
+	// response is a value of the type of the JSON response, or nil if the
+	// response is plain text.
+	response interface{}
+	// errors is whether failures are reported with the JSON error envelope.
+	errors bool
+	// compress is whether responses are compressed for clients that accept
+	// it.
+	compress bool
+	// access is who may call the route.
+	access access
+	// hidden routes aren't documented.
+	hidden bool
+}
+
+// routes lists every endpoint the server serves.
+func (s *Server) routes() []route {
+	routes := []route{
+		{
+			methods: []string{"GET", "POST"}, pattern: "/fetch",
+			handler:  s.withMaintenance(s.withIdempotency(s.fetch)),
+			summary:  "Fetch the latest snapshot from RSM and, if it's new, convert and publish it.",
+			response: runSummary{}, errors: true, access: accessOperator,
+		},
+		{
+			methods: []string{"GET", "POST"}, pattern: "/reprocess",
+			handler: s.withMaintenance(s.withIdempotency(s.reprocess)),
+			summary: "Reconvert archived snapshots produced by an older version of the query, reporting on each as plain text.",
+			access:  accessOperator,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/latest",
+			handler: http.HandlerFunc(s.latest),
+			summary: `The newest snapshot, as JSON or in the format named by the "format" parameter.`,
+			errors:  true, access: accessQuery, compress: true,
+		},
+		{
+			methods: []string{"GET", "POST"}, pattern: "/fetch/{dataset}",
+			handler: s.withDataset(func(d *Server) http.HandlerFunc {
+				return d.withMaintenance(d.withIdempotency(d.fetch))
+			}),
+			summary:  "As /fetch, for one of the other configured datasets.",
+			response: runSummary{}, errors: true, access: accessOperator,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/latest/{dataset}",
+			handler: s.withDataset(func(d *Server) http.HandlerFunc { return d.latest }),
+			summary: "As /latest, for one of the other configured datasets.",
+			errors:  true, access: accessQuery, compress: true,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/api/licence/{id}/history",
+			handler:  http.HandlerFunc(s.licenceHistory),
+			summary:  "How a licence's links changed across every stored snapshot.",
+			response: licenceHistory{}, errors: true, access: accessQuery, compress: true,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/api/snapshots",
+			handler:  http.HandlerFunc(s.listSnapshots),
+			summary:  "The timestamps of every published snapshot, oldest first.",
+			response: snapshotList{}, errors: true, access: accessQuery, compress: true,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/api/diff",
+			handler: http.HandlerFunc(s.diff),
+			summary: "The licences added, removed and modified between two snapshots, with their rows in each. " +
+				"Parameters: from and to, timestamps as listed by /api/snapshots. " +
+				"to defaults to the newest snapshot, and from to the one before it.",
+			response: snapshotDiff{}, errors: true, access: accessQuery, compress: true,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/api/snapshots/search",
+			handler: http.HandlerFunc(s.searchSnapshots),
+			summary: "Search the snapshot index. Parameters: from and to (RFC3339 or YYYY-MM-DD, to exclusive), " +
+				"min_rows and max_rows, limit, and order (asc or desc). " +
+				"For example, from=2024-01-01&limit=1 finds the first snapshot of 2024.",
+			response: snapshotSearch{}, errors: true, access: accessQuery, compress: true,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/status",
+			handler: http.HandlerFunc(s.status),
+			summary: "Pipeline health: what the current fetch is doing, the latest snapshot, " +
+				"the last run to succeed and to fail, and when the scheduler next runs.",
+			response: pipelineStatus{},
+		},
+		{
+			methods: []string{"GET"}, pattern: "/healthz",
+			handler: http.HandlerFunc(healthz),
+			summary: "Liveness check.",
+		},
+		{
+			methods: []string{"GET"}, pattern: "/readyz",
+			handler: http.HandlerFunc(s.readyz),
+			summary: "Readiness check: the conversion tools are installed and the bucket is readable. Problems are listed as plain text with a 503.",
+		},
+		{
+			methods: []string{"GET", "POST"}, pattern: "/selftest",
+			handler: http.HandlerFunc(s.selftest),
+			summary: "Convert a tiny embedded fixture end to end, and write, read and delete it under selftest/ in the bucket, " +
+				"reporting pass or fail for each stage. Any failure is a 503.",
+			response: selftestReport{}, access: accessOperator,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/openapi.json",
+			handler: http.HandlerFunc(s.openAPI),
+			summary: "This OpenAPI document.",
+		},
+		{
+			methods: []string{"GET"}, pattern: "/workflow.yaml",
+			handler: http.HandlerFunc(s.workflow),
+			summary: "A Cloud Workflows definition that runs a fetch as the /steps endpoints, with a retry policy for each.",
+		},
+		{
+			methods: []string{"POST"}, pattern: "/admin/keys",
+			handler: s.withAdmin(s.issueKey),
+			summary: "Issue an API key for the query API. The key is only shown in this response.",
+			request: issueKeyRequest{}, response: issuedKey{}, errors: true, access: accessOperator,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/admin/keys",
+			handler:  s.withAdmin(s.listKeys),
+			summary:  "List the API keys issued, by hash.",
+			response: store.APIKeys{}, errors: true, access: accessOperator,
+		},
+		{
+			methods: []string{"DELETE"}, pattern: "/admin/keys/{id}",
+			handler: s.withAdmin(s.revokeKey),
+			summary: "Revoke an API key. It may keep working for up to a minute.",
+			errors:  true, access: accessOperator,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/admin/maintenance",
+			handler:  s.withAdmin(s.getMaintenance),
+			summary:  "Whether fetches are paused for maintenance, and why.",
+			response: maintenanceStatus{}, errors: true, access: accessOperator,
+		},
+		{
+			methods: []string{"PUT"}, pattern: "/admin/maintenance",
+			handler: s.withAdmin(s.startMaintenance),
+			summary: "Pause scheduled and triggered fetches on every replica, optionally for a duration. " +
+				"Triggers get a 503 with the reason; the query API keeps serving.",
+			request: maintenanceRequest{}, response: maintenanceStatus{}, errors: true, access: accessOperator,
+		},
+		{
+			methods: []string{"DELETE"}, pattern: "/admin/maintenance",
+			handler: s.withAdmin(s.endMaintenance),
+			summary: "Resume fetches.",
+			errors:  true, access: accessOperator,
+		},
+		{
+			methods: []string{"GET"}, pattern: "/debug/vars",
+			handler: expvar.Handler(), hidden: true,
+		},
+	}
+	for _, st := range steps {
+		routes = append(routes, route{
+			methods: []string{"POST"}, pattern: "/steps/" + st.name,
+			handler: s.withMaintenance(s.stepHandler(st)),
+			summary: "Workflow step: " + st.summary,
+			request: stepState{}, response: stepResponse{}, errors: true, access: accessOperator,
+		})
+	}
+	return routes
+}
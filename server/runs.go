@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// maxRunRecords is how many recent runs are remembered for GetRun.
+const maxRunRecords = 100
+
+// runRecord is the outcome of a fetch run, as reported by the gRPC API.
+type runRecord struct {
+	RunID string `json:"run_id"`
+	// State is "running", "succeeded" or "failed".
+	State    string     `json:"state"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	// Stage is the stage that failed, if the run failed in one.
+	Stage   string      `json:"stage,omitempty"`
+	Summary *runSummary `json:"summary,omitempty"`
+}
+
+// runLog remembers recent runs, oldest first.
+type runLog struct {
+	mu      sync.Mutex
+	records []*runRecord
+}
+
+func (l *runLog) start(runID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, &runRecord{RunID: runID, State: "running", Started: time.Now().UTC()})
+	if len(l.records) > maxRunRecords {
+		l.records = l.records[len(l.records)-maxRunRecords:]
+	}
+}
+
+// finish records the outcome of a run. sum is copied, so the caller may
+// keep using it.
+func (l *runLog) finish(sum *runSummary, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.records {
+		if r.RunID != sum.RunID {
+			continue
+		}
+		copied := *sum
+		now := time.Now().UTC()
+		r.Summary = &copied
+		r.Finished = &now
+		r.State = "succeeded"
+		if err != nil {
+			r.State = "failed"
+			r.Error = err.Error()
+			var se *stageError
+			if errors.As(err, &se) {
+				r.Stage = se.Stage
+			}
+		}
+	}
+}
+
+// last returns a copy of the most recent run to finish in the given state,
+// or nil if none has.
+func (l *runLog) last(state string) *runRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found *runRecord
+	for _, r := range l.records {
+		if r.State == state && (found == nil || r.Finished.After(*found.Finished)) {
+			found = r
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	copied := *found
+	return &copied
+}
+
+// get returns a copy of the record of a run, or nil if it's not known.
+func (l *runLog) get(runID string) *runRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.records {
+		if r.RunID == runID {
+			copied := *r
+			return &copied
+		}
+	}
+	return nil
+}
+
+// runFetch runs the fetch pipeline, filling in sum and recording the run.
+func (s *Server) runFetch(ctx context.Context, sum *runSummary) error {
+	start := time.Now()
+	ctx = startTrace(ctx, sum)
+	log.Printf("run %v is in trace %v", sum.RunID, sum.TraceID)
+	if sum.TriggeredBy == nil {
+		sum.TriggeredBy = &store.Caller{Via: "anonymous"}
+	}
+	s.audit(ctx, "fetch", sum.RunID, sum.TriggeredBy)
+	s.runs.start(sum.RunID)
+	run := &run{sum: sum, publishLatest: true}
+	defer run.cleanup()
+	defer s.job.finish()
+	err := s.fetchPipeline(run).Run(ctx)
+	if err != nil {
+		s.recordFailure(ctx, run, err)
+	}
+	s.checkStaleness(ctx, run)
+	sum.Duration = time.Since(start).Round(time.Millisecond).String()
+	s.runs.finish(sum, err)
+	return err
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// salvage handles a damaged upstream zip: it tries to recover prism.mdb if
+// cfg.SalvageZip is set, and keeps the zip under forensics/ whether or not
+// that works. extractErr is why ExtractMDB failed. Failing to keep the zip is
+// only logged.
+func (s *Server) salvage(ctx context.Context, r *run, extractErr error) (*os.File, error) {
+	log.Printf("upstream zip for %v is damaged: %v", r.tSuffix, extractErr)
+	var mdb *os.File
+	err := extractErr
+	if s.cfg.SalvageZip {
+		if mdb, err = convert.SalvageMDB(r.zip, r.zipSize); err != nil {
+			err = fmt.Errorf("%w; couldn't salvage it either: %v", extractErr, err)
+		} else {
+			log.Printf("salvaged prism.mdb from the damaged zip")
+		}
+	}
+	f := &store.Forensics{Timestamp: r.tSuffix, Error: extractErr.Error(), Salvaged: err == nil}
+	if ferr := store.WriteForensics(ctx, r.bkt, store.File(r.zip), f); ferr != nil {
+		log.Printf("couldn't keep damaged zip: %v", ferr)
+	}
+	return mdb, err
+}
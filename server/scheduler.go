@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// schedulerLease is the name of the lease the scheduler's leader holds.
+const schedulerLease = "scheduler"
+
+// defaultLeaseTTL is the lease's TTL if cfg.LeaseTTL isn't set.
+const defaultLeaseTTL = 30 * time.Second
+
+// schedulerMetrics describes the built-in scheduler on this replica.
+var schedulerMetrics = expvar.NewMap("scheduler")
+
+// leadership tracks whether this replica holds the scheduler lease. It
+// counts as held until the lease it last took expires, so a storage error
+// while renewing doesn't immediately demote the leader.
+type leadership struct {
+	mu     sync.Mutex
+	holder string
+	until  time.Time
+}
+
+func (l *leadership) isLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.until)
+}
+
+func (l *leadership) set(lease *store.Lease, held bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holder = lease.Holder
+	if held {
+		l.until = lease.Expires
+	} else {
+		l.until = time.Time{}
+	}
+}
+
+// schedulerState is what /status reports of the built-in scheduler.
+type schedulerState struct {
+	mu   sync.Mutex
+	lead *leadership
+	next time.Time
+}
+
+func (st *schedulerState) set(lead *leadership, next time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lead = lead
+	st.next = next
+}
+
+// schedulerStatusJSON describes the scheduler on this replica.
+type schedulerStatusJSON struct {
+	Leader      bool   `json:"leader"`
+	LeaseHolder string `json:"lease_holder,omitempty"`
+	// NextRun is when the scheduler next triggers a fetch, if this replica
+	// is the leader.
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// status returns nil if the scheduler isn't running.
+func (st *schedulerState) status() *schedulerStatusJSON {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.lead == nil {
+		return nil
+	}
+	out := &schedulerStatusJSON{Leader: st.lead.isLeader()}
+	st.lead.mu.Lock()
+	out.LeaseHolder = st.lead.holder
+	st.lead.mu.Unlock()
+	if out.Leader {
+		next := st.next.UTC()
+		out.NextRun = &next
+	}
+	return out
+}
+
+// replicaID identifies this process among the replicas sharing a bucket.
+func replicaID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "-" + newRunID()
+}
+
+// RunScheduler triggers a fetch every cfg.Schedule until ctx is done. With
+// several replicas, only the one holding the scheduler lease in the bucket
+// triggers fetches, and the rest only serve requests. The lease is renewed
+// every third of cfg.LeaseTTL, so if the leader dies another replica takes
+// over within LeaseTTL.
+func (s *Server) RunScheduler(ctx context.Context) {
+	if s.cfg.Schedule <= 0 {
+		return
+	}
+	holder := replicaID()
+	var lead leadership
+	schedulerMetrics.Set("replica", stringVar(holder))
+	schedulerMetrics.Set("leader", expvar.Func(func() interface{} { return lead.isLeader() }))
+	schedulerMetrics.Set("lease_holder", expvar.Func(func() interface{} {
+		lead.mu.Lock()
+		defer lead.mu.Unlock()
+		return lead.holder
+	}))
+	log.Printf("scheduler: fetching every %v as %v, if leader", s.cfg.Schedule, holder)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.holdLease(ctx, holder, &lead)
+	}()
+
+	t := time.NewTicker(s.cfg.Schedule)
+	defer t.Stop()
+	s.sched.set(&lead, time.Now().Add(s.cfg.Schedule))
+	defer s.sched.set(nil, time.Time{})
+	for {
+		select {
+		case <-ctx.Done():
+			<-done
+			return
+		case <-t.C:
+		}
+		s.sched.set(&lead, time.Now().Add(s.cfg.Schedule))
+		if !lead.isLeader() {
+			log.Print("scheduler: not the leader, leaving the fetch to it")
+			continue
+		}
+		if m := s.maintenance(ctx); m != nil {
+			log.Printf("scheduler: skipping this fetch: %v", maintenanceErr(m))
+			continue
+		}
+		if s.job.snapshot().Running {
+			log.Print("scheduler: a fetch is already running, skipping this one")
+			continue
+		}
+		sum := &runSummary{RunID: newRunID(), TriggeredBy: &store.Caller{Via: "scheduler"}}
+		log.Printf("scheduler: starting run %v", sum.RunID)
+		if err := s.runFetch(ctx, sum); err != nil {
+			log.Printf("scheduler: run %v failed: %v", sum.RunID, err)
+			continue
+		}
+		log.Printf("scheduler: OK: %+v", sum)
+	}
+}
+
+// holdLease takes or renews the scheduler lease until ctx is done, then
+// releases it.
+func (s *Server) holdLease(ctx context.Context, holder string, lead *leadership) {
+	ttl := s.cfg.LeaseTTL
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	t := time.NewTicker(ttl / 3)
+	defer t.Stop()
+	for {
+		if bkt, err := s.bucket(ctx); err != nil {
+			log.Printf("scheduler: couldn't open bucket to renew lease: %v", err)
+		} else if lease, held, err := store.AcquireLease(ctx, bkt, schedulerLease, holder, ttl); err != nil {
+			log.Printf("scheduler: couldn't renew lease: %v", err)
+		} else {
+			if held != lead.isLeader() {
+				log.Printf("scheduler: leader is now %v", lease.Holder)
+			}
+			lead.set(lease, held)
+		}
+		select {
+		case <-ctx.Done():
+			if !lead.isLeader() {
+				return
+			}
+			// ctx is done, so release with a context of our own.
+			rctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if bkt, err := s.bucket(rctx); err == nil {
+				if err := store.ReleaseLease(rctx, bkt, schedulerLease, holder); err != nil {
+					log.Printf("scheduler: couldn't release lease: %v", err)
+				}
+			}
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"golang.org/x/sync/errgroup"
+)
+
+// snapshotQuery is a parsed /api/snapshots/search query.
+type snapshotQuery struct {
+	from, to         time.Time
+	minRows, maxRows int
+	limit            int
+	desc             bool
+}
+
+// snapshotSearch is the response to /api/snapshots/search.
+type snapshotSearch struct {
+	Snapshots []store.IndexEntry `json:"snapshots"`
+}
+
+// parseTime parses an RFC3339 timestamp or a YYYY-MM-DD date, as UTC.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+func parseSnapshotQuery(q url.Values) (*snapshotQuery, error) {
+	sq := &snapshotQuery{maxRows: -1}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if sq.from, err = parseTime(v); err != nil {
+			return nil, fmt.Errorf("bad from %q: want an RFC3339 time or YYYY-MM-DD", v)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if sq.to, err = parseTime(v); err != nil {
+			return nil, fmt.Errorf("bad to %q: want an RFC3339 time or YYYY-MM-DD", v)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"min_rows", &sq.minRows}, {"max_rows", &sq.maxRows}, {"limit", &sq.limit}} {
+		if v := q.Get(p.name); v != "" {
+			if *p.dst, err = strconv.Atoi(v); err != nil || *p.dst < 0 {
+				return nil, fmt.Errorf("bad %v %q: want a non-negative integer", p.name, v)
+			}
+		}
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		sq.desc = true
+	default:
+		return nil, fmt.Errorf(`bad order %q: want "asc" or "desc"`, q.Get("order"))
+	}
+	return sq, nil
+}
+
+// matchesTime reports whether a snapshot is in the query's time range. to
+// is exclusive, so from=2024-01-01&to=2024-02-01 is January.
+func (sq *snapshotQuery) matchesTime(ts string) bool {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return false
+	}
+	return (sq.from.IsZero() || !t.Before(sq.from)) && (sq.to.IsZero() || t.Before(sq.to))
+}
+
+func (sq *snapshotQuery) matchesRows(rows int) bool {
+	return rows >= sq.minRows && (sq.maxRows < 0 || rows <= sq.maxRows)
+}
+
+// results returns the entries in the query's row range, in its order, up to
+// its limit. inRange is oldest first.
+func (sq *snapshotQuery) results(inRange []store.IndexEntry) []store.IndexEntry {
+	out := []store.IndexEntry{}
+	for i := range inRange {
+		e := inRange[i]
+		if sq.desc {
+			e = inRange[len(inRange)-1-i]
+		}
+		if !sq.matchesRows(e.Rows) {
+			continue
+		}
+		out = append(out, e)
+		if sq.limit > 0 && len(out) == sq.limit {
+			break
+		}
+	}
+	return out
+}
+
+// fillRows sets the row counts of entries indexed before the index recorded
+// them, from their manifests. Aliases get the rows of the snapshot they
+// alias.
+func (s *Server) fillRows(ctx context.Context, bkt *storage.BucketHandle, entries []store.IndexEntry) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(s.cfg.Parallelism, 1))
+	for i := range entries {
+		e := &entries[i]
+		if e.Rows != 0 {
+			continue
+		}
+		g.Go(func() error {
+			ts := e.Timestamp
+			if e.AliasOf != "" {
+				ts = e.AliasOf
+			}
+			m, err := store.ReadManifest(ctx, bkt, ts)
+			if err != nil {
+				return err
+			}
+			if m != nil {
+				e.Rows = m.Rows
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func (s *Server) searchSnapshots(w http.ResponseWriter, r *http.Request) {
+	sq, err := parseSnapshotQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: err})
+		return
+	}
+	bkt, err := s.bucket(r.Context())
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	idx, _, err := store.ReadIndex(r.Context(), bkt)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+		return
+	}
+	var inRange []store.IndexEntry
+	for _, e := range idx.Snapshots {
+		if sq.matchesTime(e.Timestamp) {
+			inRange = append(inRange, e)
+		}
+	}
+	if sq.minRows > 0 || sq.maxRows >= 0 {
+		if err := s.fillRows(r.Context(), bkt, inRange); err != nil {
+			log.Printf("couldn't read manifests: %v", err)
+			writeError(w, http.StatusServiceUnavailable, "", storageErr(err))
+			return
+		}
+	}
+	res := snapshotSearch{Snapshots: sq.results(inRange)}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("couldn't write search results: %v", err)
+	}
+}
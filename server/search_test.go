@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+func TestSearchSnapshots(t *testing.T) {
+	entries := []store.IndexEntry{
+		{Timestamp: "2023-12-31T23:00:00Z", Rows: 100},
+		{Timestamp: "2024-01-01T00:00:00Z", Rows: 200},
+		{Timestamp: "2024-01-15T12:00:00Z", Rows: 300},
+		{Timestamp: "2024-01-31T23:59:59Z", Rows: 50},
+		{Timestamp: "2024-02-01T00:00:00Z", Rows: 400},
+		{Timestamp: "not a time", Rows: 500},
+	}
+	tests := []struct {
+		query string
+		// want are the timestamps found, as indexes into entries.
+		want    []int
+		wantErr bool
+	}{
+		{query: "", want: []int{0, 1, 2, 3, 4}},
+		{query: "from=2024-01-01&to=2024-02-01", want: []int{1, 2, 3}},
+		{query: "from=2024-01-15T12:00:00Z", want: []int{2, 3, 4}},
+		{query: "to=2024-01-15T12:00:00Z", want: []int{0, 1}},
+		{query: "from=2024-01-01T13:00:00%2B13:00&to=2024-01-01T00:00:01Z", want: []int{1}},
+		{query: "min_rows=200", want: []int{1, 2, 4}},
+		{query: "max_rows=100", want: []int{0, 3}},
+		{query: "min_rows=200&max_rows=300", want: []int{1, 2}},
+		{query: "order=desc", want: []int{4, 3, 2, 1, 0}},
+		{query: "limit=2", want: []int{0, 1}},
+		{query: "limit=2&order=desc", want: []int{4, 3}},
+		{query: "limit=2&order=desc&max_rows=300", want: []int{3, 2}},
+		{query: "limit=0", want: []int{0, 1, 2, 3, 4}},
+		{query: "from=2025-01-01", want: []int{}},
+		{query: "from=yesterday", wantErr: true},
+		{query: "to=2024-13-01", wantErr: true},
+		{query: "min_rows=-1", wantErr: true},
+		{query: "limit=ten", wantErr: true},
+		{query: "order=newest", wantErr: true},
+	}
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sq, err := parseSnapshotQuery(q)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSnapshotQuery(%q) = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var inRange []store.IndexEntry
+		for _, e := range entries {
+			if sq.matchesTime(e.Timestamp) {
+				inRange = append(inRange, e)
+			}
+		}
+		want := []store.IndexEntry{}
+		for _, i := range tt.want {
+			want = append(want, entries[i])
+		}
+		if got := sq.results(inRange); !reflect.DeepEqual(got, want) {
+			t.Errorf("search %q = %v, want %v", tt.query, got, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/pipeline"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// selftestZip is a prism.zip whose prism.mdb has the PRISM tables the
+// query reads, with a single link: fixture.PRISMZip's output, which
+// TestSelftestZip checks it's up to date with.
+//
+//go:embed selftest/prism.zip
+var selftestZip []byte
+
+// selftestStages are the stages of the conversion pipeline the self-test
+// runs. The rest publish to the bucket, or look up elevations.
+var selftestStages = map[string]bool{
+	"unzip": true, "mdb_to_sqlite": true, "read_mdb": true, "query": true,
+	"grid_refs": true, "sort": true, "csv_to_json": true,
+}
+
+// selftestTimeout bounds a whole self-test.
+const selftestTimeout = 2 * time.Minute
+
+// selftestStage is the outcome of one stage of a self-test.
+type selftestStage struct {
+	Name string `json:"name"`
+	// Result is "pass", "fail", or "skipped" after an earlier failure.
+	Result string `json:"result"`
+	Millis int64  `json:"millis,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// selftestReport is the response to /selftest.
+type selftestReport struct {
+	Pass   bool            `json:"pass"`
+	Stages []selftestStage `json:"stages"`
+}
+
+// selftestPipeline converts the embedded fixture with the conversion
+// pipeline's own stages, then writes, reads back and deletes the JSON under
+// selftest/{id}/ in the bucket. r holds its state; nothing else in the
+// bucket is touched.
+func (s *Server) selftestPipeline(r *run, id string) (*pipeline.Pipeline, error) {
+	p := pipeline.New()
+	p.Add("fixture", func(ctx context.Context) error {
+		f, _, err := spool("selftest.zip", bytes.NewReader(selftestZip))
+		if err != nil {
+			return err
+		}
+		return r.setZip(f, true)
+	})
+	conv := s.conversionPipeline(r)
+	for _, name := range conv.Stages() {
+		if !selftestStages[name] {
+			continue
+		}
+		stage, err := conv.Slice(name, name)
+		if err != nil {
+			return nil, err
+		}
+		p.Append(stage)
+	}
+	err := p.InsertAfter("query", "links", func(ctx context.Context) error {
+		if len(r.rows.Records) == 0 {
+			return errors.New("the query found no links in the fixture")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// written is the JSON written to the bucket, to compare with what's
+	// read back.
+	var written bytes.Buffer
+	p.Add("parse_json", func(ctx context.Context) error {
+		if err := r.json(&written); err != nil {
+			return err
+		}
+		var rows []map[string]string
+		if err := json.Unmarshal(written.Bytes(), &rows); err != nil {
+			return fmt.Errorf("couldn't parse converted JSON: %v", err)
+		}
+		return nil
+	})
+	name := "selftest/" + id + "/prism.json"
+	p.Add("gcs_write", func(ctx context.Context) error {
+		bkt, err := s.bucket(ctx)
+		if err != nil {
+			return err
+		}
+		r.bkt = bkt
+		return store.Write(ctx, bkt.Object(name), bytes.NewReader(written.Bytes()), "STANDARD")
+	})
+	p.Add("gcs_read", func(ctx context.Context) error {
+		b, err := store.Read(ctx, r.bkt, name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b, written.Bytes()) {
+			return fmt.Errorf("read back %v bytes, not the %v written", len(b), written.Len())
+		}
+		return nil
+	})
+	p.Add("gcs_delete", func(ctx context.Context) error {
+		return r.bkt.Object(name).Delete(ctx)
+	})
+	return p, nil
+}
+
+func (s *Server) selftest(w http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), selftestTimeout)
+	defer cancel()
+	id := newRunID()
+	r := &run{sum: &runSummary{RunID: id}}
+	defer r.cleanup()
+	p, err := s.selftestPipeline(r, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := selftestReport{}
+	results := make(map[string]selftestStage)
+	p.Use(pipeline.Logging(), func(name string, next pipeline.Func) pipeline.Func {
+		return func(ctx context.Context) error {
+			start := time.Now()
+			err := next(ctx)
+			st := selftestStage{Name: name, Result: "pass", Millis: time.Since(start).Milliseconds()}
+			if err != nil {
+				st.Result = "fail"
+				st.Error = err.Error()
+			}
+			results[name] = st
+			return err
+		}
+	})
+	log.Printf("selftest %v: starting", id)
+	err = p.Run(ctx)
+	if err != nil && r.bkt != nil {
+		// Don't leave the scratch object behind if reading it back failed.
+		if derr := r.bkt.Object("selftest/" + id + "/prism.json").Delete(context.Background()); derr != nil && derr != storage.ErrObjectNotExist {
+			log.Printf("selftest %v: couldn't clean up: %v", id, derr)
+		}
+	}
+	for _, name := range p.Stages() {
+		st, ok := results[name]
+		if !ok {
+			st = selftestStage{Name: name, Result: "skipped"}
+		}
+		report.Stages = append(report.Stages, st)
+	}
+	report.Pass = err == nil
+	log.Printf("selftest %v: pass=%v", id, report.Pass)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Pass {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("couldn't write selftest report: %v", err)
+	}
+}
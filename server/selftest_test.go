@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/internal/fixture"
+)
+
+var update = flag.Bool("update", false, "rewrite selftest/prism.zip")
+
+func TestSelftestZip(t *testing.T) {
+	template, err := os.ReadFile("../mdb/testdata/empty.mdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := fixture.PRISMZip(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile("selftest/prism.zip", want, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if !bytes.Equal(selftestZip, want) {
+		t.Error("selftest/prism.zip is out of date with fixture.PRISMTables; run go test ./server -run TestSelftestZip -update")
+	}
+}
+
+func TestSelftestPipeline(t *testing.T) {
+	// Only the native conversion can run here, without a JRE.
+	s := &Server{cfg: Config{NativeConvert: true, QueryFile: convert.QueryFile}}
+	r := &run{sum: &runSummary{}}
+	defer r.cleanup()
+	p, err := s.selftestPipeline(r, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Everything up to writing to the bucket.
+	if p, err = p.Slice("fixture", "parse_json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.rows.Records) != 1 {
+		t.Fatalf("converted %v links, want 1", len(r.rows.Records))
+	}
+	if got := r.rows.Records[0][1]; got != "Self Test Ltd" {
+		t.Errorf("clientname = %q, want Self Test Ltd", got)
+	}
+}
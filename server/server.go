@@ -0,0 +1,322 @@
+// Package server serves the fetcher's HTTP API: triggering fetches and
+// reprocessing, and reporting on how they're going.
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/alert"
+	"github.com/mhansen/nzwirelessmap-fetch/bq"
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/dem"
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+	"github.com/mhansen/nzwirelessmap-fetch/tracing"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Config configures a Server.
+type Config struct {
+	// PrismZipURL is where to download prism.zip from.
+	PrismZipURL string
+	// BucketName is the Google Cloud Storage bucket snapshots are kept in.
+	BucketName string
+	// QueryFile is the SQL that extracts rows from the converted database.
+	// Empty means convert.QueryFile.
+	QueryFile string
+	// NativeConvert converts prism.mdb in Go, with package mdb and
+	// convert.QueryLinks, rather than by shelling out to mdb-sqlite, the
+	// sqlite3 CLI and csv2json2.py. It's ignored when QueryFile isn't
+	// convert.QueryFile, since only sqlite3 runs other SQL. Formats such as
+	// gpkg and datasette.sqlite are built by sqlite3 either way.
+	NativeConvert bool
+	// Datasets are served alongside the default dataset, at
+	// /fetch/{dataset} and /latest/{dataset}.
+	Datasets []Dataset
+	// Parallelism is how many snapshots to process at once when
+	// reprocessing.
+	Parallelism int
+	// DownloadRateLimit is the maximum upstream download rate in bytes per
+	// second, or 0 for unlimited.
+	DownloadRateLimit int
+	// IdempotencyTTL is how long to remember the response to a request with
+	// an Idempotency-Key header.
+	IdempotencyTTL time.Duration
+	// Formats names the registered converters to publish, besides CSV and
+	// JSON.
+	Formats []string
+	// JSONPatch publishes a JSON Patch from each snapshot's predecessor,
+	// for mirrors to update from incrementally.
+	JSONPatch bool
+	// SortRows makes the order of rows in every output stable across runs,
+	// so snapshots can be diffed byte by byte.
+	SortRows bool
+	// Cache, if set, keeps recent zips on local disk, so retries and
+	// reprocessing on the same instance don't download them again.
+	Cache *fetch.Cache
+	// SalvageZip recovers prism.mdb from damaged zips by scanning for its
+	// local file header. Damaged zips are kept under forensics/ either way.
+	SalvageZip bool
+	// ArchiveZip is when to archive the upstream zip: ArchiveZipAlways,
+	// ArchiveZipNew or ArchiveZipNever. Empty means ArchiveZipAlways.
+	ArchiveZip string
+	// DEM, if set, is used to add the ground elevation of each link's
+	// endpoints to the output.
+	DEM dem.Source
+	// Events, if set, is streamed the licences each snapshot added, removed
+	// and modified.
+	Events *bq.Events
+	// StaleAfter is how long upstream can go without publishing a new
+	// snapshot before alerting. 0 disables alerting.
+	StaleAfter time.Duration
+	// Alerts is where alerts are sent. If nil, they're logged.
+	Alerts alert.Notifier
+	// FailureCooldown is how long after an upstream failure to skip
+	// fetches, rather than try upstream again. 0 disables the cool-down.
+	FailureCooldown time.Duration
+	// MinFetchInterval is the least time between requests to upstream, by
+	// any replica, however often fetches are triggered. Triggers sooner
+	// than that are skipped. 0 disables the limit.
+	MinFetchInterval time.Duration
+	// RequireAPIKeys makes the query API need a key issued through
+	// /admin/keys.
+	RequireAPIKeys bool
+	// DefaultKeyRate is how many requests a minute a new API key may make,
+	// if it's not issued with a rate of its own.
+	DefaultKeyRate int
+	// OperatorToken, if set, must be sent as a bearer token to trigger runs
+	// and manage API keys.
+	OperatorToken string
+	// TrustIdentityHeaders records the caller named by Cloud IAP's
+	// X-Goog-Authenticated-User-Email header, or by the OIDC token in the
+	// Authorization or X-Serverless-Authorization header, as who triggered
+	// a run. The headers aren't verified, so only set this behind IAP or
+	// Cloud Run authentication, which verify them first.
+	TrustIdentityHeaders bool
+	// Maintenance, if set, pauses fetches on this replica with this reason,
+	// as PUT /admin/maintenance does for every replica.
+	Maintenance string
+	// Schedule is how often RunScheduler triggers a fetch.
+	Schedule time.Duration
+	// LeaseTTL is how long the scheduler's leader holds its lease without
+	// renewing it, and so how long a dead leader goes unreplaced.
+	LeaseTTL time.Duration
+}
+
+// Values of Config.ArchiveZip. Snapshots whose zips aren't archived can't be
+// reprocessed.
+const (
+	// ArchiveZipAlways archives every new snapshot's zip.
+	ArchiveZipAlways = "always"
+	// ArchiveZipNew archives a zip only if no earlier snapshot had the same
+	// content.
+	ArchiveZipNew = "new"
+	// ArchiveZipNever keeps only the derived files.
+	ArchiveZipNever = "never"
+)
+
+// Server serves the HTTP API. Create one with New.
+type Server struct {
+	cfg        Config
+	formats    []convert.Converter
+	job        jobStatus
+	idempotent idempotencyCache
+	history    historyCache
+	storage    storageClient
+	stale      staleness
+	runs       runLog
+	alerts     alert.Notifier
+	keys       apiKeys
+	sched      schedulerState
+	started    time.Time
+	metrics    serverMetrics
+	// datasets are the servers of cfg.Datasets, by name.
+	datasets map[string]*Server
+}
+
+// serverMetrics are where a Server publishes its metrics. Datasets each
+// have their own.
+type serverMetrics struct {
+	stages, churn, upstream *expvar.Map
+}
+
+// New returns a Server with the given configuration.
+func New(cfg Config) (*Server, error) {
+	s, err := newServer(cfg, serverMetrics{stages: stageMetrics, churn: churnMetrics, upstream: upstreamMetrics})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addDatasets(cfg.Datasets); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newServer returns a Server for one dataset.
+func newServer(cfg Config, m serverMetrics) (*Server, error) {
+	if cfg.QueryFile == "" {
+		cfg.QueryFile = convert.QueryFile
+	}
+	if cfg.QueryFile != convert.QueryFile && cfg.NativeConvert {
+		log.Printf("converting with the legacy pipeline, to run %v", cfg.QueryFile)
+	}
+	s := &Server{
+		cfg:        cfg,
+		idempotent: idempotencyCache{entries: make(map[string]*cachedResponse)},
+		alerts:     cfg.Alerts,
+		started:    time.Now().UTC(),
+		metrics:    m,
+	}
+	if s.alerts == nil {
+		s.alerts = alert.Log{}
+	}
+	m.upstream.Set("newest_snapshot_age_seconds", expvar.Func(s.stale.ageSeconds))
+	switch cfg.ArchiveZip {
+	case "", ArchiveZipAlways, ArchiveZipNew, ArchiveZipNever:
+	default:
+		return nil, fmt.Errorf("unknown ArchiveZip %q: want %q, %q or %q", cfg.ArchiveZip, ArchiveZipAlways, ArchiveZipNew, ArchiveZipNever)
+	}
+	for _, name := range cfg.Formats {
+		c, ok := convert.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown format %q: have %v", name, convert.Names())
+		}
+		s.formats = append(s.formats, c)
+	}
+	return s, nil
+}
+
+// Handler returns the handler for all of the server's endpoints.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		pattern := rt.pattern
+		if len(rt.methods) == 1 {
+			pattern = rt.methods[0] + " " + pattern
+		}
+		h := rt.handler
+		if rt.compress {
+			h = withCompression(h)
+		}
+		switch rt.access {
+		case accessQuery:
+			h = s.requireAPIKey(h)
+		case accessOperator:
+			h = s.requireOperator(h)
+		}
+		mux.Handle(pattern, h)
+	}
+	return mux
+}
+
+// newStorageClient returns a Cloud Storage client that passes on the trace
+// context of each call's context.
+func newStorageClient(ctx context.Context) (*storage.Client, error) {
+	t, err := htransport.NewTransport(ctx, &tracing.Transport{}, option.WithScopes(storage.ScopeFullControl))
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewClient(ctx, option.WithHTTPClient(&http.Client{Transport: t}))
+}
+
+// storageClient is a Cloud Storage client, created the first time it's
+// needed and shared by everything the Server does after that.
+type storageClient struct {
+	mu     sync.Mutex
+	client *storage.Client
+}
+
+// get returns the client, creating it if there isn't one yet.
+func (c *storageClient) get() (*storage.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		// The client outlives whichever request creates it, so it mustn't
+		// be tied to that request's context.
+		client, err := newStorageClient(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+func (c *storageClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+// bucket opens the configured bucket.
+func (s *Server) bucket(ctx context.Context) (*storage.BucketHandle, error) {
+	client, err := s.storage.get()
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create storage client: %v", err)
+	}
+	return client.Bucket(s.cfg.BucketName), nil
+}
+
+// Close releases the Server's connections, and those of its datasets.
+func (s *Server) Close() error {
+	err := s.storage.close()
+	for _, d := range s.datasets {
+		if derr := d.Close(); err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "OK")
+}
+
+// preflightTimeout bounds how long the bucket check in /readyz may take.
+const preflightTimeout = 10 * time.Second
+
+// Preflight checks the dependencies of the configured conversion and
+// formats, as convert.Preflight does.
+func (s *Server) Preflight(ctx context.Context) []string {
+	return convert.Preflight(ctx, s.legacyConvert(), s.formats)
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	problems := s.Preflight(r.Context())
+	if err := s.checkBucket(r.Context()); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+// checkBucket makes sure the configured credentials can read the bucket's
+// metadata, so a misconfigured service account shows up at deploy time.
+func (s *Server) checkBucket(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	bkt, err := s.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	return store.CheckBucket(ctx, bkt)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/alert"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// upstreamMetrics describes the newest snapshot RSM has published.
+var upstreamMetrics = expvar.NewMap("upstream")
+
+// staleness is this instance's copy of the stored store.Staleness, for
+// metrics and /status.
+type staleness struct {
+	mu     sync.Mutex
+	newest time.Time
+	firing bool
+}
+
+// ageSeconds is how old the newest snapshot is, or 0 if there hasn't been a
+// fetch yet.
+func (st *staleness) ageSeconds() interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.newest.IsZero() {
+		return 0
+	}
+	return int64(time.Since(st.newest).Seconds())
+}
+
+// evaluate decides whether upstream is stale, given the stored alert state,
+// and updates st.Firing. It returns an alert if that has changed.
+func evaluate(st *store.Staleness, staleAfter time.Duration) *alert.Alert {
+	age := time.Since(st.Newest)
+	stale := age > staleAfter
+	if stale == st.Firing {
+		return nil
+	}
+	st.Firing = stale
+	t := st.Newest
+	a := &alert.Alert{
+		Name:   "upstream_stale",
+		Firing: stale,
+		Details: map[string]interface{}{
+			"last_modified": t.Format(time.RFC3339),
+			"age":           age.Round(time.Minute).String(),
+			"stale_after":   staleAfter.String(),
+		},
+		Time: time.Now().UTC(),
+	}
+	if stale {
+		a.Message = fmt.Sprintf("RSM hasn't published a new snapshot since %v (%v ago)", t.Format(time.RFC3339), age.Round(time.Hour))
+	} else {
+		a.Message = fmt.Sprintf("RSM published a new snapshot at %v", t.Format(time.RFC3339))
+	}
+	return a
+}
+
+// checkStaleness alerts if the newest upstream snapshot is older than
+// configured. It's called after every run, whether or not upstream could
+// be reached, so an outage still raises the alert. The alert's state is
+// kept in the bucket, so it fires and resolves once however many instances
+// come and go. A failure to alert doesn't fail the run.
+func (s *Server) checkStaleness(ctx context.Context, r *run) {
+	var lastModified time.Time
+	if r.resp != nil {
+		lastModified = r.resp.LastModified
+		s.metrics.upstream.Set("newest_snapshot", stringVar(lastModified.Format(time.RFC3339)))
+	}
+	if s.cfg.StaleAfter <= 0 {
+		return
+	}
+	bkt := r.bkt
+	if bkt == nil {
+		var err error
+		if bkt, err = s.bucket(ctx); err != nil {
+			log.Printf("couldn't check staleness: %v", err)
+			return
+		}
+	}
+	var a *alert.Alert
+	var st store.Staleness
+	err := store.UpdateStaleness(ctx, bkt, func(stored *store.Staleness) {
+		a = nil
+		if lastModified.After(stored.Newest) {
+			stored.Newest = lastModified
+		}
+		if !stored.Newest.IsZero() {
+			a = evaluate(stored, s.cfg.StaleAfter)
+		}
+		st = *stored
+	})
+	if err != nil {
+		log.Printf("couldn't check staleness: %v", err)
+		return
+	}
+	s.stale.mu.Lock()
+	s.stale.newest, s.stale.firing = st.Newest, st.Firing
+	s.stale.mu.Unlock()
+	v := new(expvar.Int)
+	if st.Firing {
+		v.Set(1)
+	}
+	s.metrics.upstream.Set("stale", v)
+	if a == nil {
+		return
+	}
+	if err := s.alerts.Notify(ctx, *a); err != nil {
+		log.Printf("couldn't alert: %v", err)
+	}
+}
+
+func stringVar(s string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(s)
+	return v
+}
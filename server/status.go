@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mhansen/nzwirelessmap-fetch/fetch"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// jobStatus tracks what the current /fetch run is doing, so a hung download
+// can be told apart from a slow one.
+type jobStatus struct {
+	mu       sync.Mutex
+	stage    string
+	started  time.Time
+	download *fetch.ProgressReader
+}
+
+func (j *jobStatus) setStage(stage string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stage == "" {
+		j.started = time.Now()
+	}
+	j.stage = stage
+}
+
+func (j *jobStatus) setDownload(p *fetch.ProgressReader) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.download = p
+}
+
+// tryStart marks the job as running, unless it already is, and reports
+// whether it did. The run's stages then replace the "starting" stage.
+func (j *jobStatus) tryStart() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stage != "" {
+		return false
+	}
+	j.stage = "starting"
+	j.started = time.Now()
+	return true
+}
+
+// finish marks the job as no longer running.
+func (j *jobStatus) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stage = ""
+	j.download = nil
+}
+
+type jobStatusJSON struct {
+	Running  bool            `json:"running"`
+	Stage    string          `json:"stage,omitempty"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Download *fetch.Progress `json:"download,omitempty"`
+}
+
+func (j *jobStatus) snapshot() jobStatusJSON {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := jobStatusJSON{Running: j.stage != "", Stage: j.stage}
+	if s.Running {
+		started := j.started
+		s.Started = &started
+	}
+	if j.download != nil {
+		p := j.download.Progress()
+		s.Download = &p
+	}
+	return s
+}
+
+// statusTimeout bounds how long /status spends reading the bucket.
+const statusTimeout = 5 * time.Second
+
+// pipelineStatus is the response to /status. The fields of jobStatusJSON
+// describe the current run, if any.
+type pipelineStatus struct {
+	jobStatusJSON
+	// Healthy is false if the last run on this replica failed, upstream has
+	// gone stale, or the bucket couldn't be read.
+	Healthy bool `json:"healthy"`
+	// LatestSnapshot is the newest snapshot in the index.
+	LatestSnapshot string `json:"latest_snapshot,omitempty"`
+	// LastSuccess and LastFailure are the last runs on this replica to
+	// succeed and fail, since it started.
+	LastSuccess *runRecord `json:"last_success,omitempty"`
+	LastFailure *runRecord `json:"last_failure,omitempty"`
+	// BackoffUntil is when fetches will next try upstream, if it recently
+	// failed.
+	BackoffUntil  *time.Time `json:"backoff_until,omitempty"`
+	UpstreamStale bool       `json:"upstream_stale"`
+	// Maintenance is set while fetches are paused.
+	Maintenance *store.Maintenance   `json:"maintenance,omitempty"`
+	Scheduler   *schedulerStatusJSON `json:"scheduler,omitempty"`
+	// Errors lists what couldn't be found out.
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	out := pipelineStatus{
+		jobStatusJSON: s.job.snapshot(),
+		LastSuccess:   s.runs.last("succeeded"),
+		LastFailure:   s.runs.last("failed"),
+		Scheduler:     s.sched.status(),
+	}
+	s.stale.mu.Lock()
+	out.UpstreamStale = s.stale.firing
+	s.stale.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), statusTimeout)
+	defer cancel()
+	out.Maintenance = s.maintenance(ctx)
+	if bkt, err := s.bucket(ctx); err != nil {
+		out.Errors = append(out.Errors, err.Error())
+	} else {
+		if idx, _, err := store.ReadIndex(ctx, bkt); err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("couldn't read index: %v", err))
+		} else if e := idx.Latest(); e != nil {
+			out.LatestSnapshot = e.Timestamp
+		}
+		if c, err := store.ReadCooldown(ctx, bkt); err != nil {
+			out.Errors = append(out.Errors, fmt.Sprintf("couldn't read cooldown: %v", err))
+		} else if c != nil && time.Now().Before(c.Until) {
+			out.BackoffUntil = &c.Until
+		}
+	}
+
+	lastFailed := out.LastFailure != nil && (out.LastSuccess == nil || out.LastFailure.Finished.After(*out.LastSuccess.Finished))
+	out.Healthy = !lastFailed && !out.UpstreamStale && len(out.Errors) == 0
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("couldn't write status: %v", err)
+	}
+}
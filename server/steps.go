@@ -0,0 +1,315 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/convert"
+	"github.com/mhansen/nzwirelessmap-fetch/pipeline"
+	"github.com/mhansen/nzwirelessmap-fetch/store"
+)
+
+// A step is a slice of the fetch pipeline served on its own at
+// /steps/{name}, so an orchestrator like Cloud Workflows can run and retry
+// each one separately. Steps hand state to each other through the bucket
+// and the stepState in their requests and responses, and are idempotent:
+// running one twice is the same as running it once.
+type step struct {
+	name    string
+	summary string
+	// needs lists the stepState fields the request must have.
+	needs []string
+	// pipeline returns the stages to run for r. want is the request.
+	pipeline func(s *Server, r *run, want stepState) (*pipeline.Pipeline, error)
+}
+
+// stepState is the request body of every step. A workflow passes each
+// step's response on to the next step as its request.
+type stepState struct {
+	Snapshot string `json:"snapshot,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+// stepResponse is the response of every step.
+type stepResponse struct {
+	stepState
+	// Done is set when there's nothing more to do for the snapshot, so the
+	// workflow should stop.
+	Done    bool        `json:"done"`
+	Summary *runSummary `json:"summary"`
+}
+
+// steps lists the steps in the order a workflow runs them.
+var steps = []step{
+	{
+		name:    "check",
+		summary: "Find upstream's current snapshot, and whether it's already been processed.",
+		pipeline: func(s *Server, r *run, want stepState) (*pipeline.Pipeline, error) {
+			return s.fetchPipeline(r).Slice("connect", "check")
+		},
+	},
+	{
+		name:    "download",
+		summary: "Download the snapshot and archive its zip, unless it's identical to the previous one.",
+		needs:   []string{"snapshot"},
+		pipeline: func(s *Server, r *run, want stepState) (*pipeline.Pipeline, error) {
+			p, err := s.fetchPipeline(r).Slice("connect", "archive_zip")
+			if err != nil {
+				return nil, err
+			}
+			// Upstream may have published another snapshot since the check.
+			// If so, leave it for the next run to pick up.
+			return p, p.InsertAfter("request", "match_snapshot", func(ctx context.Context) error {
+				if r.tSuffix == want.Snapshot {
+					return nil
+				}
+				log.Printf("exiting early: upstream is now at %v, not %v", r.tSuffix, want.Snapshot)
+				r.sum.Skipped = true
+				r.sum.SkipReason = "superseded"
+				return pipeline.ErrStop
+			})
+		},
+	},
+	{
+		name:    "convert",
+		summary: "Convert the archived zip and publish every format.",
+		needs:   []string{"snapshot"},
+		pipeline: func(s *Server, r *run, want stepState) (*pipeline.Pipeline, error) {
+			p, err := s.fetchPipeline(r).Slice("connect", "connect")
+			if err != nil {
+				return nil, err
+			}
+			p.Add("load", func(ctx context.Context) error {
+				if err := s.archivedZip(ctx, r, want.SHA256); err != nil {
+					return err
+				}
+				if err := r.hashZip(); err != nil {
+					return conversionErr(err)
+				}
+				return nil
+			})
+			return p.Append(s.conversionPipeline(r)), nil
+		},
+	},
+	{
+		name:    "index",
+		summary: "Add the snapshot to index.json, completing the run.",
+		needs:   []string{"snapshot", "sha256"},
+		pipeline: func(s *Server, r *run, want stepState) (*pipeline.Pipeline, error) {
+			fp := s.fetchPipeline(r)
+			p, err := fp.Slice("connect", "connect")
+			if err != nil {
+				return nil, err
+			}
+			index, err := fp.Slice("index", "index")
+			if err != nil {
+				return nil, err
+			}
+			return p.Append(index), nil
+		},
+	},
+}
+
+// archivedZip sets r.zip to the archived export of snapshot r.tSuffix, from
+// the local cache if it's there. If it wasn't archived because an earlier
+// snapshot had the same content, that snapshot's export is read instead.
+func (s *Server) archivedZip(ctx context.Context, r *run, sha256 string) error {
+	ts := r.tSuffix
+	if s.cfg.Cache != nil {
+		if zip, ok := s.cfg.Cache.Get(ts, ""); ok {
+			return r.setZip(zip, false)
+		}
+	}
+	zr, name, err := openExport(ctx, r.bkt, ts)
+	if errors.Is(err, storage.ErrObjectNotExist) && sha256 != "" {
+		idx, _, err := store.ReadIndex(ctx, r.bkt)
+		if err != nil {
+			return storageErr(err)
+		}
+		e := idx.FindContent(sha256)
+		if e == nil {
+			return storageErr(fmt.Errorf("the export of %v isn't archived, and neither is any with the same content: is -archive_zip %q?", ts, ArchiveZipNever))
+		}
+		log.Printf("the export of %v isn't archived: reading %v's identical one", ts, e.Timestamp)
+		zr, name, err = openExport(ctx, r.bkt, e.Timestamp)
+	}
+	if err != nil {
+		return storageErr(err)
+	}
+	defer zr.Close()
+	zip, _, err := spool("prism.zip", zr)
+	if err != nil {
+		return storageErr(fmt.Errorf("couldn't read %v: %v", name, err))
+	}
+	if err := r.setZip(zip, true); err != nil {
+		return storageErr(err)
+	}
+	if s.cfg.Cache != nil && strings.HasSuffix(name, "/"+ts) {
+		if err := s.cfg.Cache.Put(ts, "", r.zip); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return nil
+}
+
+// exportFormats are the formats upstream exports are archived in.
+func exportFormats() []string {
+	var formats []string
+	for _, f := range convert.ArchiveFormats {
+		formats = append(formats, string(f))
+	}
+	return formats
+}
+
+// openExport opens the archived export of the snapshot at ts, in whichever
+// format it was, returning its object's name. The caller must close it.
+func openExport(ctx context.Context, bkt *storage.BucketHandle, ts string) (io.ReadCloser, string, error) {
+	for _, f := range exportFormats() {
+		name := store.ExportName(f, ts)
+		r, err := store.OpenArchive(ctx, bkt, name)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			continue
+		}
+		return r, name, err
+	}
+	return nil, "", fmt.Errorf("no export of %v is archived: %w", ts, storage.ErrObjectNotExist)
+}
+
+// stepStatus is the HTTP status for a failed step. Workflows' default retry
+// policy retries 503s, so errors worth retrying get one.
+func stepStatus(err error) int {
+	var se *stageError
+	if !errors.As(err, &se) {
+		return http.StatusInternalServerError
+	}
+	switch se.Code {
+	case codeUpstreamUnavailable, codeStorageError:
+		return http.StatusServiceUnavailable
+	case codeBadRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// stepHandler serves st.
+func (s *Server) stepHandler(st step) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var want stepState
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&want); err != nil {
+				writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: fmt.Errorf("couldn't decode step request: %v", err)})
+				return
+			}
+		}
+		for _, f := range st.needs {
+			if (f == "snapshot" && want.Snapshot == "") || (f == "sha256" && want.SHA256 == "") {
+				writeError(w, http.StatusBadRequest, "", &stageError{Code: codeBadRequest, Err: fmt.Errorf("step %v needs %v", st.name, f)})
+				return
+			}
+		}
+
+		// Steps share the job status with /fetch, so one mustn't run
+		// alongside another run. Workflows retries the 503.
+		if !s.job.tryStart() {
+			writeError(w, http.StatusServiceUnavailable, "", &stageError{Code: codeBusy, Err: errors.New("a run is already in progress")})
+			return
+		}
+		defer s.job.finish()
+
+		sum := &runSummary{RunID: newRunID(), Snapshot: want.Snapshot}
+		ctx := startTrace(withTrace(req.Context(), req.Header), sum)
+		sum.TriggeredBy = s.caller(req.Header)
+		s.audit(ctx, "step "+st.name, sum.RunID, sum.TriggeredBy)
+		r := &run{sum: sum, tSuffix: want.Snapshot, zipHash: want.SHA256, publishLatest: true, followUp: want.Snapshot != ""}
+		defer r.cleanup()
+		log.Printf("starting step %v of %v as run %v in trace %v", st.name, want.Snapshot, sum.RunID, sum.TraceID)
+		p, err := st.pipeline(s, r, want)
+		if err == nil {
+			err = p.Run(ctx)
+		}
+		if st.name == steps[0].name {
+			// A workflow's first step is the one that asks upstream.
+			s.checkStaleness(ctx, r)
+		}
+		if err != nil {
+			log.Printf("step %v of run %v failed: %v", st.name, sum.RunID, err)
+			s.recordFailure(ctx, r, err)
+			writeError(w, stepStatus(err), sum.RunID, err)
+			return
+		}
+		sum.Snapshot = r.tSuffix
+		resp := stepResponse{
+			stepState: stepState{Snapshot: r.tSuffix, SHA256: r.zipHash},
+			Done:      sum.Skipped || st.name == steps[len(steps)-1].name,
+			Summary:   sum,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("couldn't write step response: %v", err)
+		}
+	}
+}
+
+// workflowDefinition returns a Cloud Workflows definition that runs the
+// steps in order against baseURL, stopping early once one says it's done.
+func workflowDefinition(baseURL string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `# Cloud Workflows definition for nzwirelessmap-fetch, generated from its
+# /steps endpoints. Deploy with a service account that may invoke the service:
+#
+#   gcloud workflows deploy nzwirelessmap-fetch --source=workflow.yaml \
+#     --service-account=SERVICE_ACCOUNT
+#
+# and trigger it from Cloud Scheduler instead of /fetch. Pass
+# {"base_url": "..."} to run against a different deployment.
+main:
+  params: [args]
+  steps:
+    - init:
+        assign:
+          - base_url: ${default(map.get(args, "base_url"), %q)}
+          - state: {}
+`, baseURL)
+	for _, st := range steps {
+		fmt.Fprintf(&b, `    - %[1]v:
+        try:
+          call: http.post
+          args:
+            url: ${base_url + "/steps/%[1]v"}
+            body: ${state}
+            auth:
+              type: OIDC
+            timeout: 1800
+          result: resp
+        retry: ${http.default_retry}
+    - %[1]v_done:
+        switch:
+          - condition: ${resp.body.done}
+            return: ${resp.body}
+    - %[1]v_state:
+        assign:
+          - state: ${resp.body}
+`, st.name)
+	}
+	b.WriteString("    - finish:\n        return: ${state}\n")
+	return b.String()
+}
+
+func (s *Server) workflow(w http.ResponseWriter, r *http.Request) {
+	scheme := "https"
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	} else if r.TLS == nil && strings.HasPrefix(r.Host, "localhost") {
+		scheme = "http"
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	fmt.Fprint(w, workflowDefinition(scheme+"://"+r.Host))
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStepRefusedWhileRunning(t *testing.T) {
+	s := &Server{}
+	s.job.setStage("download")
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/steps/check", strings.NewReader("{}"))
+	s.stepHandler(steps[0])(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	var resp errorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Code != codeBusy {
+		t.Errorf("code = %q, want %q", resp.Error.Code, codeBusy)
+	}
+	if got := s.job.snapshot().Stage; got != "download" {
+		t.Errorf("the running job's stage = %q after a refused step, want %q", got, "download")
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mhansen/nzwirelessmap-fetch/tracing"
+	"google.golang.org/grpc/metadata"
+)
+
+// withTrace returns ctx carrying the trace context in the request headers h,
+// if they have one.
+func withTrace(ctx context.Context, h http.Header) context.Context {
+	if sc, ok := tracing.FromHeader(h); ok {
+		return tracing.NewContext(ctx, sc)
+	}
+	return ctx
+}
+
+// grpcHeader returns the incoming gRPC metadata of ctx as HTTP headers.
+func grpcHeader(ctx context.Context) http.Header {
+	md, _ := metadata.FromIncomingContext(ctx)
+	h := make(http.Header, len(md))
+	for k, v := range md {
+		h[http.CanonicalHeaderKey(k)] = v
+	}
+	return h
+}
+
+// startTrace makes sure the run summarised by sum is traced, starting a new
+// trace if ctx doesn't carry one, and records the trace ID in sum. The
+// returned context is passed to everything the run calls, so the trace
+// context goes out with its requests to upstream and Cloud Storage.
+func startTrace(ctx context.Context, sum *runSummary) context.Context {
+	sc, ok := tracing.FromContext(ctx)
+	if !ok {
+		sc = tracing.New()
+		ctx = tracing.NewContext(ctx, sc)
+	}
+	sum.TraceID = sc.TraceIDString()
+	return ctx
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// APIKey is a key for the query API. Only a hash of the key is stored: the
+// bucket may be public.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex SHA-256 of the key.
+	Hash string `json:"hash"`
+	// RatePerMinute is how many requests the key may make a minute.
+	RatePerMinute int        `json:"rate_per_minute"`
+	Created       time.Time  `json:"created"`
+	Revoked       *time.Time `json:"revoked,omitempty"`
+}
+
+// APIKeys lists every key issued, including revoked ones. It's stored at
+// api_keys.json.
+type APIKeys struct {
+	Keys []APIKey `json:"keys"`
+}
+
+func apiKeysObject(bkt *storage.BucketHandle) *storage.ObjectHandle {
+	return bkt.Object("api_keys.json")
+}
+
+// ReadAPIKeys returns the issued keys. If none have been issued, the list is
+// empty.
+func ReadAPIKeys(ctx context.Context, bkt *storage.BucketHandle) (*APIKeys, error) {
+	keys := &APIKeys{}
+	_, err := ReadJSON(ctx, apiKeysObject(bkt), keys)
+	if err == storage.ErrObjectNotExist {
+		return keys, nil
+	}
+	return keys, err
+}
+
+// UpdateAPIKeys applies fn to the issued keys and writes them back, retrying
+// if someone else changed them in the meantime.
+func UpdateAPIKeys(ctx context.Context, bkt *storage.BucketHandle, fn func(*APIKeys)) error {
+	return UpdateJSON(ctx, apiKeysObject(bkt), fn)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is how archived snapshots, prism.zip/ and prism.csv/, are
+// compressed: "", "gzip" or "zstd". Compressed objects get an extension
+// after the timestamp. Reading handles any of them, so it can be changed at
+// any time.
+var Compression = ""
+
+type compression struct {
+	name, ext, contentType string
+	compress               func(w io.Writer) (io.WriteCloser, error)
+	// decompress returns a reader of r's decompressed contents, which the
+	// caller must close.
+	decompress func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressions = []compression{
+	{
+		name: "gzip", ext: ".gz", contentType: "application/gzip",
+		compress: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+		decompress: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	{
+		name: "zstd", ext: ".zst", contentType: "application/zstd",
+		compress: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		},
+		decompress: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			// Closing this closes the decoder, stopping its goroutines.
+			return d.IOReadCloser(), nil
+		},
+	},
+}
+
+// CheckCompression returns an error if name isn't a supported compression.
+func CheckCompression(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, c := range compressions {
+		if c.name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown compression %q: want gzip or zstd", name)
+}
+
+// trimArchiveExt removes a compression extension from an object name.
+func trimArchiveExt(name string) string {
+	for _, c := range compressions {
+		if s, ok := strings.CutSuffix(name, c.ext); ok {
+			return s
+		}
+	}
+	return name
+}
+
+// WriteArchive writes src to the object name, compressed as configured, and
+// returns the object it wrote.
+func WriteArchive(ctx context.Context, bkt *storage.BucketHandle, name string, src Source, storageClass, contentType string) (*storage.ObjectHandle, error) {
+	if err := CheckCompression(Compression); err != nil {
+		return nil, err
+	}
+	for _, c := range compressions {
+		if c.name != Compression {
+			continue
+		}
+		raw := src
+		src = func(w io.Writer) error {
+			cw, err := c.compress(w)
+			if err != nil {
+				return err
+			}
+			if err := raw(cw); err != nil {
+				return fmt.Errorf("couldn't compress %v: %v", name, err)
+			}
+			return cw.Close()
+		}
+		name, contentType = name+c.ext, c.contentType
+	}
+	o := bkt.Object(name)
+	return o, WriteArtifact(ctx, bkt, o, src, storageClass, contentType)
+}
+
+// decompressed closes a decompressor along with the object it reads.
+type decompressed struct {
+	io.ReadCloser
+	r io.Closer
+}
+
+func (d decompressed) Close() error {
+	err := d.ReadCloser.Close()
+	d.r.Close()
+	return err
+}
+
+// OpenArchive opens the object name, which may have been written compressed
+// by WriteArchive, for reading. The caller must close it.
+func OpenArchive(ctx context.Context, bkt *storage.BucketHandle, name string) (io.ReadCloser, error) {
+	log.Printf("reading from GCS: %v\n", name)
+	r, err := bkt.Object(name).NewReader(ctx)
+	if err == nil {
+		return r, nil
+	}
+	if err != storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("couldn't open %v: %v", name, err)
+	}
+	for _, c := range compressions {
+		o := bkt.Object(name + c.ext)
+		r, err := o.NewReader(ctx)
+		if err == storage.ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't open %v: %v", o.ObjectName(), err)
+		}
+		d, err := c.decompress(r)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("couldn't decompress %v: %v", o.ObjectName(), err)
+		}
+		return decompressed{d, r}, nil
+	}
+	return nil, fmt.Errorf("couldn't open %v: %w", name, storage.ErrObjectNotExist)
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompressions(t *testing.T) {
+	data := bytes.Repeat([]byte("licenceid,clientname\n123,Example Ltd\n"), 1000)
+	for _, c := range compressions {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := c.compress(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() >= len(data) {
+				t.Errorf("compressed %v bytes to %v", len(data), buf.Len())
+			}
+
+			d, err := c.decompress(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("decompressed %v bytes, want the %v compressed", len(got), len(data))
+			}
+
+			// OpenArchive's readers close decoders whose reads fail, too.
+			d, err = c.decompress(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(d); err == nil {
+				t.Errorf("read a truncated stream without error")
+			}
+			d.Close()
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Caller is who triggered a run, as established by whatever authenticated
+// them. The bucket may be public, so callers are written to it only as
+// pseudonyms: see Pseudonymous.
+type Caller struct {
+	// Via is how the caller was identified: "iap", "oidc", "operator_token",
+	// "scheduler", "job", or "anonymous".
+	Via     string `json:"via"`
+	Email   string `json:"email,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// AuditEntry records one action, such as triggering a run.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	RunID   string    `json:"run_id,omitempty"`
+	TraceID string    `json:"trace_id,omitempty"`
+	Caller  *Caller   `json:"caller"`
+}
+
+// Pseudonymous returns a copy of c with its email and subject replaced by
+// their SHA-256 hashes, so a caller's actions can be told apart, and matched
+// to them by someone who already knows who they are, without publishing who
+// they are.
+func (c *Caller) Pseudonymous() *Caller {
+	if c == nil {
+		return nil
+	}
+	p := *c
+	p.Email, p.Subject = pseudonym(c.Email), pseudonym(c.Subject)
+	return &p
+}
+
+func pseudonym(s string) string {
+	if s == "" {
+		return ""
+	}
+	h := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// WriteAudit stores e at audit/{time}-{run_id}.json, with its caller made
+// pseudonymous. Entries are never rewritten, and list in the order they
+// happened.
+func WriteAudit(ctx context.Context, bkt *storage.BucketHandle, e *AuditEntry) error {
+	name := "audit/" + e.Time.UTC().Format("2006-01-02T15:04:05.000000000Z") + "-" + e.RunID + ".json"
+	pe := *e
+	pe.Caller = e.Caller.Pseudonymous()
+	return WriteJSON(ctx, bkt.Object(name).If(storage.Conditions{DoesNotExist: true}), &pe)
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+)
+
+// ComposePartSize, if positive, makes WriteObject upload objects larger than
+// this many bytes as parts in parallel, then compose them into the object.
+var ComposePartSize = 0
+
+// composeParallelism is how many parts are uploaded at once.
+const composeParallelism = 8
+
+// maxComposeSources is the most objects GCS composes in one request.
+const maxComposeSources = 32
+
+// WriteObject streams src to o. If src writes more than ComposePartSize
+// bytes, it's uploaded in parts which are then composed into o. Only about
+// composeParallelism parts are held in memory at once.
+func WriteObject(ctx context.Context, bkt *storage.BucketHandle, o *storage.ObjectHandle, src Source, storageClass, contentType string) error {
+	if ComposePartSize <= 0 {
+		return WriteFrom(ctx, o, src, storageClass, contentType)
+	}
+	pr := Open(src)
+	// Closing it stops src if the upload fails before it's done.
+	defer pr.Close()
+	br := bufio.NewReaderSize(pr, ComposePartSize+1)
+	if head, err := br.Peek(ComposePartSize + 1); err != nil {
+		if err != io.EOF {
+			return fmt.Errorf("error writing to cloud storage: %w", err)
+		}
+		return WriteAs(ctx, o, bytes.NewReader(head), storageClass, contentType)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	prefix := "tmp/compose/" + hex.EncodeToString(id) + "/"
+	// Parts are deleted however the upload goes. They're STANDARD class, so
+	// deleting them early costs nothing.
+	var created []*storage.ObjectHandle
+	defer func() {
+		for _, p := range created {
+			if err := p.Delete(context.Background()); err != nil && err != storage.ErrObjectNotExist {
+				log.Printf("couldn't delete compose part %v: %v", p.ObjectName(), err)
+			}
+		}
+	}()
+
+	var parts []*storage.ObjectHandle
+	var size int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(composeParallelism)
+	for gctx.Err() == nil {
+		chunk := make([]byte, ComposePartSize)
+		n, err := io.ReadFull(br, chunk)
+		if n > 0 {
+			p := bkt.Object(fmt.Sprintf("%v%06d", prefix, len(parts)))
+			parts = append(parts, p)
+			created = append(created, p)
+			size += int64(n)
+			// Go blocks while composeParallelism parts are uploading.
+			g.Go(func() error {
+				return WriteAs(gctx, p, bytes.NewReader(chunk[:n]), "STANDARD", "")
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			g.Wait()
+			return fmt.Errorf("error writing to cloud storage: %w", err)
+		}
+	}
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("couldn't upload parts of %v: %v", o.ObjectName(), err)
+	}
+
+	// Compose in rounds of at most maxComposeSources, until there are few
+	// enough intermediates to compose into o.
+	n := len(parts)
+	for round := 0; len(parts) > maxComposeSources; round++ {
+		var next []*storage.ObjectHandle
+		for i := 0; i < len(parts); i += maxComposeSources {
+			dst := bkt.Object(fmt.Sprintf("%vround%v-%06d", prefix, round, len(next)))
+			if _, err := dst.ComposerFrom(parts[i:min(i+maxComposeSources, len(parts))]...).Run(ctx); err != nil {
+				return fmt.Errorf("couldn't compose parts of %v: %v", o.ObjectName(), err)
+			}
+			next = append(next, dst)
+			created = append(created, dst)
+		}
+		parts = next
+	}
+	c := o.ComposerFrom(parts...)
+	c.StorageClass = storageClass
+	c.ContentType = contentType
+	a, err := c.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't compose %v: %v", o.ObjectName(), err)
+	}
+	log.Printf("composed %v bytes from %v parts into GCS bucket: %v, name: %v", size, n, a.Bucket, a.Name)
+	return nil
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/storage"
+)
+
+// ContentAddressed makes WriteArtifact upload each distinct artifact once,
+// to blobs/sha256/{hash}, and write timestamped names as server-side copies
+// of it. Snapshots that RSM re-publishes unchanged then aren't uploaded
+// again. Every timestamped name still holds the artifact itself, so any
+// reader of the bucket can read it.
+var ContentAddressed = false
+
+// blobName is where content with the given hash is stored.
+func blobName(hash string) string {
+	return "blobs/sha256/" + hash
+}
+
+// WriteArtifact writes src to o. If ContentAddressed is set and a blob with
+// the same content is already stored, o is copied from it instead; if not,
+// src is uploaded to o, which is then copied to the blob for next time. src
+// is run twice then: once to hash it, once to upload it.
+func WriteArtifact(ctx context.Context, bkt *storage.BucketHandle, o *storage.ObjectHandle, src Source, storageClass, contentType string) error {
+	if !ContentAddressed {
+		return WriteObject(ctx, bkt, o, src, storageClass, contentType)
+	}
+	h := sha256.New()
+	if err := src(h); err != nil {
+		return fmt.Errorf("couldn't hash %v: %v", o.ObjectName(), err)
+	}
+	blob := bkt.Object(blobName(hex.EncodeToString(h.Sum(nil))))
+	// Trying the copy is how we find out whether the blob exists, which saves
+	// a round trip when it does.
+	err := copyObject(ctx, o, blob, storageClass, contentType)
+	if err == nil {
+		log.Printf("%v has the same content as %v: copied it", o.ObjectName(), blob.ObjectName())
+		return nil
+	}
+	if !isNotFound(err) {
+		return err
+	}
+	if err := WriteObject(ctx, bkt, o, src, storageClass, contentType); err != nil {
+		return err
+	}
+	err = copyObject(ctx, blob.If(storage.Conditions{DoesNotExist: true}), o, storageClass, contentType)
+	// If someone else wrote it first, it has the same content.
+	if err != nil && !IsPreconditionFailed(err) {
+		return err
+	}
+	return nil
+}
+
+// copyObject copies src to dst, server-side, with the given storage class
+// and content type.
+func copyObject(ctx context.Context, dst, src *storage.ObjectHandle, storageClass, contentType string) error {
+	c := dst.CopierFrom(src)
+	c.StorageClass = storageClass
+	c.ContentType = contentType
+	if _, err := c.Run(ctx); err != nil {
+		return fmt.Errorf("couldn't copy %v to %v: %w", src.ObjectName(), dst.ObjectName(), err)
+	}
+	return nil
+}
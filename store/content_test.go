@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"testing"
+
+	"github.com/mhansen/nzwirelessmap-fetch/internal/fixture"
+)
+
+func TestWriteArtifactContentAddressed(t *testing.T) {
+	defer func(old bool) { ContentAddressed = old }(ContentAddressed)
+	ContentAddressed = true
+	ctx := context.Background()
+	gcs := fixture.NewGCS()
+	defer gcs.Close()
+	client, err := gcs.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	bkt := client.Bucket("b")
+	content := []byte("licenceid\n1\n")
+	sum := sha256.Sum256(content)
+	blob := blobName(hex.EncodeToString(sum[:]))
+
+	tests := []struct {
+		name string
+		// want is the requests writing name makes.
+		want []string
+	}{
+		{name: "prism.csv/1", want: []string{"POST copy " + blob + " prism.csv/1", "POST upload prism.csv/1", "POST copy prism.csv/1 " + blob}},
+		// The same content again is copied from the blob, not uploaded.
+		{name: "prism.csv/2", want: []string{"POST copy " + blob + " prism.csv/2"}},
+	}
+	for _, tt := range tests {
+		if err := WriteArtifact(ctx, bkt, bkt.Object(tt.name), Bytes(content), "NEARLINE", "text/csv"); err != nil {
+			t.Fatal(err)
+		}
+		if got := gcs.Calls(); !slices.Equal(got, tt.want) {
+			t.Errorf("writing %v made requests %q, want %q", tt.name, got, tt.want)
+		}
+		// The timestamped name holds the content itself, for any reader.
+		if got, _ := gcs.Object("b", tt.name); string(got) != string(content) {
+			t.Errorf("%v = %q, want %q", tt.name, got, content)
+		}
+	}
+	if got, _ := gcs.Object("b", blob); string(got) != string(content) {
+		t.Errorf("%v = %q, want %q", blob, got, content)
+	}
+}
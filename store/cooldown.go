@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Cooldown records the last upstream failure, so triggers shortly after it
+// back off instead of hitting upstream again, and when upstream was last
+// requested at all.
+type Cooldown struct {
+	Failed time.Time `json:"failed"`
+	Until  time.Time `json:"until"`
+	Error  string    `json:"error"`
+	// LastRequest is when upstream was last requested, if a minimum
+	// interval between requests is being enforced.
+	LastRequest time.Time `json:"last_request"`
+}
+
+func cooldownObject(bkt *storage.BucketHandle) *storage.ObjectHandle {
+	return bkt.Object("cooldown.json")
+}
+
+// ReadCooldown returns the last upstream failure, or nil if there hasn't
+// been one.
+func ReadCooldown(ctx context.Context, bkt *storage.BucketHandle) (*Cooldown, error) {
+	var c Cooldown
+	_, err := ReadJSON(ctx, cooldownObject(bkt), &c)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// UpdateCooldown applies fn to the stored cool-down, as UpdateJSON does.
+func UpdateCooldown(ctx context.Context, bkt *storage.BucketHandle, fn func(*Cooldown)) error {
+	return UpdateJSON(ctx, cooldownObject(bkt), fn)
+}
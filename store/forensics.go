@@ -0,0 +1,27 @@
+package store
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+// Forensics describes a damaged upstream archive, kept for investigation.
+type Forensics struct {
+	Timestamp string `json:"timestamp"`
+	Error     string `json:"error"`
+	// Salvaged is whether prism.mdb was recovered anyway.
+	Salvaged bool `json:"salvaged"`
+}
+
+// WriteForensics keeps the raw bytes of a damaged archive at
+// forensics/{timestamp}/archive, with f alongside at
+// forensics/{timestamp}/forensics.json. The archive is kept whatever
+// ArchiveZip says, since it may be the only copy.
+func WriteForensics(ctx context.Context, bkt *storage.BucketHandle, archive Source, f *Forensics) error {
+	prefix := "forensics/" + f.Timestamp + "/"
+	if err := WriteObject(ctx, bkt, bkt.Object(prefix+"archive"), archive, "NEARLINE", "application/octet-stream"); err != nil {
+		return err
+	}
+	return WriteJSON(ctx, bkt.Object(prefix+"forensics.json"), f)
+}
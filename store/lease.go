@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Lease is held by one replica at a time, at leases/{name}.json, until it
+// expires. Writes are conditional on the generation read, so two replicas
+// can't both take an expired lease.
+type Lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+func leaseObject(bkt *storage.BucketHandle, name string) *storage.ObjectHandle {
+	return bkt.Object("leases/" + name + ".json")
+}
+
+// AcquireLease takes the named lease for holder until ttl from now, or
+// renews it if holder already has it. It returns the lease as it now stands,
+// and whether holder has it.
+func AcquireLease(ctx context.Context, bkt *storage.BucketHandle, name, holder string, ttl time.Duration) (*Lease, bool, error) {
+	o := leaseObject(bkt, name)
+	var cur Lease
+	gen, err := ReadJSON(ctx, o, &cur)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return nil, false, err
+	}
+	now := time.Now().UTC()
+	if cur.Holder != holder && now.Before(cur.Expires) {
+		return &cur, false, nil
+	}
+	next := &Lease{Holder: holder, Expires: now.Add(ttl)}
+	cond := o.If(storage.Conditions{DoesNotExist: true})
+	if gen != 0 {
+		cond = o.If(storage.Conditions{GenerationMatch: gen})
+	}
+	if err := WriteJSON(ctx, cond, next); err != nil {
+		if IsPreconditionFailed(err) {
+			// Someone else took or renewed it first.
+			return &cur, false, nil
+		}
+		return nil, false, err
+	}
+	return next, true, nil
+}
+
+// ReleaseLease gives up the named lease if holder has it, so another replica
+// can take it without waiting for it to expire.
+func ReleaseLease(ctx context.Context, bkt *storage.BucketHandle, name, holder string) error {
+	o := leaseObject(bkt, name)
+	var cur Lease
+	gen, err := ReadJSON(ctx, o, &cur)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cur.Holder != holder {
+		return nil
+	}
+	if err := o.If(storage.Conditions{GenerationMatch: gen}).Delete(ctx); err != nil && !IsPreconditionFailed(err) {
+		return err
+	}
+	return nil
+}
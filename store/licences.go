@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+// LicenceIndex lists the snapshots in which each licence was added, changed
+// or removed, so that a licence's history can be read from just those
+// snapshots rather than every one. It's stored at licence_index.json.
+type LicenceIndex struct {
+	// Through is the newest snapshot indexed. Snapshots after it aren't
+	// covered.
+	Through string `json:"through"`
+	// Changes lists the snapshots each licence changed in, oldest first, by
+	// licence ID.
+	Changes map[string][]string `json:"changes"`
+}
+
+// Add indexes the snapshot tSuffix, in which the given licences changed.
+// Snapshots must be added oldest first.
+func (idx *LicenceIndex) Add(tSuffix string, changed []string) {
+	if idx.Changes == nil {
+		idx.Changes = make(map[string][]string)
+	}
+	for _, id := range changed {
+		idx.Changes[id] = append(idx.Changes[id], tSuffix)
+	}
+	idx.Through = tSuffix
+}
+
+func licenceIndexObject(bkt *storage.BucketHandle) *storage.ObjectHandle {
+	return bkt.Object("licence_index.json")
+}
+
+// ReadLicenceIndex returns the licence index, or nil if it hasn't been
+// built.
+func ReadLicenceIndex(ctx context.Context, bkt *storage.BucketHandle) (*LicenceIndex, error) {
+	var idx LicenceIndex
+	_, err := ReadJSON(ctx, licenceIndexObject(bkt), &idx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// UpdateLicenceIndex applies fn to the licence index, as UpdateJSON does.
+func UpdateLicenceIndex(ctx context.Context, bkt *storage.BucketHandle, fn func(*LicenceIndex)) error {
+	return UpdateJSON(ctx, licenceIndexObject(bkt), fn)
+}
+
+// DeleteLicenceIndex deletes the licence index, so that it's rebuilt from
+// the snapshots as they are now.
+func DeleteLicenceIndex(ctx context.Context, bkt *storage.BucketHandle) error {
+	if err := licenceIndexObject(bkt).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Maintenance pauses fetches on every replica sharing the bucket, while
+// upstream or the bucket is being worked on.
+type Maintenance struct {
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	// Until, if set, is when fetches resume by themselves.
+	Until *time.Time `json:"until,omitempty"`
+	By    *Caller    `json:"by,omitempty"`
+}
+
+func maintenanceObject(bkt *storage.BucketHandle) *storage.ObjectHandle {
+	return bkt.Object("maintenance.json")
+}
+
+// ReadMaintenance returns the maintenance in effect, or nil if there isn't
+// any. Maintenance that's past its Until has ended.
+func ReadMaintenance(ctx context.Context, bkt *storage.BucketHandle) (*Maintenance, error) {
+	var m Maintenance
+	_, err := ReadJSON(ctx, maintenanceObject(bkt), &m)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Until != nil && !time.Now().Before(*m.Until) {
+		return nil, nil
+	}
+	return &m, nil
+}
+
+// WriteMaintenance starts m, replacing any maintenance already in effect.
+// Who started it is stored pseudonymously.
+func WriteMaintenance(ctx context.Context, bkt *storage.BucketHandle, m *Maintenance) error {
+	pm := *m
+	pm.By = m.By.Pseudonymous()
+	return WriteJSON(ctx, maintenanceObject(bkt), &pm)
+}
+
+// EndMaintenance ends any maintenance in effect.
+func EndMaintenance(ctx context.Context, bkt *storage.BucketHandle) error {
+	if err := maintenanceObject(bkt).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+		return err
+	}
+	return nil
+}
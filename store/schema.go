@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+func schemaObject(bkt *storage.BucketHandle) *storage.ObjectHandle {
+	return bkt.Object("schema.json")
+}
+
+// ReadSchema reads the expected upstream schema from schema.json into v. It
+// returns false if there isn't one yet.
+func ReadSchema(ctx context.Context, bkt *storage.BucketHandle, v interface{}) (bool, error) {
+	_, err := ReadJSON(ctx, schemaObject(bkt), v)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WriteSchema stores v as the expected upstream schema.
+func WriteSchema(ctx context.Context, bkt *storage.BucketHandle, v interface{}) error {
+	return WriteJSON(ctx, schemaObject(bkt), v)
+}
+
+// WriteSchemaDrift records how a snapshot's schema differed from the
+// expected one at runs/{{timestamp}}/schema_drift.json.
+func WriteSchemaDrift(ctx context.Context, bkt *storage.BucketHandle, tSuffix string, v interface{}) error {
+	return WriteJSON(ctx, bkt.Object("runs/"+tSuffix+"/schema_drift.json"), v)
+}
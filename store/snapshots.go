@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Manifest records how a snapshot's derived files were produced.
+type Manifest struct {
+	Timestamp string    `json:"timestamp"`
+	QueryHash string    `json:"query_hash"`
+	Rows      int       `json:"rows"`
+	Processed time.Time `json:"processed"`
+	// TriggeredBy is who triggered the run that produced the snapshot, if
+	// known. It's stored pseudonymously.
+	TriggeredBy *Caller `json:"triggered_by,omitempty"`
+}
+
+func manifestObject(bkt *storage.BucketHandle, tSuffix string) *storage.ObjectHandle {
+	return bkt.Object("runs/" + tSuffix + "/manifest.json")
+}
+
+// WriteManifest stores m at runs/{{timestamp}}/manifest.json, with its
+// caller made pseudonymous.
+func WriteManifest(ctx context.Context, bkt *storage.BucketHandle, m *Manifest) error {
+	pm := *m
+	pm.TriggeredBy = m.TriggeredBy.Pseudonymous()
+	return WriteJSON(ctx, manifestObject(bkt, m.Timestamp), &pm)
+}
+
+// ReadManifest returns the manifest for a snapshot, or nil if the snapshot
+// predates manifests.
+func ReadManifest(ctx context.Context, bkt *storage.BucketHandle, tSuffix string) (*Manifest, error) {
+	var m Manifest
+	_, err := ReadJSON(ctx, manifestObject(bkt, tSuffix), &m)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// WriteUpstream stores a record of the upstream HTTP exchange for a snapshot
+// at runs/{{timestamp}}/upstream.json.
+func WriteUpstream(ctx context.Context, bkt *storage.BucketHandle, tSuffix string, v interface{}) error {
+	return WriteJSON(ctx, bkt.Object("runs/"+tSuffix+"/upstream.json"), v)
+}
+
+// WriteStats stores statistics about a snapshot at
+// runs/{{timestamp}}/stats.json.
+func WriteStats(ctx context.Context, bkt *storage.BucketHandle, tSuffix string, v interface{}) error {
+	return WriteJSON(ctx, bkt.Object("runs/"+tSuffix+"/stats.json"), v)
+}
+
+// ExportName is the object the upstream export of the snapshot at tSuffix
+// is archived at, named by how it's packaged: prism.zip/{timestamp}, as
+// usual, or e.g. prism.tar.gz/{timestamp}.
+func ExportName(format, tSuffix string) string {
+	return "prism." + format + "/" + tSuffix
+}
+
+// ListSnapshots returns the timestamps of all archived exports in any of
+// formats, oldest first.
+func ListSnapshots(ctx context.Context, bkt *storage.BucketHandle, formats []string) ([]string, error) {
+	var ts []string
+	seen := make(map[string]bool)
+	for _, f := range formats {
+		fts, err := List(ctx, bkt, ExportName(f, ""))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range fts {
+			if !seen[t] {
+				seen[t] = true
+				ts = append(ts, t)
+			}
+		}
+	}
+	sort.Strings(ts)
+	return ts, nil
+}
+
+// List returns the timestamps of the objects under prefix, oldest first,
+// without any compression extension. prefix/latest isn't included.
+func List(ctx context.Context, bkt *storage.BucketHandle, prefix string) ([]string, error) {
+	var ts []string
+	seen := make(map[string]bool)
+	it := bkt.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list %v: %v", prefix, err)
+		}
+		name := trimArchiveExt(strings.TrimPrefix(attrs.Name, prefix))
+		if name != "latest" && !seen[name] {
+			seen[name] = true
+			ts = append(ts, name)
+		}
+	}
+	// RFC3339 timestamps in UTC sort lexically.
+	sort.Strings(ts)
+	return ts, nil
+}
+
+// ScanJSONRows calls fn for each row of a prism.json object, without
+// loading the whole object into memory.
+func ScanJSONRows(ctx context.Context, bkt *storage.BucketHandle, name string, fn func(row map[string]string) error) error {
+	r, err := bkt.Object(name).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't open %v: %w", name, err)
+	}
+	defer r.Close()
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("couldn't read %v: %v", name, err)
+	}
+	for dec.More() {
+		var row map[string]string
+		if err := dec.Decode(&row); err != nil {
+			return fmt.Errorf("couldn't decode %v: %v", name, err)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexEntry describes one snapshot we've seen upstream.
+type IndexEntry struct {
+	Timestamp string `json:"timestamp"`
+	SHA256    string `json:"sha256"`
+	// AliasOf is set when RSM re-posted a zip identical to an earlier
+	// snapshot. Aliases have no files of their own: see the snapshot named
+	// here instead.
+	AliasOf string `json:"alias_of,omitempty"`
+	// Rows is how many rows the query extracted. It's only recorded in
+	// entries indexed since it was added: see the snapshot's manifest for
+	// older ones.
+	Rows int `json:"rows,omitempty"`
+}
+
+// Index lists every snapshot in the bucket, oldest first. It's stored at
+// index.json.
+type Index struct {
+	Snapshots []IndexEntry `json:"snapshots"`
+}
+
+// Find returns the entry for the given timestamp, or nil.
+func (idx *Index) Find(tSuffix string) *IndexEntry {
+	for i := range idx.Snapshots {
+		if idx.Snapshots[i].Timestamp == tSuffix {
+			return &idx.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// FindContent returns the earliest snapshot, other than an alias, whose zip
+// has the given hash, or nil.
+func (idx *Index) FindContent(sha256 string) *IndexEntry {
+	for i := range idx.Snapshots {
+		if e := &idx.Snapshots[i]; e.SHA256 == sha256 && e.AliasOf == "" {
+			return e
+		}
+	}
+	return nil
+}
+
+// Latest returns the newest entry, or nil if the index is empty.
+func (idx *Index) Latest() *IndexEntry {
+	if len(idx.Snapshots) == 0 {
+		return nil
+	}
+	return &idx.Snapshots[len(idx.Snapshots)-1]
+}
+
+func indexObject(bkt *storage.BucketHandle) *storage.ObjectHandle {
+	return bkt.Object("index.json")
+}
+
+// ReadIndex returns the index and its generation. A missing index is empty,
+// with generation 0.
+func ReadIndex(ctx context.Context, bkt *storage.BucketHandle) (*Index, int64, error) {
+	idx := &Index{}
+	gen, err := ReadJSON(ctx, indexObject(bkt), idx)
+	if err == storage.ErrObjectNotExist {
+		return idx, 0, nil
+	}
+	return idx, gen, err
+}
+
+// UpdateIndex applies fn to the index and writes it back, retrying if someone
+// else changed the index in the meantime.
+func UpdateIndex(ctx context.Context, bkt *storage.BucketHandle, fn func(*Index)) error {
+	return UpdateJSON(ctx, indexObject(bkt), fn)
+}
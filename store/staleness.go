@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Staleness is the state of the alert for upstream going stale. It's kept
+// in the bucket so that replicas, and instances started after the alert
+// fired, don't alert again.
+type Staleness struct {
+	// Newest is the newest Last-Modified time upstream has served.
+	Newest time.Time `json:"newest"`
+	Firing bool      `json:"firing"`
+}
+
+// UpdateStaleness applies fn to the stored alert state, as UpdateJSON does.
+func UpdateStaleness(ctx context.Context, bkt *storage.BucketHandle, fn func(*Staleness)) error {
+	return UpdateJSON(ctx, bkt.Object("staleness.json"), fn)
+}
@@ -0,0 +1,240 @@
+// Package store reads and writes the fetcher's objects in Google Cloud
+// Storage.
+//
+// The bucket is laid out as:
+//
+//	prism.zip/{timestamp}[.gz|.zst]     the zip as downloaded from RSM, or prism.tar.gz/ etc. if it wasn't one
+//	prism.csv/{timestamp}[.gz|.zst]     links extracted by the query, as CSV
+//	prism.json/{timestamp}              the same, as JSON
+//	prism.json/latest                   the newest prism.json
+//	prism.{format}/{timestamp}          other formats, from convert.Converters
+//	prism.{format}/latest               the newest of each other format
+//	prism.json-patch/{from}/{to}        a JSON Patch from one prism.json to the next
+//	prism.diff/{timestamp}              licences changed since the previous snapshot
+//	runs/{timestamp}/manifest.json      how the snapshot was produced
+//	runs/{timestamp}/upstream.json      the upstream HTTP exchange
+//	runs/{timestamp}/stats.json         row counts and churn since the previous snapshot
+//	runs/{timestamp}/schema_drift.json  how the upstream schema differed from schema.json
+//	forensics/{timestamp}/              a damaged upstream archive, and what was wrong with it
+//	index.json                          every snapshot seen, with content hashes
+//	schema.json                         the expected upstream schema, set on first run
+//	timeseries.json                     link counts of every snapshot
+//	licence_index.json                  the snapshots each licence changed in
+//	cooldown.json                       the last upstream failure, to back off after
+//	staleness.json                      whether the alert for upstream going stale is firing
+//	maintenance.json                    why fetches are paused, if they are
+//	api_keys.json                       hashes of the query API's keys, and their rate limits
+//	leases/{name}.json                  which replica holds a lease, e.g. the scheduler's
+//	blobs/sha256/{hash}                 artifact content to copy, if ContentAddressed is set
+//	tmp/compose/{id}/                   parts of an upload being composed, see WriteObject
+//	selftest/{id}/                      scratch objects of /selftest, deleted as it finishes
+//	audit/{time}-{run_id}.json          who triggered each run
+//
+// Timestamps are the upstream Last-Modified time, formatted as RFC3339.
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// DefaultBucket is the bucket the public map reads from.
+const DefaultBucket = "nz-wireless-map"
+
+// ChunkSize is the size of each request of a resumable upload. If 0, objects
+// are uploaded in a single request. Negative values use the client library's
+// default.
+var ChunkSize = -1
+
+// A Source writes an object's content to w. Uploads call it again to retry,
+// or to hash the content first, so it must write the same bytes each time.
+type Source func(w io.Writer) error
+
+// Bytes returns a Source of b.
+func Bytes(b []byte) Source {
+	return func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	}
+}
+
+// File returns a Source of the contents of f, from its start. f isn't
+// closed, and may be read by several Sources at once.
+func File(f *os.File) Source {
+	return func(w io.Writer) error {
+		_, err := io.Copy(w, io.NewSectionReader(f, 0, math.MaxInt64))
+		return err
+	}
+}
+
+// Open returns a reader of what src writes. Closing it early stops src.
+func Open(src Source) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(src(pw)) }()
+	return pr
+}
+
+// Write copies f to the object o with the given storage class.
+func Write(ctx context.Context, o *storage.ObjectHandle, f io.Reader, storageClass string) error {
+	return WriteAs(ctx, o, f, storageClass, "")
+}
+
+// WriteAs is like Write, but also sets the object's content type.
+func WriteAs(ctx context.Context, o *storage.ObjectHandle, f io.Reader, storageClass, contentType string) error {
+	return WriteFrom(ctx, o, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	}, storageClass, contentType)
+}
+
+// WriteFrom streams src to the object o, with the given storage class and
+// content type. If src fails, the upload is abandoned and o is unchanged.
+func WriteFrom(ctx context.Context, o *storage.ObjectHandle, src Source, storageClass, contentType string) error {
+	log.Printf("writing to GCS: %v\n", o.ObjectName())
+	// Cancelling the context is how an upload is abandoned.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := o.NewWriter(ctx)
+	// From docs:
+	// Attributes can be set on the object by modifying the returned Writer's
+	// ObjectAttrs field before the first call to Write.
+	w.ObjectAttrs.StorageClass = storageClass
+	w.ObjectAttrs.ContentType = contentType
+	if ChunkSize >= 0 {
+		w.ChunkSize = ChunkSize
+	}
+	if err := src(w); err != nil {
+		cancel()
+		w.Close()
+		return fmt.Errorf("error writing to cloud storage: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error closing cloud storage writer: %w", err)
+	}
+	a := w.Attrs()
+	log.Printf("finished writing %v bytes to GCS bucket: %v, name: %v\n", a.Size, a.Bucket, a.Name)
+	return nil
+}
+
+// Read returns the contents of the object name.
+func Read(ctx context.Context, bkt *storage.BucketHandle, name string) ([]byte, error) {
+	log.Printf("reading from GCS: %v\n", name)
+	r, err := bkt.Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %v: %w", name, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %v: %v", name, err)
+	}
+	return b, nil
+}
+
+// ReadJSON decodes the JSON object o into v, returning its generation. If o
+// doesn't exist, the error is storage.ErrObjectNotExist.
+func ReadJSON(ctx context.Context, o *storage.ObjectHandle, v interface{}) (int64, error) {
+	r, err := o.NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read %v: %v", o.ObjectName(), err)
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return 0, fmt.Errorf("couldn't decode %v: %v", o.ObjectName(), err)
+	}
+	return r.Attrs.Generation, nil
+}
+
+// WriteJSON encodes v as JSON into the object o.
+func WriteJSON(ctx context.Context, o *storage.ObjectHandle, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("couldn't encode %v: %v", o.ObjectName(), err)
+	}
+	return Write(ctx, o, bytes.NewReader(b), "STANDARD")
+}
+
+// maxUpdateAttempts bounds how many times UpdateJSON retries an update that
+// raced with another writer.
+const maxUpdateAttempts = 5
+
+// UpdateJSON reads the JSON object o, applies fn to it, and writes it back,
+// retrying if someone else changed o in the meantime. If o doesn't exist, fn
+// is applied to a zero T.
+func UpdateJSON[T any](ctx context.Context, o *storage.ObjectHandle, fn func(*T)) error {
+	for attempt := 1; ; attempt++ {
+		var v T
+		gen, err := ReadJSON(ctx, o, &v)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return err
+		}
+		fn(&v)
+		cond := o.If(storage.Conditions{DoesNotExist: true})
+		if gen != 0 {
+			cond = o.If(storage.Conditions{GenerationMatch: gen})
+		}
+		err = WriteJSON(ctx, cond, &v)
+		if err == nil || !IsPreconditionFailed(err) || attempt == maxUpdateAttempts {
+			return err
+		}
+		log.Printf("%v changed underneath us, retrying (attempt %v)", o.ObjectName(), attempt)
+	}
+}
+
+// ObjectExists reports whether blob exists.
+func ObjectExists(ctx context.Context, blob *storage.ObjectHandle) (bool, error) {
+	attrs, err := blob.Attrs(ctx)
+	if err != nil {
+		log.Printf("got err getting attrs on %v: %v", blob.ObjectName(), err)
+		if err == storage.ErrObjectNotExist {
+			return false, nil
+		}
+		// We don't know if the object exists, other error getting attrs.
+		return false, fmt.Errorf("couldn't get attrs on %v: %v", blob.ObjectName(), err)
+	}
+
+	log.Printf("got attrs for %v: %v", blob.ObjectName(), attrs)
+	return true, nil
+}
+
+// URI returns the gs:// URI of o.
+func URI(o *storage.ObjectHandle) string {
+	return fmt.Sprintf("gs://%v/%v", o.BucketName(), o.ObjectName())
+}
+
+// IsPreconditionFailed reports whether err is due to a failed write
+// precondition, i.e. someone else changed the object first.
+func IsPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
+
+// isNotFound reports whether err is a JSON API error for a missing object,
+// which, unlike reads, copies don't turn into storage.ErrObjectNotExist.
+func isNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
+
+// CheckBucket makes sure the client's credentials can read the bucket's
+// metadata.
+func CheckBucket(ctx context.Context, bkt *storage.BucketHandle) error {
+	if _, err := bkt.Attrs(ctx); err != nil {
+		return fmt.Errorf("couldn't read attrs of bucket %v: %v", bkt.BucketName(), err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/mhansen/nzwirelessmap-fetch/internal/fixture"
+	"google.golang.org/api/option"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeUploads is just enough of the GCS JSON API to accept uploads, both in
+// one request and resumable in chunks. Uploaded data is discarded.
+type fakeUploads struct {
+	mu       sync.Mutex
+	sessions map[string]int64
+	next     int
+}
+
+func (f *fakeUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n, _ := io.Copy(io.Discard, r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Query().Get("uploadType") == "resumable" && r.Method == http.MethodPost:
+		f.next++
+		id := fmt.Sprint(f.next)
+		f.sessions[id] = 0
+		w.Header().Set("Location", "http://"+r.Host+"/resumable?upload_id="+id)
+		return
+	case r.URL.Path == "/resumable":
+		id := r.URL.Query().Get("upload_id")
+		f.sessions[id] += n
+		// Content-Range is "bytes a-b/*" until the final chunk gives the total.
+		if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%v", f.sessions[id]-1))
+			// Clients can ask for "308 Resume Incomplete" as a 200 with a
+			// header, since some proxies mangle 308s.
+			if r.Header.Get("X-GUploader-No-308") == "yes" {
+				w.Header().Set("X-Http-Status-Code-Override", "308")
+				return
+			}
+			w.WriteHeader(308)
+			return
+		}
+		fmt.Fprintf(w, `{"bucket":"b","name":"o","size":"%v"}`, f.sessions[id])
+	default:
+		fmt.Fprintf(w, `{"bucket":"b","name":"o","size":"%v"}`, n)
+	}
+}
+
+func BenchmarkUpload(b *testing.B) {
+	srv := httptest.NewServer(&fakeUploads{sessions: make(map[string]int64)})
+	defer srv.Close()
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+	o := client.Bucket("b").Object("o")
+
+	// A prism.json is a few tens of megabytes.
+	data := bytes.Repeat(fixture.CSV(fixture.Links), 4)
+	defer func(old int) { ChunkSize = old }(ChunkSize)
+	for _, size := range []int{0, 256 << 10, 1 << 20, 8 << 20, 16 << 20} {
+		b.Run(fmt.Sprintf("chunk=%v", size), func(b *testing.B) {
+			ChunkSize = size
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := Write(ctx, o, bytes.NewReader(data), "STANDARD"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteObjectComposes(t *testing.T) {
+	f := &fakeUploads{sessions: make(map[string]int64)}
+	var paths []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		f.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	bkt := client.Bucket("b")
+
+	defer func(old int) { ComposePartSize = old }(ComposePartSize)
+	ComposePartSize = 1 << 10
+	tests := []struct {
+		name      string
+		size      int
+		wantParts int
+	}{
+		{name: "one part", size: 1 << 10, wantParts: 0},
+		{name: "several parts", size: 5<<10 + 1, wantParts: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			src := Bytes(bytes.Repeat([]byte{'x'}, tt.size))
+			if err := WriteObject(ctx, bkt, bkt.Object("o"), src, "NEARLINE", "text/csv"); err != nil {
+				t.Fatal(err)
+			}
+			var composes, deletes int
+			for _, p := range paths {
+				switch {
+				case strings.HasSuffix(p, "/compose"):
+					composes++
+				case strings.HasPrefix(p, http.MethodDelete):
+					deletes++
+				}
+			}
+			if tt.wantParts == 0 && composes != 0 {
+				t.Errorf("composed an object that fits in one part: %q", paths)
+			}
+			if tt.wantParts > 0 && (composes != 1 || deletes != tt.wantParts) {
+				t.Errorf("got %v composes and %v deletes, want 1 compose of %v parts: %q", composes, deletes, tt.wantParts, paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"sort"
+
+	"cloud.google.com/go/storage"
+)
+
+// TimeseriesPoint is the link counts of one snapshot.
+type TimeseriesPoint struct {
+	Timestamp string `json:"timestamp"`
+	Links     int    `json:"links"`
+	// Bands counts links by frequency band.
+	Bands map[string]int `json:"bands"`
+}
+
+// Timeseries has a point for every snapshot, oldest first, so the number of
+// links can be charted over time without reading every snapshot. It's stored
+// at timeseries.json.
+type Timeseries struct {
+	Points []TimeseriesPoint `json:"points"`
+}
+
+// AddTimeseriesPoint records p in timeseries.json, replacing any existing
+// point for the same snapshot.
+func AddTimeseriesPoint(ctx context.Context, bkt *storage.BucketHandle, p TimeseriesPoint) error {
+	return UpdateJSON(ctx, bkt.Object("timeseries.json"), func(ts *Timeseries) {
+		for i := range ts.Points {
+			if ts.Points[i].Timestamp == p.Timestamp {
+				ts.Points[i] = p
+				return
+			}
+		}
+		ts.Points = append(ts.Points, p)
+		sort.Slice(ts.Points, func(i, j int) bool { return ts.Points[i].Timestamp < ts.Points[j].Timestamp })
+	})
+}
@@ -0,0 +1,167 @@
+// Package tracing propagates trace context to upstream requests, so a run's
+// calls to RSM and Cloud Storage show up in the same distributed trace as
+// the request that triggered it.
+//
+// Both W3C Trace Context (traceparent) and Cloud Trace's
+// X-Cloud-Trace-Context headers are read and written. Nothing here records
+// spans: that's left to whatever's at the other end of the headers.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// New starts a new trace, for runs nothing else is tracing.
+func New() SpanContext {
+	var sc SpanContext
+	rand.Read(sc.TraceID[:])
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// Child returns a new span in the same trace, with sc as its parent.
+func (sc SpanContext) Child() SpanContext {
+	rand.Read(sc.SpanID[:])
+	return sc
+}
+
+// TraceIDString returns the trace ID in hex, as it's logged and shown in
+// Cloud Trace.
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Traceparent formats sc as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%v", sc.TraceID, sc.SpanID, flags)
+}
+
+// Inject sets the trace context headers in h to sc.
+func (sc SpanContext) Inject(h http.Header) {
+	h.Set("traceparent", sc.Traceparent())
+	o := 0
+	if sc.Sampled {
+		o = 1
+	}
+	h.Set("X-Cloud-Trace-Context", fmt.Sprintf("%x/%v;o=%v", sc.TraceID, binary.BigEndian.Uint64(sc.SpanID[:]), o))
+}
+
+// FromHeader reads the trace context from h, preferring traceparent to
+// X-Cloud-Trace-Context. It reports false if neither is present and valid.
+func FromHeader(h http.Header) (SpanContext, bool) {
+	if sc, ok := parseTraceparent(h.Get("traceparent")); ok {
+		return sc, true
+	}
+	return parseCloudTrace(h.Get("X-Cloud-Trace-Context"))
+}
+
+// parseTraceparent parses a traceparent header like
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func parseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return sc, false
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.valid()
+}
+
+// parseCloudTrace parses an X-Cloud-Trace-Context header like
+// "105445aa7843bc8bf206b12000100000/1;o=1".
+func parseCloudTrace(v string) (SpanContext, bool) {
+	var sc SpanContext
+	v, opts, _ := strings.Cut(v, ";")
+	trace, span, _ := strings.Cut(v, "/")
+	if !decodeHex(sc.TraceID[:], trace) {
+		return sc, false
+	}
+	if span != "" {
+		id, err := strconv.ParseUint(span, 10, 64)
+		if err != nil {
+			return sc, false
+		}
+		binary.BigEndian.PutUint64(sc.SpanID[:], id)
+	}
+	sc.Sampled = opts == "o=1"
+	if sc.SpanID == [8]byte{} {
+		// Cloud Trace allows leaving out the span; make one up so that
+		// what we send on is valid traceparent.
+		rand.Read(sc.SpanID[:])
+	}
+	return sc, sc.valid()
+}
+
+// decodeHex decodes s into exactly len(dst) bytes.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// valid reports whether neither ID is all zeroes, which both formats forbid.
+func (sc SpanContext) valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying sc.
+func NewContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the span context ctx carries, if any.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Transport adds trace context headers to requests whose contexts carry a
+// span context, giving each request a span of its own as a child of it.
+type Transport struct {
+	// Base makes the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	sc, ok := FromContext(req.Context())
+	if !ok {
+		return base.RoundTrip(req)
+	}
+	// RoundTrippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	sc.Child().Inject(req.Header)
+	return base.RoundTrip(req)
+}